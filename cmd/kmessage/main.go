@@ -0,0 +1,512 @@
+// Command kmessage crafts, sends and decodes zero kmessage frames.
+//
+//	kmessage encode  -type RPC -json '{"method":"ping"}'
+//	kmessage decode  -hex 7a65726f0001...
+//	kmessage decode  -file traffic.log
+//	kmessage vectors -out vectors.json
+//	kmessage send    -addr 127.0.0.1:9090 -key dev01 -type HEARTBEAT
+//	kmessage client  -conf ./ -addr 127.0.0.1:9090 -key dev01 -type RPC -json '{}'
+//	kmessage server  -conf ./ -addr :9090 -echo
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/protocol"
+	"github.com/0meet1/zero-framework/server"
+)
+
+const xAPP_NAME = "kmessage"
+
+var xBytesStringPattern = regexp.MustCompile(`0[xX]([0-9A-Fa-f]{2})`)
+
+var xCommands = map[string]func([]string) error{
+	"encode":  encodeCommand,
+	"decode":  decodeCommand,
+	"vectors": vectorsCommand,
+	"send":    sendCommand,
+	"client":  clientCommand,
+	"server":  serverCommand,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <encode|decode|vectors|send|client|server> [flags]\n", xAPP_NAME)
+	fmt.Fprintf(os.Stderr, "run `%s <command> -h` for the flags of a command\n", xAPP_NAME)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, ok := xCommands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	err := command(os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", xAPP_NAME, os.Args[1], strings.TrimSpace(err.Error()))
+		os.Exit(1)
+	}
+}
+
+type xFrameFlags struct {
+	version   int
+	msgType   string
+	messageId string
+	uniquekey string
+	flags     string
+	sequence  int
+	jsonBody  string
+	hexBody   string
+	textBody  string
+	bodyFile  string
+}
+
+func (xFlags *xFrameFlags) bind(flags *flag.FlagSet, msgType string) {
+	flags.IntVar(&xFlags.version, "version", protocol.KMESSAGE_VERSION_1, "frame version, 1 or 2")
+	flags.StringVar(&xFlags.msgType, "type", msgType, "message type name (CONNECT, HEARTBEAT, RPC ...) or hex code like 0x21")
+	flags.StringVar(&xFlags.messageId, "id", "", "32 bytes messageId, generated when empty")
+	flags.StringVar(&xFlags.uniquekey, "key", "", "uniquekey of the frame")
+	flags.StringVar(&xFlags.flags, "flags", "", "comma separated v2 flags (GZIP,DEFLATE,CHUNKED,CHUNK_END,WINDOW,ACK_REQ)")
+	flags.IntVar(&xFlags.sequence, "seq", 0, "v2 sequence")
+	flags.StringVar(&xFlags.jsonBody, "json", "", "json body")
+	flags.StringVar(&xFlags.hexBody, "hex", "", "hex body")
+	flags.StringVar(&xFlags.textBody, "text", "", "plain text body")
+	flags.StringVar(&xFlags.bodyFile, "body", "", "read the body from a file")
+}
+
+func (xFlags *xFrameFlags) body() ([]byte, error) {
+	switch {
+	case len(xFlags.jsonBody) > 0:
+		if !json.Valid([]byte(xFlags.jsonBody)) {
+			return nil, errors.New("invalid json body")
+		}
+		compact := &bytes.Buffer{}
+		err := json.Compact(compact, []byte(xFlags.jsonBody))
+		return compact.Bytes(), err
+	case len(xFlags.hexBody) > 0:
+		return decodeHex(xFlags.hexBody)
+	case len(xFlags.textBody) > 0:
+		return []byte(xFlags.textBody), nil
+	case len(xFlags.bodyFile) > 0:
+		return os.ReadFile(xFlags.bodyFile)
+	}
+	return make([]byte, 0), nil
+}
+
+func (xFlags *xFrameFlags) build() (*protocol.ZeroKMessage, error) {
+	msgType, err := protocol.ParseKMessageType(xFlags.msgType)
+	if err != nil {
+		return nil, err
+	}
+	var flags byte
+	if len(xFlags.flags) > 0 {
+		flags, err = protocol.ParseKMessageFlags(strings.Split(xFlags.flags, ",")...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	body, err := xFlags.body()
+	if err != nil {
+		return nil, err
+	}
+	return protocol.NewKMessageFrame(byte(xFlags.version), msgType, xFlags.messageId, xFlags.uniquekey, flags, xFlags.sequence, body)
+}
+
+// decodeHex accepts plain hex, spaced or colon separated hex and the `{ 0x7A 0x65 ... }` dumps written by the kmessage logs.
+func decodeHex(text string) ([]byte, error) {
+	if matches := xBytesStringPattern.FindAllStringSubmatch(text, -1); len(matches) > 0 {
+		datas := make([]byte, 0, len(matches))
+		for _, match := range matches {
+			b, _ := hex.DecodeString(match[1])
+			datas = append(datas, b...)
+		}
+		return datas, nil
+	}
+	return hex.DecodeString(strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ':' || r == ',' {
+			return -1
+		}
+		return r
+	}, text))
+}
+
+func readInput(path string) ([]byte, error) {
+	var datas []byte
+	var err error
+	if path == "-" {
+		datas, err = io.ReadAll(os.Stdin)
+	} else {
+		datas, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(datas, []byte("zero")) {
+		return datas, nil
+	}
+	return decodeHex(string(datas))
+}
+
+func printFrames(writer io.Writer, format string, frames ...*protocol.ZeroKMessageFrame) error {
+	if format == "json" {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(frames)
+	}
+	for i, frame := range frames {
+		status := "OK"
+		if !frame.Valid {
+			status = "INVALID " + frame.Error
+		}
+		fmt.Fprintf(writer, "frame #%d %s v%d (max v%d) %d bytes checksum %s %s\n", i+1, frame.Type, frame.Version, frame.MaxVersion, len(frame.Frame)/2, frame.CheckSum, status)
+		if len(frame.MessageId) <= 0 {
+			fmt.Fprintf(writer, "  raw       : %s\n", frame.Frame)
+			continue
+		}
+		fmt.Fprintf(writer, "  uniquekey : %s\n", frame.UniqueKey)
+		fmt.Fprintf(writer, "  messageId : %s\n", frame.MessageId)
+		if frame.Version == protocol.KMESSAGE_VERSION_2 {
+			fmt.Fprintf(writer, "  flags     : %s\n", strings.Join(frame.Flags, ","))
+			fmt.Fprintf(writer, "  sequence  : %d\n", frame.Sequence)
+		}
+		fmt.Fprintf(writer, "  body      : %d bytes on wire\n", frame.BodyLength)
+		if len(frame.Body) > 0 {
+			fmt.Fprintf(writer, "  text      : %s\n", frame.Body)
+		} else if len(frame.BodyHex) > 0 {
+			fmt.Fprintf(writer, "  hex       : %s\n", frame.BodyHex)
+		}
+	}
+	return nil
+}
+
+func describeFrames(datas []byte) ([]*protocol.ZeroKMessageFrame, []byte) {
+	xFrames, remain := protocol.SplitKMessageFrames(datas)
+	frames := make([]*protocol.ZeroKMessageFrame, 0, len(xFrames))
+	for _, xFrame := range xFrames {
+		frames = append(frames, protocol.DescribeKMessage(xFrame))
+	}
+	return frames, remain
+}
+
+func encodeCommand(args []string) error {
+	flags := flag.NewFlagSet("encode", flag.ExitOnError)
+	xFlags := &xFrameFlags{}
+	xFlags.bind(flags, "RPC")
+	format := flags.String("format", "hex", "output format: hex, dump, json or raw")
+	flags.Parse(args)
+
+	message, err := xFlags.build()
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "raw":
+		_, err = os.Stdout.Write(message.Bytes())
+	case "dump":
+		fmt.Print(message.String())
+	case "json":
+		err = printFrames(os.Stdout, "json", protocol.DescribeKMessage(message.Bytes()))
+	default:
+		fmt.Println(hex.EncodeToString(message.Bytes()))
+	}
+	return err
+}
+
+func decodeCommand(args []string) error {
+	flags := flag.NewFlagSet("decode", flag.ExitOnError)
+	hexText := flags.String("hex", "", "hex frames to decode")
+	file := flags.String("file", "", "binary capture, hex text or kmessage log to decode, - for stdin")
+	format := flags.String("format", "text", "output format: text or json")
+	flags.Parse(args)
+
+	var datas []byte
+	var err error
+	if len(*hexText) > 0 {
+		datas, err = decodeHex(*hexText)
+	} else if len(*file) > 0 {
+		datas, err = readInput(*file)
+	} else {
+		return errors.New("one of -hex or -file is required")
+	}
+	if err != nil {
+		return err
+	}
+	frames, remain := describeFrames(datas)
+	if len(frames) <= 0 {
+		return errors.New("no kmessage frame found")
+	}
+	err = printFrames(os.Stdout, *format, frames...)
+	if err != nil {
+		return err
+	}
+	if len(remain) > 0 {
+		fmt.Fprintf(os.Stderr, "%d trailing bytes are not a complete frame\n", len(remain))
+	}
+	for _, frame := range frames {
+		if !frame.Valid {
+			return fmt.Errorf("%d invalid frames", invalidCount(frames))
+		}
+	}
+	return nil
+}
+
+func invalidCount(frames []*protocol.ZeroKMessageFrame) int {
+	count := 0
+	for _, frame := range frames {
+		if !frame.Valid {
+			count++
+		}
+	}
+	return count
+}
+
+type xVector struct {
+	Name    string                      `json:"name"`
+	Frame   string                      `json:"frame"`
+	Decoded *protocol.ZeroKMessageFrame `json:"decoded"`
+}
+
+func vectorsCommand(args []string) error {
+	flags := flag.NewFlagSet("vectors", flag.ExitOnError)
+	out := flags.String("out", "", "write the vectors to a file instead of stdout")
+	uniquekey := flags.String("key", "vector", "uniquekey used by the vectors")
+	flags.Parse(args)
+
+	xVectors := []struct {
+		name    string
+		version byte
+		msgType byte
+		flags   byte
+		seq     int
+		body    []byte
+	}{
+		{"connect-v1", protocol.KMESSAGE_VERSION_1, protocol.MESSAGE_TYPE_CONNECT, 0, 0, []byte{}},
+		{"connect-v2", protocol.KMESSAGE_VERSION_2, protocol.MESSAGE_TYPE_CONNECT, 0, 0, []byte{}},
+		{"connect-auth-v1", protocol.KMESSAGE_VERSION_1, protocol.MESSAGE_TYPE_CONNECT, 0, 0, []byte(`{"timestamp":1700000000,"nonce":"00000000000000000000000000000000"}`)},
+		{"connack-accepted-v1", protocol.KMESSAGE_VERSION_1, protocol.MESSAGE_TYPE_CONNACK, 0, 0, []byte{protocol.CONNACK_ACCEPTED}},
+		{"connack-bad-signature-v1", protocol.KMESSAGE_VERSION_1, protocol.MESSAGE_TYPE_CONNACK, 0, 0, []byte{protocol.CONNACK_BAD_SIGNATURE}},
+		{"heartbeat-v1", protocol.KMESSAGE_VERSION_1, protocol.MESSAGE_TYPE_HEARTBEAT, 0, 0, []byte{}},
+		{"beatack-v1", protocol.KMESSAGE_VERSION_1, protocol.MESSAGE_TYPE_BEATACK, 0, 0, []byte{}},
+		{"rpc-json-v1", protocol.KMESSAGE_VERSION_1, protocol.MESSAGE_TYPE_RPC, 0, 0, []byte(`{"method":"ping","params":{"n":1}}`)},
+		{"rpc-json-v2", protocol.KMESSAGE_VERSION_2, protocol.MESSAGE_TYPE_RPC, 0, 1, []byte(`{"method":"ping","params":{"n":1}}`)},
+		{"rpc-gzip-v2", protocol.KMESSAGE_VERSION_2, protocol.MESSAGE_TYPE_RPC, protocol.KMESSAGE_FLAG_GZIP, 2, bytes.Repeat([]byte("zero kmessage "), 16)},
+		{"rpc-deflate-v2", protocol.KMESSAGE_VERSION_2, protocol.MESSAGE_TYPE_RPC, protocol.KMESSAGE_FLAG_DEFLATE, 3, bytes.Repeat([]byte("zero kmessage "), 16)},
+		{"chunk-v2", protocol.KMESSAGE_VERSION_2, 0x21, protocol.KMESSAGE_FLAG_CHUNKED, 0, []byte("first chunk")},
+		{"chunk-end-v2", protocol.KMESSAGE_VERSION_2, 0x21, protocol.KMESSAGE_FLAG_CHUNKED | protocol.KMESSAGE_FLAG_CHUNK_END, 1, []byte("last chunk")},
+		{"window-v2", protocol.KMESSAGE_VERSION_2, 0x21, protocol.KMESSAGE_FLAG_WINDOW, 4, []byte{}},
+		{"push-ack-req-v2", protocol.KMESSAGE_VERSION_2, 0x21, protocol.KMESSAGE_FLAG_ACK_REQ, 0, []byte(`{"event":"notify"}`)},
+		{"puback-v1", protocol.KMESSAGE_VERSION_1, protocol.MESSAGE_TYPE_PUBACK, 0, 0, []byte{}},
+	}
+
+	vectors := make([]*xVector, 0, len(xVectors))
+	for i, vector := range xVectors {
+		messageId := fmt.Sprintf("%032x", i+1)
+		message, err := protocol.NewKMessageFrame(vector.version, vector.msgType, messageId, *uniquekey, vector.flags, vector.seq, vector.body)
+		if err != nil {
+			return fmt.Errorf("vector %s : %s", vector.name, err.Error())
+		}
+		vectors = append(vectors, &xVector{
+			Name:    vector.name,
+			Frame:   hex.EncodeToString(message.Bytes()),
+			Decoded: protocol.DescribeKMessage(message.Bytes()),
+		})
+	}
+
+	writer := io.Writer(os.Stdout)
+	if len(*out) > 0 {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(vectors)
+}
+
+func sendCommand(args []string) error {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	xFlags := &xFrameFlags{}
+	xFlags.bind(flags, "HEARTBEAT")
+	addr := flags.String("addr", "127.0.0.1:9090", "kmessage server address")
+	connect := flags.Bool("connect", true, "send a CONNECT frame with -key before the frame")
+	wait := flags.Int("wait", 3, "seconds to wait for response frames")
+	format := flags.String("format", "text", "output format: text or json")
+	flags.Parse(args)
+
+	message, err := xFlags.build()
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", *addr, time.Second*10)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sends := make([]*protocol.ZeroKMessage, 0, 2)
+	msgType, _ := protocol.ParseKMessageType(xFlags.msgType)
+	if *connect && msgType != protocol.MESSAGE_TYPE_CONNECT {
+		cMessage, err := protocol.NewKMessageFrame(protocol.KMESSAGE_VERSION_1, protocol.MESSAGE_TYPE_CONNECT, "", xFlags.uniquekey, 0, 0, make([]byte, 0))
+		if err != nil {
+			return err
+		}
+		sends = append(sends, cMessage)
+	}
+	sends = append(sends, message)
+	for _, send := range sends {
+		fmt.Printf(">>> %s\n", hex.EncodeToString(send.Bytes()))
+		_, err = conn.Write(send.Bytes())
+		if err != nil {
+			return err
+		}
+		if len(sends) > 1 {
+			<-time.After(time.Millisecond * 200)
+		}
+	}
+
+	datas := make([]byte, 0)
+	buffer := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second * time.Duration(*wait)))
+	for {
+		n, err := conn.Read(buffer)
+		datas = append(datas, buffer[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	frames, _ := describeFrames(datas)
+	if len(frames) <= 0 {
+		fmt.Println("<<< no response")
+		return nil
+	}
+	fmt.Printf("<<< %d frames\n", len(frames))
+	return printFrames(os.Stdout, *format, frames...)
+}
+
+type xPrintOperator struct {
+	echo bool
+}
+
+func (operator *xPrintOperator) Operation(conn server.ZeroConnect, message *protocol.ZeroKMessage) (bool, error) {
+	from := "server"
+	if conn != nil {
+		from = conn.RegisterId()
+	}
+	fmt.Printf("<<< from %s\n", from)
+	printFrames(os.Stdout, "text", protocol.DescribeKMessage(message.Bytes()))
+	if !operator.echo || conn == nil {
+		return true, nil
+	}
+	ackMessage := protocol.NewAckKMessage(protocol.MESSAGE_TYPE_RPCACK, message.MessageId(), message.MessageBody())
+	ackMessage.AddUniqueKey(message.UniqueKey())
+	err := ackMessage.Complete()
+	if err != nil {
+		return false, err
+	}
+	return true, global.Value(protocol.ZEROKMSG_SERVER).(protocol.ZeroKMessageServer).PushMessage(conn.RegisterId(), ackMessage)
+}
+
+func waitSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+}
+
+func clientCommand(args []string) error {
+	flags := flag.NewFlagSet("client", flag.ExitOnError)
+	xFlags := &xFrameFlags{}
+	xFlags.bind(flags, "RPC")
+	conf := flags.String("conf", ".", "directory containing conf/zero-framework.yaml")
+	addr := flags.String("addr", "127.0.0.1:9090", "kmessage server address")
+	heartbeat := flags.Int("heartbeat", 30, "heartbeat seconds")
+	push := flags.Bool("push", false, "push the frame without waiting for a response")
+	timeout := flags.Int("timeout", 30, "seconds to wait for the connection and the response")
+	listen := flags.Bool("listen", false, "keep running and print frames pushed by the server")
+	flags.Parse(args)
+
+	message, err := xFlags.build()
+	if err != nil {
+		return err
+	}
+	global.RunTest(xAPP_NAME, *conf)
+	protocol.RunKMessageClient(*addr, *heartbeat, *heartbeat, &xPrintOperator{}, xFlags.uniquekey)
+	client := global.Value(protocol.ZEROKMSG_CLIENT).(protocol.ZeroKMessageClient)
+
+	err = waitConnected(client, *timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf(">>> %s\n", hex.EncodeToString(message.Bytes()))
+	if *push {
+		err = client.PushMessage(message)
+	} else {
+		var resp *protocol.ZeroKMessage
+		resp, err = client.ExecMessage(message, *timeout)
+		if err == nil {
+			fmt.Println("<<< response")
+			err = printFrames(os.Stdout, "text", protocol.DescribeKMessage(resp.Bytes()))
+		}
+	}
+	if err != nil || !*listen {
+		return err
+	}
+	waitSignal()
+	return nil
+}
+
+// waitConnected waits for the tcp connection and the CONNACK, Active alone is also true before the first dial.
+func waitConnected(client protocol.ZeroKMessageClient, timeout int) error {
+	writer := client.(interface{ Write([]byte) error })
+	deadline := time.Now().Add(time.Second * time.Duration(timeout))
+	for checked := 0; checked < 2; {
+		if time.Now().After(deadline) {
+			return errors.New("connect timeout")
+		}
+		if client.ConnectError() != nil {
+			return client.ConnectError()
+		}
+		if client.Active() && writer.Write(nil) == nil {
+			checked++
+		} else {
+			checked = 0
+		}
+		<-time.After(time.Millisecond * 200)
+	}
+	return nil
+}
+
+func serverCommand(args []string) error {
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	conf := flags.String("conf", ".", "directory containing conf/zero-framework.yaml")
+	addr := flags.String("addr", ":9090", "listen address")
+	heartbeat := flags.Int("heartbeat", 60, "heartbeat seconds")
+	echo := flags.Bool("echo", false, "answer every frame with a RPCACK carrying the same body")
+	flags.Parse(args)
+
+	global.RunTest(xAPP_NAME, *conf)
+	go protocol.RunKMessageServer(*addr, *heartbeat, &xPrintOperator{echo: *echo})
+	fmt.Printf("kmessage server listen on %s\n", *addr)
+	waitSignal()
+	return nil
+}
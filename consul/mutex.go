@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -16,6 +17,7 @@ type ZeroDCSMutex struct {
 	KeyName string
 	Lock    *api.Lock
 	Lockc   chan struct{}
+	Lostc   <-chan struct{}
 }
 
 type ZeroDCSMutexTrunk interface {
@@ -30,6 +32,12 @@ type ZeroDCSMutexTrunk interface {
 	Unlock(*ZeroDCSMutex) error
 }
 
+// ZeroDCSMutexTryTrunk is a trunk that can take a lock without waiting and tell who holds it.
+type ZeroDCSMutexTryTrunk interface {
+	TryLock(string, string, int) (*ZeroDCSMutex, error)
+	Holder(string) (string, error)
+}
+
 type xZeroDCSMutexTrunk struct {
 	apiConfig *api.Config
 	apiClient *api.Client
@@ -86,7 +94,7 @@ func (mtx *xZeroDCSMutexTrunk) Lock(keyName, operator string, timeout ...int) (*
 	}
 
 	_c := make(chan struct{})
-	_, err = _xLock.Lock(_c)
+	_lostc, err := _xLock.Lock(_c)
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +102,38 @@ func (mtx *xZeroDCSMutexTrunk) Lock(keyName, operator string, timeout ...int) (*
 	return &ZeroDCSMutex{
 		Lock:  _xLock,
 		Lockc: _c,
+		Lostc: _lostc,
+	}, nil
+}
+
+// TryLock makes one attempt on a session lock with ttl seconds, consul requires at least 10,
+// it returns nil when the lock is held by another session and Lostc closes once the lock is lost.
+func (mtx *xZeroDCSMutexTrunk) TryLock(keyName, operator string, ttl int) (*ZeroDCSMutex, error) {
+	_xLock, err := mtx.apiClient.LockOpts(&api.LockOptions{
+		Key:          keyName,
+		Value:        []byte(operator),
+		SessionTTL:   fmt.Sprintf("%ds", ttl),
+		LockWaitTime: time.Second,
+		LockTryOnce:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_c := make(chan struct{})
+	_lostc, err := _xLock.Lock(_c)
+	if err != nil {
+		return nil, err
+	}
+	if _lostc == nil {
+		return nil, nil
+	}
+
+	return &ZeroDCSMutex{
+		KeyName: keyName,
+		Lock:    _xLock,
+		Lockc:   _c,
+		Lostc:   _lostc,
 	}, nil
 }
 
@@ -103,6 +143,18 @@ func (mtx *xZeroDCSMutexTrunk) Unlock(_mutex *ZeroDCSMutex) error {
 	return _mutex.Lock.Destroy()
 }
 
+// Holder returns the value of a lock key while a session holds it, empty when it is free.
+func (mtx *xZeroDCSMutexTrunk) Holder(keyName string) (string, error) {
+	val, _, err := mtx.apiClient.KV().Get(keyName, &api.QueryOptions{})
+	if err != nil {
+		return "", err
+	}
+	if val == nil || val.Session == "" {
+		return "", nil
+	}
+	return string(val.Value), nil
+}
+
 func (mtx *xZeroDCSMutexTrunk) runDCSMutex() error {
 	mtx.apiConfig = api.DefaultConfig()
 
@@ -14,6 +14,8 @@ const (
 	EXCEPTION_AUTO_PROC = "zero.exception.auto.proc"
 	EXCEPTION_OPERATION = "zero.exception.operation"
 
+	ES00400 = "ES00400"
+	ES00404 = "ES00404"
 	ES00500 = "ES00500"
 )
 
@@ -0,0 +1,572 @@
+package mfgrc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0meet1/zero-framework/consul"
+	"github.com/0meet1/zero-framework/database"
+	"github.com/0meet1/zero-framework/global"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	xCLUSTER_LEASE_PREFIX = "zero.mfgrc.lease"
+	xCLUSTER_LEASE_TTL    = 15
+)
+
+var (
+	xErrMfgrcLeaseBusy = errors.New("lease is busy, try again later")
+	xErrMfgrcLeaseLost = errors.New("lease is lost")
+
+	xErrMfgrcConsulTryLock = errors.New("consul trunk does not support try lock")
+)
+
+// ZeroMfgrcLeaseBackend coordinates unique code ownership between nodes, a lease not renewed
+// within ttl seconds is free again so a dead node loses its codes.
+type ZeroMfgrcLeaseBackend interface {
+	Acquire(key string, node string, ttl int) (bool, error)
+	Renew(key string, node string, ttl int) (bool, error)
+	Release(key string, node string) error
+	Owner(key string) (string, error)
+}
+
+// ZeroMfgrcMonoForwarder hands a mono to the node owning its unique code.
+type ZeroMfgrcMonoForwarder interface {
+	ForwardMono(node string, mono MfgrcMono) error
+}
+
+// ZeroMfgrcTimeoutForwarder is a forwarder whose requests are bounded by the cluster ForwardTimeout.
+type ZeroMfgrcTimeoutForwarder interface {
+	UseTimeout(time.Duration)
+}
+
+// ZeroMfgrcCluster runs a keeper as one node of a cluster, monos of a unique code only run on the node
+// holding its lease. Takeover is the interval in seconds for claiming monos left by dead nodes, 0 disables it.
+// ForwardTimeout bounds in seconds a mono forwarded to the owner of its unique code.
+type ZeroMfgrcCluster struct {
+	NodeId         string
+	Prefix         string
+	TTL            int
+	Takeover       int
+	ForwardTimeout int
+
+	backend   ZeroMfgrcLeaseBackend
+	forwarder ZeroMfgrcMonoForwarder
+}
+
+func NewMfgrcCluster(nodeId string, backend ZeroMfgrcLeaseBackend, ttl int) *ZeroMfgrcCluster {
+	if ttl <= 0 {
+		ttl = xCLUSTER_LEASE_TTL
+	}
+	return &ZeroMfgrcCluster{
+		NodeId:         nodeId,
+		Prefix:         xCLUSTER_LEASE_PREFIX,
+		TTL:            ttl,
+		Takeover:       ttl,
+		ForwardTimeout: ttl,
+		backend:        backend,
+	}
+}
+
+func (cluster *ZeroMfgrcCluster) UseForwarder(forwarder ZeroMfgrcMonoForwarder) *ZeroMfgrcCluster {
+	cluster.forwarder = forwarder
+	cluster.timeoutForwarder()
+	return cluster
+}
+
+func (cluster *ZeroMfgrcCluster) UseForwardTimeout(seconds int) *ZeroMfgrcCluster {
+	cluster.ForwardTimeout = seconds
+	cluster.timeoutForwarder()
+	return cluster
+}
+
+func (cluster *ZeroMfgrcCluster) timeoutForwarder() {
+	if xForwarder, ok := cluster.forwarder.(ZeroMfgrcTimeoutForwarder); ok && cluster.ForwardTimeout > 0 {
+		xForwarder.UseTimeout(time.Second * time.Duration(cluster.ForwardTimeout))
+	}
+}
+
+func (cluster *ZeroMfgrcCluster) UsePrefix(prefix string) *ZeroMfgrcCluster {
+	cluster.Prefix = prefix
+	return cluster
+}
+
+func (cluster *ZeroMfgrcCluster) UseTakeover(takeover int) *ZeroMfgrcCluster {
+	cluster.Takeover = takeover
+	return cluster
+}
+
+func (cluster *ZeroMfgrcCluster) Export() map[string]interface{} {
+	clusterMap := make(map[string]interface{})
+	clusterMap["nodeId"] = cluster.NodeId
+	clusterMap["prefix"] = cluster.Prefix
+	clusterMap["ttl"] = cluster.TTL
+	clusterMap["takeover"] = cluster.Takeover
+	clusterMap["forwarding"] = cluster.forwarder != nil
+	return clusterMap
+}
+
+type xMfgrcRedisLease struct {
+	keeper database.RedisKeeper
+}
+
+var (
+	xRedisLeaseRenew   = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("expire", KEYS[1], ARGV[2]) end return 0`)
+	xRedisLeaseRelease = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`)
+)
+
+// NewMfgrcRedisLease keeps leases as redis keys holding the node id with an expiry of ttl.
+func NewMfgrcRedisLease(keeper database.RedisKeeper) ZeroMfgrcLeaseBackend {
+	return &xMfgrcRedisLease{keeper: keeper}
+}
+
+func (lease *xMfgrcRedisLease) Acquire(key string, node string, ttl int) (bool, error) {
+	ok, err := lease.keeper.Client().SetNX(context.Background(), key, node, time.Second*time.Duration(ttl)).Result()
+	if err != nil || ok {
+		return ok, err
+	}
+	return lease.Renew(key, node, ttl)
+}
+
+func (lease *xMfgrcRedisLease) Renew(key string, node string, ttl int) (bool, error) {
+	renewed, err := xRedisLeaseRenew.Run(context.Background(), lease.keeper.Client(), []string{key}, node, ttl).Int()
+	return renewed == 1, err
+}
+
+func (lease *xMfgrcRedisLease) Release(key string, node string) error {
+	return xRedisLeaseRelease.Run(context.Background(), lease.keeper.Client(), []string{key}, node).Err()
+}
+
+func (lease *xMfgrcRedisLease) Owner(key string) (string, error) {
+	return lease.keeper.Get(key)
+}
+
+type xMfgrcConsulLease struct {
+	trunk  consul.ZeroDCSMutexTrunk
+	mutexs map[string]*consul.ZeroDCSMutex
+	mutex  sync.Mutex
+}
+
+// NewMfgrcConsulLease keeps leases as consul session locks, the session is renewed by the consul client
+// and ttl must be at least 10 seconds. The trunk must also be a ZeroDCSMutexTryTrunk.
+func NewMfgrcConsulLease(trunk consul.ZeroDCSMutexTrunk) ZeroMfgrcLeaseBackend {
+	return &xMfgrcConsulLease{
+		trunk:  trunk,
+		mutexs: make(map[string]*consul.ZeroDCSMutex),
+	}
+}
+
+func (lease *xMfgrcConsulLease) held(key string) bool {
+	xMutex, ok := lease.mutexs[key]
+	if !ok {
+		return false
+	}
+	select {
+	case <-xMutex.Lostc:
+		delete(lease.mutexs, key)
+		err := lease.trunk.Unlock(xMutex)
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("lease `%s` unlock err : %s", key, err.Error()))
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func (lease *xMfgrcConsulLease) Acquire(key string, node string, ttl int) (bool, error) {
+	lease.mutex.Lock()
+	defer lease.mutex.Unlock()
+	if lease.held(key) {
+		return true, nil
+	}
+	xTrunk, ok := lease.trunk.(consul.ZeroDCSMutexTryTrunk)
+	if !ok {
+		return false, xErrMfgrcConsulTryLock
+	}
+	xMutex, err := xTrunk.TryLock(key, node, ttl)
+	if err != nil || xMutex == nil {
+		return false, err
+	}
+	lease.mutexs[key] = xMutex
+	return true, nil
+}
+
+func (lease *xMfgrcConsulLease) Renew(key string, _ string, _ int) (bool, error) {
+	lease.mutex.Lock()
+	defer lease.mutex.Unlock()
+	return lease.held(key), nil
+}
+
+func (lease *xMfgrcConsulLease) Release(key string, _ string) error {
+	lease.mutex.Lock()
+	defer lease.mutex.Unlock()
+	xMutex, ok := lease.mutexs[key]
+	if !ok {
+		return nil
+	}
+	delete(lease.mutexs, key)
+	return lease.trunk.Unlock(xMutex)
+}
+
+func (lease *xMfgrcConsulLease) Owner(key string) (string, error) {
+	xTrunk, ok := lease.trunk.(consul.ZeroDCSMutexTryTrunk)
+	if !ok {
+		return "", xErrMfgrcConsulTryLock
+	}
+	return xTrunk.Holder(key)
+}
+
+// xMfgrcLease counts what keeps a unique code on this node, queued fluxes, scheduled and retrying monos.
+// renewed is the last time the backend confirmed the lease, fence cancels the mono running under it.
+type xMfgrcLease struct {
+	refs    int
+	lost    bool
+	renewed time.Time
+	fence   context.CancelCauseFunc
+}
+
+// lose marks the lease lost and cancels the running mono, leaseMutex must be held.
+func (lease *xMfgrcLease) lose() {
+	lease.lost = true
+	if lease.fence != nil {
+		lease.fence(xErrMfgrcLeaseLost)
+	}
+}
+
+// UseCluster enables cluster mode, it should be set before RunWorker.
+func (keeper *ZeroMfgrcKeeper) UseCluster(cluster *ZeroMfgrcCluster) *ZeroMfgrcKeeper {
+	keeper.cluster = cluster
+	return keeper
+}
+
+func (keeper *ZeroMfgrcKeeper) leaseKey(uniqueCode string) string {
+	return fmt.Sprintf("%s/%s/%s", keeper.cluster.Prefix, keeper.keeperName, uniqueCode)
+}
+
+// holdCode takes a reference on the unique code lease, acquiring it first when this node does not hold it.
+// The reference is taken before the backend is asked so the lease is not released meanwhile.
+func (keeper *ZeroMfgrcKeeper) holdCode(uniqueCode string) (bool, error) {
+	if keeper.cluster == nil {
+		return true, nil
+	}
+	keeper.leaseMutex.Lock()
+	lease, ok := keeper.leaseMap[uniqueCode]
+	if !ok {
+		lease = &xMfgrcLease{lost: true}
+		keeper.leaseMap[uniqueCode] = lease
+	}
+	lease.refs++
+	lost := lease.lost
+	keeper.leaseMutex.Unlock()
+	if !lost {
+		return true, nil
+	}
+
+	acquired, err := keeper.cluster.backend.Acquire(keeper.leaseKey(uniqueCode), keeper.cluster.NodeId, keeper.cluster.TTL)
+
+	keeper.leaseMutex.Lock()
+	defer keeper.leaseMutex.Unlock()
+	if (err != nil || !acquired) && lease.lost {
+		lease.refs--
+		if lease.refs <= 0 {
+			delete(keeper.leaseMap, uniqueCode)
+		}
+		return false, err
+	}
+	if acquired {
+		lease.lost = false
+		lease.renewed = time.Now()
+	}
+	return true, nil
+}
+
+// unholdCode drops a reference on the unique code lease, the last one releases it without holding leaseMutex,
+// a hold racing the release sees the lease refused on the next renewal and takes it back.
+func (keeper *ZeroMfgrcKeeper) unholdCode(uniqueCode string) {
+	if keeper.cluster == nil {
+		return
+	}
+	keeper.leaseMutex.Lock()
+	lease, ok := keeper.leaseMap[uniqueCode]
+	if !ok {
+		keeper.leaseMutex.Unlock()
+		return
+	}
+	lease.refs--
+	if lease.refs > 0 {
+		keeper.leaseMutex.Unlock()
+		return
+	}
+	delete(keeper.leaseMap, uniqueCode)
+	keeper.leaseMutex.Unlock()
+	if lease.lost {
+		return
+	}
+	err := keeper.cluster.backend.Release(keeper.leaseKey(uniqueCode), keeper.cluster.NodeId)
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("unique code `%s` lease release err : %s", uniqueCode, err.Error()))
+	}
+}
+
+func (keeper *ZeroMfgrcKeeper) ownsCode(uniqueCode string) bool {
+	if keeper.cluster == nil {
+		return true
+	}
+	keeper.leaseMutex.Lock()
+	defer keeper.leaseMutex.Unlock()
+	lease, ok := keeper.leaseMap[uniqueCode]
+	return ok && !lease.lost
+}
+
+// reclaimCode takes a lost lease back for a flux already holding a reference on it.
+func (keeper *ZeroMfgrcKeeper) reclaimCode(uniqueCode string) bool {
+	if keeper.cluster == nil {
+		return true
+	}
+	acquired, err := keeper.cluster.backend.Acquire(keeper.leaseKey(uniqueCode), keeper.cluster.NodeId, keeper.cluster.TTL)
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("unique code `%s` lease acquire err : %s", uniqueCode, err.Error()))
+	}
+	if err != nil || !acquired {
+		return false
+	}
+	keeper.leaseMutex.Lock()
+	defer keeper.leaseMutex.Unlock()
+	lease, ok := keeper.leaseMap[uniqueCode]
+	if !ok {
+		return false
+	}
+	lease.lost = false
+	lease.renewed = time.Now()
+	return true
+}
+
+// fenceCode registers the cancel of the mono running under the unique code lease, nil clears it.
+func (keeper *ZeroMfgrcKeeper) fenceCode(uniqueCode string, fence context.CancelCauseFunc) {
+	if keeper.cluster == nil {
+		return
+	}
+	keeper.leaseMutex.Lock()
+	defer keeper.leaseMutex.Unlock()
+	lease, ok := keeper.leaseMap[uniqueCode]
+	if !ok {
+		return
+	}
+	lease.fence = fence
+	if fence != nil && lease.lost {
+		fence(xErrMfgrcLeaseLost)
+	}
+}
+
+// forwardMono hands the mono to the owner of its unique code, the error wraps xErrMfgrcLeaseBusy
+// when the owner is unknown yet.
+func (keeper *ZeroMfgrcKeeper) forwardMono(mono MfgrcMono) error {
+	owner, err := keeper.cluster.backend.Owner(keeper.leaseKey(mono.XuniqueCode()))
+	if err != nil {
+		return fmt.Errorf("unique code `%s` %w : %s", mono.XuniqueCode(), xErrMfgrcLeaseBusy, err.Error())
+	}
+	if owner == "" || owner == keeper.cluster.NodeId {
+		return fmt.Errorf("unique code `%s` %w", mono.XuniqueCode(), xErrMfgrcLeaseBusy)
+	}
+	if keeper.cluster.forwarder == nil {
+		return fmt.Errorf("unique code `%s` is owned by node `%s`", mono.XuniqueCode(), owner)
+	}
+	global.Logger().Info(fmt.Sprintf("mono `%s` forward to node `%s`", mono.XmonoId(), owner))
+	return keeper.cluster.forwarder.ForwardMono(owner, mono)
+}
+
+// renewLeases keeps held leases alive, a lease is lost when the backend refuses the renewal or it was not
+// confirmed within ttl, its running mono is cancelled and its queued monos are forwarded to the new owner.
+// A lost lease that is free again is taken back. The backend is called without holding leaseMutex.
+func (keeper *ZeroMfgrcKeeper) renewLeases() {
+	keeper.leaseMutex.Lock()
+	leases := make(map[string]*xMfgrcLease, len(keeper.leaseMap))
+	losts := make(map[string]bool, len(keeper.leaseMap))
+	for uniqueCode, lease := range keeper.leaseMap {
+		leases[uniqueCode] = lease
+		losts[uniqueCode] = lease.lost
+	}
+	keeper.leaseMutex.Unlock()
+
+	ttl := time.Second * time.Duration(keeper.cluster.TTL)
+	for uniqueCode, lease := range leases {
+		key := keeper.leaseKey(uniqueCode)
+		if losts[uniqueCode] {
+			acquired, err := keeper.cluster.backend.Acquire(key, keeper.cluster.NodeId, keeper.cluster.TTL)
+			if err != nil || !acquired {
+				continue
+			}
+			keeper.leaseMutex.Lock()
+			xLease, ok := keeper.leaseMap[uniqueCode]
+			if xLease == lease {
+				lease.lost = false
+				lease.renewed = time.Now()
+				global.Logger().Info(fmt.Sprintf("unique code `%s` lease is taken back", uniqueCode))
+			}
+			keeper.leaseMutex.Unlock()
+			if !ok {
+				err = keeper.cluster.backend.Release(key, keeper.cluster.NodeId)
+				if err != nil {
+					global.Logger().Error(fmt.Sprintf("unique code `%s` lease release err : %s", uniqueCode, err.Error()))
+				}
+			}
+			continue
+		}
+
+		renewed, err := keeper.cluster.backend.Renew(key, keeper.cluster.NodeId, keeper.cluster.TTL)
+		keeper.leaseMutex.Lock()
+		if keeper.leaseMap[uniqueCode] != lease || lease.lost {
+			keeper.leaseMutex.Unlock()
+			continue
+		}
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("unique code `%s` lease renew err : %s", uniqueCode, err.Error()))
+			if time.Since(lease.renewed) >= ttl {
+				lease.lose()
+				global.Logger().Warn(fmt.Sprintf("unique code `%s` lease is lost, not renewed within %s", uniqueCode, ttl))
+			}
+		} else if !renewed {
+			lease.lose()
+			global.Logger().Warn(fmt.Sprintf("unique code `%s` lease is lost", uniqueCode))
+		} else {
+			lease.renewed = time.Now()
+		}
+		keeper.leaseMutex.Unlock()
+	}
+}
+
+func (keeper *ZeroMfgrcKeeper) runCluster(stop chan struct{}) {
+	renewTicker := time.NewTicker(time.Second * time.Duration(max(keeper.cluster.TTL/3, 1)))
+	defer renewTicker.Stop()
+	var takeoverc <-chan time.Time
+	if keeper.cluster.Takeover > 0 && keeper.keeperOpts != nil {
+		takeoverTicker := time.NewTicker(time.Second * time.Duration(keeper.cluster.Takeover))
+		defer takeoverTicker.Stop()
+		takeoverc = takeoverTicker.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-renewTicker.C:
+			keeper.renewLeases()
+		case <-takeoverc:
+			keeper.takeoverMonos()
+		}
+	}
+}
+
+// claimUncompleteMonos returns the unfinished monos whose unique code this node could hold and the held codes,
+// monos are fetched again after the leases are taken so a code finished meanwhile by its old owner is not run twice.
+func (keeper *ZeroMfgrcKeeper) claimUncompleteMonos(accept func(MfgrcMono) bool, report *ZeroMfgrcRecoveryReport) ([]MfgrcMono, []string, error) {
+	xMonos, err := keeper.keeperOpts.FetchUncompleteMonos()
+	if err != nil {
+		return nil, nil, err
+	}
+	codes := make([]string, 0)
+	if keeper.cluster != nil {
+		claimed := make(map[string]bool)
+		for _, mono := range xMonos {
+			if _, ok := claimed[mono.XuniqueCode()]; ok || !accept(mono) {
+				continue
+			}
+			owned, err := keeper.holdCode(mono.XuniqueCode())
+			if err != nil {
+				global.Logger().Error(fmt.Sprintf("unique code `%s` lease acquire err : %s", mono.XuniqueCode(), err.Error()))
+			}
+			claimed[mono.XuniqueCode()] = owned
+			if owned {
+				codes = append(codes, mono.XuniqueCode())
+			} else {
+				report.Skipped = append(report.Skipped, mono.XuniqueCode())
+			}
+		}
+		if len(codes) == 0 {
+			return make([]MfgrcMono, 0), codes, nil
+		}
+		xMonos, err = keeper.keeperOpts.FetchUncompleteMonos()
+		if err != nil {
+			return nil, codes, err
+		}
+		accept = func(mono MfgrcMono) bool { return claimed[mono.XuniqueCode()] }
+	}
+
+	monos := make([]MfgrcMono, 0, len(xMonos))
+	for _, mono := range xMonos {
+		if !accept(mono) {
+			continue
+		}
+		mono.Store(keeper.keeperOpts.MonoStore())
+		monos = append(monos, mono)
+	}
+	return monos, codes, nil
+}
+
+// takeoverMonos claims monos admitted by nodes whose leases expired, ready monos are left to the node
+// that is about to queue them and executing monos are left alone until a full ttl after their last update.
+func (keeper *ZeroMfgrcKeeper) takeoverMonos() {
+	keeper.statusMutex.RLock()
+	xStatus := keeper.status
+	keeper.statusMutex.RUnlock()
+	if xStatus != xKEEPER_STATUS_RUNNING {
+		return
+	}
+
+	now := time.Now()
+	if datetime, err := keeper.keeperOpts.DatebaseDatetime(); err == nil && datetime != nil {
+		now = *datetime
+	}
+	settled := now.Add(-time.Second * time.Duration(keeper.cluster.TTL))
+
+	report := newMfgrcRecoveryReport(keeper)
+	report.Takeover = true
+	monos, codes, err := keeper.claimUncompleteMonos(func(mono MfgrcMono) bool {
+		if mono.State() == WORKER_MONO_STATUS_READY {
+			return false
+		}
+		if xMono, ok := mono.(ZeroMfgrcMonoUpdated); ok && mono.State() == WORKER_MONO_STATUS_EXECUTING {
+			if updated := xMono.XupdateTime(); updated != nil && updated.After(settled) {
+				return false
+			}
+		}
+		keeper.leaseMutex.Lock()
+		defer keeper.leaseMutex.Unlock()
+		_, ok := keeper.leaseMap[mono.XuniqueCode()]
+		return !ok
+	}, report)
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf(" takeover monos err : %s", err.Error()))
+	}
+	if len(codes) == 0 {
+		return
+	}
+	report.Total = len(monos)
+	keeper.recoverUnfinished(monos, report)
+	for _, uniqueCode := range codes {
+		keeper.unholdCode(uniqueCode)
+	}
+	report.EndTime = time.Now()
+
+	global.Logger().Info(fmt.Sprintf(" worker takeover monos complete, report: %s", report))
+	if xListener, ok := keeper.keeperOpts.(ZeroMfgrcRecoveryListener); ok {
+		xListener.OnRecovered(report)
+	}
+}
+
+func (keeper *ZeroMfgrcKeeper) exportLeases() map[string]interface{} {
+	leases := make(map[string]interface{})
+	keeper.leaseMutex.Lock()
+	defer keeper.leaseMutex.Unlock()
+	for uniqueCode, lease := range keeper.leaseMap {
+		leases[uniqueCode] = map[string]interface{}{
+			"refs": lease.refs,
+			"lost": lease.lost,
+		}
+	}
+	return leases
+}
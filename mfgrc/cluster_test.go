@@ -0,0 +1,226 @@
+package mfgrc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type xTestLeaseBackend struct {
+	owners   map[string]string
+	acquires int
+	releases int
+	renewErr error
+	mutex    sync.Mutex
+}
+
+func newTestLeaseBackend() *xTestLeaseBackend {
+	return &xTestLeaseBackend{owners: make(map[string]string)}
+}
+
+func (backend *xTestLeaseBackend) Acquire(key string, node string, ttl int) (bool, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	backend.acquires++
+	if owner, ok := backend.owners[key]; ok && owner != node {
+		return false, nil
+	}
+	backend.owners[key] = node
+	return true, nil
+}
+
+func (backend *xTestLeaseBackend) Renew(key string, node string, ttl int) (bool, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	if backend.renewErr != nil {
+		return false, backend.renewErr
+	}
+	return backend.owners[key] == node, nil
+}
+
+func (backend *xTestLeaseBackend) Release(key string, node string) error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	backend.releases++
+	if backend.owners[key] == node {
+		delete(backend.owners, key)
+	}
+	return nil
+}
+
+func (backend *xTestLeaseBackend) Owner(key string) (string, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+	return backend.owners[key], nil
+}
+
+func newTestClusterKeeper(backend ZeroMfgrcLeaseBackend) *ZeroMfgrcKeeper {
+	keeper := NewWorker("test", nil, 1, 10, 0, 0, 0, 0)
+	keeper.UseCluster(NewMfgrcCluster("node-a", backend, 15))
+	return keeper
+}
+
+func TestMfgrcLeaseRefcount(t *testing.T) {
+	backend := newTestLeaseBackend()
+	keeper := newTestClusterKeeper(backend)
+	key := keeper.leaseKey("code")
+
+	for i := 0; i < 2; i++ {
+		owned, err := keeper.holdCode("code")
+		if err != nil || !owned {
+			t.Fatalf("hold %d owned %v err %v", i, owned, err)
+		}
+	}
+	if backend.acquires != 1 {
+		t.Fatalf("acquired %d times, expected once", backend.acquires)
+	}
+	if lease := keeper.leaseMap["code"]; lease == nil || lease.refs != 2 || !keeper.ownsCode("code") {
+		t.Fatalf("lease %+v, expected 2 refs and owned", lease)
+	}
+
+	keeper.unholdCode("code")
+	if backend.releases != 0 || backend.owners[key] != "node-a" {
+		t.Fatal("lease released while still referenced")
+	}
+	keeper.unholdCode("code")
+	if backend.releases != 1 || backend.owners[key] != "" {
+		t.Fatal("lease not released by the last reference")
+	}
+	if _, ok := keeper.leaseMap["code"]; ok || keeper.ownsCode("code") {
+		t.Fatal("lease still tracked after the last reference")
+	}
+}
+
+func TestMfgrcLeaseHeldByOther(t *testing.T) {
+	backend := newTestLeaseBackend()
+	keeper := newTestClusterKeeper(backend)
+	backend.owners[keeper.leaseKey("code")] = "node-b"
+
+	owned, err := keeper.holdCode("code")
+	if err != nil || owned {
+		t.Fatalf("hold owned %v err %v, expected refused", owned, err)
+	}
+	if _, ok := keeper.leaseMap["code"]; ok {
+		t.Fatal("refused lease left a reference")
+	}
+	err = keeper.forwardMono(&ZeroMfgrcMono{MonoID: "mono", UniqueCode: "code"})
+	if err == nil || errors.Is(err, xErrMfgrcLeaseBusy) {
+		t.Fatalf("forward without forwarder err %v, expected owned by node-b", err)
+	}
+
+	delete(backend.owners, keeper.leaseKey("code"))
+	err = keeper.forwardMono(&ZeroMfgrcMono{MonoID: "mono", UniqueCode: "code"})
+	if !errors.Is(err, xErrMfgrcLeaseBusy) {
+		t.Fatalf("forward without owner err %v, expected busy", err)
+	}
+}
+
+func TestMfgrcLeaseRenew(t *testing.T) {
+	backend := newTestLeaseBackend()
+	keeper := newTestClusterKeeper(backend)
+	key := keeper.leaseKey("code")
+	keeper.holdCode("code")
+	lease := keeper.leaseMap["code"]
+
+	fence, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	keeper.fenceCode("code", cancel)
+
+	backend.renewErr = errors.New("connection refused")
+	keeper.renewLeases()
+	if lease.lost || fence.Err() != nil {
+		t.Fatal("lease lost on a transport error within ttl")
+	}
+	lease.renewed = time.Now().Add(-time.Second * 16)
+	keeper.renewLeases()
+	if !lease.lost {
+		t.Fatal("lease kept after ttl without renewal")
+	}
+	if !errors.Is(context.Cause(fence), xErrMfgrcLeaseLost) {
+		t.Fatalf("running mono not fenced, cause %v", context.Cause(fence))
+	}
+
+	backend.renewErr = nil
+	keeper.renewLeases()
+	if lease.lost || lease.refs != 1 {
+		t.Fatalf("free lease not taken back, lease %+v", lease)
+	}
+
+	backend.owners[key] = "node-b"
+	keeper.renewLeases()
+	if !lease.lost || keeper.ownsCode("code") {
+		t.Fatal("lease kept after the backend refused renewal")
+	}
+	keeper.renewLeases()
+	if !lease.lost {
+		t.Fatal("lease taken back while owned by node-b")
+	}
+
+	keeper.unholdCode("code")
+	if backend.releases != 0 || backend.owners[key] != "node-b" {
+		t.Fatal("lost lease released on node-b")
+	}
+}
+
+func TestMfgrcMonoForwarded(t *testing.T) {
+	keeper := NewWorker("forwarded", nil, 1, 10, 0, 0, 3, 0)
+	cases := []struct {
+		status   string
+		accepted string
+	}{
+		{WORKER_MONO_STATUS_RETRYING, WORKER_MONO_STATUS_RETRYING},
+		{WORKER_MONO_STATUS_PENDING, WORKER_MONO_STATUS_READY},
+		{WORKER_MONO_STATUS_SCHEDULED, WORKER_MONO_STATUS_SCHEDULED},
+		{WORKER_MONO_STATUS_EXECUTING, ""},
+	}
+	for _, c := range cases {
+		origin := &ZeroMfgrcMono{MonoID: "mono", UniqueCode: "code"}
+		origin.ThisDef(origin)
+		origin.status = c.status
+		origin.maxExecuteTimes = 4
+		origin.executeTimes = 2
+		exports, err := origin.Export()
+		if err != nil {
+			t.Fatal(err)
+		}
+		jsonbytes, _ := json.Marshal(exports)
+		exports = make(map[string]interface{})
+		json.Unmarshal(jsonbytes, &exports)
+
+		mono := &ZeroMfgrcMono{MonoID: "mono", UniqueCode: "code"}
+		mono.ThisDef(mono)
+		err = mono.Forwarded(keeper, exports)
+		if c.accepted == "" {
+			if err == nil {
+				t.Errorf("forwarded %s accepted", c.status)
+			}
+			continue
+		}
+		if err != nil || mono.State() != c.accepted || mono.ExecuteTimes() != 2 || mono.MaxExecuteTimes() != 4 {
+			t.Errorf("forwarded %s as %s times %d/%d err %v", c.status, mono.State(), mono.ExecuteTimes(), mono.MaxExecuteTimes(), err)
+		}
+	}
+}
+
+func TestMfgrcForwardTimeout(t *testing.T) {
+	release := make(chan struct{})
+	owner := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release }))
+	defer owner.Close()
+	defer close(release)
+
+	forwarder := NewMfgrcXhttpForwarder("", func(string) (string, error) { return owner.URL, nil })
+	NewMfgrcCluster("node-a", newTestLeaseBackend(), 15).UseForwarder(forwarder).UseForwardTimeout(1)
+	mono := &ZeroMfgrcMono{MonoID: "mono", UniqueCode: "code"}
+	mono.ThisDef(mono)
+
+	start := time.Now()
+	err := forwarder.ForwardMono("node-b", mono)
+	if err == nil || time.Since(start) > 3*time.Second {
+		t.Fatalf("forward to a stuck owner took %s err %v", time.Since(start), err)
+	}
+}
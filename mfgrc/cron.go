@@ -0,0 +1,253 @@
+package mfgrc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type xCronField struct {
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	xCRON_SECOND = xCronField{min: 0, max: 59}
+	xCRON_MINUTE = xCronField{min: 0, max: 59}
+	xCRON_HOUR   = xCronField{min: 0, max: 23}
+	xCRON_DOM    = xCronField{min: 1, max: 31}
+	xCRON_MONTH  = xCronField{min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	xCRON_DOW = xCronField{min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+var xCronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// ZeroMfgrcCronSchedule is a parsed cron expression, fields are second minute hour day-of-month month day-of-week.
+type ZeroMfgrcCronSchedule struct {
+	Expr string
+
+	second   uint64
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	domStar  bool
+	dowStar  bool
+	location *time.Location
+}
+
+// ParseMfgrcCron accepts six fields with seconds, five fields run at second 0,
+// descriptors such as @daily and a leading CRON_TZ=<zone> or TZ=<zone> are supported.
+func ParseMfgrcCron(expr string, location *time.Location) (*ZeroMfgrcCronSchedule, error) {
+	if location == nil {
+		location = time.Local
+	}
+	schedule := &ZeroMfgrcCronSchedule{Expr: expr}
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		end := strings.IndexAny(spec, " \t")
+		if end < 0 {
+			return nil, fmt.Errorf("cron `%s` missing fields after time zone", expr)
+		}
+		zone := spec[strings.Index(spec, "=")+1 : end]
+		xLocation, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("cron `%s` unknown time zone `%s`", expr, zone)
+		}
+		location = xLocation
+		spec = strings.TrimSpace(spec[end:])
+	}
+	schedule.location = location
+
+	if descriptor, ok := xCronDescriptors[strings.ToLower(spec)]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	if len(fields) == 5 {
+		fields = append([]string{"0"}, fields...)
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("cron `%s` expected 5 or 6 fields, found %d", expr, len(fields))
+	}
+
+	var err error
+	if schedule.second, _, err = parseCronField(fields[0], xCRON_SECOND); err != nil {
+		return nil, fmt.Errorf("cron `%s` second %s", expr, err)
+	}
+	if schedule.minute, _, err = parseCronField(fields[1], xCRON_MINUTE); err != nil {
+		return nil, fmt.Errorf("cron `%s` minute %s", expr, err)
+	}
+	if schedule.hour, _, err = parseCronField(fields[2], xCRON_HOUR); err != nil {
+		return nil, fmt.Errorf("cron `%s` hour %s", expr, err)
+	}
+	if schedule.dom, schedule.domStar, err = parseCronField(fields[3], xCRON_DOM); err != nil {
+		return nil, fmt.Errorf("cron `%s` day of month %s", expr, err)
+	}
+	if schedule.month, _, err = parseCronField(fields[4], xCRON_MONTH); err != nil {
+		return nil, fmt.Errorf("cron `%s` month %s", expr, err)
+	}
+	if schedule.dow, schedule.dowStar, err = parseCronField(fields[5], xCRON_DOW); err != nil {
+		return nil, fmt.Errorf("cron `%s` day of week %s", expr, err)
+	}
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	return schedule, nil
+}
+
+func parseCronField(field string, xField xCronField) (uint64, bool, error) {
+	var bits uint64
+	star := field == "*" || field == "?"
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			xStep, err := strconv.Atoi(part[slash+1:])
+			if err != nil || xStep <= 0 {
+				return 0, false, fmt.Errorf("invalid step `%s`", part)
+			}
+			step = xStep
+			part = part[:slash]
+		}
+		start, end := xField.min, xField.max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			xStart, err := parseCronValue(bounds[0], xField)
+			if err != nil {
+				return 0, false, err
+			}
+			xEnd, err := parseCronValue(bounds[1], xField)
+			if err != nil {
+				return 0, false, err
+			}
+			start, end = xStart, xEnd
+		default:
+			value, err := parseCronValue(part, xField)
+			if err != nil {
+				return 0, false, err
+			}
+			start = value
+			if step == 1 {
+				end = value
+			}
+		}
+		if start > end {
+			return 0, false, fmt.Errorf("invalid range `%s`", part)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, star, nil
+}
+
+func parseCronValue(value string, xField xCronField) (int, error) {
+	if number, ok := xField.names[strings.ToUpper(value)]; ok {
+		return number, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < xField.min || number > xField.max {
+		return 0, fmt.Errorf("value `%s` out of range %d-%d", value, xField.min, xField.max)
+	}
+	return number, nil
+}
+
+func (schedule *ZeroMfgrcCronSchedule) Location() *time.Location {
+	return schedule.location
+}
+
+func (schedule *ZeroMfgrcCronSchedule) dayMatches(t time.Time) bool {
+	dom := schedule.dom&(1<<uint(t.Day())) != 0
+	dow := schedule.dow&(1<<uint(t.Weekday())) != 0
+	if schedule.domStar || schedule.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first activation strictly after t, a zero time when none exists within five years.
+func (schedule *ZeroMfgrcCronSchedule) Next(t time.Time) time.Time {
+	origin := t.Location()
+	t = t.In(schedule.location)
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	yearLimit := t.Year() + 5
+
+	for t.Year() <= yearLimit {
+		if schedule.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, schedule.location)
+			continue
+		}
+		if !schedule.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, schedule.location)
+			continue
+		}
+		if schedule.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(time.Duration(60-t.Minute())*time.Minute - time.Duration(t.Second())*time.Second)
+			continue
+		}
+		if schedule.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Duration(60-t.Second()) * time.Second)
+			continue
+		}
+		if schedule.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t.In(origin)
+	}
+	return time.Time{}
+}
+
+// Prev returns the last activation strictly before t, a zero time when none exists within five years.
+func (schedule *ZeroMfgrcCronSchedule) Prev(t time.Time) time.Time {
+	origin := t.Location()
+	t = t.In(schedule.location)
+	if t.Nanosecond() > 0 {
+		t = t.Add(-time.Duration(t.Nanosecond()))
+	} else {
+		t = t.Add(-time.Second)
+	}
+	yearLimit := t.Year() - 5
+
+	for t.Year() >= yearLimit {
+		if schedule.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, schedule.location).Add(-time.Second)
+			continue
+		}
+		if !schedule.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, schedule.location).Add(-time.Second)
+			continue
+		}
+		if schedule.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second()+1)*time.Second)
+			continue
+		}
+		if schedule.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(-time.Duration(t.Second()+1) * time.Second)
+			continue
+		}
+		if schedule.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(-time.Second)
+			continue
+		}
+		return t.In(origin)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,196 @@
+package mfgrc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMfgrcCron(t *testing.T) {
+	cases := []struct {
+		expr string
+		ok   bool
+	}{
+		{"* * * * * *", true},
+		{"0 30 9 * * MON-FRI", true},
+		{"30 9 * * 1-5", true},
+		{"*/15 * * * *", true},
+		{"0 0 1,15 * *", true},
+		{"0 0 * * 7", true},
+		{"0 0 1 JAN,jul *", true},
+		{"@daily", true},
+		{"@Hourly", true},
+		{"CRON_TZ=Asia/Shanghai 0 8 * * *", true},
+		{"TZ=UTC @monthly", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * * *", false},
+		{"60 * * * * *", false},
+		{"* 24 * * *", false},
+		{"0 0 0 * *", false},
+		{"0 0 * 13 *", false},
+		{"0 0 * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"0 0 * * FUN", false},
+		{"CRON_TZ=Mars/Base 0 8 * * *", false},
+		{"CRON_TZ=UTC", false},
+	}
+	for _, c := range cases {
+		_, err := ParseMfgrcCron(c.expr, time.UTC)
+		if c.ok && err != nil {
+			t.Errorf("ParseMfgrcCron(%q) unexpected error %s", c.expr, err.Error())
+		}
+		if !c.ok && err == nil {
+			t.Errorf("ParseMfgrcCron(%q) expected an error", c.expr)
+		}
+	}
+}
+
+func TestParseMfgrcCronLocation(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	schedule, err := ParseMfgrcCron("CRON_TZ=Asia/Shanghai 0 8 * * *", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule.Location().String() != shanghai.String() {
+		t.Fatalf("location %s, expected %s", schedule.Location(), shanghai)
+	}
+	next := schedule.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	expected := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if !next.Equal(expected) || next.Location() != time.UTC {
+		t.Fatalf("next %s, expected %s", next, expected)
+	}
+}
+
+func TestMfgrcCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	cases := []struct {
+		name     string
+		expr     string
+		location *time.Location
+		from     time.Time
+		next     time.Time
+	}{
+		{"every second", "* * * * * *", time.UTC,
+			time.Date(2024, 1, 1, 0, 0, 0, 500, time.UTC), time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)},
+		{"strictly after", "0 0 0 * * *", time.UTC,
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"day 31 skips short months", "0 0 31 * *", time.UTC,
+			time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", time.UTC,
+			time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"month end into next year", "0 0 1 * *", time.UTC,
+			time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"7 is sunday", "0 0 * * 7", time.UTC,
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 is sunday", "0 0 * * 0", time.UTC,
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"dom or dow", "0 0 15 * MON", time.UTC,
+			time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"dst gap skips missing hour", "0 30 2 * * *", newYork,
+			time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+		{"dst gap hourly", "0 0 * * * *", newYork,
+			time.Date(2024, 3, 10, 1, 30, 0, 0, newYork), time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC)},
+		{"dst fold", "0 0 * * * *", newYork,
+			time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), time.Date(2024, 11, 3, 6, 0, 0, 0, time.UTC)},
+		{"dst fold second pass", "0 0 * * * *", newYork,
+			time.Date(2024, 11, 3, 6, 0, 0, 0, time.UTC), time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", time.UTC,
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, c := range cases {
+		schedule, err := ParseMfgrcCron(c.expr, c.location)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err.Error())
+		}
+		next := schedule.Next(c.from)
+		if !next.Equal(c.next) {
+			t.Errorf("%s: Next(%s) = %s, expected %s", c.name, c.from, next, c.next)
+		}
+	}
+}
+
+func TestMfgrcCronPrev(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	cases := []struct {
+		name     string
+		expr     string
+		location *time.Location
+		from     time.Time
+		prev     time.Time
+	}{
+		{"strictly before", "0 0 0 * * *", time.UTC,
+			time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"same second", "* * * * * *", time.UTC,
+			time.Date(2024, 1, 1, 0, 0, 5, 500, time.UTC), time.Date(2024, 1, 1, 0, 0, 5, 0, time.UTC)},
+		{"day 31 skips short months", "0 0 31 * *", time.UTC,
+			time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"month end into last year", "0 59 23 31 12 *", time.UTC,
+			time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC)},
+		{"7 is sunday", "0 0 * * 7", time.UTC,
+			time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"dst gap", "0 0 * * * *", newYork,
+			time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC), time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", time.UTC,
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, c := range cases {
+		schedule, err := ParseMfgrcCron(c.expr, c.location)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err.Error())
+		}
+		prev := schedule.Prev(c.from)
+		if !prev.Equal(c.prev) {
+			t.Errorf("%s: Prev(%s) = %s, expected %s", c.name, c.from, prev, c.prev)
+		}
+	}
+}
+
+type xTestCronStore struct {
+	last time.Time
+}
+
+func (store *xTestCronStore) LastRun(string) (*time.Time, error) { return &store.last, nil }
+func (store *xTestCronStore) UpdateRun(string, time.Time) error  { return nil }
+
+func TestMfgrcCronCatchUpBounded(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 30, 0, time.UTC)
+	cases := []struct {
+		catchUp string
+		last    time.Time
+		queued  int
+		first   time.Time
+	}{
+		{MFGRC_CRON_CATCHUP_ALL, now.Add(-time.Minute*3 - time.Second*30), 3, time.Date(2024, 5, 31, 23, 58, 0, 0, time.UTC)},
+		{MFGRC_CRON_CATCHUP_ALL, now.AddDate(-1, 0, 0), xCRON_MAX_QUEUE, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).Add(-time.Minute * (xCRON_MAX_QUEUE - 1))},
+		{MFGRC_CRON_CATCHUP_LATEST, now.AddDate(-1, 0, 0), 1, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{MFGRC_CRON_CATCHUP_ALL, now.Add(-time.Second * 10), 0, time.Time{}},
+	}
+	for _, c := range cases {
+		keeper := NewCronKeeper("test", nil, nil).UseStore(&xTestCronStore{last: c.last})
+		job := &ZeroMfgrcCronJob{Name: "job", CatchUp: c.catchUp}
+		job.schedule, _ = ParseMfgrcCron("* * * * *", time.UTC)
+		keeper.prepareJob(job, now)
+		if len(job.queue) != c.queued {
+			t.Errorf("%s since %s queued %d, expected %d", c.catchUp, c.last, len(job.queue), c.queued)
+			continue
+		}
+		if c.queued > 0 && !job.queue[0].Equal(c.first) {
+			t.Errorf("%s since %s first %s, expected %s", c.catchUp, c.last, job.queue[0], c.first)
+		}
+		for i := 1; i < len(job.queue); i++ {
+			if !job.queue[i].After(job.queue[i-1]) {
+				t.Errorf("%s since %s queue out of order at %d", c.catchUp, c.last, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package mfgrc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+)
+
+const (
+	MFGRC_CRON_OVERLAP_SKIP    = "skip"
+	MFGRC_CRON_OVERLAP_QUEUE   = "queue"
+	MFGRC_CRON_OVERLAP_REPLACE = "replace"
+)
+
+const (
+	MFGRC_CRON_CATCHUP_NONE   = "none"
+	MFGRC_CRON_CATCHUP_LATEST = "latest"
+	MFGRC_CRON_CATCHUP_ALL    = "all"
+)
+
+const xCRON_MAX_QUEUE = 128
+
+// ZeroMfgrcCronStore keeps the last scheduled tick of every job, it is used to catch up missed runs after downtime.
+type ZeroMfgrcCronStore interface {
+	LastRun(string) (*time.Time, error)
+	UpdateRun(string, time.Time) error
+}
+
+type ZeroMfgrcCronJob struct {
+	Name     string
+	Expr     string
+	Overlap  string
+	CatchUp  string
+	Location *time.Location
+
+	newMono  func(string, time.Time) (MfgrcMono, error)
+	newGroup func(string, time.Time) (MfgrcGroup, error)
+
+	schedule  *ZeroMfgrcCronSchedule
+	paused    bool
+	next      time.Time
+	last      time.Time
+	queue     []time.Time
+	mono      MfgrcMono
+	group     MfgrcGroup
+	launching int
+	runs      int
+	skipped   int
+	xerr      string
+}
+
+// NewMfgrcCronMonoJob produces one mono per tick, newMono receives the job name and the tick time.
+func NewMfgrcCronMonoJob(name string, expr string, newMono func(string, time.Time) (MfgrcMono, error)) *ZeroMfgrcCronJob {
+	return &ZeroMfgrcCronJob{
+		Name:    name,
+		Expr:    expr,
+		Overlap: MFGRC_CRON_OVERLAP_SKIP,
+		CatchUp: MFGRC_CRON_CATCHUP_NONE,
+		newMono: newMono,
+	}
+}
+
+func NewMfgrcCronGroupJob(name string, expr string, newGroup func(string, time.Time) (MfgrcGroup, error)) *ZeroMfgrcCronJob {
+	return &ZeroMfgrcCronJob{
+		Name:     name,
+		Expr:     expr,
+		Overlap:  MFGRC_CRON_OVERLAP_SKIP,
+		CatchUp:  MFGRC_CRON_CATCHUP_NONE,
+		newGroup: newGroup,
+	}
+}
+
+// UseOverlap sets what a tick does while the last run is still going, replace revokes the running
+// mono and cancels its context before the new run starts.
+func (job *ZeroMfgrcCronJob) UseOverlap(overlap string) *ZeroMfgrcCronJob {
+	job.Overlap = overlap
+	return job
+}
+
+func (job *ZeroMfgrcCronJob) UseCatchUp(catchUp string) *ZeroMfgrcCronJob {
+	job.CatchUp = catchUp
+	return job
+}
+
+func (job *ZeroMfgrcCronJob) UseLocation(location *time.Location) *ZeroMfgrcCronJob {
+	job.Location = location
+	return job
+}
+
+// running also counts runs admitted but not yet submitted, so a tick and a Trigger never both start.
+func (job *ZeroMfgrcCronJob) running() bool {
+	if job.launching > 0 {
+		return true
+	}
+	if job.mono != nil {
+		switch job.mono.State() {
+		case WORKER_MONO_STATUS_COMPLETE, WORKER_MONO_STATUS_FAILED, WORKER_MONO_STATUS_REVOKE, WORKER_MONO_STATUS_TIMEOUT:
+			return false
+		}
+		return true
+	}
+	if job.group != nil {
+		switch job.group.State() {
+		case WORKER_MONOGROUP_STATUS_COMPLETE, WORKER_MONOGROUP_STATUS_FAILED:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func (job *ZeroMfgrcCronJob) Export() map[string]interface{} {
+	exportMap := make(map[string]interface{})
+	exportMap["name"] = job.Name
+	exportMap["expr"] = job.Expr
+	exportMap["overlap"] = job.Overlap
+	exportMap["catchUp"] = job.CatchUp
+	exportMap["location"] = job.schedule.Location().String()
+	exportMap["paused"] = job.paused
+	exportMap["running"] = job.running()
+	exportMap["runs"] = job.runs
+	exportMap["skipped"] = job.skipped
+	exportMap["queued"] = len(job.queue)
+	if !job.next.IsZero() && !job.paused {
+		exportMap["next"] = job.next.Format(time.RFC3339)
+	}
+	if !job.last.IsZero() {
+		exportMap["last"] = job.last.Format(time.RFC3339)
+	}
+	if job.mono != nil {
+		exportMap["monoId"] = job.mono.XmonoId()
+		exportMap["state"] = job.mono.State()
+	}
+	if job.group != nil {
+		exportMap["groupId"] = job.group.XgroupId()
+		exportMap["state"] = job.group.State()
+	}
+	if len(job.xerr) > 0 {
+		exportMap["reason"] = job.xerr
+	}
+	return exportMap
+}
+
+type xMfgrcCronRun struct {
+	job     *ZeroMfgrcCronJob
+	tick    time.Time
+	replace bool
+	manual  bool
+}
+
+// ZeroMfgrcCronKeeper fires cron jobs into a mono keeper or a group keeper.
+type ZeroMfgrcCronKeeper struct {
+	keeperName  string
+	monoKeeper  *ZeroMfgrcKeeper
+	groupKeeper *ZeroMfgrcGroupKeeper
+	store       ZeroMfgrcCronStore
+	location    *time.Location
+
+	jobs     map[string]*ZeroMfgrcCronJob
+	jobMutex sync.Mutex
+	wake     chan struct{}
+
+	status      string
+	statusMutex sync.RWMutex
+}
+
+func NewCronKeeper(keeperName string, monoKeeper *ZeroMfgrcKeeper, groupKeeper *ZeroMfgrcGroupKeeper) *ZeroMfgrcCronKeeper {
+	return &ZeroMfgrcCronKeeper{
+		keeperName:  keeperName,
+		monoKeeper:  monoKeeper,
+		groupKeeper: groupKeeper,
+		location:    time.Local,
+		jobs:        make(map[string]*ZeroMfgrcCronJob),
+		wake:        make(chan struct{}, 1),
+		status:      xKEEPER_STATUS_STOPPED,
+	}
+}
+
+func (keeper *ZeroMfgrcCronKeeper) UseStore(store ZeroMfgrcCronStore) *ZeroMfgrcCronKeeper {
+	keeper.store = store
+	return keeper
+}
+
+// UseLocation sets the default time zone of jobs without their own.
+func (keeper *ZeroMfgrcCronKeeper) UseLocation(location *time.Location) *ZeroMfgrcCronKeeper {
+	keeper.location = location
+	return keeper
+}
+
+func (keeper *ZeroMfgrcCronKeeper) running() bool {
+	keeper.statusMutex.RLock()
+	defer keeper.statusMutex.RUnlock()
+	return keeper.status == xKEEPER_STATUS_RUNNING
+}
+
+func (keeper *ZeroMfgrcCronKeeper) notify() {
+	select {
+	case keeper.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (keeper *ZeroMfgrcCronKeeper) AddJob(job *ZeroMfgrcCronJob) error {
+	if job.newMono == nil && job.newGroup == nil {
+		return fmt.Errorf("cron job `%s` produces nothing", job.Name)
+	}
+	if job.newMono != nil && keeper.monoKeeper == nil {
+		return fmt.Errorf("cron job `%s` requires a mono keeper", job.Name)
+	}
+	if job.newGroup != nil && keeper.groupKeeper == nil {
+		return fmt.Errorf("cron job `%s` requires a group keeper", job.Name)
+	}
+	switch job.Overlap {
+	case MFGRC_CRON_OVERLAP_SKIP, MFGRC_CRON_OVERLAP_QUEUE:
+	case MFGRC_CRON_OVERLAP_REPLACE:
+		if job.newGroup != nil {
+			return fmt.Errorf("cron job `%s` groups could not be replaced, use skip or queue", job.Name)
+		}
+	default:
+		return fmt.Errorf("cron job `%s` unknown overlap `%s`", job.Name, job.Overlap)
+	}
+	switch job.CatchUp {
+	case MFGRC_CRON_CATCHUP_NONE, MFGRC_CRON_CATCHUP_LATEST, MFGRC_CRON_CATCHUP_ALL:
+	default:
+		return fmt.Errorf("cron job `%s` unknown catch up `%s`", job.Name, job.CatchUp)
+	}
+	location := job.Location
+	if location == nil {
+		location = keeper.location
+	}
+	schedule, err := ParseMfgrcCron(job.Expr, location)
+	if err != nil {
+		return err
+	}
+	job.schedule = schedule
+
+	keeper.jobMutex.Lock()
+	defer keeper.jobMutex.Unlock()
+	if _, ok := keeper.jobs[job.Name]; ok {
+		return fmt.Errorf("cron job `%s` is already exists", job.Name)
+	}
+	keeper.jobs[job.Name] = job
+	if keeper.running() {
+		keeper.prepareJob(job, time.Now())
+		keeper.notify()
+	}
+	return nil
+}
+
+func (keeper *ZeroMfgrcCronKeeper) RemoveJob(name string) error {
+	keeper.jobMutex.Lock()
+	defer keeper.jobMutex.Unlock()
+	if _, ok := keeper.jobs[name]; !ok {
+		return fmt.Errorf("cron job `%s` not found", name)
+	}
+	delete(keeper.jobs, name)
+	return nil
+}
+
+// prepareJob plans the next tick and queues the runs missed since the stored last run, walking back from now
+// so at most xCRON_MAX_QUEUE ticks are visited, jobMutex must be held.
+func (keeper *ZeroMfgrcCronKeeper) prepareJob(job *ZeroMfgrcCronJob, now time.Time) {
+	job.next = job.schedule.Next(now)
+	if keeper.store == nil || job.CatchUp == MFGRC_CRON_CATCHUP_NONE {
+		return
+	}
+	last, err := keeper.store.LastRun(job.Name)
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("cron job `%s` fetch last run err : %s", job.Name, err.Error()))
+		return
+	}
+	if last == nil {
+		return
+	}
+	job.last = *last
+	limit := xCRON_MAX_QUEUE
+	if job.CatchUp == MFGRC_CRON_CATCHUP_LATEST {
+		limit = 1
+	}
+	missed := make([]time.Time, 0)
+	for tick := job.schedule.Prev(now.Add(time.Nanosecond)); !tick.IsZero() && tick.After(*last) && len(missed) < limit; tick = job.schedule.Prev(tick) {
+		missed = append(missed, tick)
+	}
+	if len(missed) == 0 {
+		return
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].Before(missed[j]) })
+	global.Logger().Info(fmt.Sprintf("cron job `%s` catch up %d missed runs since %s", job.Name, len(missed), last.Format(time.RFC3339)))
+	job.queue = append(job.queue, missed...)
+}
+
+func (keeper *ZeroMfgrcCronKeeper) RunCronWorker() {
+	if len(keeper.keeperName) == 0 {
+		keeper.keeperName = "default"
+	}
+	keeper.statusMutex.Lock()
+	keeper.status = xKEEPER_STATUS_RUNNING
+	keeper.statusMutex.Unlock()
+
+	now := time.Now()
+	keeper.jobMutex.Lock()
+	for _, job := range keeper.jobs {
+		keeper.prepareJob(job, now)
+	}
+	keeper.jobMutex.Unlock()
+
+	global.Logger().Info(fmt.Sprintf("[%s] cron worker start with %d jobs", keeper.keeperName, len(keeper.jobs)))
+	go keeper.runLoop()
+}
+
+func (keeper *ZeroMfgrcCronKeeper) ShutdownCronWorker() {
+	keeper.statusMutex.Lock()
+	keeper.status = xKEEPER_STATUS_STOPPED
+	keeper.statusMutex.Unlock()
+	keeper.notify()
+}
+
+func (keeper *ZeroMfgrcCronKeeper) runLoop() {
+	for keeper.running() {
+		runs, wait := keeper.dueRuns(time.Now())
+		for _, run := range runs {
+			keeper.submit(run)
+		}
+		if len(runs) > 0 {
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-keeper.wake:
+			timer.Stop()
+		}
+	}
+	global.Logger().Info(fmt.Sprintf("[%s] warning! cron worker is shutdown now", keeper.keeperName))
+}
+
+// admit applies the overlap policy to one tick, jobMutex must be held.
+func (keeper *ZeroMfgrcCronKeeper) admit(job *ZeroMfgrcCronJob, tick time.Time, busy bool) (*xMfgrcCronRun, error) {
+	if !busy {
+		job.launching++
+		return &xMfgrcCronRun{job: job, tick: tick}, nil
+	}
+	switch job.Overlap {
+	case MFGRC_CRON_OVERLAP_QUEUE:
+		if len(job.queue) >= xCRON_MAX_QUEUE {
+			job.skipped++
+			return nil, fmt.Errorf("cron job `%s` queue is full", job.Name)
+		}
+		job.queue = append(job.queue, tick)
+		return nil, nil
+	case MFGRC_CRON_OVERLAP_REPLACE:
+		job.launching++
+		return &xMfgrcCronRun{job: job, tick: tick, replace: true}, nil
+	default:
+		job.skipped++
+		return nil, fmt.Errorf("cron job `%s` is still running", job.Name)
+	}
+}
+
+// dueRuns collects the runs to submit now and how long to sleep, a job with queued runs is polled every second.
+func (keeper *ZeroMfgrcCronKeeper) dueRuns(now time.Time) ([]*xMfgrcCronRun, time.Duration) {
+	keeper.jobMutex.Lock()
+	defer keeper.jobMutex.Unlock()
+
+	names := make([]string, 0, len(keeper.jobs))
+	for name := range keeper.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	runs := make([]*xMfgrcCronRun, 0)
+	wait := time.Minute
+	for _, name := range names {
+		job := keeper.jobs[name]
+		if job.paused {
+			continue
+		}
+		busy := job.running()
+		if !busy && len(job.queue) > 0 {
+			runs = append(runs, &xMfgrcCronRun{job: job, tick: job.queue[0]})
+			job.queue = job.queue[1:]
+			job.launching++
+			busy = true
+		}
+		for !job.next.IsZero() && !job.next.After(now) {
+			tick := job.next
+			job.next = job.schedule.Next(tick)
+			run, err := keeper.admit(job, tick, busy || len(job.queue) > 0)
+			if err != nil {
+				global.Logger().Warn(fmt.Sprintf("%s, tick %s skipped", err.Error(), tick.Format(time.RFC3339)))
+			}
+			if run != nil {
+				runs = append(runs, run)
+				busy = true
+			}
+		}
+		if !job.next.IsZero() && job.next.Sub(now) < wait {
+			wait = job.next.Sub(now)
+		}
+		if len(job.queue) > 0 && wait > time.Second {
+			wait = time.Second
+		}
+	}
+	return runs, wait
+}
+
+func (keeper *ZeroMfgrcCronKeeper) submit(run *xMfgrcCronRun) {
+	job := run.job
+	if run.replace {
+		keeper.jobMutex.Lock()
+		mono := job.mono
+		keeper.jobMutex.Unlock()
+		if mono != nil {
+			err := keeper.monoKeeper.RevokeMono(mono)
+			if err != nil {
+				global.Logger().Warn(fmt.Sprintf("cron job `%s` replace mono `%s` err : %s", job.Name, mono.XmonoId(), err.Error()))
+			}
+		}
+	}
+
+	var mono MfgrcMono
+	var group MfgrcGroup
+	var err error
+	if job.newMono != nil {
+		mono, err = job.newMono(job.Name, run.tick)
+		if err == nil {
+			err = mono.Ready(keeper.monoKeeper)
+		}
+		if err == nil {
+			err = keeper.monoKeeper.AddMono(mono)
+		}
+	} else {
+		group, err = job.newGroup(job.Name, run.tick)
+		if err == nil {
+			err = group.Ready(group.UseStore())
+		}
+		if err == nil {
+			err = keeper.groupKeeper.AddGroup(group)
+		}
+	}
+
+	keeper.jobMutex.Lock()
+	job.launching--
+	job.runs++
+	job.xerr = ""
+	if err != nil {
+		job.xerr = err.Error()
+		mono, group = nil, nil
+	}
+	job.mono = mono
+	job.group = group
+	if !run.manual && run.tick.After(job.last) {
+		job.last = run.tick
+	}
+	keeper.jobMutex.Unlock()
+
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("cron job `%s` tick %s err : %s", job.Name, run.tick.Format(time.RFC3339), err.Error()))
+	} else {
+		global.Logger().Info(fmt.Sprintf("cron job `%s` tick %s submitted", job.Name, run.tick.Format(time.RFC3339)))
+	}
+	if keeper.store != nil && !run.manual {
+		xerr := keeper.store.UpdateRun(job.Name, run.tick)
+		if xerr != nil {
+			global.Logger().Error(fmt.Sprintf("cron job `%s` update last run err : %s", job.Name, xerr.Error()))
+		}
+	}
+}
+
+func (keeper *ZeroMfgrcCronKeeper) Pause(name string) error {
+	keeper.jobMutex.Lock()
+	defer keeper.jobMutex.Unlock()
+	job, ok := keeper.jobs[name]
+	if !ok {
+		return fmt.Errorf("cron job `%s` not found", name)
+	}
+	job.paused = true
+	return nil
+}
+
+func (keeper *ZeroMfgrcCronKeeper) Resume(name string) error {
+	keeper.jobMutex.Lock()
+	defer keeper.jobMutex.Unlock()
+	job, ok := keeper.jobs[name]
+	if !ok {
+		return fmt.Errorf("cron job `%s` not found", name)
+	}
+	if job.paused {
+		job.paused = false
+		job.next = job.schedule.Next(time.Now())
+		keeper.notify()
+	}
+	return nil
+}
+
+// Trigger runs a job once now, a running job is only replaced under the replace policy
+// and a manual run is not recorded as the last tick.
+func (keeper *ZeroMfgrcCronKeeper) Trigger(name string) error {
+	if !keeper.running() {
+		return errors.New("cron keeper not yet ready")
+	}
+	keeper.jobMutex.Lock()
+	job, ok := keeper.jobs[name]
+	if !ok {
+		keeper.jobMutex.Unlock()
+		return fmt.Errorf("cron job `%s` not found", name)
+	}
+	busy := job.running()
+	if busy && job.Overlap != MFGRC_CRON_OVERLAP_REPLACE {
+		keeper.jobMutex.Unlock()
+		return fmt.Errorf("cron job `%s` is still running", job.Name)
+	}
+	run := &xMfgrcCronRun{job: job, tick: time.Now(), replace: busy, manual: true}
+	job.launching++
+	keeper.jobMutex.Unlock()
+	keeper.submit(run)
+	return nil
+}
+
+func (keeper *ZeroMfgrcCronKeeper) Jobs() []map[string]interface{} {
+	keeper.jobMutex.Lock()
+	defer keeper.jobMutex.Unlock()
+	names := make([]string, 0, len(keeper.jobs))
+	for name := range keeper.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	jobs := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		jobs = append(jobs, keeper.jobs[name].Export())
+	}
+	return jobs
+}
+
+func (keeper *ZeroMfgrcCronKeeper) Export() (map[string]interface{}, error) {
+	keeper.statusMutex.RLock()
+	status := keeper.status
+	keeper.statusMutex.RUnlock()
+
+	exports := make(map[string]interface{})
+	exports["keeperName"] = keeper.keeperName
+	exports["location"] = keeper.location.String()
+	exports["status"] = status
+	exports["jobs"] = keeper.Jobs()
+	return exports, nil
+}
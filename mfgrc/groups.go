@@ -30,13 +30,16 @@ type ZeroMfgrcGroup struct {
 	Option     string `json:"option,omitempty" xhttpopt:"XX" xsacprop:"NO,VARCHAR(64),NULL" xsackey:"key" xapi:"操作类型,String"`
 	Operator   string `json:"operator,omitempty" xhttpopt:"XX" xsacprop:"NO,VARCHAR(32),NULL" xsackey:"key" xapi:"操作人,String"`
 
+	Priority int `json:"priority,omitempty" xsacprop:"YES,INT,0" xapi:"优先级,Int"`
+
 	Monos []MfgrcMono `json:"monos,omitempty"`
 
 	status string
 	reason string
 
-	xStore    ZeroMfgrcGroupStore
-	xListener ZeroMfgrcGroupEventListener
+	xStore      ZeroMfgrcGroupStore
+	xListener   ZeroMfgrcGroupEventListener
+	retryPolicy *ZeroMfgrcRetryPolicy
 }
 
 func (*ZeroMfgrcGroup) XhttpPath() string     { return "Xdenied" }
@@ -56,6 +59,7 @@ func (group *ZeroMfgrcGroup) LoadRowData(rowmap map[string]interface{}) {
 	group.Option = structs.ParseStringField(rowmap, "option")
 	group.Operator = structs.ParseStringField(rowmap, "operator")
 	group.status = structs.ParseStringField(rowmap, "status")
+	group.Priority = structs.ParseIntField(rowmap, "priority")
 
 	reason, ok := group.Features["reason"]
 	if ok {
@@ -88,6 +92,10 @@ func (group *ZeroMfgrcGroup) Xmonos() []MfgrcMono {
 
 func (group *ZeroMfgrcGroup) XLinkTable() string { return "" }
 
+func (group *ZeroMfgrcGroup) Xpriority() int {
+	return group.Priority
+}
+
 func (group *ZeroMfgrcGroup) State() string {
 	return group.status
 }
@@ -112,6 +120,15 @@ func (group *ZeroMfgrcGroup) Do() error {
 	return errors.New("not implemented")
 }
 
+// UseRetryPolicy overrides the group keeper retry policy.
+func (group *ZeroMfgrcGroup) UseRetryPolicy(policy *ZeroMfgrcRetryPolicy) {
+	group.retryPolicy = policy
+}
+
+func (group *ZeroMfgrcGroup) RetryPolicy() *ZeroMfgrcRetryPolicy {
+	return group.retryPolicy
+}
+
 func (group *ZeroMfgrcGroup) Ready(store ZeroMfgrcGroupStore) error {
 	group.status = WORKER_MONOGROUP_STATUS_READY
 	group.reason = ""
@@ -271,11 +288,12 @@ func (worker *ZeroMfgrcGroupWorker) Start() {
 	worker.statusMutex.Unlock()
 
 	global.Logger().Info(fmt.Sprintf("[%s] ready and waiting ...", worker.workName))
-	for xGroup := range worker.keeper.groupChan {
+	for {
+		xGroup, _ := worker.keeper.lanes.take().(MfgrcGroup)
 		worker.statusMutex.Lock()
 		xstatus := worker.status
 		worker.statusMutex.Unlock()
-		if xstatus != xWORKER_STATUS_RUNNING {
+		if xGroup == nil || xstatus != xWORKER_STATUS_RUNNING {
 			break
 		}
 
@@ -284,12 +302,18 @@ func (worker *ZeroMfgrcGroupWorker) Start() {
 			worker.executing = xGroup.XuniqueCode()
 
 			xGroup.AddWorker(worker)
-			err := xGroup.Executing()
+			var err error
+			if xGroup.State() != WORKER_MONOGROUP_STATUS_EXECUTING {
+				err = xGroup.Executing()
+			}
 			if err != nil {
 				xGroup.Failed(err)
 			} else {
 				err = xGroup.Do()
-				if err != nil {
+				if err != nil && worker.keeper.retryGroup(xGroup, err) {
+					worker.executing = ""
+					continue
+				} else if err != nil {
 					xGroup.Failed(err)
 				} else {
 					err = xGroup.Complete()
@@ -334,7 +358,7 @@ type ZeroMfgrcGroupKeeper struct {
 	workerMutex sync.RWMutex
 
 	groupMap   map[string]MfgrcGroup
-	groupChan  chan MfgrcGroup
+	lanes      *xMfgrcLanes
 	groupMutex sync.RWMutex
 
 	maxGroupQueues int
@@ -343,6 +367,9 @@ type ZeroMfgrcGroupKeeper struct {
 	statusMutex sync.RWMutex
 
 	keeperOpts ZeroMfgrcGroupKeeperOpts
+
+	retryPolicy *ZeroMfgrcRetryPolicy
+	retries     map[string]int
 }
 
 func NewGroupKeeper(keeperName string, keeperOpts ZeroMfgrcGroupKeeperOpts, maxGroupQueues int) *ZeroMfgrcGroupKeeper {
@@ -350,13 +377,59 @@ func NewGroupKeeper(keeperName string, keeperOpts ZeroMfgrcGroupKeeperOpts, maxG
 		keeperName:     keeperName,
 		workerMap:      make(map[string]*ZeroMfgrcGroupWorker),
 		groupMap:       make(map[string]MfgrcGroup),
-		groupChan:      make(chan MfgrcGroup, maxGroupQueues),
+		retries:        make(map[string]int),
+		lanes:          newMfgrcLanes(),
 		maxGroupQueues: maxGroupQueues,
 		status:         xKEEPER_STATUS_STOPPED,
 		keeperOpts:     keeperOpts,
 	}
 }
 
+func (keeper *ZeroMfgrcGroupKeeper) UsePriorityPolicy(policy *ZeroMfgrcPriorityPolicy) *ZeroMfgrcGroupKeeper {
+	keeper.lanes.usePolicy(policy)
+	return keeper
+}
+
+func (keeper *ZeroMfgrcGroupKeeper) UseRetryPolicy(policy *ZeroMfgrcRetryPolicy) *ZeroMfgrcGroupKeeper {
+	keeper.retryPolicy = policy
+	return keeper
+}
+
+// retryGroup requeues a failed group after its backoff delay, the group keeps its unique code busy meanwhile.
+func (keeper *ZeroMfgrcGroupKeeper) retryGroup(group MfgrcGroup, err error) bool {
+	policy := group.RetryPolicy()
+	if policy == nil {
+		policy = keeper.retryPolicy
+	}
+	if policy == nil || !policy.Retryable(err) {
+		return false
+	}
+
+	keeper.groupMutex.Lock()
+	retries := keeper.retries[group.XgroupId()]
+	if retries >= policy.MaxRetries {
+		keeper.groupMutex.Unlock()
+		return false
+	}
+	keeper.retries[group.XgroupId()] = retries + 1
+	keeper.groupMutex.Unlock()
+
+	delay := policy.Delay(retries + 1)
+	global.Logger().Info(fmt.Sprintf("group `%s` error : %s, retry %d/%d after %s", group.XgroupId(), err.Error(), retries+1, policy.MaxRetries, delay))
+	time.AfterFunc(delay, func() {
+		keeper.statusMutex.RLock()
+		xStatus := keeper.status
+		keeper.statusMutex.RUnlock()
+		if xStatus != xKEEPER_STATUS_RUNNING {
+			group.Failed(fmt.Errorf("keeper is %s, abort retry, lastest error: %s", xStatus, err.Error()))
+			keeper.closeGroup(group)
+			return
+		}
+		keeper.lanes.push(group, group.Xpriority())
+	})
+	return true
+}
+
 func (keeper *ZeroMfgrcGroupKeeper) RunGroupWorker() {
 	global.Logger().Info(fmt.Sprintf("workergroup start with maxGroupQueues: %d", keeper.maxGroupQueues))
 
@@ -417,6 +490,7 @@ func (keeper *ZeroMfgrcGroupKeeper) closeWorker(worker *ZeroMfgrcGroupWorker) {
 func (keeper *ZeroMfgrcGroupKeeper) closeGroup(group MfgrcGroup) {
 	keeper.groupMutex.Lock()
 	delete(keeper.groupMap, group.XuniqueCode())
+	delete(keeper.retries, group.XgroupId())
 	keeper.groupMutex.Unlock()
 }
 
@@ -441,7 +515,7 @@ func (keeper *ZeroMfgrcGroupKeeper) AddGroup(group MfgrcGroup) error {
 		keeper.groupMutex.Unlock()
 
 		group.Pending()
-		keeper.groupChan <- group
+		keeper.lanes.push(group, group.Xpriority())
 	} else {
 		return fmt.Errorf("unique code `%s` is busy now", group.XuniqueCode())
 	}
@@ -481,6 +555,7 @@ func (keeper *ZeroMfgrcGroupKeeper) Export() (map[string]interface{}, error) {
 	configs := make(map[string]interface{})
 
 	configs["maxGroupQueues"] = keeper.maxGroupQueues
+	configs["priorityMode"] = keeper.lanes.mode()
 	keeper.statusMutex.RLock()
 	configs["status"] = keeper.status
 	keeper.statusMutex.RUnlock()
@@ -507,6 +582,7 @@ func (keeper *ZeroMfgrcGroupKeeper) Export() (map[string]interface{}, error) {
 	exports["configs"] = configs
 	exports["workers"] = workers
 	exports["groups"] = groups
+	exports["priorities"] = keeper.lanes.depth()
 
 	return exports, nil
 }
@@ -1,6 +1,7 @@
 package mfgrc
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -162,6 +163,8 @@ type MfgrcXhttpExecutor struct {
 	MonoType   reflect.Type
 	MonoStore  ZeroMfgrcMonoStore
 
+	CronKeeper string
+
 	OnMonoReady   func(MfgrcMono, map[string]any)
 	OnMonoSuccess func(MfgrcMono) any
 	OnMonoFailed  func(MfgrcMono, map[string]any) string
@@ -245,8 +248,12 @@ func (xhttpExecutor *MfgrcXhttpExecutor) uXmonoPerformed(
 		act := &ZeroMfgrcMonoActuator{Keeper: keeper}
 		select {
 		case err := <-act.Exec(mono):
+			var timeoutErr *ZeroMfgrcTimeoutError
 			if err != nil {
 				expands["state"] = "error"
+				if errors.As(err, &timeoutErr) {
+					expands["state"] = "timeout"
+				}
 				if onFailed != nil {
 					_err := onFailed(mono, expands)
 					if _err != "" {
@@ -490,6 +497,55 @@ func (xhttpExecutor *MfgrcXhttpExecutor) monoc(writer http.ResponseWriter, req *
 	xhttpExecutor.uXmonoPerformed(writer, xRequest, k, mono, xhttpExecutor.OnMonoReady, xhttpExecutor.OnMonoSuccess, xhttpExecutor.OnMonoFailed)
 }
 
+// forward accepts a mono forwarded by another node of the cluster, it is queued here as it was exported.
+func (xhttpExecutor *MfgrcXhttpExecutor) forward(writer http.ResponseWriter, req *http.Request) {
+	mono := xhttpExecutor.newMono()
+	xRequest, err := server.XhttpZeroRequest(req)
+	if err == nil {
+		err = xhttpExecutor.uXmonoForward(xRequest, global.Value(xhttpExecutor.MonoKeeper).(*ZeroMfgrcKeeper), mono)
+	}
+	if err != nil {
+		global.Logger().ErrorS(err)
+		server.XhttpResponseMessages(writer, 500, err.Error())
+		return
+	}
+	expands := make(map[string]interface{})
+	expands["monoId"] = mono.XmonoId()
+	expands["state"] = "forwarded"
+	server.XhttpResponseDatas(writer, 200, "success", make([]interface{}, 0), expands)
+}
+
+func (xhttpExecutor *MfgrcXhttpExecutor) uXmonoForward(xRequest *structs.ZeroRequest, keeper *ZeroMfgrcKeeper, mono MfgrcMono) error {
+	if len(xRequest.Querys) != 1 {
+		return errors.New(" no support multiple tasks or task is empty ")
+	}
+	exports, ok := xRequest.Querys[0].(map[string]interface{})
+	if !ok {
+		return errors.New(" forwarded mono is not an object ")
+	}
+	jsonbytes, err := json.Marshal(exports)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(jsonbytes, mono)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(mono.XmonoId()) == "" || strings.TrimSpace(mono.XuniqueCode()) == "" {
+		return errors.New(" `monoID` or `uniqueCode` is empty ")
+	}
+	mono.ThisDef(mono)
+	xMono, ok := mono.(ZeroMfgrcForwardedMono)
+	if !ok {
+		return fmt.Errorf(" mono `%s` could not be forwarded ", mono.XmonoId())
+	}
+	err = xMono.Forwarded(keeper, exports, xhttpExecutor.MonoStore)
+	if err != nil {
+		return err
+	}
+	return keeper.AddMono(mono)
+}
+
 func (xhttpExecutor *MfgrcXhttpExecutor) revoke(writer http.ResponseWriter, req *http.Request) {
 	transaction := global.Value(xhttpExecutor.DataSource).(database.DataSource).Transaction()
 	defer func() {
@@ -585,9 +641,73 @@ func (xhttpExecutor *MfgrcXhttpExecutor) state(writer http.ResponseWriter, _ *ht
 		expands["groups"] = groupexp
 	}
 
+	cronKeeper := global.Value(xhttpExecutor.CronKeeper)
+	if cronKeeper != nil {
+		cronexp, err := cronKeeper.(*ZeroMfgrcCronKeeper).Export()
+		if err != nil {
+			panic(err)
+		}
+		expands["crons"] = cronexp
+	}
+
 	server.XhttpResponseDatas(writer, 200, "success", make([]interface{}, 0), expands)
 }
 
+func (xhttpExecutor *MfgrcXhttpExecutor) crons(writer http.ResponseWriter, _ *http.Request) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			global.Logger().ErrorS(err.(error))
+			server.XhttpResponseMessages(writer, 500, err.(error).Error())
+		}
+	}()
+
+	jobs := global.Value(xhttpExecutor.CronKeeper).(*ZeroMfgrcCronKeeper).Jobs()
+	server.XhttpResponseMaps(writer, 200, "success", jobs, nil)
+}
+
+// cronc applies operation to every job named in querys, a query is a job name or an object with `name`.
+func (xhttpExecutor *MfgrcXhttpExecutor) cronc(operation func(*ZeroMfgrcCronKeeper, string) error) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		defer func() {
+			err := recover()
+			if err != nil {
+				global.Logger().ErrorS(err.(error))
+				server.XhttpResponseMessages(writer, 500, err.(error).Error())
+			}
+		}()
+
+		xRequest, err := server.XhttpZeroRequest(req)
+		if err != nil {
+			panic(err)
+		}
+		if len(xRequest.Querys) <= 0 {
+			panic(errors.New("missing necessary parameter `query[0]`"))
+		}
+
+		k := global.Value(xhttpExecutor.CronKeeper).(*ZeroMfgrcCronKeeper)
+		expands := make(map[string]interface{})
+		for _, query := range xRequest.Querys {
+			name, ok := query.(string)
+			if !ok {
+				if xQuery, isMap := query.(map[string]interface{}); isMap {
+					name, _ = xQuery["name"].(string)
+				}
+			}
+			if strings.TrimSpace(name) == "" {
+				panic(errors.New(" cron job `name` is empty "))
+			}
+			err = operation(k, name)
+			if err != nil {
+				expands[name] = err.Error()
+			} else {
+				expands[name] = "success"
+			}
+		}
+		server.XhttpResponseDatas(writer, 200, "success", make([]interface{}, 0), expands)
+	}
+}
+
 func (xhttpExecutor *MfgrcXhttpExecutor) checkzone(xRequest *structs.ZeroRequest) (string, string) {
 	if len(xRequest.Querys) <= 0 {
 		panic(errors.New("missing necessary parameter `query[0]`"))
@@ -734,11 +854,10 @@ func (xhttpExecutor *MfgrcXhttpExecutor) monohistory(writer http.ResponseWriter,
 	server.XhttpResponseMaps(writer, 200, "success", monos, expands)
 }
 
-func (xhttpExecutor *MfgrcXhttpExecutor) Exports() []*server.XhttpExecutor {
-	executors := make([]*server.XhttpExecutor, 0)
+func xhttpPrefix(xPrefix string) string {
 	prefix := ""
-	if strings.TrimSpace(xhttpExecutor.Prefix) != "" {
-		prefix = strings.TrimSpace(xhttpExecutor.Prefix)
+	if strings.TrimSpace(xPrefix) != "" {
+		prefix = strings.TrimSpace(xPrefix)
 		if prefix[:1] == "/" {
 			prefix = prefix[1:]
 		}
@@ -746,6 +865,12 @@ func (xhttpExecutor *MfgrcXhttpExecutor) Exports() []*server.XhttpExecutor {
 			prefix = fmt.Sprintf("%s/", prefix)
 		}
 	}
+	return prefix
+}
+
+func (xhttpExecutor *MfgrcXhttpExecutor) Exports() []*server.XhttpExecutor {
+	executors := make([]*server.XhttpExecutor, 0)
+	prefix := xhttpPrefix(xhttpExecutor.Prefix)
 
 	if xhttpExecutor.GroupType != nil {
 		executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.groupc, fmt.Sprintf("%sworker/group", prefix)))
@@ -756,8 +881,81 @@ func (xhttpExecutor *MfgrcXhttpExecutor) Exports() []*server.XhttpExecutor {
 		executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.monoc, fmt.Sprintf("%sworker/push", prefix)))
 		executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.monohistory, fmt.Sprintf("%shistory/mono", prefix)))
 		executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.revoke, fmt.Sprintf("%sworker/revoke", prefix)))
+		executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.forward, fmt.Sprintf("%sworker/forward", prefix)))
+	}
+
+	if xhttpExecutor.CronKeeper != "" {
+		executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.crons, fmt.Sprintf("%sworker/cron", prefix)))
+		executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.cronc((*ZeroMfgrcCronKeeper).Pause), fmt.Sprintf("%sworker/cron/pause", prefix)))
+		executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.cronc((*ZeroMfgrcCronKeeper).Resume), fmt.Sprintf("%sworker/cron/resume", prefix)))
+		executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.cronc((*ZeroMfgrcCronKeeper).Trigger), fmt.Sprintf("%sworker/cron/trigger", prefix)))
 	}
 
 	executors = append(executors, server.XhttpFuncHandle(xhttpExecutor.state, fmt.Sprintf("%sworker/state", prefix)))
 	return executors
 }
+
+// ZeroMfgrcXhttpForwarder posts monos to the worker/forward endpoint of the owning node,
+// Resolve maps a node id to its base address such as http://10.0.0.2:8080.
+// Listeners on the forwarding node are not notified, the owner executes and stores the mono.
+type ZeroMfgrcXhttpForwarder struct {
+	Prefix  string
+	Resolve func(string) (string, error)
+
+	client *http.Client
+}
+
+func NewMfgrcXhttpForwarder(prefix string, resolve func(string) (string, error)) *ZeroMfgrcXhttpForwarder {
+	return &ZeroMfgrcXhttpForwarder{
+		Prefix:  prefix,
+		Resolve: resolve,
+		client:  &http.Client{Timeout: time.Second * time.Duration(xCLUSTER_LEASE_TTL)},
+	}
+}
+
+// UseTimeout bounds each forward request, the cluster sets it from its ForwardTimeout.
+func (forwarder *ZeroMfgrcXhttpForwarder) UseTimeout(timeout time.Duration) {
+	forwarder.client = &http.Client{Timeout: timeout}
+}
+
+func (forwarder *ZeroMfgrcXhttpForwarder) ForwardMono(node string, mono MfgrcMono) error {
+	address, err := forwarder.Resolve(node)
+	if err != nil {
+		return err
+	}
+	monoMap, err := mono.Export()
+	if err != nil {
+		return err
+	}
+	jsonbytes, err := json.Marshal(&structs.ZeroRequest{Querys: []interface{}{monoMap}})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", fmt.Sprintf("%s/%sworker/forward", strings.TrimSuffix(address, "/"), xhttpPrefix(forwarder.Prefix)), bytes.NewBuffer(jsonbytes))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	request.Header.Set("Connection", "close")
+
+	client := forwarder.client
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * time.Duration(xCLUSTER_LEASE_TTL)}
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		xResponse := &structs.ZeroResponse{}
+		err = json.NewDecoder(resp.Body).Decode(xResponse)
+		if err == nil && xResponse.Message != "" {
+			return fmt.Errorf("forward mono `%s` to node `%s` failed : %s", mono.XmonoId(), node, xResponse.Message)
+		}
+		return fmt.Errorf("forward mono `%s` to node `%s` failed status code : %d", mono.XmonoId(), node, resp.StatusCode)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package mfgrc
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -27,11 +28,15 @@ type MfgrcMono interface {
 	Xoption() string
 	Xprogress() int
 	Xoperator() string
+	Xpriority() int
+	XnotBefore() *time.Time
 
 	State() string
 
 	Ready(*ZeroMfgrcKeeper, ...ZeroMfgrcMonoStore) error
+	Scheduled() error
 	Pending(*ZeroMfgrcFlux) error
+	Resume(*ZeroMfgrcKeeper) error
 	Revoke() error
 	Delete() error
 	Timeout() error
@@ -41,8 +46,11 @@ type MfgrcMono interface {
 	Failed(error) error
 
 	Do() error
+	DoContext(context.Context) error
 
 	MaxExecuteTimes() int
+	ExecuteTimes() int
+	RetryPolicy() *ZeroMfgrcRetryPolicy
 	Export() (map[string]interface{}, error)
 
 	Store(ZeroMfgrcMonoStore)
@@ -58,6 +66,7 @@ type MfgrcGroup interface {
 	Xoperator() string
 	Xmonos() []MfgrcMono
 	XLinkTable() string
+	Xpriority() int
 
 	State() string
 	AddWorker(*ZeroMfgrcGroupWorker)
@@ -69,6 +78,7 @@ type MfgrcGroup interface {
 	Failed(error) error
 
 	Do() error
+	RetryPolicy() *ZeroMfgrcRetryPolicy
 	Export() (map[string]interface{}, error)
 
 	Store(ZeroMfgrcGroupStore)
@@ -90,6 +100,39 @@ type ZeroMfgrcMonoEventListener interface {
 	OnFailed(MfgrcMono, error) error
 }
 
+type ZeroMfgrcMonoScheduleListener interface {
+	OnScheduled(MfgrcMono) error
+}
+
+type ZeroMfgrcMonoTimeoutListener interface {
+	OnTimeout(MfgrcMono, error) error
+}
+
+// ZeroMfgrcMonoUpdated reports when a mono was last stored, cluster takeover waits one lease ttl after it.
+type ZeroMfgrcMonoUpdated interface {
+	XupdateTime() *time.Time
+}
+
+// ZeroMfgrcTimeoutError Abandoned marks a Do still running after the grace wait, such a mono is never retried.
+type ZeroMfgrcTimeoutError struct {
+	MonoId      string
+	WaitSeconds int
+	Abandoned   bool
+
+	done <-chan error
+}
+
+func (timeoutErr *ZeroMfgrcTimeoutError) Error() string {
+	if timeoutErr.Abandoned {
+		return fmt.Sprintf("mono `%s` execution timeout after %ds and abandoned", timeoutErr.MonoId, timeoutErr.WaitSeconds)
+	}
+	return fmt.Sprintf("mono `%s` execution timeout after %ds", timeoutErr.MonoId, timeoutErr.WaitSeconds)
+}
+
+func (timeoutErr *ZeroMfgrcTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
 type ZeroMfgrcGroupEventListener interface {
 	OnPending(MfgrcGroup) error
 	OnExecuting(MfgrcGroup) error
@@ -160,6 +203,12 @@ func (act *ZeroMfgrcMonoActuator) OnFailed(mono MfgrcMono, reason error) error {
 	}
 	return nil
 }
+func (act *ZeroMfgrcMonoActuator) OnTimeout(mono MfgrcMono, reason error) error {
+	if act.errchan != nil {
+		act.errchan <- reason
+	}
+	return nil
+}
 
 type ZeroMfgrcGroupActuator struct {
 	Keeper  *ZeroMfgrcGroupKeeper
@@ -277,6 +326,19 @@ func (act *ZeroMfgrcMonoQueueActuator) OnFailed(mono MfgrcMono, reason error) er
 	return nil
 }
 
+func (act *ZeroMfgrcMonoQueueActuator) OnTimeout(mono MfgrcMono, reason error) error {
+	if act.errchan == nil {
+		return nil
+	}
+	act.counterLock.Lock()
+	act.failed++
+	act.result[mono.XmonoId()] = reason.Error()
+	act.counterLock.Unlock()
+
+	act.check()
+	return nil
+}
+
 func (act *ZeroMfgrcMonoQueueActuator) Result() map[string]string {
 	return act.result
 }
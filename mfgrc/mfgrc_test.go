@@ -0,0 +1,27 @@
+package mfgrc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0meet1/zero-framework/global"
+)
+
+func TestMain(m *testing.M) {
+	cfgpath, err := os.MkdirTemp("", "mfgrc")
+	if err != nil {
+		panic(err)
+	}
+	err = os.MkdirAll(filepath.Join(cfgpath, "conf"), 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(cfgpath, "conf", "zero-framework.yml"), []byte("zero:\n  app: mfgrc\n"), 0644)
+	}
+	if err != nil {
+		panic(err)
+	}
+	global.RunTest("mfgrc", cfgpath)
+	code := m.Run()
+	os.RemoveAll(cfgpath)
+	os.Exit(code)
+}
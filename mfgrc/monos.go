@@ -1,8 +1,10 @@
 package mfgrc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/0meet1/zero-framework/autohttpconf"
 	"github.com/0meet1/zero-framework/errdef"
@@ -12,6 +14,7 @@ import (
 
 const (
 	WORKER_MONO_STATUS_READY     = "mono.status.ready"
+	WORKER_MONO_STATUS_SCHEDULED = "mono.status.scheduled"
 	WORKER_MONO_STATUS_PENDING   = "mono.status.pending"
 	WORKER_MONO_STATUS_EXECUTING = "mono.status.executing"
 	WORKER_MONO_STATUS_RETRYING  = "mono.status.retrying"
@@ -29,15 +32,18 @@ type ZeroMfgrcMono struct {
 	Option     string `json:"option,omitempty"`
 	Operator   string `json:"operator,omitempty"`
 
-	Progress int `json:"progress,omitempty"`
+	Progress  int           `json:"progress,omitempty"`
+	Priority  int           `json:"priority,omitempty"`
+	NotBefore *structs.Time `json:"notBefore,omitempty"`
 
 	status          string
 	reason          string
 	maxExecuteTimes int
 	executeTimes    int
 
-	xStore    ZeroMfgrcMonoStore
-	xListener ZeroMfgrcMonoEventListener
+	xStore      ZeroMfgrcMonoStore
+	xListener   ZeroMfgrcMonoEventListener
+	retryPolicy *ZeroMfgrcRetryPolicy
 
 	keeper   *ZeroMfgrcKeeper
 	fromFlux *ZeroMfgrcFlux
@@ -58,6 +64,8 @@ func (mono *ZeroMfgrcMono) LoadRowData(rowmap map[string]interface{}) {
 	mono.Option = structs.ParseStringField(rowmap, "option")
 	mono.Operator = structs.ParseStringField(rowmap, "operator")
 	mono.Progress = structs.ParseIntField(rowmap, "progress")
+	mono.Priority = structs.ParseIntField(rowmap, "priority")
+	mono.NotBefore = structs.ParseDateField(rowmap, "not_before")
 	mono.status = structs.ParseStringField(rowmap, "status")
 	mono.maxExecuteTimes = structs.ParseIntField(rowmap, "max_execute_times")
 	mono.executeTimes = structs.ParseIntField(rowmap, "execute_times")
@@ -90,6 +98,26 @@ func (mono *ZeroMfgrcMono) Xoperator() string {
 	return mono.Operator
 }
 
+func (mono *ZeroMfgrcMono) Xpriority() int {
+	return mono.Priority
+}
+
+func (mono *ZeroMfgrcMono) XnotBefore() *time.Time {
+	if mono.NotBefore == nil {
+		return nil
+	}
+	notBefore := time.Time(*mono.NotBefore)
+	return &notBefore
+}
+
+func (mono *ZeroMfgrcMono) XupdateTime() *time.Time {
+	if mono.UpdateTime == nil {
+		return nil
+	}
+	updateTime := time.Time(*mono.UpdateTime)
+	return &updateTime
+}
+
 func (mono *ZeroMfgrcMono) State() string {
 	return mono.status
 }
@@ -106,9 +134,28 @@ func (mono *ZeroMfgrcMono) EventListener(eventListener ZeroMfgrcMonoEventListene
 	mono.xListener = eventListener
 }
 
+// UseRetryPolicy overrides the keeper retry policy, it should be set before Ready.
+func (mono *ZeroMfgrcMono) UseRetryPolicy(policy *ZeroMfgrcRetryPolicy) {
+	mono.retryPolicy = policy
+}
+
+func (mono *ZeroMfgrcMono) RetryPolicy() *ZeroMfgrcRetryPolicy {
+	return mono.retryPolicy
+}
+
+// ScheduleAt delays the mono until notBefore, it should be set before Ready.
+func (mono *ZeroMfgrcMono) ScheduleAt(notBefore time.Time) {
+	xNotBefore := structs.Time(notBefore)
+	mono.NotBefore = &xNotBefore
+}
+
+func (mono *ZeroMfgrcMono) ScheduleAfter(delay time.Duration) {
+	mono.ScheduleAt(time.Now().Add(delay))
+}
+
 func (mono *ZeroMfgrcMono) Ready(keeper *ZeroMfgrcKeeper, store ...ZeroMfgrcMonoStore) error {
 	mono.keeper = keeper
-	mono.maxExecuteTimes = mono.keeper.taskRetryTimes + 1
+	mono.maxExecuteTimes = mono.keeper.monoRetryPolicy(mono.This().(MfgrcMono)).MaxRetries + 1
 	mono.status = WORKER_MONO_STATUS_READY
 	mono.executeTimes = 0
 	mono.reason = ""
@@ -122,12 +169,42 @@ func (mono *ZeroMfgrcMono) Ready(keeper *ZeroMfgrcKeeper, store ...ZeroMfgrcMono
 	return nil
 }
 
+func (mono *ZeroMfgrcMono) Scheduled() error {
+	if mono.status != WORKER_MONO_STATUS_READY && mono.status != WORKER_MONO_STATUS_SCHEDULED {
+		return fmt.Errorf("could not schedule mono `%s` status `%s`", mono.MonoID, mono.status)
+	}
+	if mono.NotBefore == nil {
+		return fmt.Errorf("mono `%s` has no schedule time", mono.MonoID)
+	}
+	if mono.status == WORKER_MONO_STATUS_READY {
+		mono.status = WORKER_MONO_STATUS_SCHEDULED
+		if mono.xStore != nil {
+			err := mono.xStore.UpdateMono(mono.This().(MfgrcMono))
+			if err != nil {
+				global.Logger().Error(err.Error())
+			}
+		}
+		if xListener, ok := mono.xListener.(ZeroMfgrcMonoScheduleListener); ok {
+			err := xListener.OnScheduled(mono.This().(MfgrcMono))
+			if err != nil {
+				global.Logger().Error(err.Error())
+			}
+		}
+	}
+	global.Logger().Info(fmt.Sprintf("mono `%s` is scheduled at %s", mono.MonoID, mono.XnotBefore().Format("2006-01-02 15:04:05")))
+	return nil
+}
+
 func (mono *ZeroMfgrcMono) Pending(flux *ZeroMfgrcFlux) error {
-	if mono.status != WORKER_MONO_STATUS_READY && mono.status != WORKER_MONO_STATUS_PENDING {
+	if mono.status == WORKER_MONO_STATUS_RETRYING {
+		mono.fromFlux = flux
+		return nil
+	}
+	if mono.status != WORKER_MONO_STATUS_READY && mono.status != WORKER_MONO_STATUS_SCHEDULED && mono.status != WORKER_MONO_STATUS_PENDING {
 		return fmt.Errorf("could not pending mono `%s` status `%s`", mono.MonoID, mono.status)
 	}
 
-	if mono.status == WORKER_MONO_STATUS_READY {
+	if mono.status == WORKER_MONO_STATUS_READY || mono.status == WORKER_MONO_STATUS_SCHEDULED {
 		mono.fromFlux = flux
 		mono.status = WORKER_MONO_STATUS_PENDING
 		if mono.xStore != nil {
@@ -147,6 +224,82 @@ func (mono *ZeroMfgrcMono) Pending(flux *ZeroMfgrcFlux) error {
 	return nil
 }
 
+// Resume reattaches a mono loaded from store after restart, a pending mono is queued again from ready,
+// an interrupted executing mono is run again as retrying and a scheduled mono keeps waiting.
+func (mono *ZeroMfgrcMono) Resume(keeper *ZeroMfgrcKeeper) error {
+	switch mono.status {
+	case WORKER_MONO_STATUS_SCHEDULED:
+	case WORKER_MONO_STATUS_PENDING:
+		mono.status = WORKER_MONO_STATUS_READY
+		mono.executeTimes = 0
+	case WORKER_MONO_STATUS_RETRYING:
+	case WORKER_MONO_STATUS_EXECUTING:
+		mono.status = WORKER_MONO_STATUS_RETRYING
+		mono.reason = fmt.Sprintf("mono `%s` interrupted by restart", mono.MonoID)
+	default:
+		return fmt.Errorf("could not resume mono `%s` status `%s`", mono.MonoID, mono.status)
+	}
+	mono.keeper = keeper
+	if mono.maxExecuteTimes <= 0 {
+		mono.maxExecuteTimes = keeper.monoRetryPolicy(mono.This().(MfgrcMono)).MaxRetries + 1
+	}
+	if mono.executeTimes <= 0 && mono.status == WORKER_MONO_STATUS_RETRYING {
+		mono.executeTimes = 1
+	}
+	if mono.xStore != nil {
+		err := mono.xStore.UpdateMono(mono.This().(MfgrcMono))
+		if err != nil {
+			global.Logger().Error(err.Error())
+		}
+	}
+	global.Logger().Info(fmt.Sprintf("mono `%s` is resumed as `%s`", mono.MonoID, mono.status))
+	return nil
+}
+
+// ZeroMfgrcForwardedMono takes over a mono forwarded by another node from its export,
+// ZeroMfgrcMono implements it so monos embedding it keep their state across nodes.
+type ZeroMfgrcForwardedMono interface {
+	Forwarded(*ZeroMfgrcKeeper, map[string]interface{}, ...ZeroMfgrcMonoStore) error
+}
+
+// Forwarded restores the status and execute times exported by the forwarding node without readying
+// the mono again, a pending mono was only queued there and is ready to be queued here.
+func (mono *ZeroMfgrcMono) Forwarded(keeper *ZeroMfgrcKeeper, exports map[string]interface{}, store ...ZeroMfgrcMonoStore) error {
+	xInt := func(key string) int {
+		if v, ok := exports[key].(float64); ok {
+			return int(v)
+		}
+		return 0
+	}
+	status, _ := exports["status"].(string)
+	switch status {
+	case WORKER_MONO_STATUS_READY, WORKER_MONO_STATUS_SCHEDULED, WORKER_MONO_STATUS_RETRYING:
+	case WORKER_MONO_STATUS_PENDING:
+		status = WORKER_MONO_STATUS_READY
+	default:
+		return fmt.Errorf("could not accept forwarded mono `%s` status `%s`", mono.MonoID, status)
+	}
+	mono.keeper = keeper
+	mono.status = status
+	mono.reason, _ = exports["reason"].(string)
+	mono.maxExecuteTimes = xInt("maxExecuteTimes")
+	mono.executeTimes = xInt("executeTimes")
+	if mono.maxExecuteTimes <= 0 {
+		mono.maxExecuteTimes = keeper.monoRetryPolicy(mono.This().(MfgrcMono)).MaxRetries + 1
+	}
+	if len(store) > 0 {
+		mono.xStore = store[0]
+	}
+	if mono.xStore != nil {
+		err := mono.xStore.UpdateMono(mono.This().(MfgrcMono))
+		if err != nil {
+			global.Logger().Error(err.Error())
+		}
+	}
+	global.Logger().Info(fmt.Sprintf("mono `%s` is forwarded as `%s`", mono.MonoID, mono.status))
+	return nil
+}
+
 func (mono *ZeroMfgrcMono) Revoke() error {
 	mono.status = WORKER_MONO_STATUS_REVOKE
 
@@ -167,6 +320,11 @@ func (mono *ZeroMfgrcMono) Revoke() error {
 }
 
 func (mono *ZeroMfgrcMono) Timeout() error {
+	reason := &ZeroMfgrcTimeoutError{MonoId: mono.MonoID}
+	if mono.keeper != nil {
+		reason.WaitSeconds = mono.keeper.taskWaitSeconds
+	}
+	mono.reason = reason.Error()
 	mono.status = WORKER_MONO_STATUS_TIMEOUT
 
 	if mono.xStore != nil {
@@ -176,7 +334,12 @@ func (mono *ZeroMfgrcMono) Timeout() error {
 		}
 	}
 	if mono.xListener != nil {
-		err := mono.xListener.OnRevoke(mono.This().(MfgrcMono))
+		var err error
+		if xListener, ok := mono.xListener.(ZeroMfgrcMonoTimeoutListener); ok {
+			err = xListener.OnTimeout(mono.This().(MfgrcMono), reason)
+		} else {
+			err = mono.xListener.OnFailed(mono.This().(MfgrcMono), reason)
+		}
 		if err != nil {
 			global.Logger().Error(err.Error())
 		}
@@ -298,10 +461,19 @@ func (mono *ZeroMfgrcMono) Do() error {
 	return fmt.Errorf("mono `%s` option `%s` not implement", mono.MonoID, mono.Option)
 }
 
+// DoContext runs Do by default, override it to stop the execution once ctx is done.
+func (mono *ZeroMfgrcMono) DoContext(ctx context.Context) error {
+	return mono.This().(MfgrcMono).Do()
+}
+
 func (mono *ZeroMfgrcMono) MaxExecuteTimes() int {
 	return mono.maxExecuteTimes
 }
 
+func (mono *ZeroMfgrcMono) ExecuteTimes() int {
+	return mono.executeTimes
+}
+
 func (mono *ZeroMfgrcMono) Export() (map[string]interface{}, error) {
 	jsonbytes, err := json.Marshal(mono.This())
 	if err != nil {
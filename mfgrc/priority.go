@@ -0,0 +1,211 @@
+package mfgrc
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	MFGRC_PRIORITY_LOW    = -10
+	MFGRC_PRIORITY_NORMAL = 0
+	MFGRC_PRIORITY_HIGH   = 10
+	MFGRC_PRIORITY_URGENT = 20
+)
+
+const (
+	MFGRC_PRIORITY_STRICT   = "strict"
+	MFGRC_PRIORITY_WEIGHTED = "weighted"
+)
+
+var xPriorityWeights = map[int]int{
+	MFGRC_PRIORITY_LOW:    1,
+	MFGRC_PRIORITY_NORMAL: 2,
+	MFGRC_PRIORITY_HIGH:   4,
+	MFGRC_PRIORITY_URGENT: 8,
+}
+
+// ZeroMfgrcPriorityPolicy chooses the next lane, a larger priority is more urgent.
+// A lane head waiting longer than MaxWait seconds is served first whatever the mode, 0 disables it.
+type ZeroMfgrcPriorityPolicy struct {
+	Mode    string
+	Weights map[int]int
+	MaxWait int
+}
+
+func NewMfgrcPriorityPolicy(mode string, maxWait int) *ZeroMfgrcPriorityPolicy {
+	return &ZeroMfgrcPriorityPolicy{
+		Mode:    mode,
+		Weights: make(map[int]int),
+		MaxWait: maxWait,
+	}
+}
+
+func (policy *ZeroMfgrcPriorityPolicy) UseWeight(priority int, weight int) *ZeroMfgrcPriorityPolicy {
+	policy.Weights[priority] = weight
+	return policy
+}
+
+func (policy *ZeroMfgrcPriorityPolicy) Weight(priority int) int {
+	if weight, ok := policy.Weights[priority]; ok && weight > 0 {
+		return weight
+	}
+	if weight, ok := xPriorityWeights[priority]; ok {
+		return weight
+	}
+	return 1
+}
+
+type xMfgrcLaneEntry struct {
+	item     interface{}
+	priority int
+	enqueued time.Time
+}
+
+// xMfgrcLanes hands waiting fluxes or groups to workers by priority, each lane is first-come-first-served.
+type xMfgrcLanes struct {
+	policy  *ZeroMfgrcPriorityPolicy
+	lanes   map[int][]*xMfgrcLaneEntry
+	current map[int]int
+	closed  bool
+	mutex   sync.Mutex
+	cond    *sync.Cond
+}
+
+func newMfgrcLanes() *xMfgrcLanes {
+	lanes := &xMfgrcLanes{
+		policy:  NewMfgrcPriorityPolicy(MFGRC_PRIORITY_STRICT, 60),
+		lanes:   make(map[int][]*xMfgrcLaneEntry),
+		current: make(map[int]int),
+	}
+	lanes.cond = sync.NewCond(&lanes.mutex)
+	return lanes
+}
+
+func (lanes *xMfgrcLanes) usePolicy(policy *ZeroMfgrcPriorityPolicy) {
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	lanes.policy = policy
+	lanes.current = make(map[int]int)
+}
+
+func (lanes *xMfgrcLanes) open() {
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	lanes.closed = false
+	lanes.cond.Broadcast()
+}
+
+// close wakes every waiting worker with nil, items still queued are kept for the next open.
+func (lanes *xMfgrcLanes) close() {
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	lanes.closed = true
+	lanes.cond.Broadcast()
+}
+
+func (lanes *xMfgrcLanes) push(item interface{}, priority int) {
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	lanes.lanes[priority] = append(lanes.lanes[priority], &xMfgrcLaneEntry{item: item, priority: priority, enqueued: time.Now()})
+	lanes.cond.Signal()
+}
+
+// promote moves a still waiting item up to priority, it keeps its enqueue time for starvation checks.
+func (lanes *xMfgrcLanes) promote(item interface{}, priority int) bool {
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	for xPriority, entries := range lanes.lanes {
+		if xPriority >= priority {
+			continue
+		}
+		for i, entry := range entries {
+			if entry.item != item {
+				continue
+			}
+			lanes.lanes[xPriority] = append(entries[:i:i], entries[i+1:]...)
+			if len(lanes.lanes[xPriority]) == 0 {
+				delete(lanes.lanes, xPriority)
+			}
+			entry.priority = priority
+			lanes.lanes[priority] = append(lanes.lanes[priority], entry)
+			return true
+		}
+	}
+	return false
+}
+
+func (lanes *xMfgrcLanes) take() interface{} {
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	for len(lanes.lanes) == 0 && !lanes.closed {
+		lanes.cond.Wait()
+	}
+	if lanes.closed {
+		return nil
+	}
+	priority := lanes.pick()
+	entry := lanes.lanes[priority][0]
+	lanes.lanes[priority] = lanes.lanes[priority][1:]
+	if len(lanes.lanes[priority]) == 0 {
+		delete(lanes.lanes, priority)
+		delete(lanes.current, priority)
+	}
+	return entry.item
+}
+
+// pick chooses a non-empty lane, lanes.mutex must be held.
+func (lanes *xMfgrcLanes) pick() int {
+	priorities := make([]int, 0, len(lanes.lanes))
+	for priority := range lanes.lanes {
+		priorities = append(priorities, priority)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	if lanes.policy.MaxWait > 0 {
+		starved := -1
+		deadline := time.Now().Add(-time.Second * time.Duration(lanes.policy.MaxWait))
+		for i, priority := range priorities {
+			head := lanes.lanes[priority][0]
+			if head.enqueued.Before(deadline) && (starved < 0 || head.enqueued.Before(lanes.lanes[priorities[starved]][0].enqueued)) {
+				starved = i
+			}
+		}
+		if starved >= 0 {
+			return priorities[starved]
+		}
+	}
+	if lanes.policy.Mode != MFGRC_PRIORITY_WEIGHTED {
+		return priorities[0]
+	}
+
+	total := 0
+	chosen := priorities[0]
+	for _, priority := range priorities {
+		weight := lanes.policy.Weight(priority)
+		total += weight
+		lanes.current[priority] += weight
+		if lanes.current[priority] > lanes.current[chosen] {
+			chosen = priority
+		}
+	}
+	lanes.current[chosen] -= total
+	return chosen
+}
+
+func (lanes *xMfgrcLanes) depth() map[string]int {
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	depth := make(map[string]int)
+	for priority, entries := range lanes.lanes {
+		depth[strconv.Itoa(priority)] = len(entries)
+	}
+	return depth
+}
+
+func (lanes *xMfgrcLanes) mode() string {
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	return lanes.policy.Mode
+}
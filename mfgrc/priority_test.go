@@ -0,0 +1,112 @@
+package mfgrc
+
+import (
+	"testing"
+	"time"
+)
+
+func takeMfgrcLanes(lanes *xMfgrcLanes, n int) []string {
+	taken := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		taken = append(taken, lanes.take().(string))
+	}
+	return taken
+}
+
+func TestMfgrcLanesStrict(t *testing.T) {
+	lanes := newMfgrcLanes()
+	lanes.usePolicy(NewMfgrcPriorityPolicy(MFGRC_PRIORITY_STRICT, 0))
+	lanes.push("low", MFGRC_PRIORITY_LOW)
+	lanes.push("normal-1", MFGRC_PRIORITY_NORMAL)
+	lanes.push("urgent", MFGRC_PRIORITY_URGENT)
+	lanes.push("normal-2", MFGRC_PRIORITY_NORMAL)
+	lanes.push("high", MFGRC_PRIORITY_HIGH)
+
+	expected := []string{"urgent", "high", "normal-1", "normal-2", "low"}
+	taken := takeMfgrcLanes(lanes, len(expected))
+	for i := range expected {
+		if taken[i] != expected[i] {
+			t.Fatalf("strict order %v, expected %v", taken, expected)
+		}
+	}
+}
+
+func TestMfgrcLanesWeighted(t *testing.T) {
+	lanes := newMfgrcLanes()
+	lanes.usePolicy(NewMfgrcPriorityPolicy(MFGRC_PRIORITY_WEIGHTED, 0).UseWeight(MFGRC_PRIORITY_HIGH, 3).UseWeight(MFGRC_PRIORITY_LOW, 1))
+	for i := 0; i < 8; i++ {
+		lanes.push("high", MFGRC_PRIORITY_HIGH)
+		lanes.push("low", MFGRC_PRIORITY_LOW)
+	}
+
+	counts := make(map[string]int)
+	for _, item := range takeMfgrcLanes(lanes, 8) {
+		counts[item]++
+	}
+	if counts["high"] != 6 || counts["low"] != 2 {
+		t.Fatalf("weighted 3:1 took %v, expected high 6 low 2", counts)
+	}
+}
+
+func TestMfgrcLanesMaxWait(t *testing.T) {
+	lanes := newMfgrcLanes()
+	lanes.usePolicy(NewMfgrcPriorityPolicy(MFGRC_PRIORITY_STRICT, 60))
+	lanes.push("low", MFGRC_PRIORITY_LOW)
+	lanes.push("high", MFGRC_PRIORITY_HIGH)
+	lanes.lanes[MFGRC_PRIORITY_LOW][0].enqueued = time.Now().Add(-time.Minute * 2)
+
+	taken := takeMfgrcLanes(lanes, 2)
+	if taken[0] != "low" || taken[1] != "high" {
+		t.Fatalf("starved lane order %v, expected [low high]", taken)
+	}
+}
+
+func TestMfgrcLanesPromote(t *testing.T) {
+	lanes := newMfgrcLanes()
+	lanes.usePolicy(NewMfgrcPriorityPolicy(MFGRC_PRIORITY_STRICT, 0))
+	lanes.push("a", MFGRC_PRIORITY_NORMAL)
+	lanes.push("b", MFGRC_PRIORITY_HIGH)
+	if !lanes.promote("a", MFGRC_PRIORITY_URGENT) {
+		t.Fatal("promote a waiting item failed")
+	}
+	if lanes.promote("b", MFGRC_PRIORITY_NORMAL) {
+		t.Fatal("promote to a lower priority should be refused")
+	}
+
+	taken := takeMfgrcLanes(lanes, 2)
+	if taken[0] != "a" || taken[1] != "b" {
+		t.Fatalf("promoted order %v, expected [a b]", taken)
+	}
+}
+
+func TestMfgrcLanesCloseKeepsItems(t *testing.T) {
+	lanes := newMfgrcLanes()
+	lanes.push("kept", MFGRC_PRIORITY_NORMAL)
+	lanes.close()
+	if item := lanes.take(); item != nil {
+		t.Fatalf("closed lanes handed out %v", item)
+	}
+	lanes.push("parked", MFGRC_PRIORITY_HIGH)
+
+	lanes.open()
+	taken := takeMfgrcLanes(lanes, 2)
+	if taken[0] != "parked" || taken[1] != "kept" {
+		t.Fatalf("reopened lanes took %v, expected [parked kept]", taken)
+	}
+}
+
+func TestMfgrcLanesCloseWakesWorkers(t *testing.T) {
+	lanes := newMfgrcLanes()
+	woken := make(chan interface{})
+	go func() { woken <- lanes.take() }()
+	time.Sleep(time.Millisecond * 50)
+	lanes.close()
+	select {
+	case item := <-woken:
+		if item != nil {
+			t.Fatalf("closed lanes handed out %v", item)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting worker not woken by close")
+	}
+}
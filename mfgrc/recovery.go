@@ -0,0 +1,165 @@
+package mfgrc
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	MFGRC_RECOVERY_REVOKE = "revoke"
+	MFGRC_RECOVERY_RESUME = "resume"
+)
+
+const (
+	MFGRC_RECOVERY_RERUN    = "rerun"
+	MFGRC_RECOVERY_FAIL     = "fail"
+	MFGRC_RECOVERY_CALLBACK = "callback"
+)
+
+// ZeroMfgrcIdempotentMono marks a mono whose interrupted execution is safe to run again.
+type ZeroMfgrcIdempotentMono interface {
+	Idempotent() bool
+}
+
+// ZeroMfgrcRecoveryHandler decides an interrupted executing mono, true re-runs it,
+// false fails it with the returned error.
+type ZeroMfgrcRecoveryHandler interface {
+	RecoverMono(MfgrcMono) (bool, error)
+}
+
+// ZeroMfgrcRecoveryListener receives the recovery report, keeperOpts implementing it are notified.
+type ZeroMfgrcRecoveryListener interface {
+	OnRecovered(*ZeroMfgrcRecoveryReport)
+}
+
+type ZeroMfgrcRecoveryReport struct {
+	KeeperName string            `json:"keeperName"`
+	Node       string            `json:"node,omitempty"`
+	Takeover   bool              `json:"takeover,omitempty"`
+	Mode       string            `json:"mode"`
+	Executing  string            `json:"executing,omitempty"`
+	Total      int               `json:"total"`
+	Resumed    []string          `json:"resumed"`
+	Rerun      []string          `json:"rerun"`
+	Revoked    []string          `json:"revoked"`
+	Scheduled  []string          `json:"scheduled"`
+	Skipped    []string          `json:"skipped,omitempty"`
+	Failed     map[string]string `json:"failed"`
+	StartTime  time.Time         `json:"startTime"`
+	EndTime    time.Time         `json:"endTime"`
+}
+
+func newMfgrcRecoveryReport(keeper *ZeroMfgrcKeeper) *ZeroMfgrcRecoveryReport {
+	report := &ZeroMfgrcRecoveryReport{
+		KeeperName: keeper.keeperName,
+		Mode:       keeper.recoveryMode,
+		Resumed:    make([]string, 0),
+		Rerun:      make([]string, 0),
+		Revoked:    make([]string, 0),
+		Scheduled:  make([]string, 0),
+		Skipped:    make([]string, 0),
+		Failed:     make(map[string]string),
+		StartTime:  time.Now(),
+	}
+	if keeper.cluster != nil {
+		report.Node = keeper.cluster.NodeId
+	}
+	if keeper.recoveryMode == MFGRC_RECOVERY_RESUME {
+		report.Executing = keeper.recoveryExecuting
+	}
+	return report
+}
+
+func (report *ZeroMfgrcRecoveryReport) String() string {
+	jsonbytes, err := json.Marshal(report)
+	if err != nil {
+		return err.Error()
+	}
+	return string(jsonbytes)
+}
+
+// UseRecovery chooses how unfinished monos are handled on start, mode is revoke or resume,
+// executing is rerun, fail or callback and only applies to resume.
+func (keeper *ZeroMfgrcKeeper) UseRecovery(mode string, executing string) *ZeroMfgrcKeeper {
+	keeper.recoveryMode = mode
+	keeper.recoveryExecuting = executing
+	return keeper
+}
+
+func (keeper *ZeroMfgrcKeeper) UseRecoveryHandler(handler ZeroMfgrcRecoveryHandler) *ZeroMfgrcKeeper {
+	keeper.recoveryHandler = handler
+	return keeper
+}
+
+func (keeper *ZeroMfgrcKeeper) RecoveryReport() *ZeroMfgrcRecoveryReport {
+	keeper.statusMutex.RLock()
+	defer keeper.statusMutex.RUnlock()
+	return keeper.recoveryReport
+}
+
+func (keeper *ZeroMfgrcKeeper) rerunExecuting(mono MfgrcMono) (bool, error) {
+	switch keeper.recoveryExecuting {
+	case MFGRC_RECOVERY_RERUN:
+		if xMono, ok := mono.(ZeroMfgrcIdempotentMono); ok && xMono.Idempotent() {
+			return true, nil
+		}
+		return false, fmt.Errorf("mono `%s` interrupted by restart and is not idempotent", mono.XmonoId())
+	case MFGRC_RECOVERY_CALLBACK:
+		if keeper.recoveryHandler == nil {
+			return false, fmt.Errorf("mono `%s` interrupted by restart, recovery handler not found", mono.XmonoId())
+		}
+		rerun, err := keeper.recoveryHandler.RecoverMono(mono)
+		if !rerun && err == nil {
+			err = fmt.Errorf("mono `%s` interrupted by restart, declined by recovery handler", mono.XmonoId())
+		}
+		return rerun, err
+	default:
+		return false, fmt.Errorf("mono `%s` interrupted by restart", mono.XmonoId())
+	}
+}
+
+// recoverMonos requeues unfinished monos grouped by unique code, within a code interrupted monos go first
+// and the rest keep the order returned by FetchUncompleteMonos.
+func (keeper *ZeroMfgrcKeeper) recoverMonos(monos []MfgrcMono, report *ZeroMfgrcRecoveryReport) {
+	codes := make([]string, 0)
+	reruns := make(map[string][]MfgrcMono)
+	queues := make(map[string][]MfgrcMono)
+	for _, mono := range monos {
+		if _, ok := queues[mono.XuniqueCode()]; !ok {
+			codes = append(codes, mono.XuniqueCode())
+			queues[mono.XuniqueCode()] = make([]MfgrcMono, 0)
+		}
+		if mono.State() != WORKER_MONO_STATUS_EXECUTING {
+			queues[mono.XuniqueCode()] = append(queues[mono.XuniqueCode()], mono)
+			continue
+		}
+		rerun, err := keeper.rerunExecuting(mono)
+		if rerun {
+			reruns[mono.XuniqueCode()] = append(reruns[mono.XuniqueCode()], mono)
+			continue
+		}
+		mono.Failed(err)
+		report.Failed[mono.XmonoId()] = err.Error()
+	}
+
+	for _, code := range codes {
+		for _, mono := range append(reruns[code], queues[code]...) {
+			interrupted := mono.State() == WORKER_MONO_STATUS_EXECUTING
+			err := mono.Resume(keeper)
+			if err == nil {
+				err = keeper.AddMono(mono)
+			}
+			if err != nil {
+				if mono.State() != WORKER_MONO_STATUS_FAILED {
+					mono.Failed(err)
+				}
+				report.Failed[mono.XmonoId()] = err.Error()
+			} else if interrupted {
+				report.Rerun = append(report.Rerun, mono.XmonoId())
+			} else {
+				report.Resumed = append(report.Resumed, mono.XmonoId())
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package mfgrc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type xTestIdempotentMono struct {
+	xTestMfgrcMono
+	idempotent bool
+}
+
+func (mono *xTestIdempotentMono) Idempotent() bool {
+	return mono.idempotent
+}
+
+type xTestRecoveryHandler map[string]error
+
+func (handler xTestRecoveryHandler) RecoverMono(mono MfgrcMono) (bool, error) {
+	err, ok := handler[mono.XmonoId()]
+	return ok && err == nil, err
+}
+
+func newTestRecoveryMono(t *testing.T, keeper *ZeroMfgrcKeeper, xListener ZeroMfgrcMonoEventListener, monoId string, uniqueCode string, status string, idempotent bool) *xTestIdempotentMono {
+	t.Helper()
+	mono := &xTestIdempotentMono{
+		xTestMfgrcMono: xTestMfgrcMono{
+			ZeroMfgrcMono: ZeroMfgrcMono{MonoID: monoId, UniqueCode: uniqueCode},
+			do:            func(context.Context) error { return nil },
+		},
+		idempotent: idempotent,
+	}
+	mono.ThisDef(mono)
+	mono.EventListener(xListener)
+	err := mono.Ready(keeper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == WORKER_MONO_STATUS_SCHEDULED {
+		mono.ScheduleAfter(time.Hour)
+	}
+	mono.status = status
+	if status == WORKER_MONO_STATUS_EXECUTING {
+		mono.executeTimes = 1
+	}
+	return mono
+}
+
+func testMfgrcReportHas(report []string, monoId string) bool {
+	for _, xMonoId := range report {
+		if xMonoId == monoId {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMfgrcRecoveryRevoke(t *testing.T) {
+	keeper := runTestMfgrcKeeper(t, NewWorker("revoke", nil, 1, 10, 0, 0, 0, 0))
+	xListener := newTestMfgrcListener()
+	monos := []MfgrcMono{
+		newTestRecoveryMono(t, keeper, xListener, "pending", "code", WORKER_MONO_STATUS_PENDING, true),
+		newTestRecoveryMono(t, keeper, xListener, "executing", "code", WORKER_MONO_STATUS_EXECUTING, true),
+		newTestRecoveryMono(t, keeper, xListener, "scheduled", "code", WORKER_MONO_STATUS_SCHEDULED, true),
+	}
+
+	report := newMfgrcRecoveryReport(keeper)
+	keeper.recoverUnfinished(monos, report)
+	if len(report.Revoked) != 2 || !testMfgrcReportHas(report.Revoked, "pending") || !testMfgrcReportHas(report.Revoked, "executing") {
+		t.Fatalf("revoked %v", report.Revoked)
+	}
+	if len(report.Scheduled) != 1 || report.Scheduled[0] != "scheduled" || len(report.Resumed)+len(report.Rerun)+len(report.Failed) != 0 {
+		t.Fatalf("report %s", report)
+	}
+	if monos[0].State() != WORKER_MONO_STATUS_REVOKE || monos[1].State() != WORKER_MONO_STATUS_REVOKE || monos[2].State() != WORKER_MONO_STATUS_SCHEDULED {
+		t.Fatalf("states %s %s %s", monos[0].State(), monos[1].State(), monos[2].State())
+	}
+	keeper.revokeSchedule(monos[2])
+}
+
+func TestMfgrcRecoveryResume(t *testing.T) {
+	cases := []struct {
+		name      string
+		executing string
+		handler   ZeroMfgrcRecoveryHandler
+		rerun     []string
+		failed    []string
+	}{
+		{"rerun", MFGRC_RECOVERY_RERUN, nil, []string{"idempotent"}, []string{"plain"}},
+		{"fail", MFGRC_RECOVERY_FAIL, nil, nil, []string{"idempotent", "plain"}},
+		{"callback", MFGRC_RECOVERY_CALLBACK, xTestRecoveryHandler{"plain": nil, "idempotent": errors.New("declined")}, []string{"plain"}, []string{"idempotent"}},
+		{"callback without handler", MFGRC_RECOVERY_CALLBACK, nil, nil, []string{"idempotent", "plain"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			keeper := NewWorker("resume", nil, 1, 10, 0, 0, 0, 0).UseRecovery(MFGRC_RECOVERY_RESUME, c.executing)
+			if c.handler != nil {
+				keeper.UseRecoveryHandler(c.handler)
+			}
+			runTestMfgrcKeeper(t, keeper)
+			xListener := newTestMfgrcListener()
+			monos := []MfgrcMono{
+				newTestRecoveryMono(t, keeper, xListener, "queued", "code", WORKER_MONO_STATUS_PENDING, false),
+				newTestRecoveryMono(t, keeper, xListener, "idempotent", "code", WORKER_MONO_STATUS_EXECUTING, true),
+				newTestRecoveryMono(t, keeper, xListener, "plain", "code", WORKER_MONO_STATUS_EXECUTING, false),
+			}
+
+			report := newMfgrcRecoveryReport(keeper)
+			keeper.recoverUnfinished(monos, report)
+			if len(report.Resumed) != 1 || report.Resumed[0] != "queued" || len(report.Revoked) != 0 {
+				t.Fatalf("report %s", report)
+			}
+			if len(report.Rerun) != len(c.rerun) || len(report.Failed) != len(c.failed) {
+				t.Fatalf("report %s", report)
+			}
+			for _, monoId := range c.rerun {
+				if !testMfgrcReportHas(report.Rerun, monoId) {
+					t.Fatalf("%s not rerun, report %s", monoId, report)
+				}
+			}
+			for _, monoId := range c.failed {
+				if _, ok := report.Failed[monoId]; !ok {
+					t.Fatalf("%s not failed, report %s", monoId, report)
+				}
+			}
+
+			expected := append(append([]string{}, c.rerun...), "queued")
+			for _, monoId := range expected {
+				xListener.await(t, 2*time.Second, monoId+":"+WORKER_MONO_STATUS_COMPLETE, "queued:"+WORKER_MONO_STATUS_COMPLETE)
+			}
+		})
+	}
+}
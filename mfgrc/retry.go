@@ -0,0 +1,78 @@
+package mfgrc
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	MFGRC_BACKOFF_FIXED       = "fixed"
+	MFGRC_BACKOFF_LINEAR      = "linear"
+	MFGRC_BACKOFF_EXPONENTIAL = "exponential"
+)
+
+type ZeroMfgrcRetryClassifier interface {
+	Retryable(error) bool
+}
+
+// ZeroMfgrcRetryPolicy delays are in seconds, Jitter spreads each delay by ±Jitter of itself.
+type ZeroMfgrcRetryPolicy struct {
+	Backoff    string
+	Interval   int
+	MaxDelay   int
+	MaxRetries int
+	Jitter     float64
+
+	classifier ZeroMfgrcRetryClassifier
+}
+
+func NewMfgrcRetryPolicy(backoff string, interval int, maxDelay int, maxRetries int) *ZeroMfgrcRetryPolicy {
+	return &ZeroMfgrcRetryPolicy{
+		Backoff:    backoff,
+		Interval:   interval,
+		MaxDelay:   maxDelay,
+		MaxRetries: maxRetries,
+	}
+}
+
+func (policy *ZeroMfgrcRetryPolicy) UseJitter(jitter float64) *ZeroMfgrcRetryPolicy {
+	policy.Jitter = math.Max(0, math.Min(jitter, 1))
+	return policy
+}
+
+func (policy *ZeroMfgrcRetryPolicy) UseClassifier(classifier ZeroMfgrcRetryClassifier) *ZeroMfgrcRetryPolicy {
+	policy.classifier = classifier
+	return policy
+}
+
+func (policy *ZeroMfgrcRetryPolicy) Retryable(err error) bool {
+	if policy.classifier == nil {
+		return true
+	}
+	return policy.classifier.Retryable(err)
+}
+
+// Delay returns the wait before the retry-th retry, counting from 1.
+func (policy *ZeroMfgrcRetryPolicy) Delay(retry int) time.Duration {
+	if retry < 1 {
+		retry = 1
+	}
+	delay := float64(policy.Interval)
+	switch policy.Backoff {
+	case MFGRC_BACKOFF_LINEAR:
+		delay *= float64(retry)
+	case MFGRC_BACKOFF_EXPONENTIAL:
+		delay *= math.Pow(2, math.Min(float64(retry-1), 30))
+	}
+	if policy.Jitter > 0 {
+		delay += delay * policy.Jitter * (rand.Float64()*2 - 1)
+	}
+	if policy.MaxDelay > 0 && delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(delay * float64(time.Second))
+}
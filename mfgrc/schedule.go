@@ -0,0 +1,147 @@
+package mfgrc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+)
+
+// xSCHEDULE_WAIT is how often due monos are checked again while the keeper is not running.
+const xSCHEDULE_WAIT = time.Second
+
+type xMfgrcSchedule struct {
+	mono     MfgrcMono
+	sequence int
+}
+
+func (keeper *ZeroMfgrcKeeper) scheduling(mono MfgrcMono) bool {
+	if mono.State() != WORKER_MONO_STATUS_READY && mono.State() != WORKER_MONO_STATUS_SCHEDULED {
+		return false
+	}
+	notBefore := mono.XnotBefore()
+	return notBefore != nil && notBefore.After(time.Now())
+}
+
+// scheduleMono parks a mono until its not-before time, it is persisted as scheduled so it survives restarts,
+// the caller holds the unique code which is released once the mono leaves the schedule.
+func (keeper *ZeroMfgrcKeeper) scheduleMono(mono MfgrcMono) error {
+	keeper.scheduleMutex.Lock()
+	defer keeper.scheduleMutex.Unlock()
+	if _, ok := keeper.scheduleMap[mono.XmonoId()]; ok {
+		return fmt.Errorf("scheduled mono `%s` is already exists", mono.XmonoId())
+	}
+	err := mono.Scheduled()
+	if err != nil {
+		return err
+	}
+	keeper.scheduleSeq++
+	keeper.scheduleMap[mono.XmonoId()] = &xMfgrcSchedule{mono: mono, sequence: keeper.scheduleSeq}
+	keeper.rearmSchedule()
+	return nil
+}
+
+func (keeper *ZeroMfgrcKeeper) revokeSchedule(mono MfgrcMono) (bool, error) {
+	keeper.scheduleMutex.Lock()
+	schedule, ok := keeper.scheduleMap[mono.XmonoId()]
+	if ok {
+		delete(keeper.scheduleMap, mono.XmonoId())
+		keeper.rearmSchedule()
+	}
+	keeper.scheduleMutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+	defer keeper.unholdCode(schedule.mono.XuniqueCode())
+	return true, schedule.mono.Revoke()
+}
+
+// rearmSchedule points the timer at the earliest not-before time, no sooner than xSCHEDULE_WAIT
+// while the keeper is not running, scheduleMutex must be held.
+func (keeper *ZeroMfgrcKeeper) rearmSchedule() {
+	if keeper.scheduleTimer != nil {
+		keeper.scheduleTimer.Stop()
+		keeper.scheduleTimer = nil
+	}
+	if len(keeper.scheduleMap) == 0 {
+		return
+	}
+	delay := time.Duration(-1)
+	for _, schedule := range keeper.scheduleMap {
+		xDelay := time.Duration(0)
+		if notBefore := schedule.mono.XnotBefore(); notBefore != nil {
+			xDelay = time.Until(*notBefore)
+		}
+		if delay < 0 || xDelay < delay {
+			delay = max(xDelay, 0)
+		}
+	}
+	keeper.statusMutex.RLock()
+	if keeper.status != xKEEPER_STATUS_RUNNING {
+		delay = max(delay, xSCHEDULE_WAIT)
+	}
+	keeper.statusMutex.RUnlock()
+	keeper.scheduleTimer = time.AfterFunc(delay, keeper.fireSchedules)
+}
+
+// fireSchedules queues every due mono ordered by not-before time then schedule order,
+// due monos wait while the keeper is not running and are checked again later.
+func (keeper *ZeroMfgrcKeeper) fireSchedules() {
+	keeper.statusMutex.RLock()
+	xStatus := keeper.status
+	keeper.statusMutex.RUnlock()
+	if xStatus != xKEEPER_STATUS_RUNNING {
+		keeper.scheduleMutex.Lock()
+		keeper.rearmSchedule()
+		keeper.scheduleMutex.Unlock()
+		return
+	}
+
+	now := time.Now()
+	keeper.scheduleMutex.Lock()
+	schedules := make([]*xMfgrcSchedule, 0)
+	for monoId, schedule := range keeper.scheduleMap {
+		notBefore := schedule.mono.XnotBefore()
+		if notBefore == nil || !notBefore.After(now) {
+			schedules = append(schedules, schedule)
+			delete(keeper.scheduleMap, monoId)
+		}
+	}
+	keeper.rearmSchedule()
+	keeper.scheduleMutex.Unlock()
+
+	sort.SliceStable(schedules, func(i, j int) bool {
+		xi, xj := schedules[i].mono.XnotBefore(), schedules[j].mono.XnotBefore()
+		if xi != nil && xj != nil && !xi.Equal(*xj) {
+			return xi.Before(*xj)
+		}
+		return schedules[i].sequence < schedules[j].sequence
+	})
+	for _, schedule := range schedules {
+		if schedule.mono.State() != WORKER_MONO_STATUS_SCHEDULED {
+			keeper.unholdCode(schedule.mono.XuniqueCode())
+			continue
+		}
+		global.Logger().Info(fmt.Sprintf("scheduled mono `%s` is due", schedule.mono.XmonoId()))
+		err := keeper.AddMono(schedule.mono)
+		if err != nil {
+			schedule.mono.Failed(err)
+		}
+		keeper.unholdCode(schedule.mono.XuniqueCode())
+	}
+}
+
+func (keeper *ZeroMfgrcKeeper) exportSchedules() (map[string]interface{}, error) {
+	keeper.scheduleMutex.Lock()
+	defer keeper.scheduleMutex.Unlock()
+	schedules := make(map[string]interface{})
+	for monoId, schedule := range keeper.scheduleMap {
+		monoMap, err := schedule.mono.Export()
+		if err != nil {
+			return nil, err
+		}
+		schedules[monoId] = monoMap
+	}
+	return schedules, nil
+}
@@ -0,0 +1,62 @@
+package mfgrc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMfgrcScheduleNotBefore(t *testing.T) {
+	keeper := runTestMfgrcKeeper(t, NewWorker("schedule", nil, 1, 10, 0, 0, 0, 0))
+	xListener := newTestMfgrcListener()
+
+	executed := make(chan time.Time, 2)
+	do := func(context.Context) error {
+		executed <- time.Now()
+		return nil
+	}
+	later := newTestMfgrcMono(t, keeper, xListener, "later", "code-a", do)
+	later.ScheduleAfter(time.Millisecond * 400)
+	sooner := newTestMfgrcMono(t, keeper, xListener, "sooner", "code-b", do)
+	sooner.ScheduleAfter(time.Millisecond * 200)
+	testMfgrcAddMono(t, keeper, later)
+	testMfgrcAddMono(t, keeper, sooner)
+	keeper.scheduleMutex.Lock()
+	scheduled := len(keeper.scheduleMap)
+	keeper.scheduleMutex.Unlock()
+	if scheduled != 2 {
+		t.Fatalf("%d monos scheduled, expected 2", scheduled)
+	}
+
+	xListener.await(t, 2*time.Second, "sooner:"+WORKER_MONO_STATUS_COMPLETE, "later:"+WORKER_MONO_STATUS_COMPLETE)
+	if at := <-executed; at.Before(time.Time(*sooner.XnotBefore())) {
+		t.Fatalf("sooner executed at %s before %s", at, time.Time(*sooner.XnotBefore()))
+	}
+	xListener.await(t, 2*time.Second, "later:"+WORKER_MONO_STATUS_COMPLETE)
+	if at := <-executed; at.Before(time.Time(*later.XnotBefore())) {
+		t.Fatalf("later executed at %s before %s", at, time.Time(*later.XnotBefore()))
+	}
+}
+
+func TestMfgrcScheduleWaitsForRunning(t *testing.T) {
+	keeper := NewWorker("schedule-wait", nil, 1, 10, 0, 0, 0, 0)
+	xListener := newTestMfgrcListener()
+	mono := newTestMfgrcMono(t, keeper, xListener, "due", "code", func(context.Context) error { return nil })
+	mono.ScheduleAfter(time.Millisecond * 50)
+	err := keeper.scheduleMono(mono)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 200)
+	keeper.scheduleMutex.Lock()
+	_, waiting := keeper.scheduleMap["due"]
+	armed := keeper.scheduleTimer != nil
+	keeper.scheduleMutex.Unlock()
+	if !waiting || !armed || mono.State() != WORKER_MONO_STATUS_SCHEDULED {
+		t.Fatalf("due mono waiting %v armed %v state %s while stopped", waiting, armed, mono.State())
+	}
+
+	runTestMfgrcKeeper(t, keeper)
+	xListener.await(t, 2*time.Second, "due:"+WORKER_MONO_STATUS_COMPLETE)
+}
@@ -1,20 +1,30 @@
 package mfgrc
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/0meet1/zero-framework/global"
 )
 
+var xErrMfgrcMonoRevoked = errors.New("mono is revoked")
+
 type ZeroMfgrcFlux struct {
 	UniqueId  string
 	monoMap   map[string]MfgrcMono
 	monos     chan MfgrcMono
+	head      MfgrcMono
 	monoMutex sync.RWMutex
 
+	running       MfgrcMono
+	cancelRunning context.CancelCauseFunc
+
+	priority int
+
 	keeper *ZeroMfgrcKeeper
 	worker *ZeroMfgrcWorker
 }
@@ -23,12 +33,13 @@ func newMfgrcFlux(mono MfgrcMono, keeper *ZeroMfgrcKeeper) error {
 	flux := &ZeroMfgrcFlux{}
 	flux.open(keeper)
 	flux.UniqueId = mono.XuniqueCode()
+	flux.priority = mono.Xpriority()
 	err := flux.Push(mono, keeper)
 	if err != nil {
 		return err
 	}
 	keeper.mfgrcMap[flux.UniqueId] = flux
-	go func() { keeper.mfgrcChan <- flux }()
+	keeper.lanes.push(flux, flux.priority)
 	return nil
 }
 
@@ -54,12 +65,20 @@ func (flux *ZeroMfgrcFlux) Push(mono MfgrcMono, keeper *ZeroMfgrcKeeper) error {
 
 	flux.monoMutex.Lock()
 	if flux.monos != nil {
-		go func() { flux.monos <- mono }()
+		select {
+		case flux.monos <- mono:
+		default:
+			go func() { flux.monos <- mono }()
+		}
+	}
+	if mono.Xpriority() > flux.priority && flux.keeper.lanes.promote(flux, mono.Xpriority()) {
+		flux.priority = mono.Xpriority()
 	}
 	flux.monoMutex.Unlock()
 	return nil
 }
 
+// Revoke revokes a queued mono, a running mono has its context cancelled and is revoked once Do returns.
 func (flux *ZeroMfgrcFlux) Revoke(mono MfgrcMono) error {
 	flux.monoMutex.Lock()
 	defer flux.monoMutex.Unlock()
@@ -67,6 +86,10 @@ func (flux *ZeroMfgrcFlux) Revoke(mono MfgrcMono) error {
 	if !ok {
 		return fmt.Errorf("mono `%s` not found", mono.XmonoId())
 	}
+	if flux.running != nil && flux.running.XmonoId() == mono.XmonoId() {
+		flux.cancelRunning(xErrMfgrcMonoRevoked)
+		return nil
+	}
 	return mono.Revoke()
 }
 
@@ -105,6 +128,7 @@ func (flux *ZeroMfgrcFlux) close() bool {
 
 	flux.keeper.mfgrcMutex.Lock()
 	delete(flux.keeper.mfgrcMap, flux.UniqueId)
+	flux.keeper.unholdCode(flux.UniqueId)
 	flux.keeper.mfgrcMutex.Unlock()
 	return false
 }
@@ -115,55 +139,187 @@ func (flux *ZeroMfgrcFlux) cleanMono(mono MfgrcMono) {
 	flux.monoMutex.Unlock()
 }
 
-func (flux *ZeroMfgrcFlux) completeMono(mono MfgrcMono, err error) {
+// execMono bounds one execution by taskWaitSeconds, any return after expiry counts as a timeout.
+// The flux then waits as long again for Do to return, a Do still running is abandoned and the
+// flux keeps holding the code until it returns so the next mono of the code never overlaps it.
+// The context is also cancelled when the mono is revoked, or in cluster mode when the unique code lease is lost.
+func (flux *ZeroMfgrcFlux) execMono(mono MfgrcMono) error {
+	fence, cancelFence := context.WithCancelCause(context.Background())
+	defer cancelFence(nil)
+	flux.keeper.fenceCode(flux.UniqueId, cancelFence)
+	defer flux.keeper.fenceCode(flux.UniqueId, nil)
+	flux.runMono(mono, cancelFence)
+	defer flux.runMono(nil, nil)
+	fenced := func(err error) error {
+		if cause := context.Cause(fence); cause != nil {
+			return fmt.Errorf("flux `%s` mono `%s` cancelled, %w", flux.UniqueId, mono.XmonoId(), cause)
+		}
+		return err
+	}
+
+	if flux.keeper.taskWaitSeconds <= 0 {
+		return fenced(mono.DoContext(fence))
+	}
+	ctx, cancel := context.WithTimeout(fence, time.Second*time.Duration(flux.keeper.taskWaitSeconds))
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- mono.DoContext(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+		if ctx.Err() == nil {
+			return err
+		}
+	case <-ctx.Done():
+		select {
+		case err = <-done:
+		case <-time.After(time.Second * time.Duration(flux.keeper.taskWaitSeconds)):
+			global.Logger().Warn(fmt.Sprintf("flux `%s` mono `%s` abandoned, Do still running", flux.UniqueId, mono.XmonoId()))
+			return &ZeroMfgrcTimeoutError{MonoId: mono.XmonoId(), WaitSeconds: flux.keeper.taskWaitSeconds, Abandoned: true, done: done}
+		}
+	}
+	if context.Cause(fence) != nil {
+		return fenced(err)
+	}
+	return &ZeroMfgrcTimeoutError{MonoId: mono.XmonoId(), WaitSeconds: flux.keeper.taskWaitSeconds}
+}
+
+func (flux *ZeroMfgrcFlux) runMono(mono MfgrcMono, cancel context.CancelCauseFunc) {
+	flux.monoMutex.Lock()
+	defer flux.monoMutex.Unlock()
+	flux.running = mono
+	flux.cancelRunning = cancel
+}
+
+// completeMono settles one execution, it returns true with the backoff delay when a retry should be scheduled.
+func (flux *ZeroMfgrcFlux) completeMono(mono MfgrcMono, err error) (time.Duration, bool) {
 	if err == nil {
 		err = mono.Complete()
 		if err != nil {
 			mono.Failed(err)
 		}
-	} else {
-		global.Logger().Error(fmt.Sprintf("flux `%s` mono `%s` error : %s", flux.UniqueId, mono.XmonoId(), err.Error()))
-		if mono.MaxExecuteTimes() > 1 {
-			err := mono.Retrying(err)
-			if err != nil {
-				mono.Failed(err)
-			} else {
-				if flux.keeper.taskIntervalSeconds > 0 {
-					<-time.After(time.Second * time.Duration(flux.keeper.taskIntervalSeconds))
-				}
-				flux.completeMono(mono, mono.Do())
-			}
-		} else {
-			mono.Failed(err)
+		return 0, false
+	}
+
+	if errors.Is(err, xErrMfgrcMonoRevoked) {
+		mono.Revoke()
+		return 0, false
+	}
+	global.Logger().Error(fmt.Sprintf("flux `%s` mono `%s` error : %s", flux.UniqueId, mono.XmonoId(), err.Error()))
+	var timeoutErr *ZeroMfgrcTimeoutError
+	if errors.As(err, &timeoutErr) && timeoutErr.Abandoned {
+		mono.Timeout()
+		return 0, false
+	}
+	policy := flux.keeper.monoRetryPolicy(mono)
+	if mono.MaxExecuteTimes() > 1 && policy.Retryable(err) {
+		xerr := mono.Retrying(err)
+		if xerr == nil {
+			return policy.Delay(mono.ExecuteTimes() - 1), true
+		}
+		if !errors.As(err, &timeoutErr) {
+			mono.Failed(xerr)
+			return 0, false
 		}
 	}
+	if errors.As(err, &timeoutErr) {
+		mono.Timeout()
+	} else {
+		mono.Failed(err)
+	}
+	return 0, false
+}
+
+// park keeps a retrying mono at the head of its flux, the worker is released and the flux
+// rejoins the lanes after delay so later monos of the code never overtake the retry.
+func (flux *ZeroMfgrcFlux) park(mono MfgrcMono, delay time.Duration) {
+	global.Logger().Info(fmt.Sprintf("mono `%s` will retry after %s", mono.XmonoId(), delay))
+	flux.monoMutex.Lock()
+	flux.head = mono
+	flux.monoMutex.Unlock()
+	time.AfterFunc(delay, func() {
+		flux.monoMutex.RLock()
+		priority := flux.priority
+		flux.monoMutex.RUnlock()
+		flux.keeper.lanes.push(flux, priority)
+	})
+}
+
+// hold releases the worker while an abandoned Do is still running, the flux keeps its code
+// and rejoins the lanes once Do returns.
+func (flux *ZeroMfgrcFlux) hold(mono MfgrcMono, done <-chan error) {
+	global.Logger().Info(fmt.Sprintf("flux `%s` held until abandoned mono `%s` returns", flux.UniqueId, mono.XmonoId()))
+	go func() {
+		err := <-done
+		if err != nil {
+			global.Logger().Warn(fmt.Sprintf("flux `%s` abandoned mono `%s` returned : %s", flux.UniqueId, mono.XmonoId(), err.Error()))
+		}
+		flux.monoMutex.RLock()
+		priority := flux.priority
+		flux.monoMutex.RUnlock()
+		flux.keeper.lanes.push(flux, priority)
+	}()
 }
 
 func (flux *ZeroMfgrcFlux) runLoop() bool {
-	select {
-	case mono := <-flux.monos:
-		if mono.State() != WORKER_MONO_STATUS_PENDING && mono.State() != WORKER_MONO_STATUS_EXECUTING && mono.State() != WORKER_MONO_STATUS_RETRYING {
-			flux.cleanMono(mono)
-		} else {
-			if mono.State() == WORKER_MONO_STATUS_PENDING {
-				err := mono.Executing()
-				if err != nil {
-					mono.Failed(err)
-					flux.cleanMono(mono)
-				}
-			}
-			if mono.State() == WORKER_MONO_STATUS_EXECUTING {
-				flux.completeMono(mono, mono.Do())
+	flux.monoMutex.Lock()
+	mono := flux.head
+	flux.head = nil
+	flux.monoMutex.Unlock()
+	if mono == nil {
+		select {
+		case mono = <-flux.monos:
+		case <-time.After(time.Millisecond * time.Duration(500)):
+			return flux.close()
+		}
+	}
+
+	if mono.State() != WORKER_MONO_STATUS_PENDING && mono.State() != WORKER_MONO_STATUS_EXECUTING && mono.State() != WORKER_MONO_STATUS_RETRYING {
+		flux.cleanMono(mono)
+	} else if !flux.keeper.ownsCode(flux.UniqueId) && !flux.keeper.reclaimCode(flux.UniqueId) {
+		err := flux.keeper.forwardMono(mono)
+		if errors.Is(err, xErrMfgrcLeaseBusy) {
+			flux.park(mono, time.Second*time.Duration(max(flux.keeper.cluster.TTL/3, 1)))
+			return false
+		}
+		flux.cleanMono(mono)
+		if err != nil {
+			mono.Failed(err)
+		}
+	} else {
+		if mono.State() == WORKER_MONO_STATUS_PENDING {
+			err := mono.Executing()
+			if err != nil {
+				mono.Failed(err)
 				flux.cleanMono(mono)
 			}
 		}
-		if flux.keeper.taskIntervalSeconds > 0 {
-			<-time.After(time.Second * time.Duration(flux.keeper.taskIntervalSeconds))
+		if mono.State() == WORKER_MONO_STATUS_EXECUTING || mono.State() == WORKER_MONO_STATUS_RETRYING {
+			err := flux.execMono(mono)
+			if errors.Is(err, xErrMfgrcLeaseLost) {
+				global.Logger().Warn(err.Error())
+				flux.park(mono, 0)
+				return false
+			}
+			delay, retry := flux.completeMono(mono, err)
+			if retry {
+				flux.park(mono, delay)
+				return false
+			}
+			flux.cleanMono(mono)
+			var timeoutErr *ZeroMfgrcTimeoutError
+			if errors.As(err, &timeoutErr) && timeoutErr.done != nil {
+				flux.hold(mono, timeoutErr.done)
+				return false
+			}
 		}
-		return true
-	case <-time.After(time.Millisecond * time.Duration(500)):
-		return flux.close()
 	}
+	if flux.keeper.taskIntervalSeconds > 0 {
+		<-time.After(time.Second * time.Duration(flux.keeper.taskIntervalSeconds))
+	}
+	return true
 }
 
 func (flux *ZeroMfgrcFlux) Start(worker *ZeroMfgrcWorker) {
@@ -179,6 +335,7 @@ func (flux *ZeroMfgrcFlux) Export() (map[string]interface{}, error) {
 	exportMap := make(map[string]interface{})
 
 	exportMap["uniqueId"] = flux.UniqueId
+	exportMap["priority"] = flux.priority
 	exportMap["workName"] = flux.worker.workName
 
 	monosMap := make(map[string]interface{})
@@ -220,12 +377,16 @@ func (worker *ZeroMfgrcWorker) Start() {
 	worker.statusMutex.Unlock()
 
 	global.Logger().Info(fmt.Sprintf("[%s] ready and waiting ...", worker.workName))
-	for xQueue := range worker.keeper.mfgrcChan {
+	for {
+		xQueue, _ := worker.keeper.lanes.take().(*ZeroMfgrcFlux)
 		worker.statusMutex.Lock()
 		xstatus := worker.status
 		worker.statusMutex.Unlock()
 
 		if xQueue == nil || xstatus != xWORKER_STATUS_RUNNING {
+			if xQueue != nil {
+				worker.keeper.lanes.push(xQueue, xQueue.priority)
+			}
 			break
 		}
 
@@ -264,8 +425,8 @@ type ZeroMfgrcKeeper struct {
 	workerMutex sync.RWMutex
 
 	mfgrcMap   map[string]*ZeroMfgrcFlux
-	mfgrcChan  chan *ZeroMfgrcFlux
 	mfgrcMutex sync.RWMutex
+	lanes      *xMfgrcLanes
 
 	maxQueues           int
 	maxQueueLimit       int
@@ -277,7 +438,23 @@ type ZeroMfgrcKeeper struct {
 	status      string
 	statusMutex sync.RWMutex
 
-	keeperOpts ZeroMfgrcKeeperOpts
+	keeperOpts  ZeroMfgrcKeeperOpts
+	retryPolicy *ZeroMfgrcRetryPolicy
+
+	recoveryMode      string
+	recoveryExecuting string
+	recoveryHandler   ZeroMfgrcRecoveryHandler
+	recoveryReport    *ZeroMfgrcRecoveryReport
+
+	scheduleMap   map[string]*xMfgrcSchedule
+	scheduleMutex sync.Mutex
+	scheduleTimer *time.Timer
+	scheduleSeq   int
+
+	cluster     *ZeroMfgrcCluster
+	leaseMap    map[string]*xMfgrcLease
+	leaseMutex  sync.Mutex
+	clusterStop chan struct{}
 }
 
 func NewWorker(
@@ -294,7 +471,9 @@ func NewWorker(
 		keeperName:          keeperName,
 		workerMap:           make(map[string]*ZeroMfgrcWorker),
 		mfgrcMap:            make(map[string]*ZeroMfgrcFlux),
-		mfgrcChan:           make(chan *ZeroMfgrcFlux, maxQueues),
+		lanes:               newMfgrcLanes(),
+		scheduleMap:         make(map[string]*xMfgrcSchedule),
+		leaseMap:            make(map[string]*xMfgrcLease),
 		maxQueues:           maxQueues,
 		maxQueueLimit:       maxQueueLimit,
 		taskWaitSeconds:     taskWaitSeconds,
@@ -303,9 +482,30 @@ func NewWorker(
 		taskRetryInterval:   taskRetryInterval,
 		status:              xKEEPER_STATUS_STOPPED,
 		keeperOpts:          keeperOpts,
+		retryPolicy:         NewMfgrcRetryPolicy(MFGRC_BACKOFF_FIXED, taskRetryInterval, 0, taskRetryTimes),
+		recoveryMode:        MFGRC_RECOVERY_REVOKE,
+		recoveryExecuting:   MFGRC_RECOVERY_FAIL,
 	}
 }
 
+func (keeper *ZeroMfgrcKeeper) UseRetryPolicy(policy *ZeroMfgrcRetryPolicy) *ZeroMfgrcKeeper {
+	keeper.retryPolicy = policy
+	return keeper
+}
+
+// UsePriorityPolicy chooses how waiting fluxes are served across priorities, monos of one flux keep their order.
+func (keeper *ZeroMfgrcKeeper) UsePriorityPolicy(policy *ZeroMfgrcPriorityPolicy) *ZeroMfgrcKeeper {
+	keeper.lanes.usePolicy(policy)
+	return keeper
+}
+
+func (keeper *ZeroMfgrcKeeper) monoRetryPolicy(mono MfgrcMono) *ZeroMfgrcRetryPolicy {
+	if mono.RetryPolicy() != nil {
+		return mono.RetryPolicy()
+	}
+	return keeper.retryPolicy
+}
+
 func (keeper *ZeroMfgrcKeeper) RunWorker() {
 	global.Logger().Info(fmt.Sprintf("worker start with maxQueues: %d, maxGroupLimit: %d, taskRetryTimes: %d, taskWaitSeconds: %ds",
 		keeper.maxQueues,
@@ -317,6 +517,7 @@ func (keeper *ZeroMfgrcKeeper) RunWorker() {
 		keeper.keeperName = "default"
 	}
 
+	keeper.lanes.open()
 	for i := 0; i < keeper.maxQueues; i++ {
 		worker := newMfgrcWorker(fmt.Sprintf("%s-worker-%03d::", keeper.keeperName, i), keeper)
 
@@ -325,6 +526,10 @@ func (keeper *ZeroMfgrcKeeper) RunWorker() {
 		keeper.workerMutex.Unlock()
 		go worker.Start()
 	}
+	if keeper.cluster != nil {
+		keeper.clusterStop = make(chan struct{})
+		go keeper.runCluster(keeper.clusterStop)
+	}
 	go keeper.resumeMonos()
 }
 
@@ -333,35 +538,99 @@ func (keeper *ZeroMfgrcKeeper) ShutdownWorker() {
 		worker.Stop()
 	}
 
-	keeper.mfgrcMutex.Lock()
-	for i := 0; i < keeper.maxQueues-len(keeper.mfgrcMap); i++ {
-		keeper.mfgrcChan <- nil
+	keeper.lanes.close()
+	if keeper.clusterStop != nil {
+		close(keeper.clusterStop)
+		keeper.clusterStop = nil
 	}
-	keeper.mfgrcMutex.Unlock()
 
 	keeper.statusMutex.Lock()
 	defer keeper.statusMutex.Unlock()
 	keeper.status = xKEEPER_STATUS_STOPPING
 }
 
+// resumeMonos handles the monos left unfinished by the last run, in cluster mode only codes
+// this node can hold are handled and the rest stay with their owners.
 func (keeper *ZeroMfgrcKeeper) resumeMonos() {
+	report := newMfgrcRecoveryReport(keeper)
+	monos := make([]MfgrcMono, 0)
+	codes := make([]string, 0)
 	if keeper.keeperOpts != nil {
 		<-time.After(time.Second * time.Duration(3))
-		monos, err := keeper.keeperOpts.FetchUncompleteMonos()
+		xMonos, xCodes, err := keeper.claimUncompleteMonos(func(MfgrcMono) bool { return true }, report)
 		if err != nil {
 			global.Logger().Error(fmt.Sprintf(" resume monos err : %s", err.Error()))
 		} else {
-			for _, mono := range monos {
-				mono.Store(keeper.keeperOpts.MonoStore())
-				mono.Revoke()
-			}
+			monos = xMonos
 		}
+		codes = append(codes, xCodes...)
 	}
+	report.Total = len(monos)
+
 	keeper.statusMutex.Lock()
 	keeper.status = xKEEPER_STATUS_RUNNING
 	keeper.statusMutex.Unlock()
 
-	global.Logger().Info(" worker check and resume monos complete ")
+	keeper.scheduleMutex.Lock()
+	keeper.rearmSchedule()
+	keeper.scheduleMutex.Unlock()
+
+	keeper.recoverUnfinished(monos, report)
+	for _, uniqueCode := range codes {
+		keeper.unholdCode(uniqueCode)
+	}
+	report.EndTime = time.Now()
+
+	keeper.statusMutex.Lock()
+	keeper.recoveryReport = report
+	keeper.statusMutex.Unlock()
+
+	global.Logger().Info(fmt.Sprintf(" worker check and resume monos complete, report: %s", report))
+	if xListener, ok := keeper.keeperOpts.(ZeroMfgrcRecoveryListener); ok {
+		xListener.OnRecovered(report)
+	}
+}
+
+func (keeper *ZeroMfgrcKeeper) recoverUnfinished(monos []MfgrcMono, report *ZeroMfgrcRecoveryReport) {
+	scheduled := make([]MfgrcMono, 0)
+	unfinished := make([]MfgrcMono, 0, len(monos))
+	for _, mono := range monos {
+		if mono.State() == WORKER_MONO_STATUS_SCHEDULED {
+			scheduled = append(scheduled, mono)
+		} else {
+			unfinished = append(unfinished, mono)
+		}
+	}
+	monos = unfinished
+
+	if keeper.recoveryMode != MFGRC_RECOVERY_RESUME {
+		for _, mono := range monos {
+			mono.Revoke()
+			report.Revoked = append(report.Revoked, mono.XmonoId())
+		}
+	}
+
+	for _, mono := range scheduled {
+		err := mono.Resume(keeper)
+		if err == nil {
+			_, err = keeper.holdCode(mono.XuniqueCode())
+			if err == nil {
+				err = keeper.scheduleMono(mono)
+				if err != nil {
+					keeper.unholdCode(mono.XuniqueCode())
+				}
+			}
+		}
+		if err != nil {
+			mono.Failed(err)
+			report.Failed[mono.XmonoId()] = err.Error()
+		} else {
+			report.Scheduled = append(report.Scheduled, mono.XmonoId())
+		}
+	}
+	if keeper.recoveryMode == MFGRC_RECOVERY_RESUME {
+		keeper.recoverMonos(monos, report)
+	}
 }
 
 func (keeper *ZeroMfgrcKeeper) closeWorker(worker *ZeroMfgrcWorker) {
@@ -392,15 +661,53 @@ func (keeper *ZeroMfgrcKeeper) AddMono(mono MfgrcMono) error {
 	} else if xStatus == xKEEPER_STATUS_STOPPING {
 		return errors.New("keeper is stopping now")
 	}
+	return keeper.addMono(mono)
+}
+
+// addMono queues a mono on this node, in cluster mode a unique code owned by another node is forwarded.
+func (keeper *ZeroMfgrcKeeper) addMono(mono MfgrcMono) error {
+	if keeper.scheduling(mono) {
+		owned, err := keeper.holdCode(mono.XuniqueCode())
+		if err != nil {
+			return err
+		}
+		if !owned {
+			return keeper.forwardMono(mono)
+		}
+		err = keeper.scheduleMono(mono)
+		if err != nil {
+			keeper.unholdCode(mono.XuniqueCode())
+		}
+		return err
+	}
 
 	keeper.mfgrcMutex.Lock()
-	defer keeper.mfgrcMutex.Unlock()
 	flux, ok := keeper.mfgrcMap[mono.XuniqueCode()]
-	if !ok {
-		return newMfgrcFlux(mono, keeper)
-	} else {
+	if ok {
+		defer keeper.mfgrcMutex.Unlock()
+		return flux.Push(mono, keeper)
+	}
+	keeper.mfgrcMutex.Unlock()
+
+	owned, err := keeper.holdCode(mono.XuniqueCode())
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return keeper.forwardMono(mono)
+	}
+
+	keeper.mfgrcMutex.Lock()
+	defer keeper.mfgrcMutex.Unlock()
+	if flux, ok := keeper.mfgrcMap[mono.XuniqueCode()]; ok {
+		keeper.unholdCode(mono.XuniqueCode())
 		return flux.Push(mono, keeper)
 	}
+	err = newMfgrcFlux(mono, keeper)
+	if err != nil {
+		keeper.unholdCode(mono.XuniqueCode())
+	}
+	return err
 }
 
 func (keeper *ZeroMfgrcKeeper) RevokeMono(mono MfgrcMono) error {
@@ -412,6 +719,9 @@ func (keeper *ZeroMfgrcKeeper) RevokeMono(mono MfgrcMono) error {
 	} else if xStatus == xKEEPER_STATUS_STOPPING {
 		return errors.New("keeper is stopping now")
 	}
+	if ok, err := keeper.revokeSchedule(mono); ok {
+		return err
+	}
 
 	keeper.mfgrcMutex.Lock()
 	flux, ok := keeper.mfgrcMap[mono.XuniqueCode()]
@@ -442,15 +752,8 @@ func (keeper *ZeroMfgrcKeeper) AddMonosQueue(monos ...MfgrcMono) error {
 		return fmt.Errorf("exceeding maximum number of monos = %d", keeper.maxQueueLimit)
 	}
 
-	keeper.mfgrcMutex.Lock()
-	defer keeper.mfgrcMutex.Unlock()
 	for _, mono := range monos {
-		flux, ok := keeper.mfgrcMap[mono.XuniqueCode()]
-		if !ok {
-			return newMfgrcFlux(mono, keeper)
-		} else {
-			return flux.Push(mono, keeper)
-		}
+		return keeper.addMono(mono)
 	}
 	return nil
 }
@@ -479,6 +782,25 @@ func (keeper *ZeroMfgrcKeeper) Check(mono MfgrcMono) error {
 	}
 }
 
+// QueueDepth reports waiting fluxes and queued monos per priority.
+func (keeper *ZeroMfgrcKeeper) QueueDepth() map[string]interface{} {
+	monos := make(map[string]int)
+	keeper.mfgrcMutex.RLock()
+	for _, flux := range keeper.mfgrcMap {
+		flux.monoMutex.RLock()
+		for _, mono := range flux.monoMap {
+			monos[strconv.Itoa(mono.Xpriority())]++
+		}
+		flux.monoMutex.RUnlock()
+	}
+	keeper.mfgrcMutex.RUnlock()
+
+	depth := make(map[string]interface{})
+	depth["fluxs"] = keeper.lanes.depth()
+	depth["monos"] = monos
+	return depth
+}
+
 func (keeper *ZeroMfgrcKeeper) Export() (map[string]interface{}, error) {
 	configs := make(map[string]interface{})
 
@@ -490,6 +812,12 @@ func (keeper *ZeroMfgrcKeeper) Export() (map[string]interface{}, error) {
 	configs["taskRetryTimes"] = keeper.taskRetryTimes
 	configs["taskRetryInterval"] = keeper.taskRetryInterval
 	configs["status"] = keeper.status
+	configs["recoveryMode"] = keeper.recoveryMode
+	configs["recoveryExecuting"] = keeper.recoveryExecuting
+	configs["priorityMode"] = keeper.lanes.mode()
+	if keeper.cluster != nil {
+		configs["cluster"] = keeper.cluster.Export()
+	}
 
 	workers := make(map[string]interface{})
 	keeper.workerMutex.RLock()
@@ -498,6 +826,8 @@ func (keeper *ZeroMfgrcKeeper) Export() (map[string]interface{}, error) {
 	}
 	keeper.workerMutex.RUnlock()
 
+	priorities := keeper.QueueDepth()
+
 	fluxs := make(map[string]interface{})
 	keeper.mfgrcMutex.RLock()
 	defer keeper.mfgrcMutex.RUnlock()
@@ -513,6 +843,18 @@ func (keeper *ZeroMfgrcKeeper) Export() (map[string]interface{}, error) {
 	exports["configs"] = configs
 	exports["workers"] = workers
 	exports["fluxs"] = fluxs
+	exports["priorities"] = priorities
+	schedules, err := keeper.exportSchedules()
+	if err != nil {
+		return nil, err
+	}
+	exports["schedules"] = schedules
+	if keeper.cluster != nil {
+		exports["leases"] = keeper.exportLeases()
+	}
+	if keeper.recoveryReport != nil {
+		exports["recovery"] = keeper.recoveryReport
+	}
 
 	return exports, nil
 }
@@ -0,0 +1,234 @@
+package mfgrc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type xTestMfgrcListener struct {
+	events chan string
+}
+
+func newTestMfgrcListener() *xTestMfgrcListener {
+	return &xTestMfgrcListener{events: make(chan string, 64)}
+}
+
+func (xListener *xTestMfgrcListener) record(mono MfgrcMono, event string) error {
+	xListener.events <- mono.XmonoId() + ":" + event
+	return nil
+}
+
+func (xListener *xTestMfgrcListener) OnPending(mono MfgrcMono) error {
+	return xListener.record(mono, WORKER_MONO_STATUS_PENDING)
+}
+func (xListener *xTestMfgrcListener) OnRevoke(mono MfgrcMono) error {
+	return xListener.record(mono, WORKER_MONO_STATUS_REVOKE)
+}
+func (xListener *xTestMfgrcListener) OnExecuting(mono MfgrcMono) error {
+	return xListener.record(mono, WORKER_MONO_STATUS_EXECUTING)
+}
+func (xListener *xTestMfgrcListener) OnRetrying(mono MfgrcMono) error {
+	return xListener.record(mono, WORKER_MONO_STATUS_RETRYING)
+}
+func (xListener *xTestMfgrcListener) OnComplete(mono MfgrcMono) error {
+	return xListener.record(mono, WORKER_MONO_STATUS_COMPLETE)
+}
+func (xListener *xTestMfgrcListener) OnFailed(mono MfgrcMono, reason error) error {
+	return xListener.record(mono, WORKER_MONO_STATUS_FAILED)
+}
+func (xListener *xTestMfgrcListener) OnTimeout(mono MfgrcMono, reason error) error {
+	return xListener.record(mono, WORKER_MONO_STATUS_TIMEOUT)
+}
+
+// await skips events until expected arrives and fails on any of unexpected on the way.
+func (xListener *xTestMfgrcListener) await(t *testing.T, timeout time.Duration, expected string, unexpected ...string) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event := <-xListener.events:
+			if event == expected {
+				return
+			}
+			for _, xEvent := range unexpected {
+				if event == xEvent {
+					t.Fatalf("event %s while waiting for %s", event, expected)
+				}
+			}
+		case <-deadline:
+			t.Fatalf("timeout waiting for event %s", expected)
+		}
+	}
+}
+
+type xTestMfgrcMono struct {
+	ZeroMfgrcMono
+	do func(context.Context) error
+}
+
+func (mono *xTestMfgrcMono) DoContext(ctx context.Context) error {
+	return mono.do(ctx)
+}
+
+func newTestMfgrcMono(t *testing.T, keeper *ZeroMfgrcKeeper, xListener ZeroMfgrcMonoEventListener, monoId string, uniqueCode string, do func(context.Context) error) *xTestMfgrcMono {
+	t.Helper()
+	mono := &xTestMfgrcMono{ZeroMfgrcMono: ZeroMfgrcMono{MonoID: monoId, UniqueCode: uniqueCode}, do: do}
+	mono.ThisDef(mono)
+	mono.EventListener(xListener)
+	err := mono.Ready(keeper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mono
+}
+
+func runTestMfgrcKeeper(t *testing.T, keeper *ZeroMfgrcKeeper) *ZeroMfgrcKeeper {
+	t.Helper()
+	keeper.RunWorker()
+	t.Cleanup(keeper.ShutdownWorker)
+	deadline := time.Now().Add(time.Second)
+	for {
+		keeper.statusMutex.RLock()
+		status := keeper.status
+		keeper.statusMutex.RUnlock()
+		if status == xKEEPER_STATUS_RUNNING {
+			return keeper
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("keeper not running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testMfgrcAddMono(t *testing.T, keeper *ZeroMfgrcKeeper, mono MfgrcMono) {
+	t.Helper()
+	err := keeper.AddMono(mono)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMfgrcExecMonoTimeout(t *testing.T) {
+	keeper := runTestMfgrcKeeper(t, NewWorker("timeout", nil, 1, 10, 1, 0, 0, 0))
+	xListener := newTestMfgrcListener()
+
+	cancelled := newTestMfgrcMono(t, keeper, xListener, "cancelled", "code", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	testMfgrcAddMono(t, keeper, cancelled)
+	xListener.await(t, 3*time.Second, "cancelled:"+WORKER_MONO_STATUS_TIMEOUT, "cancelled:"+WORKER_MONO_STATUS_FAILED)
+
+	late := newTestMfgrcMono(t, keeper, xListener, "late", "code", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	testMfgrcAddMono(t, keeper, late)
+	xListener.await(t, 3*time.Second, "late:"+WORKER_MONO_STATUS_TIMEOUT, "late:"+WORKER_MONO_STATUS_COMPLETE)
+
+	quick := newTestMfgrcMono(t, keeper, xListener, "quick", "code", func(ctx context.Context) error {
+		return nil
+	})
+	testMfgrcAddMono(t, keeper, quick)
+	xListener.await(t, 3*time.Second, "quick:"+WORKER_MONO_STATUS_COMPLETE, "quick:"+WORKER_MONO_STATUS_TIMEOUT)
+}
+
+func TestMfgrcExecMonoAbandon(t *testing.T) {
+	keeper := runTestMfgrcKeeper(t, NewWorker("abandon", nil, 2, 10, 1, 0, 0, 0))
+	xListener := newTestMfgrcListener()
+
+	release := make(chan struct{})
+	returned := make(chan time.Time, 1)
+	stuck := newTestMfgrcMono(t, keeper, xListener, "stuck", "code", func(ctx context.Context) error {
+		<-release
+		returned <- time.Now()
+		return nil
+	})
+	executed := make(chan time.Time, 1)
+	next := newTestMfgrcMono(t, keeper, xListener, "next", "code", func(ctx context.Context) error {
+		executed <- time.Now()
+		return nil
+	})
+	testMfgrcAddMono(t, keeper, stuck)
+	testMfgrcAddMono(t, keeper, next)
+
+	xListener.await(t, 4*time.Second, "stuck:"+WORKER_MONO_STATUS_TIMEOUT, "stuck:"+WORKER_MONO_STATUS_COMPLETE)
+	select {
+	case <-executed:
+		t.Fatal("next mono of the code overlapped the abandoned Do")
+	case <-time.After(500 * time.Millisecond):
+	}
+	keeper.mfgrcMutex.RLock()
+	_, held := keeper.mfgrcMap["code"]
+	keeper.mfgrcMutex.RUnlock()
+	if !held {
+		t.Fatal("flux dropped while its abandoned Do is running")
+	}
+
+	other := newTestMfgrcMono(t, keeper, xListener, "other", "other-code", func(ctx context.Context) error { return nil })
+	testMfgrcAddMono(t, keeper, other)
+	xListener.await(t, 2*time.Second, "other:"+WORKER_MONO_STATUS_COMPLETE)
+
+	close(release)
+	returnedAt := <-returned
+	xListener.await(t, 2*time.Second, "next:"+WORKER_MONO_STATUS_COMPLETE)
+	if executedAt := <-executed; executedAt.Before(returnedAt) {
+		t.Fatal("next mono executed before the abandoned Do returned")
+	}
+}
+
+func TestMfgrcParkKeepsOrder(t *testing.T) {
+	keeper := runTestMfgrcKeeper(t, NewWorker("park", nil, 2, 10, 0, 0, 2, 1))
+	xListener := newTestMfgrcListener()
+
+	attempts := 0
+	first := newTestMfgrcMono(t, keeper, xListener, "first", "code", func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("busy")
+		}
+		return nil
+	})
+	second := newTestMfgrcMono(t, keeper, xListener, "second", "code", func(ctx context.Context) error { return nil })
+	testMfgrcAddMono(t, keeper, first)
+	testMfgrcAddMono(t, keeper, second)
+
+	unexpected := []string{"second:" + WORKER_MONO_STATUS_EXECUTING, "first:" + WORKER_MONO_STATUS_FAILED}
+	xListener.await(t, 2*time.Second, "first:"+WORKER_MONO_STATUS_RETRYING, unexpected...)
+	xListener.await(t, 2*time.Second, "first:"+WORKER_MONO_STATUS_RETRYING, unexpected...)
+	xListener.await(t, 2*time.Second, "first:"+WORKER_MONO_STATUS_COMPLETE, unexpected...)
+	xListener.await(t, 2*time.Second, "second:"+WORKER_MONO_STATUS_COMPLETE)
+	if attempts != 3 {
+		t.Fatalf("first attempts %d, expected 3", attempts)
+	}
+}
+
+func TestMfgrcRevokeRunning(t *testing.T) {
+	keeper := runTestMfgrcKeeper(t, NewWorker("revoke-running", nil, 1, 10, 5, 0, 2, 0))
+	xListener := newTestMfgrcListener()
+
+	started := make(chan struct{})
+	running := newTestMfgrcMono(t, keeper, xListener, "running", "code", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	})
+	next := newTestMfgrcMono(t, keeper, xListener, "next", "code", func(ctx context.Context) error {
+		return nil
+	})
+	testMfgrcAddMono(t, keeper, running)
+	testMfgrcAddMono(t, keeper, next)
+	<-started
+
+	err := keeper.RevokeMono(running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xListener.await(t, 2*time.Second, "running:"+WORKER_MONO_STATUS_REVOKE,
+		"running:"+WORKER_MONO_STATUS_COMPLETE, "running:"+WORKER_MONO_STATUS_RETRYING, "next:"+WORKER_MONO_STATUS_COMPLETE)
+	xListener.await(t, 2*time.Second, "next:"+WORKER_MONO_STATUS_COMPLETE, "running:"+WORKER_MONO_STATUS_COMPLETE)
+}
@@ -1,6 +1,11 @@
 package protocol
 
-import "github.com/0meet1/zero-framework/server"
+import (
+	"context"
+	"io"
+
+	"github.com/0meet1/zero-framework/server"
+)
 
 const (
 	ZEROKMSG_SERVER = "ZEROKMSG_SERVER"
@@ -13,16 +18,40 @@ var (
 )
 
 type ZeroKMessageServer interface {
+	LookupConnect(string) (ZeroKMessageConnect, error)
+	Online(string) bool
 	ExecMessage(string, *ZeroKMessage, int) (*ZeroKMessage, error)
+	ExecMessageContext(context.Context, string, *ZeroKMessage) (*ZeroKMessage, error)
+	ExecStream(context.Context, string, byte, io.Reader) (*ZeroKMessage, error)
 	PushMessage(string, *ZeroKMessage) error
+	PushStream(context.Context, string, byte, io.Reader) error
+	SendFile(context.Context, string, string, map[string]string, ZeroKMessageFileProgress) error
+	PushReliable(string, *ZeroKMessage, int) error
+	ReliableStatus(string) (*ZeroKMessageReliableRecord, error)
+	Publish(string, []byte, bool) (int, error)
+	Subscribers(string) []string
+	Shutdown() error
 }
 
 type ZeroKMessageClient interface {
 	Active() bool
+	ConnectError() error
+	SessionKey() string
 	ExecMessage(*ZeroKMessage, int) (*ZeroKMessage, error)
+	ExecMessageContext(context.Context, *ZeroKMessage) (*ZeroKMessage, error)
+	ExecStream(context.Context, byte, io.Reader) (*ZeroKMessage, error)
 	PushMessage(*ZeroKMessage) error
+	PushStream(context.Context, byte, io.Reader) error
+	SendFile(context.Context, string, map[string]string, ZeroKMessageFileProgress) error
+	Subscribe(context.Context, string, ZeroKMessageTopicHandler) error
+	Unsubscribe(context.Context, string) error
+	Publish(context.Context, string, []byte, bool) (int, error)
 }
 
 type ZeroKMessageOperator interface {
 	Operation(server.ZeroConnect, *ZeroKMessage) (bool, error)
 }
+
+type ZeroKMessageClientOperator interface {
+	ClientOperation(ZeroKMessageClient, *ZeroKMessage) (bool, error)
+}
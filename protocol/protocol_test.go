@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/server"
+)
+
+func TestMain(m *testing.M) {
+	cfgpath, err := os.MkdirTemp("", "protocol")
+	if err != nil {
+		panic(err)
+	}
+	err = os.MkdirAll(filepath.Join(cfgpath, "conf"), 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(cfgpath, "conf", "zero-framework.yml"), []byte("zero:\n  app: protocol\n"), 0644)
+	}
+	if err != nil {
+		panic(err)
+	}
+	global.RunTest("protocol", cfgpath)
+	code := m.Run()
+	os.RemoveAll(cfgpath)
+	os.Exit(code)
+}
+
+type xTestZeroServ struct{}
+
+func (xServ *xTestZeroServ) OnConnect(server.ZeroConnect) error    { return nil }
+func (xServ *xTestZeroServ) OnDisconnect(server.ZeroConnect) error { return nil }
+func (xServ *xTestZeroServ) OnAuthorized(server.ZeroConnect) error { return nil }
+func (xServ *xTestZeroServ) OnHeartbeat(server.ZeroConnect) error  { return nil }
+func (xServ *xTestZeroServ) UseConnect(registerId string) (server.ZeroConnect, error) {
+	return nil, nil
+}
+
+type xTestSecretFetcher map[string]string
+
+func (fetcher xTestSecretFetcher) FetchSecret(uniquekey string) string {
+	return fetcher[uniquekey]
+}
+
+func newTestKMessageKeeper(fetcher ZeroKMessageSecretFetcher) *kZeroKMessageKeeper {
+	options := newKMessageOptions()
+	return &kZeroKMessageKeeper{
+		TCPServer:    *server.NewTCPServer("127.0.0.1:0", xDEFAULT_AUTH_WAIT, 60, options.bufferSize),
+		maxInflights: kMessageMaxInflights(),
+		fetcher:      fetcher,
+		nonces:       newKMessageNonces(),
+		options:      options,
+		fileOptions:  newKMessageFileOptions(),
+		reliable:     newKMessageReliable(),
+		topics:       newKMessageTopics(),
+		duplicate:    KMESSAGE_DUPLICATE_KICK,
+	}
+}
+
+type xTestKMessagePeer struct {
+	conn     *kZeroKMessageConnect
+	client   net.Conn
+	messages chan *ZeroKMessage
+}
+
+func newTestKMessagePeer(keeper *kZeroKMessageKeeper) *xTestKMessagePeer {
+	serverconn, client := net.Pipe()
+	peer := &xTestKMessagePeer{
+		conn:     newKMessageConnect(keeper),
+		client:   client,
+		messages: make(chan *ZeroKMessage, 64),
+	}
+	peer.conn.Accept(&xTestZeroServ{}, serverconn)
+	go func() {
+		defer close(peer.messages)
+		checker := &kZeroKMessageChecker{}
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := client.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, data := range checker.CheckPackageData("peer", append([]byte{}, buf[:n]...)) {
+				peer.messages <- ParseKMessage(data)
+			}
+		}
+	}()
+	return peer
+}
+
+func (peer *xTestKMessagePeer) send(t *testing.T, message *ZeroKMessage) error {
+	t.Helper()
+	err := message.Complete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peer.conn.OnMessage(message.Bytes())
+}
+
+func (peer *xTestKMessagePeer) next(t *testing.T, messageType int) *ZeroKMessage {
+	t.Helper()
+	for {
+		select {
+		case message, ok := <-peer.messages:
+			if !ok {
+				t.Fatalf("peer closed while waiting for message type 0x%02x", messageType)
+			}
+			if message.MessageType() == messageType {
+				return message
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for message type 0x%02x", messageType)
+		}
+	}
+}
+
+func (peer *xTestKMessagePeer) closed(t *testing.T) {
+	t.Helper()
+	for {
+		select {
+		case _, ok := <-peer.messages:
+			if !ok {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for peer close")
+		}
+	}
+}
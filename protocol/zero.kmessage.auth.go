@@ -0,0 +1,166 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/structs"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	CONNACK_ACCEPTED            = 0x00
+	CONNACK_AUTH_REQUIRED       = 0x01
+	CONNACK_UNKNOWN_UNIQUEKEY   = 0x02
+	CONNACK_TIMESTAMP_INVALID   = 0x03
+	CONNACK_NONCE_REPLAYED      = 0x04
+	CONNACK_BAD_SIGNATURE       = 0x05
+	CONNACK_PROTOCOL_ERROR      = 0x06
+	CONNACK_DUPLICATE_UNIQUEKEY = 0x07
+	CONNACK_CIPHER_REJECTED     = 0x08
+
+	xAUTH_TIMESTAMP_WINDOW = 300
+
+	xAUTH_PURPOSE_CLIENT  = "client"
+	xAUTH_PURPOSE_SERVER  = "server"
+	xAUTH_PURPOSE_SESSION = "session"
+)
+
+var (
+	kMessageSecretFetcher ZeroKMessageSecretFetcher
+	kMessageSecret        string
+)
+
+type ZeroKMessageSecretFetcher interface {
+	FetchSecret(uniquekey string) string
+}
+
+func UseKMessageSecretFetcher(fetcher ZeroKMessageSecretFetcher) {
+	kMessageSecretFetcher = fetcher
+}
+
+func UseKMessageSecret(secret string) {
+	kMessageSecret = secret
+}
+
+func kMessageClientSecret() string {
+	if len(kMessageSecret) > 0 {
+		return kMessageSecret
+	}
+	return global.StringValue("zero.kmessage.secret")
+}
+
+type ZeroKMessageConnackError struct {
+	Code byte
+}
+
+func (connackErr *ZeroKMessageConnackError) Reason() string {
+	switch connackErr.Code {
+	case CONNACK_AUTH_REQUIRED:
+		return "auth required"
+	case CONNACK_UNKNOWN_UNIQUEKEY:
+		return "unknown uniquekey"
+	case CONNACK_TIMESTAMP_INVALID:
+		return "timestamp invalid"
+	case CONNACK_NONCE_REPLAYED:
+		return "nonce replayed"
+	case CONNACK_BAD_SIGNATURE:
+		return "bad signature"
+	case CONNACK_PROTOCOL_ERROR:
+		return "protocol error"
+	case CONNACK_DUPLICATE_UNIQUEKEY:
+		return "duplicate uniquekey"
+	case CONNACK_CIPHER_REJECTED:
+		return "cipher rejected"
+	}
+	return "unknown"
+}
+
+func (connackErr *ZeroKMessageConnackError) Error() string {
+	return fmt.Sprintf("0protocol/1.0 connack refused 0x%02x %s", connackErr.Code, connackErr.Reason())
+}
+
+type kMessageAuthPayload struct {
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Cipher    string `json:"cipher,omitempty"`
+}
+
+func parseAuthPayload(datas []byte) (*kMessageAuthPayload, error) {
+	if len(datas) <= 0 {
+		return nil, errors.New(" empty auth payload ")
+	}
+	payload := &kMessageAuthPayload{}
+	err := json.Unmarshal(datas, payload)
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (payload *kMessageAuthPayload) Bytes() []byte {
+	jsonbytes, _ := json.Marshal(payload)
+	return jsonbytes
+}
+
+func newAuthNonce() (string, error) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(uid.String(), "-", ""), nil
+}
+
+func checkAuthTimestamp(timestamp int64) bool {
+	return math.Abs(float64(time.Now().Unix()-timestamp)) <= xAUTH_TIMESTAMP_WINDOW
+}
+
+type kMessageChallenge struct {
+	secret      string
+	uniquekey   string
+	clientNonce string
+	serverNonce string
+	timestamp   int64
+	cipher      string
+}
+
+func (challenge *kMessageChallenge) sign(purpose string) string {
+	content := fmt.Sprintf("%s&%s&%s&%s&%d", purpose, challenge.uniquekey, challenge.clientNonce, challenge.serverNonce, challenge.timestamp)
+	if len(challenge.cipher) > 0 {
+		content = fmt.Sprintf("%s&%s", content, challenge.cipher)
+	}
+	return structs.HmacSha256(content, challenge.secret)
+}
+
+type kMessageNonces struct {
+	nonces      map[string]int64
+	noncesMutex sync.Mutex
+}
+
+func newKMessageNonces() *kMessageNonces {
+	return &kMessageNonces{nonces: make(map[string]int64)}
+}
+
+func (xNonces *kMessageNonces) use(uniquekey string, nonce string) bool {
+	xNonces.noncesMutex.Lock()
+	defer xNonces.noncesMutex.Unlock()
+	now := time.Now().Unix()
+	for key, expireAt := range xNonces.nonces {
+		if expireAt < now {
+			delete(xNonces.nonces, key)
+		}
+	}
+	key := fmt.Sprintf("%s:%s", uniquekey, nonce)
+	if _, ok := xNonces.nonces[key]; ok {
+		return false
+	}
+	xNonces.nonces[key] = now + 2*xAUTH_TIMESTAMP_WINDOW
+	return true
+}
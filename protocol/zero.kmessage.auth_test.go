@@ -0,0 +1,201 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"testing"
+	"time"
+)
+
+func TestKMessageNonces(t *testing.T) {
+	nonces := newKMessageNonces()
+	if !nonces.use("u1", "n1") {
+		t.Fatal("expected first use accepted")
+	}
+	if nonces.use("u1", "n1") {
+		t.Fatal("expected replayed nonce rejected")
+	}
+	if !nonces.use("u2", "n1") {
+		t.Fatal("expected same nonce accepted for another uniquekey")
+	}
+	if !nonces.use("u1", "n2") {
+		t.Fatal("expected new nonce accepted")
+	}
+
+	nonces.nonces["u1:n1"] = time.Now().Unix() - 1
+	if !nonces.use("u1", "n3") {
+		t.Fatal("expected new nonce accepted")
+	}
+	if _, ok := nonces.nonces["u1:n1"]; ok {
+		t.Fatal("expected expired nonce purged")
+	}
+}
+
+func TestKMessageChallengeSign(t *testing.T) {
+	challenge := &kMessageChallenge{
+		secret:      "secret",
+		uniquekey:   "u1",
+		clientNonce: "client",
+		serverNonce: "server",
+		timestamp:   1700000000,
+	}
+	signatures := make(map[string]string)
+	for _, purpose := range []string{xAUTH_PURPOSE_CLIENT, xAUTH_PURPOSE_SERVER, xAUTH_PURPOSE_SESSION} {
+		signature := challenge.sign(purpose)
+		if signature != challenge.sign(purpose) {
+			t.Fatalf("%s signature not stable", purpose)
+		}
+		for xPurpose, xSignature := range signatures {
+			if xSignature == signature {
+				t.Fatalf("%s signature equals %s", purpose, xPurpose)
+			}
+		}
+		signatures[purpose] = signature
+	}
+
+	xChallenge := *challenge
+	xChallenge.cipher = KMESSAGE_CIPHER_AES_GCM
+	if xChallenge.sign(xAUTH_PURPOSE_CLIENT) == signatures[xAUTH_PURPOSE_CLIENT] {
+		t.Fatal("expected cipher bound into signature")
+	}
+	xChallenge = *challenge
+	xChallenge.secret = "other"
+	if xChallenge.sign(xAUTH_PURPOSE_CLIENT) == signatures[xAUTH_PURPOSE_CLIENT] {
+		t.Fatal("expected secret bound into signature")
+	}
+}
+
+func TestCheckAuthTimestamp(t *testing.T) {
+	now := time.Now().Unix()
+	cases := []struct {
+		timestamp int64
+		ok        bool
+	}{
+		{now, true},
+		{now - xAUTH_TIMESTAMP_WINDOW + 5, true},
+		{now + xAUTH_TIMESTAMP_WINDOW - 5, true},
+		{now - xAUTH_TIMESTAMP_WINDOW - 5, false},
+		{now + xAUTH_TIMESTAMP_WINDOW + 5, false},
+		{0, false},
+	}
+	for _, c := range cases {
+		if checkAuthTimestamp(c.timestamp) != c.ok {
+			t.Fatalf("timestamp %d expected %v", c.timestamp, c.ok)
+		}
+	}
+}
+
+func testConnectMessage(t *testing.T, uniquekey string, payload *kMessageAuthPayload) *ZeroKMessage {
+	t.Helper()
+	body := make([]byte, 0)
+	if payload != nil {
+		body = payload.Bytes()
+	}
+	message, err := NewKMessage(MESSAGE_TYPE_CONNECT, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.AddUniqueKey(uniquekey)
+	return message
+}
+
+func testAuthMessage(uniquekey string, messageId string, signature string) *ZeroKMessage {
+	message := NewAckKMessage(MESSAGE_TYPE_AUTH, messageId, (&kMessageAuthPayload{Signature: signature}).Bytes())
+	message.AddUniqueKey(uniquekey)
+	return message
+}
+
+func TestKMessageAuthHandshake(t *testing.T) {
+	keeper := newTestKMessageKeeper(xTestSecretFetcher{"device1": "secret1"})
+
+	peer := newTestKMessagePeer(keeper)
+	challenge := &kMessageChallenge{secret: "secret1", uniquekey: "device1", clientNonce: "nonce-accepted"}
+	connect := testConnectMessage(t, "device1", &kMessageAuthPayload{Timestamp: time.Now().Unix(), Nonce: challenge.clientNonce})
+	peer.send(t, connect)
+
+	challengeMessage := peer.next(t, MESSAGE_TYPE_CHALLENGE)
+	if challengeMessage.MessageId() != connect.MessageId() {
+		t.Fatal("challenge not bound to connect message id")
+	}
+	payload, err := parseAuthPayload(challengeMessage.MessageBody())
+	if err != nil || len(payload.Nonce) <= 0 {
+		t.Fatalf("invalid challenge payload %v", err)
+	}
+	challenge.serverNonce = payload.Nonce
+	challenge.timestamp = payload.Timestamp
+
+	peer.send(t, testAuthMessage("device1", connect.MessageId(), challenge.sign(xAUTH_PURPOSE_CLIENT)))
+	connack := peer.next(t, MESSAGE_TYPE_CONNACK)
+	body := connack.MessageBody()
+	if len(body) <= 0 || body[0] != CONNACK_ACCEPTED {
+		t.Fatalf("expected accepted connack got %v", body)
+	}
+	payload, err = parseAuthPayload(body[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hmac.Equal([]byte(payload.Signature), []byte(challenge.sign(xAUTH_PURPOSE_SERVER))) {
+		t.Fatal("server signature mismatch")
+	}
+	if peer.conn.SessionKey() != challenge.sign(xAUTH_PURPOSE_SESSION) || !peer.conn.authorized {
+		t.Fatal("session not established")
+	}
+
+	replay := newTestKMessagePeer(keeper)
+	replay.send(t, testConnectMessage(t, "device1", &kMessageAuthPayload{Timestamp: time.Now().Unix(), Nonce: challenge.clientNonce}))
+	connack = replay.next(t, MESSAGE_TYPE_CONNACK)
+	if body := connack.MessageBody(); len(body) != 1 || body[0] != CONNACK_NONCE_REPLAYED {
+		t.Fatalf("expected nonce replayed got %v", body)
+	}
+	replay.closed(t)
+	peer.conn.Close()
+}
+
+func TestKMessageAuthRefused(t *testing.T) {
+	keeper := newTestKMessageKeeper(xTestSecretFetcher{"device1": "secret1"})
+	now := time.Now().Unix()
+
+	cases := []struct {
+		name      string
+		uniquekey string
+		payload   *kMessageAuthPayload
+		signature string
+		auth      bool
+		code      byte
+	}{
+		{name: "missing payload", uniquekey: "device1", code: CONNACK_AUTH_REQUIRED},
+		{name: "missing nonce", uniquekey: "device1", payload: &kMessageAuthPayload{Timestamp: now}, code: CONNACK_AUTH_REQUIRED},
+		{name: "stale timestamp", uniquekey: "device1", payload: &kMessageAuthPayload{Timestamp: now - 2*xAUTH_TIMESTAMP_WINDOW, Nonce: "n-stale"}, code: CONNACK_TIMESTAMP_INVALID},
+		{name: "unknown uniquekey", uniquekey: "device2", payload: &kMessageAuthPayload{Timestamp: now, Nonce: "n-unknown"}, code: CONNACK_UNKNOWN_UNIQUEKEY},
+		{name: "unsupported cipher", uniquekey: "device1", payload: &kMessageAuthPayload{Timestamp: now, Nonce: "n-cipher", Cipher: "rot13"}, code: CONNACK_CIPHER_REJECTED},
+		{name: "bad signature", uniquekey: "device1", payload: &kMessageAuthPayload{Timestamp: now, Nonce: "n-signature"}, auth: true, signature: "forged", code: CONNACK_BAD_SIGNATURE},
+		{name: "auth without connect", uniquekey: "device1", auth: true, signature: "forged", code: CONNACK_PROTOCOL_ERROR},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			peer := newTestKMessagePeer(keeper)
+			messageId := "00000000000000000000000000000000"
+			if c.payload != nil || !c.auth {
+				connect := testConnectMessage(t, c.uniquekey, c.payload)
+				messageId = connect.MessageId()
+				peer.send(t, connect)
+			}
+			if c.auth {
+				if c.payload != nil {
+					peer.next(t, MESSAGE_TYPE_CHALLENGE)
+				}
+				peer.send(t, testAuthMessage(c.uniquekey, messageId, c.signature))
+			}
+			connack := peer.next(t, MESSAGE_TYPE_CONNACK)
+			if body := connack.MessageBody(); len(body) != 1 || body[0] != c.code {
+				t.Fatalf("expected connack 0x%02x got %v", c.code, body)
+			}
+			if connack.MessageId() != messageId {
+				t.Fatal("connack not bound to request message id")
+			}
+			peer.closed(t)
+			if peer.conn.authorized {
+				t.Fatal("refused connect authorized")
+			}
+		})
+	}
+}
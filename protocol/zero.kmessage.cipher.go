@@ -0,0 +1,160 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	KMESSAGE_CIPHER_AES_GCM  = "aes-gcm"
+	KMESSAGE_CIPHER_CHACHA20 = "chacha20-poly1305"
+
+	xCIPHER_CLIENT_KEY = "zero.kmessage.cipher&client"
+	xCIPHER_SERVER_KEY = "zero.kmessage.cipher&server"
+
+	xCIPHER_NONCE_SIZE   = 12
+	xCIPHER_NONCE_PREFIX = 4
+)
+
+func kMessageCipherSupported(name string) bool {
+	return name == KMESSAGE_CIPHER_AES_GCM || name == KMESSAGE_CIPHER_CHACHA20
+}
+
+func isKMessageHandshake(messageType int) bool {
+	return messageType == MESSAGE_TYPE_CONNECT || messageType == MESSAGE_TYPE_AUTH ||
+		messageType == MESSAGE_TYPE_CHALLENGE || messageType == MESSAGE_TYPE_CONNACK
+}
+
+func newKMessageAEAD(name string, sessionKey string, purpose string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, []byte(sessionKey))
+	mac.Write([]byte(purpose))
+	key := mac.Sum(nil)
+	switch name {
+	case KMESSAGE_CIPHER_AES_GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case KMESSAGE_CIPHER_CHACHA20:
+		return chacha20poly1305.New(key)
+	}
+	return nil, fmt.Errorf(" unsupported kmessage cipher `%s` ", name)
+}
+
+func kMessageNoncePrefix(messageId string) []byte {
+	digest := sha256.Sum256([]byte(messageId))
+	return digest[:xCIPHER_NONCE_PREFIX]
+}
+
+func kMessageAdditional(message *ZeroKMessage) []byte {
+	additional := []byte{byte(message.MessageType()), message.Flags()}
+	additional = binary.BigEndian.AppendUint32(additional, uint32(message.Sequence()))
+	additional = append(additional, message.MessageId()...)
+	return append(additional, message.UniqueKey()...)
+}
+
+// kMessageCipher seals every non-handshake body with a per-direction key derived from the session key.
+// Nonces are a messageId digest plus a strictly increasing counter, so the receiver also rejects replays.
+type kMessageCipher struct {
+	name   string
+	sealer cipher.AEAD
+	opener cipher.AEAD
+
+	sendCounter uint64
+	sendMutex   sync.Mutex
+	recvCounter uint64
+}
+
+func newKMessageCipher(name string, sessionKey string, serverSide bool) (*kMessageCipher, error) {
+	sealPurpose, openPurpose := xCIPHER_CLIENT_KEY, xCIPHER_SERVER_KEY
+	if serverSide {
+		sealPurpose, openPurpose = xCIPHER_SERVER_KEY, xCIPHER_CLIENT_KEY
+	}
+	sealer, err := newKMessageAEAD(name, sessionKey, sealPurpose)
+	if err != nil {
+		return nil, err
+	}
+	opener, err := newKMessageAEAD(name, sessionKey, openPurpose)
+	if err != nil {
+		return nil, err
+	}
+	return &kMessageCipher{name: name, sealer: sealer, opener: opener}, nil
+}
+
+func (xCipher *kMessageCipher) push(version byte, options *kMessageOptions, message *ZeroKMessage, write func([]byte) error) error {
+	plain := []byte{0x00}
+	body := message.MessageBody()
+	if options.compression != 0 && len(body) >= options.compressThreshold {
+		compressed, err := compressKMessageBody(options.compression, body)
+		if err != nil {
+			return err
+		}
+		plain[0] = options.compression
+		body = compressed
+	}
+	plain = append(plain, body...)
+
+	sealed := NewAckKMessage(byte(message.MessageType()), message.MessageId(), nil)
+	err := sealed.AddUniqueKey(message.UniqueKey())
+	if err != nil {
+		return err
+	}
+	if version >= KMESSAGE_VERSION_2 {
+		sealed.useVersion(KMESSAGE_VERSION_2)
+		sealed.useFlags(message.Flags() &^ xFLAG_COMPRESSION)
+		sealed.useSequence(message.Sequence())
+	}
+
+	xCipher.sendMutex.Lock()
+	defer xCipher.sendMutex.Unlock()
+	xCipher.sendCounter++
+	nonce := binary.BigEndian.AppendUint64(kMessageNoncePrefix(message.MessageId()), xCipher.sendCounter)
+	sealed.messageBody = xCipher.sealer.Seal(nonce, nonce, plain, kMessageAdditional(sealed))
+	err = sealed.Complete()
+	if err != nil {
+		return err
+	}
+	return write(sealed.Bytes())
+}
+
+func (xCipher *kMessageCipher) open(message *ZeroKMessage) error {
+	if xCipher == nil || isKMessageHandshake(message.MessageType()) {
+		return nil
+	}
+	body := message.MessageBody()
+	if len(body) < xCIPHER_NONCE_SIZE+xCipher.opener.Overhead() {
+		return errors.New(" sealed body too short ")
+	}
+	nonce := body[:xCIPHER_NONCE_SIZE]
+	if !bytes.Equal(nonce[:xCIPHER_NONCE_PREFIX], kMessageNoncePrefix(message.MessageId())) {
+		return errors.New(" nonce not bound to messageId ")
+	}
+	counter := binary.BigEndian.Uint64(nonce[xCIPHER_NONCE_PREFIX:])
+	if counter <= xCipher.recvCounter {
+		return fmt.Errorf(" nonce counter %d replayed ", counter)
+	}
+	plain, err := xCipher.opener.Open(nil, nonce, body[xCIPHER_NONCE_SIZE:], kMessageAdditional(message))
+	if err != nil {
+		return errors.New(" message authentication failed ")
+	}
+	xCipher.recvCounter = counter
+	if len(plain) <= 0 {
+		return errors.New(" sealed body without header ")
+	}
+	messageBody, err := decompressKMessageBody(plain[0], plain[1:])
+	if err != nil {
+		return err
+	}
+	message.messageBody = messageBody
+	return nil
+}
@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func testSealKMessage(t *testing.T, xCipher *kMessageCipher, version byte, options *kMessageOptions, body []byte) []byte {
+	t.Helper()
+	message, err := NewKMessage(MESSAGE_TYPE_RPC, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.AddUniqueKey("device1")
+	var datas []byte
+	err = xCipher.push(version, options, message, func(xDatas []byte) error {
+		datas = xDatas
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return datas
+}
+
+func TestKMessageCipherRoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte("sealed "), 256)
+	for _, name := range []string{KMESSAGE_CIPHER_AES_GCM, KMESSAGE_CIPHER_CHACHA20} {
+		for _, version := range []byte{KMESSAGE_VERSION_1, KMESSAGE_VERSION_2} {
+			for _, compression := range []byte{0, KMESSAGE_FLAG_GZIP} {
+				options := newTestKMessageOptions(xDEFAULT_CHUNK_SIZE, xDEFAULT_STREAM_WINDOW)
+				options.compression = compression
+				serverCipher, err := newKMessageCipher(name, "session", true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				clientCipher, err := newKMessageCipher(name, "session", false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				datas := testSealKMessage(t, serverCipher, version, options, body)
+				if bytes.Contains(datas, []byte("sealed sealed")) {
+					t.Fatalf("%s v%d body not sealed", name, version)
+				}
+				message := ParseKMessage(datas)
+				if err := message.Check(); err != nil {
+					t.Fatal(err)
+				}
+				if message.Version() != int(version) || message.Flags()&xFLAG_COMPRESSION != 0 {
+					t.Fatalf("%s sealed frame version %d flags 0x%02x", name, message.Version(), message.Flags())
+				}
+				if err := serverCipher.open(ParseKMessage(datas)); err == nil {
+					t.Fatalf("%s server opened its own direction", name)
+				}
+				if err := clientCipher.open(message); err != nil {
+					t.Fatalf("%s v%d compression 0x%02x open %s", name, version, compression, err)
+				}
+				if !bytes.Equal(message.MessageBody(), body) {
+					t.Fatalf("%s v%d opened body differs", name, version)
+				}
+			}
+		}
+	}
+}
+
+func TestKMessageCipherCounters(t *testing.T) {
+	options := newTestKMessageOptions(xDEFAULT_CHUNK_SIZE, xDEFAULT_STREAM_WINDOW)
+	serverCipher, _ := newKMessageCipher(KMESSAGE_CIPHER_AES_GCM, "session", true)
+	clientCipher, _ := newKMessageCipher(KMESSAGE_CIPHER_AES_GCM, "session", false)
+
+	frames := make([][]byte, 0)
+	for i := 1; i <= 3; i++ {
+		datas := testSealKMessage(t, serverCipher, KMESSAGE_VERSION_2, options, []byte("counter"))
+		body := ParseKMessage(datas).MessageBody()
+		if counter := binary.BigEndian.Uint64(body[xCIPHER_NONCE_PREFIX:xCIPHER_NONCE_SIZE]); counter != uint64(i) {
+			t.Fatalf("send counter %d expect %d", counter, i)
+		}
+		frames = append(frames, datas)
+	}
+
+	if err := clientCipher.open(ParseKMessage(frames[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientCipher.open(ParseKMessage(frames[1])); err == nil {
+		t.Fatal("replayed frame opened")
+	}
+	if err := clientCipher.open(ParseKMessage(frames[0])); err == nil {
+		t.Fatal("older counter opened")
+	}
+	if clientCipher.recvCounter != 2 {
+		t.Fatalf("recv counter %d", clientCipher.recvCounter)
+	}
+	if err := clientCipher.open(ParseKMessage(frames[2])); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKMessageCipherRejected(t *testing.T) {
+	options := newTestKMessageOptions(xDEFAULT_CHUNK_SIZE, xDEFAULT_STREAM_WINDOW)
+	serverCipher, _ := newKMessageCipher(KMESSAGE_CIPHER_CHACHA20, "session", true)
+	clientCipher, _ := newKMessageCipher(KMESSAGE_CIPHER_CHACHA20, "session", false)
+	otherCipher, _ := newKMessageCipher(KMESSAGE_CIPHER_CHACHA20, "other", false)
+
+	datas := testSealKMessage(t, serverCipher, KMESSAGE_VERSION_2, options, []byte("tamper"))
+	cases := []struct {
+		name    string
+		xCipher *kMessageCipher
+		modify  func(*ZeroKMessage)
+	}{
+		{"other session", otherCipher, func(*ZeroKMessage) {}},
+		{"messageId", clientCipher, func(message *ZeroKMessage) { message.messageId = []byte("0123456789abcdef0123456789abcdef") }},
+		{"uniquekey", clientCipher, func(message *ZeroKMessage) { message.AddUniqueKey("device2") }},
+		{"sequence", clientCipher, func(message *ZeroKMessage) { message.useSequence(9) }},
+		{"ciphertext", clientCipher, func(message *ZeroKMessage) {
+			message.messageBody = append([]byte{}, message.messageBody...)
+			message.messageBody[len(message.messageBody)-1] ^= 0xFF
+		}},
+		{"short", clientCipher, func(message *ZeroKMessage) { message.messageBody = message.messageBody[:xCIPHER_NONCE_SIZE] }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			message := ParseKMessage(append([]byte{}, datas...))
+			c.modify(message)
+			if err := c.xCipher.open(message); err == nil {
+				t.Fatal("tampered frame opened")
+			}
+		})
+	}
+	if clientCipher.recvCounter != 0 {
+		t.Fatalf("rejected frames moved recv counter to %d", clientCipher.recvCounter)
+	}
+	if err := clientCipher.open(ParseKMessage(datas)); err != nil {
+		t.Fatal(err)
+	}
+
+	handshake, _ := NewKMessage(MESSAGE_TYPE_CONNACK, []byte("plain"))
+	if err := clientCipher.open(handshake); err != nil || string(handshake.MessageBody()) != "plain" {
+		t.Fatal("handshake message not passed through")
+	}
+	var xCipher *kMessageCipher
+	if err := xCipher.open(handshake); err != nil {
+		t.Fatal("nil cipher rejected message")
+	}
+	if _, err := newKMessageCipher("des", "session", true); err == nil {
+		t.Fatal("unsupported cipher accepted")
+	}
+}
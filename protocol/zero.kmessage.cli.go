@@ -1,9 +1,11 @@
 package protocol
 
 import (
+	"context"
+	"crypto/hmac"
 	"errors"
 	"fmt"
-	"sync"
+	"io"
 	"time"
 
 	"github.com/0meet1/zero-framework/global"
@@ -15,16 +17,43 @@ type kZeroKMessageClientListener struct {
 }
 
 func (xListener *kZeroKMessageClientListener) OnConnect(conn server.ZeroClientConnect) error {
-	cMessage, err := NewKMessage(MESSAGE_TYPE_CONNECT, make([]byte, 0))
+	client := conn.(*kZeroKMessageClient)
+	cBody := make([]byte, 0)
+	client.challenge = nil
+	client.cipher = nil
+	if len(client.options.cipher) > 0 && len(client.secret) <= 0 {
+		return fmt.Errorf(" kmessage encryption `%s` requires a secret ", client.options.cipher)
+	}
+	if len(client.secret) > 0 {
+		nonce, err := newAuthNonce()
+		if err != nil {
+			return err
+		}
+		client.challenge = &kMessageChallenge{
+			secret:      client.secret,
+			uniquekey:   xListener.uniquekey,
+			clientNonce: nonce,
+			cipher:      client.options.cipher,
+		}
+		cBody = (&kMessageAuthPayload{Timestamp: time.Now().Unix(), Nonce: nonce, Cipher: client.options.cipher}).Bytes()
+	}
+
+	cMessage, err := NewKMessage(MESSAGE_TYPE_CONNECT, cBody)
 	if err != nil {
 		return err
 	}
 	cMessage.AddUniqueKey(xListener.uniquekey)
+	cMessage.advertise(client.options.maxVersion)
 	err = cMessage.Complete()
 	if err != nil {
 		return err
 	}
-	conn.(*kZeroKMessageClient).connectMessage = cMessage
+	client.connectMessage = cMessage
+	client.sessionKey = ""
+	client.version = KMESSAGE_VERSION_1
+	client.mux.abort()
+	client.streams.abort()
+	client.files.abort()
 
 	<-time.After(time.Duration(time.Second * 1))
 	err = conn.(*kZeroKMessageClient).Write(cMessage.Bytes())
@@ -59,10 +88,19 @@ type kZeroKMessageClient struct {
 
 	uniquekey string
 	operator  ZeroKMessageOperator
+	mux       *kZeroKMessageMux
+
+	secret     string
+	challenge  *kMessageChallenge
+	sessionKey string
+	connectErr error
+	cipher     *kMessageCipher
 
-	request      *ZeroKMessage
-	requestMutex sync.Mutex
-	responseChan chan *ZeroKMessage
+	options *kMessageOptions
+	version byte
+	streams *kZeroKMessageStreams
+	files   *kZeroKMessageFiles
+	topics  *kZeroKMessageSubscriptions
 
 	connectMessage *ZeroKMessage
 }
@@ -78,68 +116,187 @@ func (client *kZeroKMessageClient) Active() bool {
 	return client.connectMessage == nil
 }
 
+func (client *kZeroKMessageClient) ConnectError() error {
+	return client.connectErr
+}
+
+func (client *kZeroKMessageClient) SessionKey() string {
+	return client.sessionKey
+}
+
 func (client *kZeroKMessageClient) ExecMessage(message *ZeroKMessage, withSecond int) (*ZeroKMessage, error) {
-	if client.responseChan != nil {
-		return nil, fmt.Errorf("0protocol/1.0 client connect %s is busying", client.RemoteAddr())
-	}
-	err := client.Write(message.Bytes())
-	if err != nil {
-		return nil, err
+	if !client.Active() && client.connectErr != nil {
+		return nil, client.connectErr
 	}
+	return client.mux.execTimeout(client.PushMessage, message, withSecond)
+}
 
-	client.requestMutex.Lock()
-	client.request = message
-	client.requestMutex.Unlock()
-	client.responseChan = make(chan *ZeroKMessage, 1)
-	select {
-	case resp := <-client.responseChan:
-		client.requestMutex.Lock()
-		client.request = nil
-		client.responseChan = nil
-		client.requestMutex.Unlock()
-		return resp, nil
-	case <-time.After(time.Second * time.Duration(withSecond)):
-		client.requestMutex.Lock()
-		client.request = nil
-		client.responseChan = nil
-		client.requestMutex.Unlock()
-		return nil, errors.New(" request timeout ")
+func (client *kZeroKMessageClient) ExecMessageContext(ctx context.Context, message *ZeroKMessage) (*ZeroKMessage, error) {
+	if !client.Active() && client.connectErr != nil {
+		return nil, client.connectErr
 	}
+	return client.mux.exec(ctx, client.PushMessage, message)
 }
 
 func (client *kZeroKMessageClient) PushMessage(message *ZeroKMessage) error {
+	if client.cipher != nil && !isKMessageHandshake(message.MessageType()) {
+		return client.cipher.push(client.version, client.options, message, client.Write)
+	}
+	err := client.options.frame(client.version, message)
+	if err != nil {
+		return err
+	}
 	return client.Write(message.Bytes())
 }
 
+func (client *kZeroKMessageClient) PushStream(ctx context.Context, messageType byte, reader io.Reader) error {
+	return client.streams.send(ctx, client.version, client.PushMessage, "", messageType, client.uniquekey, reader)
+}
+
+func (client *kZeroKMessageClient) ExecStream(ctx context.Context, messageType byte, reader io.Reader) (*ZeroKMessage, error) {
+	if !client.Active() && client.connectErr != nil {
+		return nil, client.connectErr
+	}
+	message, err := NewKMessage(messageType, make([]byte, 0))
+	if err != nil {
+		return nil, err
+	}
+	return client.mux.exec(ctx, func(message *ZeroKMessage) error {
+		return client.streams.send(ctx, client.version, client.PushMessage, message.MessageId(), messageType, client.uniquekey, reader)
+	}, message)
+}
+
+func (client *kZeroKMessageClient) SendFile(ctx context.Context, filePath string, meta map[string]string, progress ZeroKMessageFileProgress) error {
+	return client.files.send(ctx, client.ExecMessageContext, client.uniquekey, filePath, meta, progress)
+}
+
+func (client *kZeroKMessageClient) Subscribe(ctx context.Context, topic string, handler ZeroKMessageTopicHandler) error {
+	return client.topics.subscribe(ctx, client.ExecMessageContext, client.uniquekey, topic, handler)
+}
+
+func (client *kZeroKMessageClient) Unsubscribe(ctx context.Context, topic string) error {
+	return client.topics.unsubscribe(ctx, client.ExecMessageContext, client.uniquekey, topic)
+}
+
+func (client *kZeroKMessageClient) Publish(ctx context.Context, topic string, payload []byte, retain bool) (int, error) {
+	return client.topics.publish(ctx, client.ExecMessageContext, client.uniquekey, topic, payload, retain)
+}
+
+func (client *kZeroKMessageClient) dispatch(message *ZeroKMessage) error {
+	if client.mux.deliver(message) || message.MessageType() == MESSAGE_TYPE_FILEACK || message.MessageType() == MESSAGE_TYPE_TOPICACK {
+		return nil
+	}
+	var err error
+	if client.operator == nil {
+		global.Logger().Debug(fmt.Sprintf("0protocol/1.0 client connect %s ignore message \n%s", client.RemoteAddr(), message.String()))
+	} else if clientOperator, ok := client.operator.(ZeroKMessageClientOperator); ok {
+		_, err = clientOperator.ClientOperation(client, message)
+	} else {
+		_, err = client.operator.Operation(nil, message)
+	}
+	if err != nil || message.Flags()&KMESSAGE_FLAG_ACK_REQ == 0 {
+		return err
+	}
+	pubackMessage := NewAckKMessage(MESSAGE_TYPE_PUBACK, message.MessageId(), make([]byte, 0))
+	pubackMessage.AddUniqueKey(client.uniquekey)
+	err = pubackMessage.Complete()
+	if err != nil {
+		return err
+	}
+	return client.PushMessage(pubackMessage)
+}
+
 func (client *kZeroKMessageClient) OnMessage(datas []byte) error {
 	client.TCPClient.OnMessage(datas)
 	uMessage := ParseKMessage(datas)
 
 	global.Logger().Debug(fmt.Sprintf("0protocol/1.0 client connect %s on message \n%s", client.RemoteAddr(), uMessage.String()))
 	if uMessage.MessageType() == MESSAGE_TYPE_CONNACK {
-		if uMessage.MessageId() == client.connectMessage.MessageId() {
-			client.connectMessage = nil
-		}
-	} else if uMessage.MessageType() == MESSAGE_TYPE_BEATACK {
-		client.Heartbeat()
-	} else {
-		callback := func() {
-			if client.responseChan != nil {
-				client.responseChan <- uMessage
-			} else {
-				global.Logger().Debug(fmt.Sprintf("0protocol/1.0 client connect %s ignore message \n%s", client.RemoteAddr(), uMessage.String()))
+		if client.connectMessage != nil && uMessage.MessageId() == client.connectMessage.MessageId() {
+			err := client.onConnack(uMessage)
+			if err != nil {
+				client.connectErr = err
+				global.Logger().Error(fmt.Sprintf("0protocol/1.0 client connect %s connack error : %s", client.RemoteAddr(), err.Error()))
+				return nil
 			}
+			client.connectErr = nil
+			client.connectMessage = nil
+			client.version = client.options.negotiate(uMessage.MaxVersion())
+			go client.topics.resubscribe(client.ExecMessageContext, client.uniquekey)
 		}
-		if client.operator == nil {
-			callback()
-		} else {
-			ok, err := client.operator.Operation(nil, uMessage)
+	} else if uMessage.MessageType() == MESSAGE_TYPE_CHALLENGE {
+		if client.connectMessage != nil && uMessage.MessageId() == client.connectMessage.MessageId() {
+			err := client.onChallenge(uMessage)
 			if err != nil {
+				client.connectErr = err
 				return err
 			}
-			if !ok {
-				callback()
-			}
+		}
+	} else if err := client.cipher.open(uMessage); err != nil {
+		global.Logger().Warn(fmt.Sprintf("0protocol/1.0 client connect %s reject message %s : %s", client.RemoteAddr(), uMessage.MessageId(), err.Error()))
+	} else if uMessage.MessageType() == MESSAGE_TYPE_BEATACK {
+		client.Heartbeat()
+	} else if uMessage.Flags()&KMESSAGE_FLAG_WINDOW != 0 {
+		client.streams.onWindow(uMessage)
+	} else if uMessage.Flags()&KMESSAGE_FLAG_CHUNKED != 0 {
+		return client.streams.onChunk(nil, uMessage, client.operator, client.PushMessage, client.dispatch)
+	} else if uMessage.MessageType() == MESSAGE_TYPE_FILE {
+		return client.files.onFile(nil, uMessage, client.operator, client.PushMessage)
+	} else if uMessage.MessageType() == MESSAGE_TYPE_TOPIC {
+		client.topics.onTopic(uMessage)
+	} else {
+		return client.dispatch(uMessage)
+	}
+	return nil
+}
+
+func (client *kZeroKMessageClient) onChallenge(message *ZeroKMessage) error {
+	if client.challenge == nil {
+		return errors.New(" challenge without secret ")
+	}
+	payload, err := parseAuthPayload(message.MessageBody())
+	if err != nil {
+		return err
+	}
+	if payload.Cipher != client.challenge.cipher {
+		return fmt.Errorf(" cipher mismatch `%s` ", payload.Cipher)
+	}
+	client.challenge.serverNonce = payload.Nonce
+	client.challenge.timestamp = payload.Timestamp
+
+	authMessage := NewAckKMessage(MESSAGE_TYPE_AUTH, message.MessageId(),
+		(&kMessageAuthPayload{Signature: client.challenge.sign(xAUTH_PURPOSE_CLIENT)}).Bytes())
+	authMessage.AddUniqueKey(client.uniquekey)
+	err = authMessage.Complete()
+	if err != nil {
+		return err
+	}
+	return client.PushMessage(authMessage)
+}
+
+func (client *kZeroKMessageClient) onConnack(message *ZeroKMessage) error {
+	body := message.MessageBody()
+	if len(body) > 0 && body[0] != CONNACK_ACCEPTED {
+		return &ZeroKMessageConnackError{Code: body[0]}
+	}
+	if client.challenge == nil {
+		return nil
+	}
+	if len(body) <= 0 || len(client.challenge.serverNonce) <= 0 {
+		return errors.New(" server not authenticated ")
+	}
+	payload, err := parseAuthPayload(body[1:])
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(payload.Signature), []byte(client.challenge.sign(xAUTH_PURPOSE_SERVER))) {
+		return errors.New(" server signature mismatch ")
+	}
+	client.sessionKey = client.challenge.sign(xAUTH_PURPOSE_SESSION)
+	if len(client.challenge.cipher) > 0 {
+		client.cipher, err = newKMessageCipher(client.challenge.cipher, client.sessionKey, false)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
@@ -151,23 +308,36 @@ func (client *kZeroKMessageClient) Connect() {
 	client.TCPClient.Connect()
 }
 
-var RunKMessageClient = func(addr string, heartbeatTime int, heartbeatCheckInterval int, operator ZeroKMessageOperator, unk ...string) {
-	_uniquekey := ""
-	if len(unk) > 0 {
-		_uniquekey = unk[0]
-	}
+func newKMessageClient(addr string, heartbeatTime int, heartbeatCheckInterval int, operator ZeroKMessageOperator, uniquekey string) *kZeroKMessageClient {
+	options := newKMessageOptions()
 	kMessageCli := &kZeroKMessageClient{
 		TCPClient: *server.NewTCPClient(
 			addr,
 			xDEFAULT_AUTH_WAIT,
 			int64(heartbeatTime),
 			int64(heartbeatCheckInterval),
-			xDEFAULT_BUFFER_SIZE,
+			options.bufferSize,
 		),
-		uniquekey: _uniquekey,
+		uniquekey: uniquekey,
 		operator:  operator,
+		mux:       newKMessageMux(kMessageMaxInflights()),
+		secret:    kMessageClientSecret(),
+		options:   options,
+		version:   KMESSAGE_VERSION_1,
+		streams:   newKMessageStreams(options),
+		files:     newKMessageFiles(newKMessageFileOptions()),
+		topics:    newKMessageSubscriptions(),
 	}
 	kMessageCli.ThisDef(kMessageCli)
+	return kMessageCli
+}
+
+var RunKMessageClient = func(addr string, heartbeatTime int, heartbeatCheckInterval int, operator ZeroKMessageOperator, unk ...string) {
+	_uniquekey := ""
+	if len(unk) > 0 {
+		_uniquekey = unk[0]
+	}
+	kMessageCli := newKMessageClient(addr, heartbeatTime, heartbeatCheckInterval, operator, _uniquekey)
 	global.Key(ZEROKMSG_CLIENT, kMessageCli)
 	kMessageCli.Connect()
 }
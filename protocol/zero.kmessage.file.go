@@ -0,0 +1,440 @@
+package protocol
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/server"
+	"github.com/0meet1/zero-framework/structs"
+)
+
+const (
+	xFILE_OP_OFFER  = 0x01
+	xFILE_OP_CHUNK  = 0x02
+	xFILE_OP_FINISH = 0x03
+
+	xFILE_STATUS_OK       = 0x00
+	xFILE_STATUS_REJECTED = 0x01
+	xFILE_STATUS_OFFSET   = 0x02
+	xFILE_STATUS_MISMATCH = 0x03
+	xFILE_STATUS_ERROR    = 0x04
+
+	xFILE_ID_LENGTH = 32
+
+	xDEFAULT_FILE_DIR            = "files"
+	xDEFAULT_FILE_CHUNK_SIZE     = 64 * 1024
+	xDEFAULT_FILE_ACK_TIMEOUT    = 30
+	xDEFAULT_FILE_MAX_RETRIES    = 10
+	xDEFAULT_FILE_RETRY_INTERVAL = 3
+)
+
+type ZeroKMessageFileOffer struct {
+	FileId    string            `json:"fileId"`
+	Name      string            `json:"name"`
+	Size      int64             `json:"size"`
+	ChunkSize int               `json:"chunkSize"`
+	Hash      string            `json:"hash"`
+	Meta      map[string]string `json:"meta,omitempty"`
+}
+
+type ZeroKMessageFileProgress func(*ZeroKMessageFileOffer, int64)
+
+type ZeroKMessageFileOperator interface {
+	OnFileOffer(server.ZeroConnect, *ZeroKMessageFileOffer) error
+	OnFileProgress(server.ZeroConnect, *ZeroKMessageFileOffer, int64)
+	OnFileReceived(server.ZeroConnect, *ZeroKMessageFileOffer, string) error
+}
+
+type kMessageFileRefused struct {
+	reason string
+}
+
+func (refused *kMessageFileRefused) Error() string {
+	return fmt.Sprintf(" file transfer refused : %s ", refused.reason)
+}
+
+type kMessageFileOptions struct {
+	dir           string
+	chunkSize     int
+	ackTimeout    int
+	maxRetries    int
+	retryInterval int
+}
+
+func newKMessageFileOptions() *kMessageFileOptions {
+	options := &kMessageFileOptions{
+		dir:           global.StringValue("zero.kmessage.file.dir"),
+		chunkSize:     global.IntValue("zero.kmessage.file.chunkSize"),
+		ackTimeout:    global.IntValue("zero.kmessage.file.ackTimeout"),
+		maxRetries:    global.IntValue("zero.kmessage.file.maxRetries"),
+		retryInterval: global.IntValue("zero.kmessage.file.retryInterval"),
+	}
+	if len(options.dir) <= 0 {
+		options.dir = xDEFAULT_FILE_DIR
+	}
+	if !strings.HasPrefix(options.dir, "/") {
+		options.dir = path.Join(global.ServerAbsPath(), options.dir)
+	}
+	if options.chunkSize <= 0 {
+		options.chunkSize = xDEFAULT_FILE_CHUNK_SIZE
+	}
+	if options.ackTimeout <= 0 {
+		options.ackTimeout = xDEFAULT_FILE_ACK_TIMEOUT
+	}
+	if options.maxRetries <= 0 {
+		options.maxRetries = xDEFAULT_FILE_MAX_RETRIES
+	}
+	if options.retryInterval <= 0 {
+		options.retryInterval = xDEFAULT_FILE_RETRY_INTERVAL
+	}
+	return options
+}
+
+func newKMessageFileFrame(uniquekey string, op byte, fileId string, payload []byte) (*ZeroKMessage, error) {
+	body := make([]byte, 0, 1+xFILE_ID_LENGTH+len(payload))
+	body = append(body, op)
+	body = append(body, fileId...)
+	body = append(body, payload...)
+	message, err := NewKMessage(MESSAGE_TYPE_FILE, body)
+	if err != nil {
+		return nil, err
+	}
+	err = message.AddUniqueKey(uniquekey)
+	if err != nil {
+		return nil, err
+	}
+	return message, message.Complete()
+}
+
+func newKMessageFileAck(message *ZeroKMessage, status byte, offset int64, reason string) (*ZeroKMessage, error) {
+	body := binary.BigEndian.AppendUint64([]byte{status}, uint64(offset))
+	ackMessage := NewAckKMessage(MESSAGE_TYPE_FILEACK, message.MessageId(), append(body, reason...))
+	ackMessage.AddUniqueKey(message.UniqueKey())
+	return ackMessage, ackMessage.Complete()
+}
+
+func parseKMessageFileAck(message *ZeroKMessage) (byte, int64, string, error) {
+	body := message.MessageBody()
+	if message.MessageType() != MESSAGE_TYPE_FILEACK || len(body) < 9 {
+		return 0, 0, "", errors.New(" invalid file ack ")
+	}
+	return body[0], int64(binary.BigEndian.Uint64(body[1:9])), string(body[9:]), nil
+}
+
+func kMessageFileHash(reader io.Reader) (string, int64, error) {
+	digest := sha256.New()
+	size, err := io.Copy(digest, reader)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), size, nil
+}
+
+type xFileReceive struct {
+	offer  *ZeroKMessageFileOffer
+	file   *os.File
+	offset int64
+}
+
+type kZeroKMessageFiles struct {
+	options  *kMessageFileOptions
+	receives map[string]*xFileReceive
+	mutex    sync.Mutex
+}
+
+func newKMessageFiles(options *kMessageFileOptions) *kZeroKMessageFiles {
+	return &kZeroKMessageFiles{
+		options:  options,
+		receives: make(map[string]*xFileReceive),
+	}
+}
+
+func (files *kZeroKMessageFiles) keyDir(conn server.ZeroConnect) string {
+	if xconn, ok := conn.(ZeroKMessageConnect); ok && len(xconn.UniqueKey()) > 0 {
+		return filepath.Join(files.options.dir, hex.EncodeToString([]byte(xconn.UniqueKey())))
+	}
+	return files.options.dir
+}
+
+func (files *kZeroKMessageFiles) partPath(conn server.ZeroConnect, fileId string) string {
+	return filepath.Join(files.keyDir(conn), fileId+".part")
+}
+
+func (files *kZeroKMessageFiles) filePath(conn server.ZeroConnect, fileId string, name string) string {
+	return filepath.Join(files.keyDir(conn), fmt.Sprintf("%s-%s", fileId, filepath.Base(filepath.Clean("/"+name))))
+}
+
+func (files *kZeroKMessageFiles) onFile(conn server.ZeroConnect, message *ZeroKMessage, operator ZeroKMessageOperator, push func(*ZeroKMessage) error) error {
+	status, offset, reason := byte(xFILE_STATUS_REJECTED), int64(0), "file transfer not supported"
+	body := message.MessageBody()
+	fileOperator, ok := operator.(ZeroKMessageFileOperator)
+	if len(body) < 1+xFILE_ID_LENGTH {
+		reason = "invalid file frame"
+	} else if _, err := hex.DecodeString(string(body[1 : 1+xFILE_ID_LENGTH])); err != nil {
+		reason = "invalid file id"
+	} else if ok {
+		fileId, payload := string(body[1:1+xFILE_ID_LENGTH]), body[1+xFILE_ID_LENGTH:]
+		switch body[0] {
+		case xFILE_OP_OFFER:
+			status, offset, reason = files.offer(conn, fileOperator, fileId, payload)
+		case xFILE_OP_CHUNK:
+			status, offset, reason = files.chunk(conn, fileOperator, fileId, payload)
+		case xFILE_OP_FINISH:
+			status, offset, reason = files.finish(conn, fileOperator, fileId)
+		default:
+			reason = fmt.Sprintf("unknown file op 0x%02x", body[0])
+		}
+	}
+	ackMessage, err := newKMessageFileAck(message, status, offset, reason)
+	if err != nil {
+		return err
+	}
+	return push(ackMessage)
+}
+
+func (files *kZeroKMessageFiles) offer(conn server.ZeroConnect, operator ZeroKMessageFileOperator, fileId string, payload []byte) (byte, int64, string) {
+	offer := &ZeroKMessageFileOffer{}
+	err := json.Unmarshal(payload, offer)
+	if err != nil || offer.FileId != fileId || offer.Size < 0 {
+		return xFILE_STATUS_REJECTED, 0, "invalid file offer"
+	}
+	err = operator.OnFileOffer(conn, offer)
+	if err != nil {
+		return xFILE_STATUS_REJECTED, 0, err.Error()
+	}
+
+	if !structs.Xfexists(files.keyDir(conn)) {
+		err = os.MkdirAll(files.keyDir(conn), 0777)
+		if err != nil {
+			return xFILE_STATUS_ERROR, 0, err.Error()
+		}
+	}
+	file, err := os.OpenFile(files.partPath(conn, fileId), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return xFILE_STATUS_ERROR, 0, err.Error()
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return xFILE_STATUS_ERROR, 0, err.Error()
+	}
+	offset := stat.Size()
+	if offset > offer.Size {
+		offset = 0
+		err = file.Truncate(0)
+		if err != nil {
+			file.Close()
+			return xFILE_STATUS_ERROR, 0, err.Error()
+		}
+	}
+
+	files.mutex.Lock()
+	if receive, ok := files.receives[fileId]; ok {
+		receive.file.Close()
+	}
+	files.receives[fileId] = &xFileReceive{offer: offer, file: file, offset: offset}
+	files.mutex.Unlock()
+	global.Logger().Info(fmt.Sprintf("0protocol/1.0 file %s `%s` accepted, resume from %d/%d", fileId, offer.Name, offset, offer.Size))
+	return xFILE_STATUS_OK, offset, ""
+}
+
+func (files *kZeroKMessageFiles) chunk(conn server.ZeroConnect, operator ZeroKMessageFileOperator, fileId string, payload []byte) (byte, int64, string) {
+	files.mutex.Lock()
+	receive, ok := files.receives[fileId]
+	files.mutex.Unlock()
+	if !ok {
+		return xFILE_STATUS_ERROR, 0, "file not offered"
+	}
+	if len(payload) < 8 {
+		return xFILE_STATUS_ERROR, receive.offset, "invalid file chunk"
+	}
+	offset, data := int64(binary.BigEndian.Uint64(payload[:8])), payload[8:]
+	if offset != receive.offset {
+		return xFILE_STATUS_OFFSET, receive.offset, ""
+	}
+	if offset+int64(len(data)) > receive.offer.Size {
+		return xFILE_STATUS_ERROR, receive.offset, "file chunk exceeds offered size"
+	}
+	_, err := receive.file.WriteAt(data, offset)
+	if err != nil {
+		return xFILE_STATUS_ERROR, receive.offset, err.Error()
+	}
+	receive.offset += int64(len(data))
+	operator.OnFileProgress(conn, receive.offer, receive.offset)
+	return xFILE_STATUS_OK, receive.offset, ""
+}
+
+func (files *kZeroKMessageFiles) finish(conn server.ZeroConnect, operator ZeroKMessageFileOperator, fileId string) (byte, int64, string) {
+	files.mutex.Lock()
+	receive, ok := files.receives[fileId]
+	if ok && receive.offset == receive.offer.Size {
+		delete(files.receives, fileId)
+	}
+	files.mutex.Unlock()
+	if !ok {
+		return xFILE_STATUS_ERROR, 0, "file not offered"
+	}
+	if receive.offset != receive.offer.Size {
+		return xFILE_STATUS_OFFSET, receive.offset, ""
+	}
+
+	_, err := receive.file.Seek(0, io.SeekStart)
+	if err != nil {
+		receive.file.Close()
+		return xFILE_STATUS_ERROR, 0, err.Error()
+	}
+	hash, _, err := kMessageFileHash(receive.file)
+	receive.file.Close()
+	if err != nil {
+		return xFILE_STATUS_ERROR, 0, err.Error()
+	}
+	if hash != receive.offer.Hash {
+		os.Remove(files.partPath(conn, fileId))
+		return xFILE_STATUS_MISMATCH, 0, "file hash mismatch"
+	}
+
+	filePath := files.filePath(conn, fileId, receive.offer.Name)
+	err = os.Rename(files.partPath(conn, fileId), filePath)
+	if err != nil {
+		return xFILE_STATUS_ERROR, 0, err.Error()
+	}
+	err = operator.OnFileReceived(conn, receive.offer, filePath)
+	if err != nil {
+		return xFILE_STATUS_REJECTED, receive.offset, err.Error()
+	}
+	return xFILE_STATUS_OK, receive.offset, ""
+}
+
+func (files *kZeroKMessageFiles) abort() {
+	files.mutex.Lock()
+	defer files.mutex.Unlock()
+	for fileId, receive := range files.receives {
+		delete(files.receives, fileId)
+		receive.file.Close()
+	}
+}
+
+type xKMessageExec func(context.Context, *ZeroKMessage) (*ZeroKMessage, error)
+
+func (files *kZeroKMessageFiles) send(ctx context.Context, exec xKMessageExec, uniquekey string, filePath string, meta map[string]string, progress ZeroKMessageFileProgress) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash, size, err := kMessageFileHash(file)
+	if err != nil {
+		return err
+	}
+	offer := &ZeroKMessageFileOffer{
+		FileId:    hash[:xFILE_ID_LENGTH],
+		Name:      filepath.Base(filePath),
+		Size:      size,
+		ChunkSize: files.options.chunkSize,
+		Hash:      hash,
+		Meta:      meta,
+	}
+
+	for attempts := 0; ; attempts++ {
+		err = files.transfer(ctx, exec, uniquekey, file, offer, progress)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*kMessageFileRefused); ok || attempts >= files.options.maxRetries {
+			return err
+		}
+		global.Logger().Warn(fmt.Sprintf("0protocol/1.0 file %s `%s` transfer interrupted, retry %d : %s", offer.FileId, offer.Name, attempts+1, err.Error()))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second * time.Duration(files.options.retryInterval)):
+		}
+	}
+}
+
+func (files *kZeroKMessageFiles) exec(ctx context.Context, exec xKMessageExec, message *ZeroKMessage) (byte, int64, error) {
+	xCtx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(files.options.ackTimeout))
+	defer cancel()
+	ackMessage, err := exec(xCtx, message)
+	if err != nil {
+		return 0, 0, err
+	}
+	status, offset, reason, err := parseKMessageFileAck(ackMessage)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch status {
+	case xFILE_STATUS_OK, xFILE_STATUS_OFFSET:
+		return status, offset, nil
+	case xFILE_STATUS_ERROR:
+		return status, offset, errors.New(reason)
+	}
+	return status, offset, &kMessageFileRefused{reason: reason}
+}
+
+func (files *kZeroKMessageFiles) transfer(ctx context.Context, exec xKMessageExec, uniquekey string, file *os.File, offer *ZeroKMessageFileOffer, progress ZeroKMessageFileProgress) error {
+	offerBytes, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+	message, err := newKMessageFileFrame(uniquekey, xFILE_OP_OFFER, offer.FileId, offerBytes)
+	if err != nil {
+		return err
+	}
+	_, offset, err := files.exec(ctx, exec, message)
+	if err != nil {
+		return err
+	}
+
+	buffer := make([]byte, offer.ChunkSize)
+	for {
+		if progress != nil {
+			progress(offer, offset)
+		}
+		if offset >= offer.Size {
+			message, err = newKMessageFileFrame(uniquekey, xFILE_OP_FINISH, offer.FileId, nil)
+			if err != nil {
+				return err
+			}
+			status, xOffset, err := files.exec(ctx, exec, message)
+			if err != nil {
+				return err
+			}
+			if status == xFILE_STATUS_OK {
+				return nil
+			}
+			offset = xOffset
+			continue
+		}
+
+		n, err := file.ReadAt(buffer, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n <= 0 {
+			return &kMessageFileRefused{reason: "file changed during transfer"}
+		}
+		payload := binary.BigEndian.AppendUint64(make([]byte, 0, 8+n), uint64(offset))
+		message, err = newKMessageFileFrame(uniquekey, xFILE_OP_CHUNK, offer.FileId, append(payload, buffer[:n]...))
+		if err != nil {
+			return err
+		}
+		_, offset, err = files.exec(ctx, exec, message)
+		if err != nil {
+			return err
+		}
+	}
+}
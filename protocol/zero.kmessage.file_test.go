@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/0meet1/zero-framework/server"
+)
+
+type xTestFileOperator struct {
+	received map[string]string
+	progress []int64
+	mutex    sync.Mutex
+}
+
+func (operator *xTestFileOperator) Operation(server.ZeroConnect, *ZeroKMessage) (bool, error) {
+	return false, nil
+}
+
+func (operator *xTestFileOperator) OnFileOffer(server.ZeroConnect, *ZeroKMessageFileOffer) error {
+	return nil
+}
+
+func (operator *xTestFileOperator) OnFileProgress(conn server.ZeroConnect, offer *ZeroKMessageFileOffer, offset int64) {
+	operator.mutex.Lock()
+	defer operator.mutex.Unlock()
+	operator.progress = append(operator.progress, offset)
+}
+
+func (operator *xTestFileOperator) OnFileReceived(conn server.ZeroConnect, offer *ZeroKMessageFileOffer, filePath string) error {
+	operator.mutex.Lock()
+	defer operator.mutex.Unlock()
+	operator.received[conn.(ZeroKMessageConnect).UniqueKey()] = filePath
+	return nil
+}
+
+func newTestKMessageFileOptions(dir string) *kMessageFileOptions {
+	return &kMessageFileOptions{dir: dir, chunkSize: 4, ackTimeout: 5, maxRetries: 3}
+}
+
+func testKMessageFileExec(files *kZeroKMessageFiles, conn server.ZeroConnect, operator ZeroKMessageOperator, intercept func(*ZeroKMessage) error) xKMessageExec {
+	return func(ctx context.Context, message *ZeroKMessage) (*ZeroKMessage, error) {
+		if intercept != nil {
+			if err := intercept(message); err != nil {
+				return nil, err
+			}
+		}
+		var ackMessage *ZeroKMessage
+		err := files.onFile(conn, ParseKMessage(message.Bytes()), operator, func(xMessage *ZeroKMessage) error {
+			ackMessage = ParseKMessage(xMessage.Bytes())
+			return nil
+		})
+		return ackMessage, err
+	}
+}
+
+func TestKMessageFileResume(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "source.bin")
+	content := []byte("0123456789abcdefghij-resume")
+	if err := os.WriteFile(srcPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	recvDir := filepath.Join(dir, "recv")
+	operator := &xTestFileOperator{received: make(map[string]string)}
+	conn := &kZeroKMessageConnect{uniquekey: "device1"}
+	sender := newKMessageFiles(newTestKMessageFileOptions(dir))
+
+	receiver := newKMessageFiles(newTestKMessageFileOptions(recvDir))
+	chunks := 0
+	interrupted := errors.New("connect lost")
+	err := sender.send(context.Background(), testKMessageFileExec(receiver, conn, operator, func(message *ZeroKMessage) error {
+		if message.MessageBody()[0] == xFILE_OP_CHUNK {
+			chunks++
+			if chunks > 3 {
+				return interrupted
+			}
+		}
+		return nil
+	}), "device1", srcPath, nil, nil)
+	if err != interrupted {
+		t.Fatalf("interrupted transfer %v", err)
+	}
+	receiver.abort()
+
+	hash, _, _ := kMessageFileHash(bytes.NewReader(content))
+	fileId := hash[:xFILE_ID_LENGTH]
+	keyDir := filepath.Join(recvDir, hex.EncodeToString([]byte("device1")))
+	stat, err := os.Stat(filepath.Join(keyDir, fileId+".part"))
+	if err != nil || stat.Size() != 12 {
+		t.Fatalf("part file %v %v", stat, err)
+	}
+
+	receiver = newKMessageFiles(newTestKMessageFileOptions(recvDir))
+	offsets := make([]int64, 0)
+	err = sender.send(context.Background(), testKMessageFileExec(receiver, conn, operator, func(message *ZeroKMessage) error {
+		if message.MessageBody()[0] == xFILE_OP_CHUNK {
+			offsets = append(offsets, int64(binary.BigEndian.Uint64(message.MessageBody()[1+xFILE_ID_LENGTH:])))
+		}
+		return nil
+	}), "device1", srcPath, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) <= 0 || offsets[0] != 12 {
+		t.Fatalf("resumed offsets %v", offsets)
+	}
+
+	filePath := filepath.Join(keyDir, fileId+"-source.bin")
+	if operator.received["device1"] != filePath {
+		t.Fatalf("received path %s expect %s", operator.received["device1"], filePath)
+	}
+	datas, err := os.ReadFile(filePath)
+	if err != nil || !bytes.Equal(datas, content) {
+		t.Fatalf("received file %q %v", datas, err)
+	}
+	if _, err := os.Stat(filepath.Join(keyDir, fileId+".part")); !os.IsNotExist(err) {
+		t.Fatal("part file kept after finish")
+	}
+
+	other := &kZeroKMessageConnect{uniquekey: "device2"}
+	err = sender.send(context.Background(), testKMessageFileExec(newKMessageFiles(newTestKMessageFileOptions(recvDir)), other, operator, nil), "device2", srcPath, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if operator.received["device2"] == filePath || filepath.Dir(operator.received["device2"]) != filepath.Join(recvDir, hex.EncodeToString([]byte("device2"))) {
+		t.Fatalf("second uniquekey received at %s", operator.received["device2"])
+	}
+	if datas, _ := os.ReadFile(filePath); !bytes.Equal(datas, content) {
+		t.Fatal("first uniquekey file overwritten")
+	}
+}
+
+func TestKMessageFileRejected(t *testing.T) {
+	files := newKMessageFiles(newTestKMessageFileOptions(t.TempDir()))
+	operator := &xTestFileOperator{received: make(map[string]string)}
+	conn := &kZeroKMessageConnect{uniquekey: "device1"}
+	fileId := "0123456789abcdef0123456789abcdef"
+
+	status, _, _ := files.chunk(conn, operator, fileId, make([]byte, 12))
+	if status != xFILE_STATUS_ERROR {
+		t.Fatalf("chunk without offer status 0x%02x", status)
+	}
+	status, _, _ = files.offer(conn, operator, fileId, []byte(`{"fileId":"`+fileId+`","name":"../../etc/passwd","size":4,"hash":"x"}`))
+	if status != xFILE_STATUS_OK {
+		t.Fatalf("offer status 0x%02x", status)
+	}
+	status, offset, _ := files.chunk(conn, operator, fileId, append(binary.BigEndian.AppendUint64(nil, 2), "ab"...))
+	if status != xFILE_STATUS_OFFSET || offset != 0 {
+		t.Fatalf("out of order chunk status 0x%02x offset %d", status, offset)
+	}
+	status, _, _ = files.chunk(conn, operator, fileId, append(binary.BigEndian.AppendUint64(nil, 0), "abcde"...))
+	if status != xFILE_STATUS_ERROR {
+		t.Fatalf("oversize chunk status 0x%02x", status)
+	}
+	files.chunk(conn, operator, fileId, append(binary.BigEndian.AppendUint64(nil, 0), "abcd"...))
+	status, _, _ = files.finish(conn, operator, fileId)
+	if status != xFILE_STATUS_MISMATCH {
+		t.Fatalf("hash mismatch status 0x%02x", status)
+	}
+	if filepath.Base(files.filePath(conn, fileId, "../../etc/passwd")) != fileId+"-passwd" {
+		t.Fatal("file name escapes unique key dir")
+	}
+}
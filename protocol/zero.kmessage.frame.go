@@ -0,0 +1,210 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+var kMessageTypeNames = map[int]string{
+	MESSAGE_TYPE_CONNECT:   "CONNECT",
+	MESSAGE_TYPE_HEARTBEAT: "HEARTBEAT",
+	MESSAGE_TYPE_AUTH:      "AUTH",
+	MESSAGE_TYPE_RPC:       "RPC",
+	MESSAGE_TYPE_FILE:      "FILE",
+	MESSAGE_TYPE_TOPIC:     "TOPIC",
+	MESSAGE_TYPE_CONNACK:   "CONNACK",
+	MESSAGE_TYPE_BEATACK:   "BEATACK",
+	MESSAGE_TYPE_CHALLENGE: "CHALLENGE",
+	MESSAGE_TYPE_RPCACK:    "RPCACK",
+	MESSAGE_TYPE_PUBACK:    "PUBACK",
+	MESSAGE_TYPE_FILEACK:   "FILEACK",
+	MESSAGE_TYPE_TOPICACK:  "TOPICACK",
+}
+
+var kMessageFlagNames = []struct {
+	flag byte
+	name string
+}{
+	{KMESSAGE_FLAG_GZIP, "GZIP"},
+	{KMESSAGE_FLAG_DEFLATE, "DEFLATE"},
+	{KMESSAGE_FLAG_CHUNKED, "CHUNKED"},
+	{KMESSAGE_FLAG_CHUNK_END, "CHUNK_END"},
+	{KMESSAGE_FLAG_WINDOW, "WINDOW"},
+	{KMESSAGE_FLAG_ACK_REQ, "ACK_REQ"},
+}
+
+func KMessageTypeName(messageType int) string {
+	if name, ok := kMessageTypeNames[messageType]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", messageType)
+}
+
+func KMessageFlagNames(flags byte) []string {
+	names := make([]string, 0)
+	for _, xFlag := range kMessageFlagNames {
+		if flags&xFlag.flag != 0 {
+			names = append(names, xFlag.name)
+		}
+	}
+	return names
+}
+
+func ParseKMessageType(name string) (byte, error) {
+	for messageType, typeName := range kMessageTypeNames {
+		if strings.EqualFold(typeName, name) {
+			return byte(messageType), nil
+		}
+	}
+	var messageType byte
+	_, err := fmt.Sscanf(strings.ToLower(name), "0x%x", &messageType)
+	if err != nil {
+		return 0, fmt.Errorf(" unknown message type `%s` ", name)
+	}
+	return messageType, nil
+}
+
+func ParseKMessageFlags(names ...string) (byte, error) {
+	var flags byte
+	for _, name := range names {
+		found := false
+		for _, xFlag := range kMessageFlagNames {
+			if strings.EqualFold(xFlag.name, strings.TrimSpace(name)) {
+				flags |= xFlag.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf(" unknown message flag `%s` ", name)
+		}
+	}
+	return flags, nil
+}
+
+// NewKMessageFrame builds a completed frame with every header field chosen by the caller,
+// an empty messageId is generated.
+func NewKMessageFrame(version byte, messageType byte, messageId string, uniquekey string, flags byte, sequence int, xBody []byte) (*ZeroKMessage, error) {
+	if version != KMESSAGE_VERSION_1 && version != KMESSAGE_VERSION_2 {
+		return nil, fmt.Errorf(" unsupported kmessage version %d ", version)
+	}
+	if version == KMESSAGE_VERSION_1 && (flags != 0 || sequence != 0) {
+		return nil, fmt.Errorf(" flags and sequence require kmessage version 2 ")
+	}
+	var message *ZeroKMessage
+	if len(messageId) > 0 {
+		if len(messageId) != 32 {
+			return nil, fmt.Errorf(" messageId should be 32 bytes ")
+		}
+		message = NewAckKMessage(messageType, messageId, xBody)
+	} else {
+		xMessage, err := NewKMessage(messageType, xBody)
+		if err != nil {
+			return nil, err
+		}
+		message = xMessage
+	}
+	err := message.AddUniqueKey(uniquekey)
+	if err != nil {
+		return nil, err
+	}
+	if version == KMESSAGE_VERSION_2 {
+		message.advertise(KMESSAGE_VERSION_2)
+		message.useVersion(KMESSAGE_VERSION_2)
+		message.useFlags(flags)
+		message.useSequence(sequence)
+	}
+	return message, message.Complete()
+}
+
+// SplitKMessageFrames cuts a raw byte stream into frames, bytes before a frame head are skipped
+// and an incomplete tail is returned as remain.
+func SplitKMessageFrames(datas []byte) ([][]byte, []byte) {
+	frames := make([][]byte, 0)
+	for len(datas) >= 4 {
+		if !reflect.DeepEqual(datas[:4], kZERO_MESSAGE_HEAD) {
+			datas = datas[1:]
+			continue
+		}
+		dataLength := ParseKMessageLength(datas)
+		if dataLength < 0 && len(datas) >= 10 {
+			datas = datas[1:]
+			continue
+		}
+		if dataLength < 0 || len(datas) < dataLength {
+			return frames, datas
+		}
+		frames = append(frames, datas[:dataLength])
+		datas = datas[dataLength:]
+	}
+	return frames, datas
+}
+
+type ZeroKMessageFrame struct {
+	Version    int      `json:"version"`
+	MaxVersion int      `json:"maxVersion"`
+	DataLength int      `json:"dataLength"`
+	UniqueKey  string   `json:"uniquekey,omitempty"`
+	MessageId  string   `json:"messageId"`
+	Type       string   `json:"type"`
+	TypeCode   int      `json:"typeCode"`
+	Flags      []string `json:"flags,omitempty"`
+	Sequence   int      `json:"sequence,omitempty"`
+	BodyLength int      `json:"bodyLength"`
+	Body       string   `json:"body,omitempty"`
+	BodyHex    string   `json:"bodyHex,omitempty"`
+	CheckSum   string   `json:"checkSum"`
+	Valid      bool     `json:"valid"`
+	Error      string   `json:"error,omitempty"`
+	Frame      string   `json:"frame"`
+}
+
+// DescribeKMessage parses one raw frame into its readable fields and validates its length and checksum.
+func DescribeKMessage(datas []byte) *ZeroKMessageFrame {
+	xDatas := append([]byte{}, datas...)
+	frame := &ZeroKMessageFrame{Frame: hex.EncodeToString(xDatas)}
+	if len(xDatas) < xV1_FIXED_LENGTH || ParseKMessageLength(xDatas) < 0 {
+		frame.Error = fmt.Sprintf("invalid frame of %d bytes", len(xDatas))
+		return frame
+	}
+	message := ParseKMessage(xDatas)
+	if message.head == nil {
+		frame.Error = kMessageFrameError(message.xerr)
+		return frame
+	}
+	frame.Version = message.Version()
+	frame.MaxVersion = message.MaxVersion()
+	frame.DataLength = message.DataLength()
+	frame.UniqueKey = message.UniqueKey()
+	frame.MessageId = message.MessageId()
+	frame.Type = KMessageTypeName(message.MessageType())
+	frame.TypeCode = message.MessageType()
+	frame.Flags = KMessageFlagNames(message.Flags())
+	frame.Sequence = message.Sequence()
+	frame.BodyLength = message.BodyLength()
+	frame.CheckSum = hex.EncodeToString(message.checkSum)
+	if utf8.Valid(message.MessageBody()) {
+		frame.Body = string(message.MessageBody())
+	}
+	frame.BodyHex = hex.EncodeToString(message.MessageBody())
+	err := message.Check()
+	if err != nil {
+		frame.Error = kMessageFrameError(err)
+	} else {
+		frame.Valid = true
+	}
+	return frame
+}
+
+func kMessageFrameError(err error) string {
+	reasons := strings.Split(err.Error(), "###")
+	for _, reason := range reasons {
+		if len(strings.TrimSpace(reason)) > 0 {
+			return strings.TrimSpace(reason)
+		}
+	}
+	return err.Error()
+}
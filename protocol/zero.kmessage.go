@@ -1,8 +1,13 @@
 package protocol
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"reflect"
 	"strings"
 
@@ -13,6 +18,8 @@ import (
 var (
 	xV1_VERSION = []byte{0x00, 0x01}
 
+	kMessageMaxBodySize = xDEFAULT_MAX_BODY_SIZE
+
 	defaultUniqueKey = []byte{
 		'`', '`', '`', '`', '`', '`', '`', '`',
 		'`', '`', '`', '`', '`', '`', '`', '`',
@@ -23,11 +30,43 @@ var (
 const (
 	MESSAGE_TYPE_CONNECT   = 0x01
 	MESSAGE_TYPE_HEARTBEAT = 0x02
-
-	MESSAGE_TYPE_CONNACK = 0x11
-	MESSAGE_TYPE_BEATACK = 0x12
+	MESSAGE_TYPE_AUTH      = 0x03
+	MESSAGE_TYPE_RPC       = 0x04
+	MESSAGE_TYPE_FILE      = 0x05
+	MESSAGE_TYPE_TOPIC     = 0x06
+
+	MESSAGE_TYPE_CONNACK   = 0x11
+	MESSAGE_TYPE_BEATACK   = 0x12
+	MESSAGE_TYPE_CHALLENGE = 0x13
+	MESSAGE_TYPE_RPCACK    = 0x14
+	MESSAGE_TYPE_PUBACK    = 0x15
+	MESSAGE_TYPE_FILEACK   = 0x16
+	MESSAGE_TYPE_TOPICACK  = 0x17
+
+	KMESSAGE_VERSION_1 = 0x01
+	KMESSAGE_VERSION_2 = 0x02
+
+	KMESSAGE_FLAG_GZIP      = 0x01
+	KMESSAGE_FLAG_DEFLATE   = 0x02
+	KMESSAGE_FLAG_CHUNKED   = 0x04
+	KMESSAGE_FLAG_CHUNK_END = 0x08
+	KMESSAGE_FLAG_WINDOW    = 0x10
+	KMESSAGE_FLAG_ACK_REQ   = 0x20
+
+	xFLAG_COMPRESSION = KMESSAGE_FLAG_GZIP | KMESSAGE_FLAG_DEFLATE
+
+	xV1_FIXED_LENGTH = 85
+	xV2_FIXED_LENGTH = 92
+
+	xDEFAULT_MAX_BODY_SIZE = 64 * 1024 * 1024
 )
 
+func UseKMessageMaxBodySize(maxBodySize int) {
+	if maxBodySize > 0 {
+		kMessageMaxBodySize = maxBodySize
+	}
+}
+
 type ZeroKMessage struct {
 	head        []byte
 	version     []byte
@@ -39,6 +78,11 @@ type ZeroKMessage struct {
 	messageBody []byte
 	checkSum    []byte
 	end         []byte
+
+	flags    byte
+	sequence []byte
+	wireBody []byte
+	xerr     error
 }
 
 func NewKMessage(messageType byte, xBody []byte) (*ZeroKMessage, error) {
@@ -82,10 +126,21 @@ func ParseKMessageLength(datas []byte) int {
 	if !reflect.DeepEqual(datas[0:4], kZERO_MESSAGE_HEAD) {
 		return -1
 	}
-	return int(binary.BigEndian.Uint32(datas[6:10]))
+	fixedLength := xV1_FIXED_LENGTH
+	if datas[5] == KMESSAGE_VERSION_2 {
+		fixedLength = xV2_FIXED_LENGTH
+	}
+	dataLength := uint64(binary.BigEndian.Uint32(datas[6:10]))
+	if dataLength < uint64(fixedLength) || dataLength > uint64(fixedLength+kMessageMaxBodySize) {
+		return -1
+	}
+	return int(dataLength)
 }
 
 func ParseKMessage(datas []byte) *ZeroKMessage {
+	if len(datas) > 5 && datas[5] == KMESSAGE_VERSION_2 {
+		return parseKMessageV2(datas)
+	}
 	xDatasLength := len(datas)
 	return &ZeroKMessage{
 		head:        datas[0:4],
@@ -118,7 +173,49 @@ func (v1msg *ZeroKMessage) EndString() string {
 }
 
 func (v1msg *ZeroKMessage) Version() int {
-	return int(binary.BigEndian.Uint16(v1msg.version))
+	return int(v1msg.version[1])
+}
+
+func (v1msg *ZeroKMessage) MaxVersion() int {
+	if v1msg.version[0] > v1msg.version[1] {
+		return int(v1msg.version[0])
+	}
+	return int(v1msg.version[1])
+}
+
+func (v1msg *ZeroKMessage) Flags() byte {
+	return v1msg.flags
+}
+
+func (v1msg *ZeroKMessage) Sequence() int {
+	if len(v1msg.sequence) < 4 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(v1msg.sequence))
+}
+
+func (v1msg *ZeroKMessage) advertise(maxVersion byte) {
+	v1msg.version = []byte{maxVersion, v1msg.version[1]}
+}
+
+func (v1msg *ZeroKMessage) useVersion(version byte) {
+	v1msg.version = []byte{v1msg.version[0], version}
+	if version == KMESSAGE_VERSION_2 {
+		v1msg.checkSum = make([]byte, 4)
+		if len(v1msg.sequence) < 4 {
+			v1msg.sequence = make([]byte, 4)
+		}
+	} else {
+		v1msg.checkSum = make([]byte, 2)
+	}
+}
+
+func (v1msg *ZeroKMessage) useFlags(flags byte) {
+	v1msg.flags = flags
+}
+
+func (v1msg *ZeroKMessage) useSequence(sequence int) {
+	v1msg.sequence = binary.BigEndian.AppendUint32(make([]byte, 0, 4), uint32(sequence))
 }
 
 func (v1msg *ZeroKMessage) DataLength() int {
@@ -162,6 +259,9 @@ func (v1msg *ZeroKMessage) MessageBody() []byte {
 }
 
 func (v1msg *ZeroKMessage) Complete() error {
+	if v1msg.Version() == KMESSAGE_VERSION_2 {
+		return v1msg.completeV2()
+	}
 	binary.BigEndian.PutUint32(v1msg.bodyLength, uint32(len(v1msg.messageBody)))
 	binary.BigEndian.PutUint32(v1msg.dataLength, uint32(85+len(v1msg.messageBody)))
 
@@ -184,6 +284,12 @@ func (v1msg *ZeroKMessage) Complete() error {
 }
 
 func (v1msg *ZeroKMessage) Check() error {
+	if v1msg.xerr != nil {
+		return v1msg.xerr
+	}
+	if v1msg.Version() == KMESSAGE_VERSION_2 {
+		return v1msg.checkV2()
+	}
 	bodys := make([]byte, 0)
 	bodys = append(bodys, v1msg.head...)
 	bodys = append(bodys, v1msg.version...)
@@ -230,6 +336,9 @@ func (v1msg *ZeroKMessage) Check() error {
 }
 
 func (v1msg *ZeroKMessage) Bytes() []byte {
+	if v1msg.Version() == KMESSAGE_VERSION_2 {
+		return v1msg.frameV2(v1msg.checkSum)
+	}
 	bodys := make([]byte, 0)
 	bodys = append(bodys, v1msg.head...)
 	bodys = append(bodys, v1msg.version...)
@@ -247,3 +356,135 @@ func (v1msg *ZeroKMessage) Bytes() []byte {
 func (v1msg *ZeroKMessage) String() string {
 	return structs.BytesString(v1msg.Bytes()...)
 }
+
+func parseKMessageV2(datas []byte) *ZeroKMessage {
+	xDatasLength := len(datas)
+	if xDatasLength < xV2_FIXED_LENGTH {
+		return &ZeroKMessage{
+			version: datas[4:6],
+			xerr:    fmt.Errorf("\n### err message v2 length %d ### message datas \n%s", xDatasLength, structs.BytesString(datas...)),
+		}
+	}
+	v2msg := &ZeroKMessage{
+		head:        datas[0:4],
+		version:     datas[4:6],
+		dataLength:  datas[6:10],
+		uniquekey:   datas[10:42],
+		messageId:   datas[42:74],
+		messageType: datas[74],
+		flags:       datas[75],
+		sequence:    datas[76:80],
+		bodyLength:  datas[80:84],
+		wireBody:    datas[84 : xDatasLength-8],
+		checkSum:    datas[xDatasLength-8 : xDatasLength-4],
+		end:         datas[xDatasLength-4:],
+	}
+	v2msg.messageBody, v2msg.xerr = decompressKMessageBody(v2msg.flags, v2msg.wireBody)
+	return v2msg
+}
+
+func compressKMessageBody(flags byte, body []byte) ([]byte, error) {
+	var writer io.WriteCloser
+	buffer := &bytes.Buffer{}
+	switch flags & xFLAG_COMPRESSION {
+	case KMESSAGE_FLAG_GZIP:
+		writer = gzip.NewWriter(buffer)
+	case KMESSAGE_FLAG_DEFLATE:
+		xWriter, err := flate.NewWriter(buffer, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		writer = xWriter
+	default:
+		return body, nil
+	}
+	_, err := writer.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decompressKMessageBody(flags byte, wireBody []byte) ([]byte, error) {
+	var reader io.ReadCloser
+	switch flags & xFLAG_COMPRESSION {
+	case KMESSAGE_FLAG_GZIP:
+		xReader, err := gzip.NewReader(bytes.NewReader(wireBody))
+		if err != nil {
+			return nil, err
+		}
+		reader = xReader
+	case KMESSAGE_FLAG_DEFLATE:
+		reader = flate.NewReader(bytes.NewReader(wireBody))
+	default:
+		return wireBody, nil
+	}
+	defer reader.Close()
+	body, err := io.ReadAll(io.LimitReader(reader, int64(kMessageMaxBodySize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > kMessageMaxBodySize {
+		return nil, fmt.Errorf(" kmessage body exceeds max body size %d ", kMessageMaxBodySize)
+	}
+	return body, nil
+}
+
+func (v1msg *ZeroKMessage) frameV2(checkSum []byte) []byte {
+	bodys := make([]byte, 0, xV2_FIXED_LENGTH+len(v1msg.wireBody))
+	bodys = append(bodys, v1msg.head...)
+	bodys = append(bodys, v1msg.version...)
+	bodys = append(bodys, v1msg.dataLength...)
+	bodys = append(bodys, v1msg.uniquekey...)
+	bodys = append(bodys, v1msg.messageId...)
+	bodys = append(bodys, v1msg.messageType)
+	bodys = append(bodys, v1msg.flags)
+	bodys = append(bodys, v1msg.sequence...)
+	bodys = append(bodys, v1msg.bodyLength...)
+	bodys = append(bodys, v1msg.wireBody...)
+	bodys = append(bodys, checkSum...)
+	bodys = append(bodys, v1msg.end...)
+	return bodys
+}
+
+func (v1msg *ZeroKMessage) completeV2() error {
+	wireBody, err := compressKMessageBody(v1msg.flags, v1msg.messageBody)
+	if err != nil {
+		return err
+	}
+	v1msg.wireBody = wireBody
+	binary.BigEndian.PutUint32(v1msg.bodyLength, uint32(len(v1msg.wireBody)))
+	binary.BigEndian.PutUint32(v1msg.dataLength, uint32(xV2_FIXED_LENGTH+len(v1msg.wireBody)))
+	binary.BigEndian.PutUint32(v1msg.checkSum, crc32.ChecksumIEEE(v1msg.frameV2(make([]byte, 4))))
+	return nil
+}
+
+func (v1msg *ZeroKMessage) checkV2() error {
+	bodys := v1msg.frameV2(make([]byte, 4))
+
+	if !reflect.DeepEqual(kZERO_MESSAGE_HEAD, v1msg.head) {
+		return fmt.Errorf("\n### err message head %s ### message datas \n%s", structs.BytesString(v1msg.head...), structs.BytesString(bodys...))
+	}
+
+	if !reflect.DeepEqual(kZERO_MESSAGE_END, v1msg.end) {
+		return fmt.Errorf("\n### err message end %s ### message datas \n%s", structs.BytesString(v1msg.end...), structs.BytesString(bodys...))
+	}
+
+	if v1msg.DataLength() != len(bodys) {
+		return fmt.Errorf("\n### err message data length %d reality %d", v1msg.DataLength(), len(bodys))
+	}
+
+	if v1msg.BodyLength() != len(v1msg.wireBody) {
+		return fmt.Errorf("\n### err message body length %d reality %d", v1msg.BodyLength(), len(v1msg.wireBody))
+	}
+
+	if binary.BigEndian.Uint32(v1msg.checkSum) != crc32.ChecksumIEEE(bodys) {
+		return fmt.Errorf("\n### err message verify %s", structs.BytesString(v1msg.checkSum...))
+	}
+
+	return nil
+}
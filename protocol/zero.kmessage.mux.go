@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+)
+
+const (
+	xDEFAULT_MAX_INFLIGHTS = 64
+)
+
+type kZeroKMessageMux struct {
+	calls      map[string]chan *ZeroKMessage
+	callsMutex sync.Mutex
+
+	inflights chan struct{}
+}
+
+func newKMessageMux(maxInflights int) *kZeroKMessageMux {
+	if maxInflights <= 0 {
+		maxInflights = xDEFAULT_MAX_INFLIGHTS
+	}
+	return &kZeroKMessageMux{
+		calls:     make(map[string]chan *ZeroKMessage),
+		inflights: make(chan struct{}, maxInflights),
+	}
+}
+
+func kMessageMaxInflights() int {
+	maxInflights := global.IntValue("zero.kmessage.maxInflights")
+	if maxInflights <= 0 {
+		return xDEFAULT_MAX_INFLIGHTS
+	}
+	return maxInflights
+}
+
+func (mux *kZeroKMessageMux) exec(ctx context.Context, push func(*ZeroKMessage) error, message *ZeroKMessage) (*ZeroKMessage, error) {
+	select {
+	case mux.inflights <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-mux.inflights }()
+
+	messageId := message.MessageId()
+	responseChan := make(chan *ZeroKMessage, 1)
+	mux.callsMutex.Lock()
+	if _, ok := mux.calls[messageId]; ok {
+		mux.callsMutex.Unlock()
+		return nil, fmt.Errorf(" message %s already in flight ", messageId)
+	}
+	mux.calls[messageId] = responseChan
+	mux.callsMutex.Unlock()
+	defer func() {
+		mux.callsMutex.Lock()
+		delete(mux.calls, messageId)
+		mux.callsMutex.Unlock()
+	}()
+
+	err := push(message)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-responseChan:
+		if !ok {
+			return nil, errors.New(" connect closed ")
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (mux *kZeroKMessageMux) execTimeout(push func(*ZeroKMessage) error, message *ZeroKMessage, withSecond int) (*ZeroKMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(withSecond))
+	defer cancel()
+	resp, err := mux.exec(ctx, push, message)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, errors.New(" request timeout ")
+	}
+	return resp, err
+}
+
+func (mux *kZeroKMessageMux) deliver(message *ZeroKMessage) bool {
+	mux.callsMutex.Lock()
+	responseChan, ok := mux.calls[message.MessageId()]
+	if ok {
+		delete(mux.calls, message.MessageId())
+	}
+	mux.callsMutex.Unlock()
+	if ok {
+		responseChan <- message
+	}
+	return ok
+}
+
+func (mux *kZeroKMessageMux) abort() {
+	mux.callsMutex.Lock()
+	for messageId, responseChan := range mux.calls {
+		delete(mux.calls, messageId)
+		close(responseChan)
+	}
+	mux.callsMutex.Unlock()
+}
+
+func (mux *kZeroKMessageMux) pending() int {
+	return len(mux.inflights)
+}
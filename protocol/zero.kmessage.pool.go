@@ -0,0 +1,264 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0meet1/zero-framework/consul"
+	"github.com/0meet1/zero-framework/global"
+)
+
+const (
+	KMESSAGE_BALANCE_ROUND_ROBIN    = "roundRobin"
+	KMESSAGE_BALANCE_LEAST_INFLIGHT = "leastInflight"
+
+	xDEFAULT_POOL_REFRESH = 30
+)
+
+type ZeroKMessageEndpointProvider interface {
+	Endpoints() ([]string, error)
+}
+
+type xConsulEndpointProvider struct {
+	service string
+}
+
+func (provider *xConsulEndpointProvider) Endpoints() ([]string, error) {
+	registry, ok := global.Value(consul.REGISTRY_TRUNK).(consul.ZeroServeRegistryTrunk)
+	if !ok {
+		return nil, fmt.Errorf(" consul registry `%s` not found ", consul.REGISTRY_TRUNK)
+	}
+	services, err := registry.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]string, 0)
+	for _, service := range services {
+		if service.Service == provider.service {
+			endpoints = append(endpoints, net.JoinHostPort(service.Address, strconv.Itoa(service.Port)))
+		}
+	}
+	return endpoints, nil
+}
+
+func NewKMessageConsulEndpoints(service string) ZeroKMessageEndpointProvider {
+	return &xConsulEndpointProvider{service: service}
+}
+
+type ZeroKMessagePool struct {
+	heartbeatTime          int
+	heartbeatCheckInterval int
+	operator               ZeroKMessageOperator
+	uniquekey              string
+
+	balancer string
+	cursor   uint64
+
+	clients      map[string]*kZeroKMessageClient
+	clientsMutex sync.RWMutex
+
+	provider ZeroKMessageEndpointProvider
+	refresh  int
+	done     chan struct{}
+}
+
+func NewKMessagePool(heartbeatTime int, heartbeatCheckInterval int, operator ZeroKMessageOperator, unk ...string) *ZeroKMessagePool {
+	pool := &ZeroKMessagePool{
+		heartbeatTime:          heartbeatTime,
+		heartbeatCheckInterval: heartbeatCheckInterval,
+		operator:               operator,
+		balancer:               KMESSAGE_BALANCE_ROUND_ROBIN,
+		clients:                make(map[string]*kZeroKMessageClient),
+		done:                   make(chan struct{}),
+	}
+	if len(unk) > 0 {
+		pool.uniquekey = unk[0]
+	}
+	if strings.EqualFold(global.StringValue("zero.kmessage.pool.balancer"), KMESSAGE_BALANCE_LEAST_INFLIGHT) {
+		pool.balancer = KMESSAGE_BALANCE_LEAST_INFLIGHT
+	}
+	return pool
+}
+
+func (pool *ZeroKMessagePool) UseBalancer(balancer string) *ZeroKMessagePool {
+	pool.balancer = balancer
+	return pool
+}
+
+func (pool *ZeroKMessagePool) UseProvider(provider ZeroKMessageEndpointProvider, refreshSeconds ...int) *ZeroKMessagePool {
+	pool.provider = provider
+	pool.refresh = global.IntValue("zero.kmessage.pool.refreshInterval")
+	if len(refreshSeconds) > 0 {
+		pool.refresh = refreshSeconds[0]
+	}
+	if pool.refresh <= 0 {
+		pool.refresh = xDEFAULT_POOL_REFRESH
+	}
+	pool.sync()
+	go pool.watch()
+	return pool
+}
+
+func (pool *ZeroKMessagePool) Add(addrs ...string) {
+	pool.clientsMutex.Lock()
+	defer pool.clientsMutex.Unlock()
+	for _, addr := range addrs {
+		if _, ok := pool.clients[addr]; ok {
+			continue
+		}
+		client := newKMessageClient(addr, pool.heartbeatTime, pool.heartbeatCheckInterval, pool.operator, pool.uniquekey)
+		pool.clients[addr] = client
+		go client.Connect()
+		global.Logger().Info(fmt.Sprintf("0protocol/1.0 pool endpoint %s added", addr))
+	}
+}
+
+func (pool *ZeroKMessagePool) Remove(addrs ...string) {
+	pool.clientsMutex.Lock()
+	defer pool.clientsMutex.Unlock()
+	for _, addr := range addrs {
+		client, ok := pool.clients[addr]
+		if !ok {
+			continue
+		}
+		delete(pool.clients, addr)
+		client.mux.abort()
+		client.streams.abort()
+		err := client.Shutdown()
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("0protocol/1.0 pool endpoint %s shutdown error : %s", addr, err.Error()))
+		}
+		global.Logger().Info(fmt.Sprintf("0protocol/1.0 pool endpoint %s removed", addr))
+	}
+}
+
+func (pool *ZeroKMessagePool) Endpoints() []string {
+	pool.clientsMutex.RLock()
+	defer pool.clientsMutex.RUnlock()
+	endpoints := make([]string, 0, len(pool.clients))
+	for addr := range pool.clients {
+		endpoints = append(endpoints, addr)
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+func (pool *ZeroKMessagePool) Healthy() []string {
+	clients := pool.healthy()
+	endpoints := make([]string, 0, len(clients))
+	for _, client := range clients {
+		endpoints = append(endpoints, client.addr)
+	}
+	return endpoints
+}
+
+func (pool *ZeroKMessagePool) Close() {
+	select {
+	case <-pool.done:
+		return
+	default:
+		close(pool.done)
+	}
+	pool.Remove(pool.Endpoints()...)
+}
+
+func (pool *ZeroKMessagePool) ExecMessage(message *ZeroKMessage, withSecond int) (*ZeroKMessage, error) {
+	client, err := pool.pick()
+	if err != nil {
+		return nil, err
+	}
+	return client.ExecMessage(message, withSecond)
+}
+
+func (pool *ZeroKMessagePool) ExecMessageContext(ctx context.Context, message *ZeroKMessage) (*ZeroKMessage, error) {
+	client, err := pool.pick()
+	if err != nil {
+		return nil, err
+	}
+	return client.ExecMessageContext(ctx, message)
+}
+
+func (pool *ZeroKMessagePool) PushMessage(message *ZeroKMessage) error {
+	client, err := pool.pick()
+	if err != nil {
+		return err
+	}
+	return client.PushMessage(message)
+}
+
+type xPoolClient struct {
+	addr   string
+	client *kZeroKMessageClient
+}
+
+func (pool *ZeroKMessagePool) healthy() []*xPoolClient {
+	pool.clientsMutex.RLock()
+	defer pool.clientsMutex.RUnlock()
+	clients := make([]*xPoolClient, 0, len(pool.clients))
+	for addr, client := range pool.clients {
+		if client.TCPClient.Active() && client.Active() && client.ConnectError() == nil {
+			clients = append(clients, &xPoolClient{addr: addr, client: client})
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].addr < clients[j].addr
+	})
+	return clients
+}
+
+func (pool *ZeroKMessagePool) pick() (*kZeroKMessageClient, error) {
+	clients := pool.healthy()
+	if len(clients) <= 0 {
+		return nil, fmt.Errorf(" kmessage pool has no healthy endpoint ")
+	}
+	if pool.balancer == KMESSAGE_BALANCE_LEAST_INFLIGHT {
+		offset := int(atomic.AddUint64(&pool.cursor, 1) % uint64(len(clients)))
+		picked := clients[offset]
+		for i := 1; i < len(clients); i++ {
+			xClient := clients[(offset+i)%len(clients)]
+			if xClient.client.mux.pending() < picked.client.mux.pending() {
+				picked = xClient
+			}
+		}
+		return picked.client, nil
+	}
+	return clients[int(atomic.AddUint64(&pool.cursor, 1)%uint64(len(clients)))].client, nil
+}
+
+func (pool *ZeroKMessagePool) sync() {
+	endpoints, err := pool.provider.Endpoints()
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("0protocol/1.0 pool load endpoints error : %s", err.Error()))
+		return
+	}
+	latest := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		latest[endpoint] = true
+	}
+	evicts := make([]string, 0)
+	for _, endpoint := range pool.Endpoints() {
+		if !latest[endpoint] {
+			evicts = append(evicts, endpoint)
+		}
+	}
+	pool.Remove(evicts...)
+	pool.Add(endpoints...)
+}
+
+func (pool *ZeroKMessagePool) watch() {
+	for {
+		select {
+		case <-pool.done:
+			return
+		case <-time.After(time.Second * time.Duration(pool.refresh)):
+			pool.sync()
+		}
+	}
+}
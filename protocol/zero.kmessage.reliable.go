@@ -0,0 +1,378 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+)
+
+const (
+	KMESSAGE_RELIABLE_PENDING   = "pending"
+	KMESSAGE_RELIABLE_INFLIGHT  = "inflight"
+	KMESSAGE_RELIABLE_DELIVERED = "delivered"
+	KMESSAGE_RELIABLE_EXPIRED   = "expired"
+	KMESSAGE_RELIABLE_FAILED    = "failed"
+
+	KMESSAGE_RELIABLE_STORE_MEMORY = "memory"
+	KMESSAGE_RELIABLE_STORE_SQLITE = "sqlite"
+
+	xDEFAULT_RELIABLE_TTL            = 86400
+	xDEFAULT_RELIABLE_ACK_TIMEOUT    = 10
+	xDEFAULT_RELIABLE_MAX_ATTEMPTS   = 10
+	xDEFAULT_RELIABLE_RETRY_INTERVAL = 2
+	xDEFAULT_RELIABLE_MAX_RETRY      = 300
+	xRELIABLE_SWEEP_INTERVAL         = 60
+	xRELIABLE_RETENTION              = 86400
+)
+
+var kMessageReliableStore ZeroKMessageReliableStore
+
+func UseKMessageReliableStore(store ZeroKMessageReliableStore) {
+	kMessageReliableStore = store
+}
+
+type ZeroKMessageReliableRecord struct {
+	MessageId   string    `json:"messageId,omitempty"`
+	UniqueKey   string    `json:"uniquekey,omitempty"`
+	MessageType byte      `json:"messageType,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Attempts    int       `json:"attempts"`
+	Reason      string    `json:"reason,omitempty"`
+	CreateTime  time.Time `json:"createTime,omitempty"`
+	ExpireAt    time.Time `json:"expireAt,omitempty"`
+	NextRetryAt time.Time `json:"nextRetryAt,omitempty"`
+	DeliveredAt time.Time `json:"deliveredAt,omitempty"`
+}
+
+func (record *ZeroKMessageReliableRecord) Finished() bool {
+	return record.Status != KMESSAGE_RELIABLE_PENDING && record.Status != KMESSAGE_RELIABLE_INFLIGHT
+}
+
+func (record *ZeroKMessageReliableRecord) expired(now time.Time) bool {
+	return !record.ExpireAt.IsZero() && now.After(record.ExpireAt)
+}
+
+type ZeroKMessageReliableStore interface {
+	Save(*ZeroKMessageReliableRecord) error
+	Find(messageId string) (*ZeroKMessageReliableRecord, error)
+	Pending(uniquekey string) ([]*ZeroKMessageReliableRecord, error)
+	Expire(now time.Time) (int, error)
+}
+
+type kMessageReliableOptions struct {
+	ttl              int
+	ackTimeout       int
+	maxAttempts      int
+	retryInterval    int
+	maxRetryInterval int
+}
+
+func newKMessageReliableOptions() *kMessageReliableOptions {
+	options := &kMessageReliableOptions{
+		ttl:              global.IntValue("zero.kmessage.reliable.ttl"),
+		ackTimeout:       global.IntValue("zero.kmessage.reliable.ackTimeout"),
+		maxAttempts:      global.IntValue("zero.kmessage.reliable.maxAttempts"),
+		retryInterval:    global.IntValue("zero.kmessage.reliable.retryInterval"),
+		maxRetryInterval: global.IntValue("zero.kmessage.reliable.maxRetryInterval"),
+	}
+	if options.ttl <= 0 {
+		options.ttl = xDEFAULT_RELIABLE_TTL
+	}
+	if options.ackTimeout <= 0 {
+		options.ackTimeout = xDEFAULT_RELIABLE_ACK_TIMEOUT
+	}
+	if options.maxAttempts <= 0 {
+		options.maxAttempts = xDEFAULT_RELIABLE_MAX_ATTEMPTS
+	}
+	if options.retryInterval <= 0 {
+		options.retryInterval = xDEFAULT_RELIABLE_RETRY_INTERVAL
+	}
+	if options.maxRetryInterval <= 0 {
+		options.maxRetryInterval = xDEFAULT_RELIABLE_MAX_RETRY
+	}
+	return options
+}
+
+func (options *kMessageReliableOptions) backoff(attempts int) time.Duration {
+	interval := options.retryInterval
+	for i := 1; i < attempts && interval < options.maxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > options.maxRetryInterval {
+		interval = options.maxRetryInterval
+	}
+	return time.Second * time.Duration(interval)
+}
+
+func newKMessageReliableStore() ZeroKMessageReliableStore {
+	if kMessageReliableStore != nil {
+		return kMessageReliableStore
+	}
+	if strings.ToLower(global.StringValue("zero.kmessage.reliable.store")) == KMESSAGE_RELIABLE_STORE_SQLITE {
+		store, err := NewKMessageSQLiteStore()
+		if err != nil {
+			panic(err)
+		}
+		return store
+	}
+	return NewKMessageMemoryStore()
+}
+
+type xReliableWorker struct {
+	conn *kZeroKMessageConnect
+	kick chan struct{}
+	done chan struct{}
+}
+
+type kZeroKMessageReliable struct {
+	store   ZeroKMessageReliableStore
+	options *kMessageReliableOptions
+
+	workers      map[string]*xReliableWorker
+	legacies     map[string]*kZeroKMessageConnect
+	workersMutex sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newKMessageReliable() *kZeroKMessageReliable {
+	reliable := &kZeroKMessageReliable{
+		store:    newKMessageReliableStore(),
+		options:  newKMessageReliableOptions(),
+		workers:  make(map[string]*xReliableWorker),
+		legacies: make(map[string]*kZeroKMessageConnect),
+		done:     make(chan struct{}),
+	}
+	go reliable.sweep()
+	return reliable
+}
+
+func (reliable *kZeroKMessageReliable) push(uniquekey string, message *ZeroKMessage, ttlSeconds int) error {
+	if len(uniquekey) <= 0 {
+		return fmt.Errorf(" reliable push without uniquekey ")
+	}
+	reliable.workersMutex.Lock()
+	legacy, ok := reliable.legacies[uniquekey]
+	reliable.workersMutex.Unlock()
+	if ok {
+		return fmt.Errorf(" reliable push to `%s` connected with version %d, ack request needs version %d ", uniquekey, legacy.version, KMESSAGE_VERSION_2)
+	}
+	record, err := reliable.store.Find(message.MessageId())
+	if err != nil {
+		return err
+	}
+	if record != nil {
+		return fmt.Errorf(" reliable message %s already exists ", message.MessageId())
+	}
+	if ttlSeconds <= 0 {
+		ttlSeconds = reliable.options.ttl
+	}
+	now := time.Now()
+	err = reliable.store.Save(&ZeroKMessageReliableRecord{
+		MessageId:   message.MessageId(),
+		UniqueKey:   uniquekey,
+		MessageType: byte(message.MessageType()),
+		Body:        append([]byte{}, message.MessageBody()...),
+		Status:      KMESSAGE_RELIABLE_PENDING,
+		CreateTime:  now,
+		ExpireAt:    now.Add(time.Second * time.Duration(ttlSeconds)),
+	})
+	if err != nil {
+		return err
+	}
+	reliable.notify(uniquekey)
+	return nil
+}
+
+func (reliable *kZeroKMessageReliable) status(messageId string) (*ZeroKMessageReliableRecord, error) {
+	record, err := reliable.store.Find(messageId)
+	if err != nil || record == nil {
+		return record, err
+	}
+	if !record.Finished() && record.expired(time.Now()) {
+		record.Status = KMESSAGE_RELIABLE_EXPIRED
+		err = reliable.store.Save(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func (reliable *kZeroKMessageReliable) notify(uniquekey string) {
+	reliable.workersMutex.Lock()
+	worker, ok := reliable.workers[uniquekey]
+	reliable.workersMutex.Unlock()
+	if ok {
+		select {
+		case worker.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (reliable *kZeroKMessageReliable) online(conn *kZeroKMessageConnect) {
+	if len(conn.UniqueKey()) <= 0 {
+		return
+	}
+	reliable.workersMutex.Lock()
+	defer reliable.workersMutex.Unlock()
+	if xWorker, ok := reliable.workers[conn.UniqueKey()]; ok {
+		delete(reliable.workers, conn.UniqueKey())
+		close(xWorker.done)
+	}
+	if conn.version < KMESSAGE_VERSION_2 {
+		global.Logger().Warn(fmt.Sprintf("zerov1 reliable `%s` connected with version %d, ack request needs version %d", conn.UniqueKey(), conn.version, KMESSAGE_VERSION_2))
+		reliable.legacies[conn.UniqueKey()] = conn
+		return
+	}
+	delete(reliable.legacies, conn.UniqueKey())
+	select {
+	case <-reliable.done:
+		return
+	default:
+	}
+	worker := &xReliableWorker{
+		conn: conn,
+		kick: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	reliable.workers[conn.UniqueKey()] = worker
+	go reliable.run(worker)
+}
+
+func (reliable *kZeroKMessageReliable) offline(conn *kZeroKMessageConnect) {
+	reliable.workersMutex.Lock()
+	defer reliable.workersMutex.Unlock()
+	if legacy, ok := reliable.legacies[conn.UniqueKey()]; ok && legacy == conn {
+		delete(reliable.legacies, conn.UniqueKey())
+	}
+	if worker, ok := reliable.workers[conn.UniqueKey()]; ok && worker.conn == conn {
+		delete(reliable.workers, conn.UniqueKey())
+		close(worker.done)
+	}
+}
+
+func (reliable *kZeroKMessageReliable) run(worker *xReliableWorker) {
+	eager := true
+	for {
+		wait := reliable.flush(worker, eager)
+		eager = false
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-worker.kick:
+		case <-timer:
+		case <-worker.done:
+			return
+		}
+	}
+}
+
+func (reliable *kZeroKMessageReliable) flush(worker *xReliableWorker, eager bool) time.Duration {
+	records, err := reliable.store.Pending(worker.conn.UniqueKey())
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("zerov1 reliable `%s` load pending error : %s", worker.conn.UniqueKey(), err.Error()))
+		return time.Second * time.Duration(reliable.options.retryInterval)
+	}
+	for _, record := range records {
+		select {
+		case <-worker.done:
+			return 0
+		default:
+		}
+
+		now := time.Now()
+		if record.expired(now) {
+			record.Status = KMESSAGE_RELIABLE_EXPIRED
+			reliable.save(record)
+			continue
+		}
+		if !eager && record.NextRetryAt.After(now) {
+			return record.NextRetryAt.Sub(now)
+		}
+
+		record.Status = KMESSAGE_RELIABLE_INFLIGHT
+		record.Attempts++
+		reliable.save(record)
+		err := reliable.deliver(worker.conn, record)
+		if err == nil {
+			record.Status = KMESSAGE_RELIABLE_DELIVERED
+			record.Reason = ""
+			record.DeliveredAt = time.Now()
+			reliable.save(record)
+			continue
+		}
+
+		record.Reason = err.Error()
+		if record.Attempts >= reliable.options.maxAttempts {
+			record.Status = KMESSAGE_RELIABLE_FAILED
+			reliable.save(record)
+			global.Logger().Warn(fmt.Sprintf("zerov1 reliable message %s to `%s` failed after %d attempts : %s", record.MessageId, record.UniqueKey, record.Attempts, err.Error()))
+			continue
+		}
+		backoff := reliable.options.backoff(record.Attempts)
+		record.Status = KMESSAGE_RELIABLE_PENDING
+		record.NextRetryAt = time.Now().Add(backoff)
+		reliable.save(record)
+		return backoff
+	}
+	return 0
+}
+
+func (reliable *kZeroKMessageReliable) deliver(conn *kZeroKMessageConnect, record *ZeroKMessageReliableRecord) error {
+	message := NewAckKMessage(record.MessageType, record.MessageId, record.Body)
+	message.AddUniqueKey(record.UniqueKey)
+	message.useFlags(KMESSAGE_FLAG_ACK_REQ)
+	err := message.Complete()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(reliable.options.ackTimeout))
+	defer cancel()
+	_, err = conn.execMessageContext(ctx, message)
+	return err
+}
+
+func (reliable *kZeroKMessageReliable) save(record *ZeroKMessageReliableRecord) {
+	err := reliable.store.Save(record)
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("zerov1 reliable message %s save error : %s", record.MessageId, err.Error()))
+	}
+}
+
+func (reliable *kZeroKMessageReliable) close() {
+	reliable.closeOnce.Do(func() {
+		close(reliable.done)
+		reliable.workersMutex.Lock()
+		defer reliable.workersMutex.Unlock()
+		for uniquekey, worker := range reliable.workers {
+			delete(reliable.workers, uniquekey)
+			close(worker.done)
+		}
+	})
+}
+
+func (reliable *kZeroKMessageReliable) sweep() {
+	for {
+		select {
+		case <-time.After(time.Second * xRELIABLE_SWEEP_INTERVAL):
+		case <-reliable.done:
+			return
+		}
+		expired, err := reliable.store.Expire(time.Now())
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("zerov1 reliable expire error : %s", err.Error()))
+		} else if expired > 0 {
+			global.Logger().Info(fmt.Sprintf("zerov1 reliable %d messages expired", expired))
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKMessageMemoryStore(t *testing.T) {
+	store := NewKMessageMemoryStore()
+	now := time.Now()
+	records := []*ZeroKMessageReliableRecord{
+		{MessageId: "m1", UniqueKey: "d1", Status: KMESSAGE_RELIABLE_PENDING, ExpireAt: now.Add(time.Hour)},
+		{MessageId: "m2", UniqueKey: "d1", Status: KMESSAGE_RELIABLE_PENDING, ExpireAt: now.Add(-time.Second)},
+		{MessageId: "m3", UniqueKey: "d1", Status: KMESSAGE_RELIABLE_PENDING, ExpireAt: now.Add(time.Hour)},
+		{MessageId: "m4", UniqueKey: "d2", Status: KMESSAGE_RELIABLE_DELIVERED, ExpireAt: now.Add(-time.Second * (xRELIABLE_RETENTION + 1))},
+	}
+	for _, record := range records {
+		if err := store.Save(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records[0].Status = KMESSAGE_RELIABLE_FAILED
+	record, _ := store.Find("m1")
+	if record.Status != KMESSAGE_RELIABLE_PENDING {
+		t.Fatal("store shares record with caller")
+	}
+	record.Status = KMESSAGE_RELIABLE_DELIVERED
+	store.Save(record)
+
+	pending, _ := store.Pending("d1")
+	if len(pending) != 2 || pending[0].MessageId != "m2" || pending[1].MessageId != "m3" {
+		t.Fatalf("pending %v", pending)
+	}
+
+	expired, _ := store.Expire(now)
+	if expired != 1 {
+		t.Fatalf("expired %d", expired)
+	}
+	if record, _ := store.Find("m2"); record.Status != KMESSAGE_RELIABLE_EXPIRED {
+		t.Fatalf("m2 status %s", record.Status)
+	}
+	if record, _ := store.Find("m4"); record != nil {
+		t.Fatal("finished record kept beyond retention")
+	}
+	if record, _ := store.Find("m1"); record == nil {
+		t.Fatal("finished record dropped within retention")
+	}
+	pending, _ = store.Pending("d1")
+	if len(pending) != 1 || pending[0].MessageId != "m3" {
+		t.Fatalf("pending after expire %v", pending)
+	}
+}
+
+func TestKMessageReliableBackoff(t *testing.T) {
+	options := &kMessageReliableOptions{retryInterval: 2, maxRetryInterval: 10}
+	for attempts, backoff := range []int{2, 2, 4, 8, 10, 10} {
+		if options.backoff(attempts) != time.Second*time.Duration(backoff) {
+			t.Fatalf("attempts %d backoff %s", attempts, options.backoff(attempts))
+		}
+	}
+}
+
+func waitKMessageReliable(t *testing.T, keeper *kZeroKMessageKeeper, messageId string, status string) *ZeroKMessageReliableRecord {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		record, err := keeper.ReliableStatus(messageId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record != nil && record.Status == status {
+			return record
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reliable %s status %v expect %s", messageId, record, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func connectTestKMessagePeer(t *testing.T, keeper *kZeroKMessageKeeper, uniquekey string, version byte) *xTestKMessagePeer {
+	t.Helper()
+	peer := newTestKMessagePeer(keeper)
+	connect := testConnectMessage(t, uniquekey, nil)
+	connect.advertise(version)
+	peer.send(t, connect)
+	peer.next(t, MESSAGE_TYPE_CONNACK)
+	if peer.conn.version != version {
+		t.Fatalf("negotiated version %d expect %d", peer.conn.version, version)
+	}
+	return peer
+}
+
+func TestKMessageReliableDeliver(t *testing.T) {
+	keeper := newTestKMessageKeeper(nil)
+	defer keeper.reliable.close()
+
+	message, _ := NewKMessage(0x30, []byte("reliable"))
+	err := keeper.PushReliable("device1", message, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := waitKMessageReliable(t, keeper, message.MessageId(), KMESSAGE_RELIABLE_PENDING); record.Attempts != 0 {
+		t.Fatalf("offline attempts %d", record.Attempts)
+	}
+	if err := keeper.PushReliable("device1", message, 60); err == nil {
+		t.Fatal("duplicate reliable message accepted")
+	}
+
+	peer := connectTestKMessagePeer(t, keeper, "device1", KMESSAGE_VERSION_2)
+	delivery := peer.next(t, 0x30)
+	if delivery.MessageId() != message.MessageId() || delivery.Flags()&KMESSAGE_FLAG_ACK_REQ == 0 || string(delivery.MessageBody()) != "reliable" {
+		t.Fatalf("delivery %s", delivery.String())
+	}
+	peer.send(t, NewAckKMessage(MESSAGE_TYPE_PUBACK, delivery.MessageId(), make([]byte, 0)))
+	record := waitKMessageReliable(t, keeper, message.MessageId(), KMESSAGE_RELIABLE_DELIVERED)
+	if record.Attempts != 1 || record.DeliveredAt.IsZero() {
+		t.Fatalf("delivered record %v", record)
+	}
+	peer.conn.Close()
+}
+
+func TestKMessageReliableLegacy(t *testing.T) {
+	keeper := newTestKMessageKeeper(nil)
+	defer keeper.reliable.close()
+
+	peer := connectTestKMessagePeer(t, keeper, "legacy1", KMESSAGE_VERSION_1)
+	message, _ := NewKMessage(0x30, []byte("reliable"))
+	if err := keeper.PushReliable("legacy1", message, 60); err == nil {
+		t.Fatal("reliable push to v1 connect accepted")
+	}
+	if record, _ := keeper.ReliableStatus(message.MessageId()); record != nil {
+		t.Fatal("reliable record saved for v1 connect")
+	}
+
+	peer.conn.Close()
+	if err := keeper.PushReliable("legacy1", message, 60); err != nil {
+		t.Fatalf("reliable push after v1 offline %s", err)
+	}
+}
+
+func TestKMessageReliableClose(t *testing.T) {
+	keeper := newTestKMessageKeeper(nil)
+	peer := connectTestKMessagePeer(t, keeper, "device2", KMESSAGE_VERSION_2)
+	keeper.reliable.workersMutex.Lock()
+	worker := keeper.reliable.workers["device2"]
+	keeper.reliable.workersMutex.Unlock()
+	if worker == nil {
+		t.Fatal("reliable worker not started")
+	}
+
+	err := keeper.Shutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keeper.reliable.close()
+	select {
+	case <-worker.done:
+	default:
+		t.Fatal("reliable worker not stopped")
+	}
+	select {
+	case <-keeper.reliable.done:
+	default:
+		t.Fatal("reliable sweep not stopped")
+	}
+
+	keeper.reliable.online(peer.conn)
+	if len(keeper.reliable.workers) != 0 {
+		t.Fatal("reliable worker started after close")
+	}
+	peer.conn.Close()
+}
@@ -0,0 +1,401 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/0meet1/zero-framework/errdef"
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/server"
+)
+
+const (
+	KMESSAGE_RPC_INBOUND  = "inbound"
+	KMESSAGE_RPC_OUTBOUND = "outbound"
+
+	xRPC_STATUS_OK    = 0x00
+	xRPC_STATUS_ERROR = 0x01
+)
+
+var (
+	kRPCContextType = reflect.TypeOf(&ZeroKMessageRPCContext{})
+	kErrorType      = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type ZeroKMessageCodec interface {
+	Marshal(interface{}) ([]byte, error)
+	Unmarshal([]byte, interface{}) error
+}
+
+type ZeroKMessageJSONCodec struct{}
+
+func (*ZeroKMessageJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (*ZeroKMessageJSONCodec) Unmarshal(datas []byte, v interface{}) error {
+	return json.Unmarshal(datas, v)
+}
+
+type ZeroKMessageRPCContext struct {
+	Context     context.Context
+	Direction   string
+	RegisterId  string
+	Conn        server.ZeroConnect
+	Client      ZeroKMessageClient
+	Method      string
+	MessageType byte
+	Message     *ZeroKMessage
+	Request     interface{}
+	Response    interface{}
+	Values      map[string]interface{}
+}
+
+type ZeroKMessageRPCHandler func(*ZeroKMessageRPCContext) error
+type ZeroKMessageRPCMiddleware func(*ZeroKMessageRPCContext, ZeroKMessageRPCHandler) error
+
+type xRPCHandler struct {
+	handler reflect.Value
+	reqType reflect.Type
+}
+
+func newRPCHandler(handler interface{}) (*xRPCHandler, error) {
+	xHandler := reflect.ValueOf(handler)
+	xType := xHandler.Type()
+	if xType.Kind() != reflect.Func ||
+		xType.NumIn() != 2 || xType.In(0) != kRPCContextType ||
+		xType.NumOut() != 2 || xType.Out(1) != kErrorType {
+		return nil, fmt.Errorf("rpc handler must be func(*ZeroKMessageRPCContext, Request) (Response, error), got %s", xType)
+	}
+	return &xRPCHandler{handler: xHandler, reqType: xType.In(1)}, nil
+}
+
+func (xHandler *xRPCHandler) newRequest() (reflect.Value, interface{}) {
+	if xHandler.reqType.Kind() == reflect.Pointer {
+		req := reflect.New(xHandler.reqType.Elem())
+		return req, req.Interface()
+	}
+	req := reflect.New(xHandler.reqType)
+	return req.Elem(), req.Interface()
+}
+
+func (xHandler *xRPCHandler) call(rpcctx *ZeroKMessageRPCContext, req reflect.Value) (interface{}, error) {
+	outs := xHandler.handler.Call([]reflect.Value{reflect.ValueOf(rpcctx), req})
+	if !outs[1].IsNil() {
+		return nil, outs[1].Interface().(error)
+	}
+	return outs[0].Interface(), nil
+}
+
+type ZeroKMessageRPC struct {
+	codec       ZeroKMessageCodec
+	operator    ZeroKMessageOperator
+	middlewares []ZeroKMessageRPCMiddleware
+
+	methods      map[string]*xRPCHandler
+	types        map[byte]*xRPCHandler
+	handlesMutex sync.RWMutex
+}
+
+func NewKMessageRPC() *ZeroKMessageRPC {
+	return &ZeroKMessageRPC{
+		codec:       &ZeroKMessageJSONCodec{},
+		middlewares: make([]ZeroKMessageRPCMiddleware, 0),
+		methods:     make(map[string]*xRPCHandler),
+		types:       make(map[byte]*xRPCHandler),
+	}
+}
+
+func (rpc *ZeroKMessageRPC) UseCodec(codec ZeroKMessageCodec) *ZeroKMessageRPC {
+	rpc.codec = codec
+	return rpc
+}
+
+func (rpc *ZeroKMessageRPC) UseOperator(operator ZeroKMessageOperator) *ZeroKMessageRPC {
+	rpc.operator = operator
+	return rpc
+}
+
+func (rpc *ZeroKMessageRPC) UseMiddlewares(middlewares ...ZeroKMessageRPCMiddleware) *ZeroKMessageRPC {
+	rpc.middlewares = append(rpc.middlewares, middlewares...)
+	return rpc
+}
+
+func (rpc *ZeroKMessageRPC) AddMethod(method string, handler interface{}) error {
+	if len(method) <= 0 || len(method) > 0xFFFF {
+		return fmt.Errorf("rpc method `%s` invalid", method)
+	}
+	xHandler, err := newRPCHandler(handler)
+	if err != nil {
+		return err
+	}
+	rpc.handlesMutex.Lock()
+	defer rpc.handlesMutex.Unlock()
+	rpc.methods[method] = xHandler
+	return nil
+}
+
+func (rpc *ZeroKMessageRPC) AddMessageType(messageType byte, handler interface{}) error {
+	switch messageType {
+	case MESSAGE_TYPE_CONNECT, MESSAGE_TYPE_HEARTBEAT, MESSAGE_TYPE_AUTH, MESSAGE_TYPE_RPC,
+		MESSAGE_TYPE_CONNACK, MESSAGE_TYPE_BEATACK, MESSAGE_TYPE_CHALLENGE, MESSAGE_TYPE_RPCACK, MESSAGE_TYPE_PUBACK,
+		MESSAGE_TYPE_FILE, MESSAGE_TYPE_FILEACK, MESSAGE_TYPE_TOPIC, MESSAGE_TYPE_TOPICACK:
+		return fmt.Errorf("rpc message type 0x%02x is reserved", messageType)
+	}
+	xHandler, err := newRPCHandler(handler)
+	if err != nil {
+		return err
+	}
+	rpc.handlesMutex.Lock()
+	defer rpc.handlesMutex.Unlock()
+	rpc.types[messageType] = xHandler
+	return nil
+}
+
+func (rpc *ZeroKMessageRPC) chain(final ZeroKMessageRPCHandler) ZeroKMessageRPCHandler {
+	handler := final
+	for i := len(rpc.middlewares) - 1; i >= 0; i-- {
+		middleware, next := rpc.middlewares[i], handler
+		handler = func(rpcctx *ZeroKMessageRPCContext) error {
+			return middleware(rpcctx, next)
+		}
+	}
+	return handler
+}
+
+func (rpc *ZeroKMessageRPC) route(message *ZeroKMessage) (string, []byte, *xRPCHandler, bool) {
+	rpc.handlesMutex.RLock()
+	defer rpc.handlesMutex.RUnlock()
+	body := message.MessageBody()
+	if message.MessageType() == MESSAGE_TYPE_RPC {
+		if len(body) < 2 || len(body) < 2+int(binary.BigEndian.Uint16(body[:2])) {
+			return "", nil, nil, true
+		}
+		methodLen := int(binary.BigEndian.Uint16(body[:2]))
+		method := string(body[2 : 2+methodLen])
+		return method, body[2+methodLen:], rpc.methods[method], true
+	}
+	xHandler, ok := rpc.types[byte(message.MessageType())]
+	return "", body, xHandler, ok
+}
+
+func (rpc *ZeroKMessageRPC) Operation(conn server.ZeroConnect, message *ZeroKMessage) (bool, error) {
+	method, payload, xHandler, ok := rpc.route(message)
+	if !ok {
+		if rpc.operator != nil {
+			return rpc.operator.Operation(conn, message)
+		}
+		return false, nil
+	}
+	go rpc.serve(conn, nil, message, method, payload, xHandler)
+	return true, nil
+}
+
+func (rpc *ZeroKMessageRPC) ClientOperation(client ZeroKMessageClient, message *ZeroKMessage) (bool, error) {
+	method, payload, xHandler, ok := rpc.route(message)
+	if !ok {
+		if clientOperator, ok := rpc.operator.(ZeroKMessageClientOperator); ok {
+			return clientOperator.ClientOperation(client, message)
+		}
+		if rpc.operator != nil {
+			return rpc.operator.Operation(nil, message)
+		}
+		return false, nil
+	}
+	go rpc.serve(nil, client, message, method, payload, xHandler)
+	return true, nil
+}
+
+func (rpc *ZeroKMessageRPC) serve(conn server.ZeroConnect, client ZeroKMessageClient, message *ZeroKMessage, method string, payload []byte, xHandler *xRPCHandler) {
+	rpcctx := &ZeroKMessageRPCContext{
+		Context:     context.Background(),
+		Direction:   KMESSAGE_RPC_INBOUND,
+		Conn:        conn,
+		Client:      client,
+		Method:      method,
+		MessageType: byte(message.MessageType()),
+		Message:     message,
+		Values:      make(map[string]interface{}),
+	}
+	if conn != nil {
+		rpcctx.RegisterId = conn.RegisterId()
+	}
+
+	err := func() (err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				err = &errdef.ZeroExceptionDef{Code: errdef.ES00500, Description: fmt.Sprintf("%v", rec)}
+			}
+		}()
+		if xHandler == nil {
+			return &errdef.ZeroExceptionDef{Code: errdef.ES00404, Description: fmt.Sprintf("rpc method `%s` type 0x%02x not found", method, message.MessageType())}
+		}
+		req, reqPtr := xHandler.newRequest()
+		if len(payload) > 0 {
+			err := rpc.codec.Unmarshal(payload, reqPtr)
+			if err != nil {
+				return &errdef.ZeroExceptionDef{Code: errdef.ES00400, Description: err.Error()}
+			}
+		}
+		rpcctx.Request = req.Interface()
+		return rpc.chain(func(rpcctx *ZeroKMessageRPCContext) error {
+			resp, err := xHandler.call(rpcctx, reflect.ValueOf(rpcctx.Request))
+			if err != nil {
+				return err
+			}
+			rpcctx.Response = resp
+			return nil
+		})(rpcctx)
+	}()
+
+	err = rpc.reply(conn, client, message, rpcctx.Response, err)
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("0protocol/1.0 rpc `%s` type 0x%02x reply error : %s", method, message.MessageType(), err.Error()))
+	}
+}
+
+func (rpc *ZeroKMessageRPC) reply(conn server.ZeroConnect, client ZeroKMessageClient, message *ZeroKMessage, resp interface{}, reason error) error {
+	status := byte(xRPC_STATUS_OK)
+	var payload []byte
+	var err error
+	if reason != nil {
+		status = xRPC_STATUS_ERROR
+		exception := &errdef.ZeroExceptionDef{Code: errdef.ES00500, Description: reason.Error()}
+		if errdef.Is(reason) {
+			xException := errdef.Parse(reason)
+			exception = &errdef.ZeroExceptionDef{Code: xException.Code, Description: xException.Description, Parameters: xException.Parameters}
+		}
+		payload, err = rpc.codec.Marshal(exception)
+	} else if resp != nil {
+		payload, err = rpc.codec.Marshal(resp)
+	}
+	if err != nil {
+		return err
+	}
+
+	ackMessage := NewAckKMessage(MESSAGE_TYPE_RPCACK, message.MessageId(), append([]byte{status}, payload...))
+	ackMessage.AddUniqueKey(message.UniqueKey())
+	err = ackMessage.Complete()
+	if err != nil {
+		return err
+	}
+	if kconn, ok := conn.(*kZeroKMessageConnect); ok {
+		return kconn.pushMessage(ackMessage)
+	}
+	if conn != nil {
+		return conn.Write(ackMessage.Bytes())
+	}
+	if client == nil {
+		xClient, ok := global.Value(ZEROKMSG_CLIENT).(ZeroKMessageClient)
+		if !ok {
+			return errors.New(" kmessage client not running ")
+		}
+		client = xClient
+	}
+	return client.PushMessage(ackMessage)
+}
+
+func (rpc *ZeroKMessageRPC) call(rpcctx *ZeroKMessageRPCContext, exec func(context.Context, *ZeroKMessage) (*ZeroKMessage, error)) error {
+	return rpc.chain(func(rpcctx *ZeroKMessageRPCContext) error {
+		payload := make([]byte, 0)
+		if rpcctx.Request != nil {
+			xPayload, err := rpc.codec.Marshal(rpcctx.Request)
+			if err != nil {
+				return err
+			}
+			payload = xPayload
+		}
+
+		body := payload
+		if rpcctx.MessageType == MESSAGE_TYPE_RPC {
+			body = binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(rpcctx.Method)+len(payload)), uint16(len(rpcctx.Method)))
+			body = append(append(body, rpcctx.Method...), payload...)
+		}
+		message, err := NewKMessage(rpcctx.MessageType, body)
+		if err != nil {
+			return err
+		}
+		err = message.Complete()
+		if err != nil {
+			return err
+		}
+		rpcctx.Message = message
+
+		resp, err := exec(rpcctx.Context, message)
+		if err != nil {
+			return err
+		}
+		if resp.MessageType() != MESSAGE_TYPE_RPCACK || len(resp.MessageBody()) <= 0 {
+			return fmt.Errorf(" rpc unexpected response type 0x%02x ", resp.MessageType())
+		}
+		status, respPayload := resp.MessageBody()[0], resp.MessageBody()[1:]
+		if status != xRPC_STATUS_OK {
+			exception := &errdef.ZeroExceptionDef{}
+			err = rpc.codec.Unmarshal(respPayload, exception)
+			if err != nil {
+				return err
+			}
+			return exception
+		}
+		if rpcctx.Response != nil && len(respPayload) > 0 {
+			return rpc.codec.Unmarshal(respPayload, rpcctx.Response)
+		}
+		return nil
+	})(rpcctx)
+}
+
+func (rpc *ZeroKMessageRPC) clientCall(ctx context.Context, method string, messageType byte, req interface{}, resp interface{}) error {
+	client, ok := global.Value(ZEROKMSG_CLIENT).(ZeroKMessageClient)
+	if !ok {
+		return errors.New(" kmessage client not running ")
+	}
+	return rpc.call(&ZeroKMessageRPCContext{
+		Context:     ctx,
+		Direction:   KMESSAGE_RPC_OUTBOUND,
+		Method:      method,
+		MessageType: messageType,
+		Request:     req,
+		Response:    resp,
+		Values:      make(map[string]interface{}),
+	}, client.ExecMessageContext)
+}
+
+func (rpc *ZeroKMessageRPC) connectCall(ctx context.Context, registerId string, method string, messageType byte, req interface{}, resp interface{}) error {
+	keeper, ok := global.Value(ZEROKMSG_SERVER).(ZeroKMessageServer)
+	if !ok {
+		return errors.New(" kmessage server not running ")
+	}
+	return rpc.call(&ZeroKMessageRPCContext{
+		Context:     ctx,
+		Direction:   KMESSAGE_RPC_OUTBOUND,
+		RegisterId:  registerId,
+		Method:      method,
+		MessageType: messageType,
+		Request:     req,
+		Response:    resp,
+		Values:      make(map[string]interface{}),
+	}, func(ctx context.Context, message *ZeroKMessage) (*ZeroKMessage, error) {
+		return keeper.ExecMessageContext(ctx, registerId, message)
+	})
+}
+
+func (rpc *ZeroKMessageRPC) Call(ctx context.Context, method string, req interface{}, resp interface{}) error {
+	return rpc.clientCall(ctx, method, MESSAGE_TYPE_RPC, req, resp)
+}
+
+func (rpc *ZeroKMessageRPC) CallType(ctx context.Context, messageType byte, req interface{}, resp interface{}) error {
+	return rpc.clientCall(ctx, "", messageType, req, resp)
+}
+
+func (rpc *ZeroKMessageRPC) CallConnect(ctx context.Context, registerId string, method string, req interface{}, resp interface{}) error {
+	return rpc.connectCall(ctx, registerId, method, MESSAGE_TYPE_RPC, req, resp)
+}
+
+func (rpc *ZeroKMessageRPC) CallConnectType(ctx context.Context, registerId string, messageType byte, req interface{}, resp interface{}) error {
+	return rpc.connectCall(ctx, registerId, "", messageType, req, resp)
+}
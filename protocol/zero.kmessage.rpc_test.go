@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/server"
+)
+
+type xTestKMessageClient struct {
+	ZeroKMessageClient
+	pushed chan *ZeroKMessage
+}
+
+func (client *xTestKMessageClient) PushMessage(message *ZeroKMessage) error {
+	client.pushed <- message
+	return nil
+}
+
+func newTestKMessageClient() *xTestKMessageClient {
+	return &xTestKMessageClient{pushed: make(chan *ZeroKMessage, 4)}
+}
+
+type xTestEcho struct {
+	Text string `json:"text"`
+}
+
+func testRPCMessage(t *testing.T, method string, req interface{}) *ZeroKMessage {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := binary.BigEndian.AppendUint16(make([]byte, 0), uint16(len(method)))
+	body = append(append(body, method...), payload...)
+	message, err := NewKMessage(MESSAGE_TYPE_RPC, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = message.Complete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestKMessageRPCClientReply(t *testing.T) {
+	global.Key(ZEROKMSG_CLIENT, newTestKMessageClient())
+	xGlobal := global.Value(ZEROKMSG_CLIENT).(*xTestKMessageClient)
+
+	rpc := NewKMessageRPC()
+	err := rpc.AddMethod("echo", func(rpcctx *ZeroKMessageRPCContext, req *xTestEcho) (*xTestEcho, error) {
+		if rpcctx.Client == nil {
+			t.Error("rpc context without receiving client")
+		}
+		return req, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := []*xTestKMessageClient{newTestKMessageClient(), newTestKMessageClient()}
+	for i, client := range clients {
+		message := testRPCMessage(t, "echo", &xTestEcho{Text: string(rune('a' + i))})
+		ok, err := rpc.ClientOperation(client, message)
+		if !ok || err != nil {
+			t.Fatalf("client %d operation %v %v", i, ok, err)
+		}
+
+		select {
+		case ack := <-client.pushed:
+			if ack.MessageType() != MESSAGE_TYPE_RPCACK || ack.MessageId() != message.MessageId() {
+				t.Fatalf("client %d ack type 0x%02x id %s", i, ack.MessageType(), ack.MessageId())
+			}
+			resp := &xTestEcho{}
+			err = json.Unmarshal(ack.MessageBody()[1:], resp)
+			if ack.MessageBody()[0] != xRPC_STATUS_OK || err != nil || resp.Text != string(rune('a'+i)) {
+				t.Fatalf("client %d ack body %q", i, ack.MessageBody())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("client %d no reply", i)
+		}
+	}
+
+	select {
+	case ack := <-xGlobal.pushed:
+		t.Fatalf("reply %s went through global client", ack.MessageId())
+	case <-time.After(50 * time.Millisecond):
+	}
+	for i, client := range clients {
+		if len(client.pushed) != 0 {
+			t.Fatalf("client %d extra replies %d", i, len(client.pushed))
+		}
+	}
+}
+
+func TestKMessageRPCClientDelegate(t *testing.T) {
+	message, err := NewKMessage(MESSAGE_TYPE_TOPIC, []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delegate := &xTestClientOperator{}
+	rpc := NewKMessageRPC().UseOperator(delegate)
+	client := newTestKMessageClient()
+	ok, err := rpc.ClientOperation(client, message)
+	if !ok || err != nil || delegate.client != client {
+		t.Fatalf("delegate %v %v %v", ok, err, delegate.client)
+	}
+}
+
+type xTestClientOperator struct {
+	client ZeroKMessageClient
+}
+
+func (operator *xTestClientOperator) Operation(server.ZeroConnect, *ZeroKMessage) (bool, error) {
+	return false, nil
+}
+
+func (operator *xTestClientOperator) ClientOperation(client ZeroKMessageClient, message *ZeroKMessage) (bool, error) {
+	operator.client = client
+	return true, nil
+}
@@ -1,9 +1,13 @@
 package protocol
 
 import (
-	"errors"
+	"context"
+	"crypto/hmac"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,15 +17,33 @@ import (
 )
 
 const (
+	KMESSAGE_DUPLICATE_KICK   = "kick"
+	KMESSAGE_DUPLICATE_REJECT = "reject"
+
 	xDEFAULT_AUTH_WAIT   = 10
-	xDEFAULT_BUFFER_SIZE = 8 * 1024 * 1024
+	xDEFAULT_BUFFER_SIZE = 64 * 1024
 )
 
+func kMessageDuplicatePolicy() string {
+	if strings.ToLower(global.StringValue("zero.kmessage.duplicate")) == KMESSAGE_DUPLICATE_REJECT {
+		return KMESSAGE_DUPLICATE_REJECT
+	}
+	return KMESSAGE_DUPLICATE_KICK
+}
+
 type xZeroKMessageConnectBuilder struct{}
 
 func (xDefault *xZeroKMessageConnectBuilder) NewConnect() server.ZeroConnect {
+	return newKMessageConnect(global.Value(ZEROKMSG_SERVER).(*kZeroKMessageKeeper))
+}
+
+func newKMessageConnect(keeper *kZeroKMessageKeeper) *kZeroKMessageConnect {
 	tcpconn := &kZeroKMessageConnect{
-		keeper: global.Value(ZEROKMSG_SERVER).(*kZeroKMessageKeeper),
+		keeper:  keeper,
+		mux:     newKMessageMux(keeper.maxInflights),
+		version: KMESSAGE_VERSION_1,
+		streams: newKMessageStreams(keeper.options),
+		files:   newKMessageFiles(keeper.fileOptions),
 	}
 	tcpconn.ThisDef(tcpconn)
 	tcpconn.AddChecker(&kZeroKMessageChecker{})
@@ -56,7 +78,7 @@ func (checker *kZeroKMessageChecker) unpacking(registerId string, historys ...[]
 		if checker.cachebytes == nil {
 			return comps
 		} else {
-			return checker.unpacking(registerId, historys...)
+			return checker.unpacking(registerId, comps...)
 		}
 	}
 	return comps
@@ -72,7 +94,7 @@ func (checker *kZeroKMessageChecker) CheckPackageData(registerId string, data []
 		}
 	}()
 
-	if len(data) < 4 {
+	if len(data) < 4 || ParseKMessageLength(checker.cachebytes) > len(checker.cachebytes) {
 		if checker.cachebytes != nil {
 			checker.cachebytes = append(checker.cachebytes, data...)
 		}
@@ -83,7 +105,13 @@ func (checker *kZeroKMessageChecker) CheckPackageData(registerId string, data []
 		checker.cachebytes = append(checker.cachebytes, data...)
 	}
 
-	if len(checker.cachebytes) > 0 && reflect.DeepEqual(checker.cachebytes[len(checker.cachebytes)-4:], kZERO_MESSAGE_END) {
+	dataLength := ParseKMessageLength(checker.cachebytes)
+	if dataLength < 0 && len(checker.cachebytes) >= 10 {
+		global.Logger().Error(fmt.Sprintf("zerov1 connect %s drop invalid frame length %d", registerId, binary.BigEndian.Uint32(checker.cachebytes[6:10])))
+		checker.cachebytes = nil
+		return nil
+	}
+	if dataLength > 0 && len(checker.cachebytes) >= dataLength {
 		pkgs := checker.unpacking(registerId)
 		if len(pkgs) > 0 {
 			checks := make([][]byte, 0)
@@ -106,6 +134,8 @@ type ZeroKMessageConnect interface {
 	server.ZeroConnect
 
 	UniqueKey() string
+	SessionKey() string
+	Cipher() string
 }
 
 type kZeroKMessageConnect struct {
@@ -113,23 +143,67 @@ type kZeroKMessageConnect struct {
 
 	uniquekey string
 	keeper    *kZeroKMessageKeeper
+	mux       *kZeroKMessageMux
+
+	authorized bool
+	challenge  *kMessageChallenge
+	sessionKey string
+	cipher     *kMessageCipher
 
-	request      *ZeroKMessage
-	requestMutex sync.Mutex
-	responseChan chan *ZeroKMessage
+	version     byte
+	peerVersion int
+	streams     *kZeroKMessageStreams
+	files       *kZeroKMessageFiles
 }
 
 func (v1conn *kZeroKMessageConnect) UniqueKey() string {
 	return v1conn.uniquekey
 }
 
+func (v1conn *kZeroKMessageConnect) SessionKey() string {
+	return v1conn.sessionKey
+}
+
+func (v1conn *kZeroKMessageConnect) Cipher() string {
+	if v1conn.cipher == nil {
+		return ""
+	}
+	return v1conn.cipher.name
+}
+
 func (v1conn *kZeroKMessageConnect) Authorized(datas ...byte) bool {
 	authMessage := ParseKMessage(datas)
 
+	v1conn.keeper.registerMutex.Lock()
+	defer v1conn.keeper.registerMutex.Unlock()
+
 	v1conn.uniquekey = authMessage.UniqueKey()
-	ackMessage := NewAckKMessage(MESSAGE_TYPE_CONNACK, authMessage.MessageId(), make([]byte, 0))
+	conn, err := v1conn.keeper.UseConnect(v1conn.RegisterId())
+	if err == nil && conn != nil && conn != server.ZeroConnect(v1conn) {
+		if v1conn.keeper.duplicate == KMESSAGE_DUPLICATE_REJECT {
+			err = v1conn.refuse(authMessage, CONNACK_DUPLICATE_UNIQUEKEY)
+			if err != nil {
+				global.Logger().ErrorS(err)
+			}
+			return false
+		}
+		global.Logger().Warn(fmt.Sprintf("zerov1 connect `%s` already exists on %s, kick it for %s", v1conn.RegisterId(), conn.RemoteAddr(), v1conn.RemoteAddr()))
+		err = conn.Close()
+		if err != nil {
+			global.Logger().ErrorS(err)
+		}
+	}
+
+	ackBody := make([]byte, 0)
+	if v1conn.challenge != nil {
+		ackBody = append(ackBody, CONNACK_ACCEPTED)
+		ackBody = append(ackBody, (&kMessageAuthPayload{Signature: v1conn.challenge.sign(xAUTH_PURPOSE_SERVER)}).Bytes()...)
+		v1conn.sessionKey = v1conn.challenge.sign(xAUTH_PURPOSE_SESSION)
+	}
+	ackMessage := NewAckKMessage(MESSAGE_TYPE_CONNACK, authMessage.MessageId(), ackBody)
 	ackMessage.AddUniqueKey(authMessage.UniqueKey())
-	err := ackMessage.Complete()
+	ackMessage.advertise(v1conn.keeper.options.maxVersion)
+	err = ackMessage.Complete()
 	if err != nil {
 		global.Logger().ErrorS(err)
 		return false
@@ -140,62 +214,157 @@ func (v1conn *kZeroKMessageConnect) Authorized(datas ...byte) bool {
 		global.Logger().ErrorS(err)
 		return false
 	}
-
-	conn, err := v1conn.keeper.UseConnect(v1conn.RegisterId())
-	if err == nil && conn != nil {
-		global.Logger().Warnf(fmt.Sprintf("zerov1 connect %s already exists, will close now", v1conn.RemoteAddr()))
-		err = conn.Close()
+	if v1conn.challenge != nil && len(v1conn.challenge.cipher) > 0 {
+		v1conn.cipher, err = newKMessageCipher(v1conn.challenge.cipher, v1conn.sessionKey, true)
 		if err != nil {
 			global.Logger().ErrorS(err)
+			return false
 		}
-		return false
 	}
 
-	global.Logger().Info(fmt.Sprintf("zerov1 connect %s authorized", v1conn.RemoteAddr()))
+	v1conn.version = v1conn.keeper.options.negotiate(v1conn.peerVersion)
+	global.Logger().Info(fmt.Sprintf("zerov1 connect %s authorized, version %d", v1conn.RemoteAddr(), v1conn.version))
+	v1conn.authorized = true
 	v1conn.Heartbeat()
 	v1conn.ZeroSocketConnect.Authorized()
+	v1conn.keeper.reliable.online(v1conn)
 
 	return true
 }
 
+func (v1conn *kZeroKMessageConnect) refuse(message *ZeroKMessage, code byte) error {
+	defer v1conn.Close()
+	global.Logger().Warn(fmt.Sprintf("zerov1 connect %s uniquekey `%s` refused 0x%02x", v1conn.RemoteAddr(), message.UniqueKey(), code))
+	ackMessage := NewAckKMessage(MESSAGE_TYPE_CONNACK, message.MessageId(), []byte{code})
+	ackMessage.AddUniqueKey(message.UniqueKey())
+	err := ackMessage.Complete()
+	if err != nil {
+		return err
+	}
+	return v1conn.pushMessage(ackMessage)
+}
+
+func (v1conn *kZeroKMessageConnect) challengeConnect(message *ZeroKMessage) error {
+	if v1conn.challenge != nil || v1conn.authorized {
+		return v1conn.refuse(message, CONNACK_PROTOCOL_ERROR)
+	}
+	payload, err := parseAuthPayload(message.MessageBody())
+	if err != nil || len(payload.Nonce) <= 0 {
+		return v1conn.refuse(message, CONNACK_AUTH_REQUIRED)
+	}
+	if !checkAuthTimestamp(payload.Timestamp) {
+		return v1conn.refuse(message, CONNACK_TIMESTAMP_INVALID)
+	}
+	if (len(payload.Cipher) > 0 && !kMessageCipherSupported(payload.Cipher)) ||
+		(len(payload.Cipher) <= 0 && len(v1conn.keeper.options.cipher) > 0) {
+		return v1conn.refuse(message, CONNACK_CIPHER_REJECTED)
+	}
+	secret := v1conn.keeper.fetcher.FetchSecret(message.UniqueKey())
+	if len(secret) <= 0 {
+		return v1conn.refuse(message, CONNACK_UNKNOWN_UNIQUEKEY)
+	}
+	if !v1conn.keeper.nonces.use(message.UniqueKey(), payload.Nonce) {
+		return v1conn.refuse(message, CONNACK_NONCE_REPLAYED)
+	}
+
+	serverNonce, err := newAuthNonce()
+	if err != nil {
+		return err
+	}
+	v1conn.challenge = &kMessageChallenge{
+		secret:      secret,
+		uniquekey:   message.UniqueKey(),
+		clientNonce: payload.Nonce,
+		serverNonce: serverNonce,
+		timestamp:   time.Now().Unix(),
+		cipher:      payload.Cipher,
+	}
+	challengeMessage := NewAckKMessage(MESSAGE_TYPE_CHALLENGE, message.MessageId(),
+		(&kMessageAuthPayload{Timestamp: v1conn.challenge.timestamp, Nonce: serverNonce, Cipher: payload.Cipher}).Bytes())
+	challengeMessage.AddUniqueKey(message.UniqueKey())
+	err = challengeMessage.Complete()
+	if err != nil {
+		return err
+	}
+	return v1conn.pushMessage(challengeMessage)
+}
+
+func (v1conn *kZeroKMessageConnect) verifyAuth(message *ZeroKMessage, datas []byte) error {
+	if v1conn.challenge == nil || v1conn.authorized || message.UniqueKey() != v1conn.challenge.uniquekey {
+		return v1conn.refuse(message, CONNACK_PROTOCOL_ERROR)
+	}
+	payload, err := parseAuthPayload(message.MessageBody())
+	if err != nil {
+		return v1conn.refuse(message, CONNACK_PROTOCOL_ERROR)
+	}
+	if !hmac.Equal([]byte(payload.Signature), []byte(v1conn.challenge.sign(xAUTH_PURPOSE_CLIENT))) {
+		return v1conn.refuse(message, CONNACK_BAD_SIGNATURE)
+	}
+	if !v1conn.Authorized(datas...) {
+		v1conn.Close()
+	}
+	return nil
+}
+
 func (v1conn *kZeroKMessageConnect) Close() error {
+	defer v1conn.files.abort()
+	defer v1conn.streams.abort()
+	defer v1conn.mux.abort()
+	v1conn.keeper.reliable.offline(v1conn)
+	v1conn.keeper.topics.offline(v1conn)
 	return v1conn.ZeroSocketConnect.Close()
 }
 
 func (v1conn *kZeroKMessageConnect) RegisterId() string {
+	if len(v1conn.uniquekey) > 0 {
+		return v1conn.uniquekey
+	}
 	return v1conn.RemoteAddr()
 }
 
 func (v1conn *kZeroKMessageConnect) execMessage(message *ZeroKMessage, withSecond int) (*ZeroKMessage, error) {
-	if v1conn.responseChan != nil {
-		return nil, fmt.Errorf("zerov1 connect %s is busying", v1conn.RemoteAddr())
+	return v1conn.mux.execTimeout(v1conn.pushMessage, message, withSecond)
+}
+
+func (v1conn *kZeroKMessageConnect) execMessageContext(ctx context.Context, message *ZeroKMessage) (*ZeroKMessage, error) {
+	return v1conn.mux.exec(ctx, v1conn.pushMessage, message)
+}
+
+func (v1conn *kZeroKMessageConnect) pushMessage(message *ZeroKMessage) error {
+	if v1conn.cipher != nil && !isKMessageHandshake(message.MessageType()) {
+		return v1conn.cipher.push(v1conn.version, v1conn.keeper.options, message, v1conn.Write)
 	}
-	err := v1conn.Write(message.Bytes())
+	err := v1conn.keeper.options.frame(v1conn.version, message)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	v1conn.requestMutex.Lock()
-	v1conn.request = message
-	v1conn.requestMutex.Unlock()
-	v1conn.responseChan = make(chan *ZeroKMessage, 1)
-	select {
-	case resp := <-v1conn.responseChan:
-		v1conn.requestMutex.Lock()
-		v1conn.request = nil
-		v1conn.responseChan = nil
-		v1conn.requestMutex.Unlock()
-		return resp, nil
-	case <-time.After(time.Second * time.Duration(withSecond)):
-		v1conn.requestMutex.Lock()
-		v1conn.request = nil
-		v1conn.responseChan = nil
-		v1conn.requestMutex.Unlock()
-		return nil, errors.New(" request timeout ")
+	return v1conn.Write(message.Bytes())
+}
+
+func (v1conn *kZeroKMessageConnect) pushStream(ctx context.Context, messageType byte, reader io.Reader) error {
+	return v1conn.streams.send(ctx, v1conn.version, v1conn.pushMessage, "", messageType, v1conn.uniquekey, reader)
+}
+
+func (v1conn *kZeroKMessageConnect) execStream(ctx context.Context, messageType byte, reader io.Reader) (*ZeroKMessage, error) {
+	message, err := NewKMessage(messageType, make([]byte, 0))
+	if err != nil {
+		return nil, err
 	}
+	return v1conn.mux.exec(ctx, func(message *ZeroKMessage) error {
+		return v1conn.streams.send(ctx, v1conn.version, v1conn.pushMessage, message.MessageId(), messageType, v1conn.uniquekey, reader)
+	}, message)
 }
 
-func (v1conn *kZeroKMessageConnect) pushMessage(message *ZeroKMessage) error {
-	return v1conn.Write(message.Bytes())
+func (v1conn *kZeroKMessageConnect) dispatch(message *ZeroKMessage) error {
+	if v1conn.mux.deliver(message) || message.MessageType() == MESSAGE_TYPE_PUBACK || message.MessageType() == MESSAGE_TYPE_FILEACK {
+		return nil
+	}
+	if v1conn.keeper.operator == nil {
+		global.Logger().Debug(fmt.Sprintf("zerov1 connect %s ignore message \n%s", v1conn.RegisterId(), message.String()))
+		return nil
+	}
+	_, err := v1conn.keeper.operator.Operation(v1conn, message)
+	return err
 }
 
 func (v1conn *kZeroKMessageConnect) OnMessage(datas []byte) error {
@@ -203,9 +372,22 @@ func (v1conn *kZeroKMessageConnect) OnMessage(datas []byte) error {
 
 	global.Logger().Debug(fmt.Sprintf("zerov1 connect %s on message \n%s", v1conn.RegisterId(), uMessage.String()))
 	if uMessage.MessageType() == MESSAGE_TYPE_CONNECT {
+		v1conn.peerVersion = uMessage.MaxVersion()
+		if v1conn.keeper.fetcher != nil {
+			return v1conn.challengeConnect(uMessage)
+		}
 		if !v1conn.Authorized(datas...) {
 			v1conn.Close()
 		}
+	} else if uMessage.MessageType() == MESSAGE_TYPE_AUTH {
+		if v1conn.keeper.fetcher == nil {
+			return v1conn.refuse(uMessage, CONNACK_PROTOCOL_ERROR)
+		}
+		return v1conn.verifyAuth(uMessage, datas)
+	} else if v1conn.keeper.fetcher != nil && !v1conn.authorized {
+		global.Logger().Debug(fmt.Sprintf("zerov1 connect %s unauthorized, ignore message \n%s", v1conn.RegisterId(), uMessage.String()))
+	} else if err := v1conn.cipher.open(uMessage); err != nil {
+		global.Logger().Warn(fmt.Sprintf("zerov1 connect %s reject message %s : %s", v1conn.RegisterId(), uMessage.MessageId(), err.Error()))
 	} else if uMessage.MessageType() == MESSAGE_TYPE_HEARTBEAT {
 		v1conn.Heartbeat()
 		beatack := NewAckKMessage(MESSAGE_TYPE_BEATACK, uMessage.MessageId(), make([]byte, 0))
@@ -219,25 +401,16 @@ func (v1conn *kZeroKMessageConnect) OnMessage(datas []byte) error {
 		if err != nil {
 			return err
 		}
+	} else if uMessage.Flags()&KMESSAGE_FLAG_WINDOW != 0 {
+		v1conn.streams.onWindow(uMessage)
+	} else if uMessage.Flags()&KMESSAGE_FLAG_CHUNKED != 0 {
+		return v1conn.streams.onChunk(v1conn, uMessage, v1conn.keeper.operator, v1conn.pushMessage, v1conn.dispatch)
+	} else if uMessage.MessageType() == MESSAGE_TYPE_FILE {
+		return v1conn.files.onFile(v1conn, uMessage, v1conn.keeper.operator, v1conn.pushMessage)
+	} else if uMessage.MessageType() == MESSAGE_TYPE_TOPIC {
+		return v1conn.keeper.topics.onTopic(v1conn, uMessage, v1conn.keeper.operator)
 	} else {
-		callback := func() {
-			if v1conn.responseChan != nil {
-				v1conn.responseChan <- uMessage
-			} else {
-				global.Logger().Debug(fmt.Sprintf("zerov1 connect %s ignore message \n%s", v1conn.RegisterId(), uMessage.String()))
-			}
-		}
-		if v1conn.keeper.operator == nil {
-			callback()
-		} else {
-			ok, err := v1conn.keeper.operator.Operation(v1conn, uMessage)
-			if err != nil {
-				return err
-			}
-			if !ok {
-				callback()
-			}
-		}
+		return v1conn.dispatch(uMessage)
 	}
 	return nil
 }
@@ -245,7 +418,33 @@ func (v1conn *kZeroKMessageConnect) OnMessage(datas []byte) error {
 type kZeroKMessageKeeper struct {
 	server.TCPServer
 
-	operator ZeroKMessageOperator
+	operator     ZeroKMessageOperator
+	maxInflights int
+
+	fetcher ZeroKMessageSecretFetcher
+	nonces  *kMessageNonces
+	options *kMessageOptions
+
+	fileOptions *kMessageFileOptions
+
+	reliable *kZeroKMessageReliable
+	topics   *kZeroKMessageTopics
+
+	duplicate     string
+	registerMutex sync.Mutex
+}
+
+func (keeper *kZeroKMessageKeeper) LookupConnect(uniquekey string) (ZeroKMessageConnect, error) {
+	conn, err := keeper.UseConnect(uniquekey)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(ZeroKMessageConnect), nil
+}
+
+func (keeper *kZeroKMessageKeeper) Online(uniquekey string) bool {
+	conn, err := keeper.UseConnect(uniquekey)
+	return err == nil && conn.Active()
 }
 
 func (keeper *kZeroKMessageKeeper) ExecMessage(registerId string, message *ZeroKMessage, withSecond int) (*ZeroKMessage, error) {
@@ -256,6 +455,30 @@ func (keeper *kZeroKMessageKeeper) ExecMessage(registerId string, message *ZeroK
 	return conn.(*kZeroKMessageConnect).execMessage(message, withSecond)
 }
 
+func (keeper *kZeroKMessageKeeper) ExecMessageContext(ctx context.Context, registerId string, message *ZeroKMessage) (*ZeroKMessage, error) {
+	conn, err := keeper.UseConnect(registerId)
+	if err != nil {
+		return nil, fmt.Errorf("use connect `%s` error: %s", registerId, err.Error())
+	}
+	return conn.(*kZeroKMessageConnect).execMessageContext(ctx, message)
+}
+
+func (keeper *kZeroKMessageKeeper) PushStream(ctx context.Context, registerId string, messageType byte, reader io.Reader) error {
+	conn, err := keeper.UseConnect(registerId)
+	if err != nil {
+		return fmt.Errorf("use connect `%s` error: %s", registerId, err.Error())
+	}
+	return conn.(*kZeroKMessageConnect).pushStream(ctx, messageType, reader)
+}
+
+func (keeper *kZeroKMessageKeeper) ExecStream(ctx context.Context, registerId string, messageType byte, reader io.Reader) (*ZeroKMessage, error) {
+	conn, err := keeper.UseConnect(registerId)
+	if err != nil {
+		return nil, fmt.Errorf("use connect `%s` error: %s", registerId, err.Error())
+	}
+	return conn.(*kZeroKMessageConnect).execStream(ctx, messageType, reader)
+}
+
 func (keeper *kZeroKMessageKeeper) PushMessage(registerId string, message *ZeroKMessage) error {
 	conn, err := keeper.UseConnect(registerId)
 	if err != nil {
@@ -264,21 +487,65 @@ func (keeper *kZeroKMessageKeeper) PushMessage(registerId string, message *ZeroK
 	return conn.(*kZeroKMessageConnect).pushMessage(message)
 }
 
+func (keeper *kZeroKMessageKeeper) SendFile(ctx context.Context, registerId string, filePath string, meta map[string]string, progress ZeroKMessageFileProgress) error {
+	conn, err := keeper.LookupConnect(registerId)
+	if err != nil {
+		return fmt.Errorf("use connect `%s` error: %s", registerId, err.Error())
+	}
+	return newKMessageFiles(keeper.fileOptions).send(ctx, func(ctx context.Context, message *ZeroKMessage) (*ZeroKMessage, error) {
+		return keeper.ExecMessageContext(ctx, registerId, message)
+	}, conn.UniqueKey(), filePath, meta, progress)
+}
+
+func (keeper *kZeroKMessageKeeper) PushReliable(uniquekey string, message *ZeroKMessage, ttlSeconds int) error {
+	return keeper.reliable.push(uniquekey, message, ttlSeconds)
+}
+
+func (keeper *kZeroKMessageKeeper) ReliableStatus(messageId string) (*ZeroKMessageReliableRecord, error) {
+	return keeper.reliable.status(messageId)
+}
+
+func (keeper *kZeroKMessageKeeper) Publish(topic string, payload []byte, retain bool) (int, error) {
+	return keeper.topics.publish(topic, payload, retain)
+}
+
+func (keeper *kZeroKMessageKeeper) Subscribers(topic string) []string {
+	return keeper.topics.names(topic)
+}
+
 func (keeper *kZeroKMessageKeeper) RunServer() {
 	keeper.ConnectBuilder = &xZeroKMessageConnectBuilder{}
+	defer keeper.reliable.close()
 	keeper.TCPServer.RunServer()
 }
 
+func (keeper *kZeroKMessageKeeper) Shutdown() error {
+	defer keeper.reliable.close()
+	return keeper.TCPServer.Shutdown()
+}
+
 var RunKMessageServer = func(addr string, heartbeatTime int, operator ZeroKMessageOperator, watchers ...server.ZeroServerWatcher) {
+	options := newKMessageOptions()
 	zerov1serv := &kZeroKMessageKeeper{
 		TCPServer: *server.NewTCPServer(
 			addr,
 			xDEFAULT_AUTH_WAIT,
 			int64(heartbeatTime),
-			xDEFAULT_BUFFER_SIZE,
+			options.bufferSize,
 			watchers...,
 		),
-		operator: operator,
+		operator:     operator,
+		maxInflights: kMessageMaxInflights(),
+		fetcher:      kMessageSecretFetcher,
+		nonces:       newKMessageNonces(),
+		options:      options,
+		fileOptions:  newKMessageFileOptions(),
+		reliable:     newKMessageReliable(),
+		topics:       newKMessageTopics(),
+		duplicate:    kMessageDuplicatePolicy(),
+	}
+	if len(options.cipher) > 0 && zerov1serv.fetcher == nil {
+		panic(fmt.Errorf(" kmessage encryption `%s` requires a secret fetcher ", options.cipher))
 	}
 	global.Key(ZEROKMSG_SERVER, zerov1serv)
 	zerov1serv.RunServer()
@@ -0,0 +1,251 @@
+package protocol
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0meet1/zero-framework/database"
+	"github.com/0meet1/zero-framework/global"
+)
+
+type ZeroKMessageMemoryStore struct {
+	records      map[string]*ZeroKMessageReliableRecord
+	queues       map[string][]string
+	recordsMutex sync.Mutex
+}
+
+func NewKMessageMemoryStore() *ZeroKMessageMemoryStore {
+	return &ZeroKMessageMemoryStore{
+		records: make(map[string]*ZeroKMessageReliableRecord),
+		queues:  make(map[string][]string),
+	}
+}
+
+func (store *ZeroKMessageMemoryStore) Save(record *ZeroKMessageReliableRecord) error {
+	store.recordsMutex.Lock()
+	defer store.recordsMutex.Unlock()
+	if _, ok := store.records[record.MessageId]; !ok {
+		store.queues[record.UniqueKey] = append(store.queues[record.UniqueKey], record.MessageId)
+	}
+	xRecord := *record
+	store.records[record.MessageId] = &xRecord
+	return nil
+}
+
+func (store *ZeroKMessageMemoryStore) Find(messageId string) (*ZeroKMessageReliableRecord, error) {
+	store.recordsMutex.Lock()
+	defer store.recordsMutex.Unlock()
+	record, ok := store.records[messageId]
+	if !ok {
+		return nil, nil
+	}
+	xRecord := *record
+	return &xRecord, nil
+}
+
+func (store *ZeroKMessageMemoryStore) Pending(uniquekey string) ([]*ZeroKMessageReliableRecord, error) {
+	store.recordsMutex.Lock()
+	defer store.recordsMutex.Unlock()
+	records := make([]*ZeroKMessageReliableRecord, 0)
+	queue := make([]string, 0, len(store.queues[uniquekey]))
+	for _, messageId := range store.queues[uniquekey] {
+		record, ok := store.records[messageId]
+		if !ok || record.Finished() {
+			continue
+		}
+		queue = append(queue, messageId)
+		xRecord := *record
+		records = append(records, &xRecord)
+	}
+	if len(queue) > 0 {
+		store.queues[uniquekey] = queue
+	} else {
+		delete(store.queues, uniquekey)
+	}
+	return records, nil
+}
+
+func (store *ZeroKMessageMemoryStore) Expire(now time.Time) (int, error) {
+	store.recordsMutex.Lock()
+	defer store.recordsMutex.Unlock()
+	expired := 0
+	for messageId, record := range store.records {
+		if record.Finished() {
+			if record.expired(now.Add(-time.Second * xRELIABLE_RETENTION)) {
+				delete(store.records, messageId)
+			}
+			continue
+		}
+		if record.expired(now) {
+			record.Status = KMESSAGE_RELIABLE_EXPIRED
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+const (
+	xRELIABLE_SQLITE_TABLE = "zero_kmessage_reliable"
+	xRELIABLE_SQLITE_CORE  = `CREATE TABLE IF NOT EXISTS zero_kmessage_reliable (
+		message_id VARCHAR(32) PRIMARY KEY,
+		uniquekey VARCHAR(32) NOT NULL,
+		message_type INTEGER NOT NULL,
+		body BLOB,
+		status VARCHAR(16) NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		reason TEXT,
+		create_time INTEGER NOT NULL,
+		expire_at INTEGER NOT NULL,
+		next_retry_at INTEGER NOT NULL DEFAULT 0,
+		delivered_at INTEGER NOT NULL DEFAULT 0
+	)`
+	xRELIABLE_SQLITE_INDEX  = "CREATE INDEX IF NOT EXISTS idx_zero_kmessage_reliable_device ON zero_kmessage_reliable (uniquekey, status)"
+	xRELIABLE_SQLITE_FIELDS = "message_id, uniquekey, message_type, body, status, attempts, reason, create_time, expire_at, next_retry_at, delivered_at"
+)
+
+type ZeroKMessageSQLiteStore struct {
+	datasource *database.SqliteDataSource
+}
+
+func NewKMessageSQLiteStore(datasources ...*database.SqliteDataSource) (*ZeroKMessageSQLiteStore, error) {
+	store := &ZeroKMessageSQLiteStore{}
+	if len(datasources) > 0 {
+		store.datasource = datasources[0]
+	} else {
+		datasource, ok := global.Value(database.DATABASE_SQLITE).(*database.SqliteDataSource)
+		if !ok {
+			return nil, fmt.Errorf(" sqlite datasource `%s` not found ", database.DATABASE_SQLITE)
+		}
+		store.datasource = datasource
+	}
+	_, err := store.transaction(func(transaction *sql.Tx) any {
+		_, err := transaction.Exec(xRELIABLE_SQLITE_CORE)
+		if err != nil {
+			panic(err)
+		}
+		_, err = transaction.Exec(xRELIABLE_SQLITE_INDEX)
+		if err != nil {
+			panic(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *ZeroKMessageSQLiteStore) transaction(performer func(*sql.Tx) any) (any, error) {
+	var xerr error
+	result := store.datasource.SecureTransaction(performer, func(err error) {
+		if err != nil {
+			xerr = err
+		}
+	})
+	return result, xerr
+}
+
+func (store *ZeroKMessageSQLiteStore) Save(record *ZeroKMessageReliableRecord) error {
+	_, err := store.transaction(func(transaction *sql.Tx) any {
+		_, err := transaction.Exec(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (message_id) DO UPDATE SET status = excluded.status, attempts = excluded.attempts, reason = excluded.reason,
+			expire_at = excluded.expire_at, next_retry_at = excluded.next_retry_at, delivered_at = excluded.delivered_at`,
+			xRELIABLE_SQLITE_TABLE, xRELIABLE_SQLITE_FIELDS),
+			record.MessageId, record.UniqueKey, record.MessageType, record.Body, record.Status, record.Attempts, record.Reason,
+			xUnixMilli(record.CreateTime), xUnixMilli(record.ExpireAt), xUnixMilli(record.NextRetryAt), xUnixMilli(record.DeliveredAt))
+		if err != nil {
+			panic(err)
+		}
+		return nil
+	})
+	return err
+}
+
+func (store *ZeroKMessageSQLiteStore) Find(messageId string) (*ZeroKMessageReliableRecord, error) {
+	records, err := store.query(fmt.Sprintf("SELECT %s FROM %s WHERE message_id = ?", xRELIABLE_SQLITE_FIELDS, xRELIABLE_SQLITE_TABLE), messageId)
+	if err != nil || len(records) <= 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+func (store *ZeroKMessageSQLiteStore) Pending(uniquekey string) ([]*ZeroKMessageReliableRecord, error) {
+	return store.query(fmt.Sprintf("SELECT %s FROM %s WHERE uniquekey = ? AND status IN (?, ?) ORDER BY rowid", xRELIABLE_SQLITE_FIELDS, xRELIABLE_SQLITE_TABLE),
+		uniquekey, KMESSAGE_RELIABLE_PENDING, KMESSAGE_RELIABLE_INFLIGHT)
+}
+
+func (store *ZeroKMessageSQLiteStore) Expire(now time.Time) (int, error) {
+	result, err := store.transaction(func(transaction *sql.Tx) any {
+		_, err := transaction.Exec(fmt.Sprintf("DELETE FROM %s WHERE status NOT IN (?, ?) AND expire_at < ?", xRELIABLE_SQLITE_TABLE),
+			KMESSAGE_RELIABLE_PENDING, KMESSAGE_RELIABLE_INFLIGHT, now.Add(-time.Second*xRELIABLE_RETENTION).UnixMilli())
+		if err != nil {
+			panic(err)
+		}
+		result, err := transaction.Exec(fmt.Sprintf("UPDATE %s SET status = ? WHERE status IN (?, ?) AND expire_at < ?", xRELIABLE_SQLITE_TABLE),
+			KMESSAGE_RELIABLE_EXPIRED, KMESSAGE_RELIABLE_PENDING, KMESSAGE_RELIABLE_INFLIGHT, now.UnixMilli())
+		if err != nil {
+			panic(err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			panic(err)
+		}
+		return int(affected)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.(int), nil
+}
+
+func (store *ZeroKMessageSQLiteStore) query(query string, args ...any) ([]*ZeroKMessageReliableRecord, error) {
+	result, err := store.transaction(func(transaction *sql.Tx) any {
+		rows, err := transaction.Query(query, args...)
+		if err != nil {
+			panic(err)
+		}
+		defer rows.Close()
+		records := make([]*ZeroKMessageReliableRecord, 0)
+		for rows.Next() {
+			record := &ZeroKMessageReliableRecord{}
+			var reason sql.NullString
+			var createTime, expireAt, nextRetryAt, deliveredAt int64
+			err := rows.Scan(&record.MessageId, &record.UniqueKey, &record.MessageType, &record.Body, &record.Status, &record.Attempts,
+				&reason, &createTime, &expireAt, &nextRetryAt, &deliveredAt)
+			if err != nil {
+				panic(err)
+			}
+			record.Reason = reason.String
+			record.CreateTime = xFromUnixMilli(createTime)
+			record.ExpireAt = xFromUnixMilli(expireAt)
+			record.NextRetryAt = xFromUnixMilli(nextRetryAt)
+			record.DeliveredAt = xFromUnixMilli(deliveredAt)
+			records = append(records, record)
+		}
+		err = rows.Err()
+		if err != nil {
+			panic(err)
+		}
+		return records
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]*ZeroKMessageReliableRecord), nil
+}
+
+func xUnixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func xFromUnixMilli(millis int64) time.Time {
+	if millis <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
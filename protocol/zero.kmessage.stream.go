@@ -0,0 +1,419 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/server"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	xDEFAULT_COMPRESS_THRESHOLD = 1024
+	xDEFAULT_CHUNK_SIZE         = 32 * 1024
+	xDEFAULT_STREAM_WINDOW      = 8
+)
+
+type ZeroKMessageStreamOperator interface {
+	OnStream(server.ZeroConnect, *ZeroKMessageStream) error
+}
+
+type kMessageOptions struct {
+	maxVersion        byte
+	compression       byte
+	compressThreshold int
+	chunkSize         int
+	streamWindow      int
+	bufferSize        int
+	cipher            string
+}
+
+func newKMessageOptions() *kMessageOptions {
+	options := &kMessageOptions{
+		maxVersion:        KMESSAGE_VERSION_2,
+		compressThreshold: global.IntValue("zero.kmessage.compressThreshold"),
+		chunkSize:         global.IntValue("zero.kmessage.chunkSize"),
+		streamWindow:      global.IntValue("zero.kmessage.streamWindow"),
+		bufferSize:        global.IntValue("zero.kmessage.bufferSize"),
+	}
+	UseKMessageMaxBodySize(global.IntValue("zero.kmessage.maxBodySize"))
+	if global.IntValue("zero.kmessage.version") == KMESSAGE_VERSION_1 {
+		options.maxVersion = KMESSAGE_VERSION_1
+	}
+	switch strings.ToLower(global.StringValue("zero.kmessage.compression")) {
+	case "gzip":
+		options.compression = KMESSAGE_FLAG_GZIP
+	case "deflate":
+		options.compression = KMESSAGE_FLAG_DEFLATE
+	}
+	options.cipher = strings.ToLower(global.StringValue("zero.kmessage.encryption"))
+	if len(options.cipher) > 0 && !kMessageCipherSupported(options.cipher) {
+		panic(fmt.Errorf(" unsupported kmessage encryption `%s` ", options.cipher))
+	}
+	if options.compressThreshold <= 0 {
+		options.compressThreshold = xDEFAULT_COMPRESS_THRESHOLD
+	}
+	if options.chunkSize <= 0 {
+		options.chunkSize = xDEFAULT_CHUNK_SIZE
+	}
+	if options.streamWindow <= 0 {
+		options.streamWindow = xDEFAULT_STREAM_WINDOW
+	}
+	if options.bufferSize <= 0 {
+		options.bufferSize = xDEFAULT_BUFFER_SIZE
+	}
+	return options
+}
+
+func (options *kMessageOptions) negotiate(peerVersion int) byte {
+	if peerVersion >= KMESSAGE_VERSION_2 && options.maxVersion >= KMESSAGE_VERSION_2 {
+		return KMESSAGE_VERSION_2
+	}
+	return KMESSAGE_VERSION_1
+}
+
+func (options *kMessageOptions) frame(version byte, message *ZeroKMessage) error {
+	if version < KMESSAGE_VERSION_2 || message.Version() == KMESSAGE_VERSION_2 {
+		return nil
+	}
+	message.useVersion(KMESSAGE_VERSION_2)
+	flags := message.Flags()
+	if options.compression != 0 && flags&xFLAG_COMPRESSION == 0 && len(message.MessageBody()) >= options.compressThreshold {
+		flags |= options.compression
+	}
+	message.useFlags(flags)
+	return message.Complete()
+}
+
+type ZeroKMessageStream struct {
+	messageId   string
+	messageType int
+	uniquekey   string
+
+	chunks  chan []byte
+	current []byte
+	grant   func(int) error
+
+	nextSequence int
+	eof          bool
+	xerr         error
+	xerrMutex    sync.Mutex
+}
+
+func (stream *ZeroKMessageStream) MessageId() string {
+	return stream.messageId
+}
+
+func (stream *ZeroKMessageStream) MessageType() int {
+	return stream.messageType
+}
+
+func (stream *ZeroKMessageStream) UniqueKey() string {
+	return stream.uniquekey
+}
+
+func (stream *ZeroKMessageStream) err() error {
+	stream.xerrMutex.Lock()
+	defer stream.xerrMutex.Unlock()
+	return stream.xerr
+}
+
+func (stream *ZeroKMessageStream) abort(err error) {
+	stream.xerrMutex.Lock()
+	if stream.xerr == nil {
+		stream.xerr = err
+		close(stream.chunks)
+	}
+	stream.xerrMutex.Unlock()
+}
+
+func (stream *ZeroKMessageStream) Read(p []byte) (int, error) {
+	for len(stream.current) <= 0 {
+		if stream.eof {
+			return 0, io.EOF
+		}
+		chunk, ok := <-stream.chunks
+		if !ok {
+			err := stream.err()
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		if chunk == nil {
+			stream.eof = true
+			return 0, io.EOF
+		}
+		stream.current = chunk
+		err := stream.grant(1)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, stream.current)
+	stream.current = stream.current[n:]
+	return n, nil
+}
+
+type xStreamSender struct {
+	credits      int
+	creditsMutex sync.Mutex
+	notify       chan struct{}
+	done         chan struct{}
+}
+
+func (sender *xStreamSender) acquire(ctx context.Context) error {
+	for {
+		sender.creditsMutex.Lock()
+		if sender.credits > 0 {
+			sender.credits--
+			sender.creditsMutex.Unlock()
+			return nil
+		}
+		sender.creditsMutex.Unlock()
+		select {
+		case <-sender.notify:
+		case <-sender.done:
+			return errors.New(" connect closed ")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (sender *xStreamSender) release(credits int) {
+	sender.creditsMutex.Lock()
+	sender.credits += credits
+	sender.creditsMutex.Unlock()
+	select {
+	case sender.notify <- struct{}{}:
+	default:
+	}
+}
+
+type kZeroKMessageStreams struct {
+	options *kMessageOptions
+
+	senders   map[string]*xStreamSender
+	receivers map[string]*ZeroKMessageStream
+	assembles map[string]*ZeroKMessage
+	mutex     sync.Mutex
+}
+
+func newKMessageStreams(options *kMessageOptions) *kZeroKMessageStreams {
+	return &kZeroKMessageStreams{
+		options:   options,
+		senders:   make(map[string]*xStreamSender),
+		receivers: make(map[string]*ZeroKMessageStream),
+		assembles: make(map[string]*ZeroKMessage),
+	}
+}
+
+func (streams *kZeroKMessageStreams) window(message *ZeroKMessage, credits int) *ZeroKMessage {
+	windowMessage := NewAckKMessage(byte(message.MessageType()), message.MessageId(), binary.BigEndian.AppendUint32(make([]byte, 0, 4), uint32(credits)))
+	windowMessage.AddUniqueKey(message.UniqueKey())
+	windowMessage.useVersion(KMESSAGE_VERSION_2)
+	windowMessage.useFlags(KMESSAGE_FLAG_WINDOW)
+	return windowMessage
+}
+
+func (streams *kZeroKMessageStreams) onWindow(message *ZeroKMessage) {
+	if len(message.MessageBody()) < 4 {
+		return
+	}
+	streams.mutex.Lock()
+	sender, ok := streams.senders[message.MessageId()]
+	streams.mutex.Unlock()
+	if ok {
+		sender.release(int(binary.BigEndian.Uint32(message.MessageBody())))
+	}
+}
+
+func (streams *kZeroKMessageStreams) onChunk(conn server.ZeroConnect, message *ZeroKMessage, operator ZeroKMessageOperator, push func(*ZeroKMessage) error, dispatch func(*ZeroKMessage) error) error {
+	grant := func(credits int) error {
+		windowMessage := streams.window(message, credits)
+		err := windowMessage.Complete()
+		if err != nil {
+			return err
+		}
+		return push(windowMessage)
+	}
+	end := message.Flags()&KMESSAGE_FLAG_CHUNK_END != 0
+
+	if streamOperator, ok := operator.(ZeroKMessageStreamOperator); ok {
+		streams.mutex.Lock()
+		stream, ok := streams.receivers[message.MessageId()]
+		if !ok {
+			stream = &ZeroKMessageStream{
+				messageId:   message.MessageId(),
+				messageType: message.MessageType(),
+				uniquekey:   message.UniqueKey(),
+				chunks:      make(chan []byte, streams.options.streamWindow+1),
+				grant:       grant,
+			}
+			streams.receivers[message.MessageId()] = stream
+		}
+		if end {
+			delete(streams.receivers, message.MessageId())
+		}
+		streams.mutex.Unlock()
+
+		if !ok {
+			go func() {
+				err := streamOperator.OnStream(conn, stream)
+				if err != nil {
+					global.Logger().Error(fmt.Sprintf("0protocol/1.0 stream %s on stream error : %s", stream.messageId, err.Error()))
+				}
+			}()
+		}
+		return streams.feed(stream, message, end)
+	}
+
+	streams.mutex.Lock()
+	assemble, ok := streams.assembles[message.MessageId()]
+	if !ok {
+		assemble = NewAckKMessage(byte(message.MessageType()), message.MessageId(), make([]byte, 0))
+		assemble.AddUniqueKey(message.UniqueKey())
+		assemble.sequence = make([]byte, 4)
+		streams.assembles[message.MessageId()] = assemble
+	}
+	if end {
+		delete(streams.assembles, message.MessageId())
+	}
+	streams.mutex.Unlock()
+
+	if message.Sequence() != assemble.Sequence() {
+		streams.mutex.Lock()
+		delete(streams.assembles, message.MessageId())
+		streams.mutex.Unlock()
+		return fmt.Errorf("0protocol/1.0 stream %s sequence %d expect %d", message.MessageId(), message.Sequence(), assemble.Sequence())
+	}
+	assemble.useSequence(message.Sequence() + 1)
+	assemble.messageBody = append(assemble.messageBody, message.MessageBody()...)
+	if !end {
+		return grant(1)
+	}
+	err := assemble.Complete()
+	if err != nil {
+		return err
+	}
+	return dispatch(assemble)
+}
+
+func (streams *kZeroKMessageStreams) feed(stream *ZeroKMessageStream, message *ZeroKMessage, end bool) error {
+	stream.xerrMutex.Lock()
+	defer stream.xerrMutex.Unlock()
+	if stream.xerr != nil {
+		return nil
+	}
+	if message.Sequence() != stream.nextSequence {
+		stream.xerr = fmt.Errorf("0protocol/1.0 stream %s sequence %d expect %d", stream.messageId, message.Sequence(), stream.nextSequence)
+		close(stream.chunks)
+		return stream.xerr
+	}
+	stream.nextSequence++
+
+	chunks := make([][]byte, 0, 2)
+	if len(message.MessageBody()) > 0 {
+		chunks = append(chunks, message.MessageBody())
+	}
+	if end {
+		chunks = append(chunks, nil)
+	}
+	for _, chunk := range chunks {
+		select {
+		case stream.chunks <- chunk:
+		default:
+			stream.xerr = fmt.Errorf("0protocol/1.0 stream %s window overflow", stream.messageId)
+			close(stream.chunks)
+			return stream.xerr
+		}
+	}
+	return nil
+}
+
+func (streams *kZeroKMessageStreams) send(ctx context.Context, version byte, push func(*ZeroKMessage) error, messageId string, messageType byte, uniquekey string, reader io.Reader) error {
+	if version < KMESSAGE_VERSION_2 {
+		return errors.New(" peer does not support kmessage v2 stream ")
+	}
+	if len(messageId) <= 0 {
+		uid, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		messageId = strings.ReplaceAll(uid.String(), "-", "")
+	}
+
+	sender := &xStreamSender{
+		credits: streams.options.streamWindow,
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	streams.mutex.Lock()
+	streams.senders[messageId] = sender
+	streams.mutex.Unlock()
+	defer func() {
+		streams.mutex.Lock()
+		delete(streams.senders, messageId)
+		streams.mutex.Unlock()
+	}()
+
+	for sequence := 0; ; sequence++ {
+		chunk := make([]byte, streams.options.chunkSize)
+		n, err := io.ReadFull(reader, chunk)
+		flags := byte(KMESSAGE_FLAG_CHUNKED)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			flags |= KMESSAGE_FLAG_CHUNK_END
+		} else if err != nil {
+			return err
+		}
+
+		err = sender.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		chunkMessage := NewAckKMessage(messageType, messageId, chunk[:n])
+		if len(uniquekey) > 0 {
+			chunkMessage.AddUniqueKey(uniquekey)
+		}
+		chunkMessage.useVersion(KMESSAGE_VERSION_2)
+		if streams.options.compression != 0 && n >= streams.options.compressThreshold {
+			flags |= streams.options.compression
+		}
+		chunkMessage.useFlags(flags)
+		chunkMessage.useSequence(sequence)
+		err = chunkMessage.Complete()
+		if err != nil {
+			return err
+		}
+		err = push(chunkMessage)
+		if err != nil {
+			return err
+		}
+		if flags&KMESSAGE_FLAG_CHUNK_END != 0 {
+			return nil
+		}
+	}
+}
+
+func (streams *kZeroKMessageStreams) abort() {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+	for messageId, sender := range streams.senders {
+		delete(streams.senders, messageId)
+		close(sender.done)
+	}
+	for messageId, stream := range streams.receivers {
+		delete(streams.receivers, messageId)
+		stream.abort(errors.New(" connect closed "))
+	}
+	for messageId := range streams.assembles {
+		delete(streams.assembles, messageId)
+	}
+}
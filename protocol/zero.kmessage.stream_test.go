@@ -0,0 +1,180 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0meet1/zero-framework/server"
+)
+
+func newTestKMessageOptions(chunkSize int, streamWindow int) *kMessageOptions {
+	return &kMessageOptions{
+		maxVersion:        KMESSAGE_VERSION_2,
+		compressThreshold: xDEFAULT_COMPRESS_THRESHOLD,
+		chunkSize:         chunkSize,
+		streamWindow:      streamWindow,
+		bufferSize:        xDEFAULT_BUFFER_SIZE,
+	}
+}
+
+func testKMessageWire(t *testing.T, message *ZeroKMessage) *ZeroKMessage {
+	parsed := ParseKMessage(append([]byte{}, message.Bytes()...))
+	err := parsed.Check()
+	if err != nil {
+		t.Error(err)
+	}
+	return parsed
+}
+
+type xTestStreamOperator struct {
+	started chan *ZeroKMessageStream
+}
+
+func (operator *xTestStreamOperator) Operation(server.ZeroConnect, *ZeroKMessage) (bool, error) {
+	return false, nil
+}
+
+func (operator *xTestStreamOperator) OnStream(conn server.ZeroConnect, stream *ZeroKMessageStream) error {
+	operator.started <- stream
+	return nil
+}
+
+func TestKMessageStreamAssemble(t *testing.T) {
+	options := newTestKMessageOptions(4, 2)
+	options.compression = KMESSAGE_FLAG_DEFLATE
+	options.compressThreshold = 4
+	sender := newKMessageStreams(options)
+	receiver := newKMessageStreams(options)
+
+	var assembled *ZeroKMessage
+	chunks := 0
+	pushBack := func(message *ZeroKMessage) error {
+		sender.onWindow(testKMessageWire(t, message))
+		return nil
+	}
+	push := func(message *ZeroKMessage) error {
+		chunks++
+		return receiver.onChunk(nil, testKMessageWire(t, message), nil, pushBack, func(message *ZeroKMessage) error {
+			assembled = message
+			return nil
+		})
+	}
+
+	body := []byte("0123456789abcdefghij")
+	err := sender.send(context.Background(), KMESSAGE_VERSION_2, push, "", MESSAGE_TYPE_RPC, "k1", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks != 6 {
+		t.Fatalf("chunks %d", chunks)
+	}
+	if assembled == nil || !bytes.Equal(assembled.MessageBody(), body) || assembled.UniqueKey() != "k1" {
+		t.Fatalf("assembled %v", assembled)
+	}
+	if len(receiver.assembles) != 0 || len(sender.senders) != 0 {
+		t.Fatalf("assembles %d senders %d", len(receiver.assembles), len(sender.senders))
+	}
+
+	err = sender.send(context.Background(), KMESSAGE_VERSION_1, push, "", MESSAGE_TYPE_RPC, "", bytes.NewReader(body))
+	if err == nil {
+		t.Fatal("v1 stream accepted")
+	}
+}
+
+func TestKMessageStreamSequence(t *testing.T) {
+	receiver := newKMessageStreams(newTestKMessageOptions(4, 2))
+	push := func(*ZeroKMessage) error { return nil }
+	dispatch := func(*ZeroKMessage) error {
+		t.Error("out of order stream dispatched")
+		return nil
+	}
+
+	for _, sequence := range []int{0, 2} {
+		message, err := NewKMessageFrame(KMESSAGE_VERSION_2, MESSAGE_TYPE_RPC, "0123456789abcdef0123456789abcdef", "", KMESSAGE_FLAG_CHUNKED, sequence, []byte("abcd"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = receiver.onChunk(nil, message, nil, push, dispatch)
+		if sequence == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if sequence == 2 && err == nil {
+			t.Fatal("sequence gap accepted")
+		}
+	}
+	if len(receiver.assembles) != 0 {
+		t.Fatalf("assembles %d", len(receiver.assembles))
+	}
+}
+
+func TestKMessageStreamWindow(t *testing.T) {
+	options := newTestKMessageOptions(4, 2)
+	sender := newKMessageStreams(options)
+	receiver := newKMessageStreams(options)
+	operator := &xTestStreamOperator{started: make(chan *ZeroKMessageStream, 1)}
+
+	var pushed atomic.Int32
+	pushBack := func(message *ZeroKMessage) error {
+		sender.onWindow(testKMessageWire(t, message))
+		return nil
+	}
+	push := func(message *ZeroKMessage) error {
+		pushed.Add(1)
+		return receiver.onChunk(nil, testKMessageWire(t, message), operator, pushBack, nil)
+	}
+
+	body := bytes.Repeat([]byte("abcd"), 6)
+	done := make(chan error, 1)
+	go func() {
+		done <- sender.send(context.Background(), KMESSAGE_VERSION_2, push, "", MESSAGE_TYPE_RPC, "", bytes.NewReader(body))
+	}()
+
+	var stream *ZeroKMessageStream
+	select {
+	case stream = <-operator.started:
+	case <-time.After(time.Second):
+		t.Fatal("stream not started")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := pushed.Load(); n != 2 {
+		t.Fatalf("pushed %d chunks beyond window 2", n)
+	}
+
+	datas, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(datas, body) {
+		t.Fatalf("stream %q", datas)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sender blocked")
+	}
+}
+
+func TestKMessageStreamAbort(t *testing.T) {
+	sender := newKMessageStreams(newTestKMessageOptions(4, 1))
+	done := make(chan error, 1)
+	go func() {
+		done <- sender.send(context.Background(), KMESSAGE_VERSION_2, func(*ZeroKMessage) error { return nil }, "", MESSAGE_TYPE_RPC, "", bytes.NewReader(make([]byte, 16)))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	sender.abort()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("aborted stream completed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sender not released on abort")
+	}
+}
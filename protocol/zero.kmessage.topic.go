@@ -0,0 +1,339 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/server"
+)
+
+const (
+	xTOPIC_OP_SUBSCRIBE   = 0x01
+	xTOPIC_OP_UNSUBSCRIBE = 0x02
+	xTOPIC_OP_PUBLISH     = 0x03
+
+	xTOPIC_STATUS_OK       = 0x00
+	xTOPIC_STATUS_REJECTED = 0x01
+
+	xTOPIC_FLAG_RETAIN = 0x01
+
+	xTOPIC_MAX_LENGTH              = 1024
+	xDEFAULT_TOPIC_RESUBSCRIBE_ACK = 10
+)
+
+type ZeroKMessageTopicHandler func(topic string, payload []byte, retained bool)
+
+type ZeroKMessageTopicOperator interface {
+	OnSubscribe(server.ZeroConnect, string) error
+	OnPublish(server.ZeroConnect, string, []byte) error
+}
+
+func checkKMessageTopic(topic string) error {
+	if len(topic) <= 0 || len(topic) > xTOPIC_MAX_LENGTH {
+		return fmt.Errorf(" topic length should between 1 and %d ", xTOPIC_MAX_LENGTH)
+	}
+	if !utf8.ValidString(topic) {
+		return errors.New(" topic should be utf8 ")
+	}
+	return nil
+}
+
+func newKMessageTopicFrame(uniquekey string, op byte, flags byte, topic string, payload []byte) (*ZeroKMessage, error) {
+	body := make([]byte, 0, 4+len(topic)+len(payload))
+	body = append(body, op, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(topic)))
+	body = append(body, topic...)
+	body = append(body, payload...)
+	message, err := NewKMessage(MESSAGE_TYPE_TOPIC, body)
+	if err != nil {
+		return nil, err
+	}
+	err = message.AddUniqueKey(uniquekey)
+	if err != nil {
+		return nil, err
+	}
+	return message, message.Complete()
+}
+
+func parseKMessageTopicFrame(message *ZeroKMessage) (byte, byte, string, []byte, error) {
+	body := message.MessageBody()
+	if len(body) < 4 {
+		return 0, 0, "", nil, errors.New(" invalid topic frame ")
+	}
+	topicLength := int(binary.BigEndian.Uint16(body[2:4]))
+	if len(body) < 4+topicLength {
+		return 0, 0, "", nil, errors.New(" invalid topic frame ")
+	}
+	topic := string(body[4 : 4+topicLength])
+	return body[0], body[1], topic, body[4+topicLength:], checkKMessageTopic(topic)
+}
+
+func newKMessageTopicAck(message *ZeroKMessage, status byte, count int, reason string) (*ZeroKMessage, error) {
+	body := binary.BigEndian.AppendUint32([]byte{status}, uint32(count))
+	ackMessage := NewAckKMessage(MESSAGE_TYPE_TOPICACK, message.MessageId(), append(body, reason...))
+	ackMessage.AddUniqueKey(message.UniqueKey())
+	return ackMessage, ackMessage.Complete()
+}
+
+func parseKMessageTopicAck(message *ZeroKMessage) (int, error) {
+	body := message.MessageBody()
+	if message.MessageType() != MESSAGE_TYPE_TOPICACK || len(body) < 5 {
+		return 0, errors.New(" invalid topic ack ")
+	}
+	if body[0] != xTOPIC_STATUS_OK {
+		return 0, fmt.Errorf(" topic refused : %s ", string(body[5:]))
+	}
+	return int(binary.BigEndian.Uint32(body[1:5])), nil
+}
+
+type kZeroKMessageTopics struct {
+	subscribers map[string]map[*kZeroKMessageConnect]struct{}
+	retained    map[string][]byte
+	mutex       sync.RWMutex
+}
+
+func newKMessageTopics() *kZeroKMessageTopics {
+	return &kZeroKMessageTopics{
+		subscribers: make(map[string]map[*kZeroKMessageConnect]struct{}),
+		retained:    make(map[string][]byte),
+	}
+}
+
+func (topics *kZeroKMessageTopics) subscribe(conn *kZeroKMessageConnect, topic string) []byte {
+	topics.mutex.Lock()
+	defer topics.mutex.Unlock()
+	conns, ok := topics.subscribers[topic]
+	if !ok {
+		conns = make(map[*kZeroKMessageConnect]struct{})
+		topics.subscribers[topic] = conns
+	}
+	conns[conn] = struct{}{}
+	return topics.retained[topic]
+}
+
+func (topics *kZeroKMessageTopics) unsubscribe(conn *kZeroKMessageConnect, topic string) {
+	topics.mutex.Lock()
+	defer topics.mutex.Unlock()
+	if conns, ok := topics.subscribers[topic]; ok {
+		delete(conns, conn)
+		if len(conns) <= 0 {
+			delete(topics.subscribers, topic)
+		}
+	}
+}
+
+func (topics *kZeroKMessageTopics) offline(conn *kZeroKMessageConnect) {
+	topics.mutex.Lock()
+	defer topics.mutex.Unlock()
+	for topic, conns := range topics.subscribers {
+		delete(conns, conn)
+		if len(conns) <= 0 {
+			delete(topics.subscribers, topic)
+		}
+	}
+}
+
+func (topics *kZeroKMessageTopics) list(topic string) []*kZeroKMessageConnect {
+	topics.mutex.RLock()
+	defer topics.mutex.RUnlock()
+	conns := make([]*kZeroKMessageConnect, 0, len(topics.subscribers[topic]))
+	for conn := range topics.subscribers[topic] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (topics *kZeroKMessageTopics) retain(topic string, payload []byte) {
+	topics.mutex.Lock()
+	defer topics.mutex.Unlock()
+	if len(payload) <= 0 {
+		delete(topics.retained, topic)
+		return
+	}
+	topics.retained[topic] = append([]byte{}, payload...)
+}
+
+func (topics *kZeroKMessageTopics) publish(topic string, payload []byte, retain bool) (int, error) {
+	err := checkKMessageTopic(topic)
+	if err != nil {
+		return 0, err
+	}
+	if retain {
+		topics.retain(topic, payload)
+	}
+	delivered := 0
+	for _, conn := range topics.list(topic) {
+		err := topics.deliver(conn, topic, payload, 0)
+		if err != nil {
+			global.Logger().Warn(fmt.Sprintf("zerov1 topic `%s` deliver to %s error : %s", topic, conn.RegisterId(), err.Error()))
+			continue
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+func (topics *kZeroKMessageTopics) deliver(conn *kZeroKMessageConnect, topic string, payload []byte, flags byte) error {
+	message, err := newKMessageTopicFrame(conn.UniqueKey(), xTOPIC_OP_PUBLISH, flags, topic, payload)
+	if err != nil {
+		return err
+	}
+	return conn.pushMessage(message)
+}
+
+func (topics *kZeroKMessageTopics) onTopic(conn *kZeroKMessageConnect, message *ZeroKMessage, operator ZeroKMessageOperator) error {
+	status, count, reason := byte(xTOPIC_STATUS_OK), 0, ""
+	var retained []byte
+	topicOperator, ok := operator.(ZeroKMessageTopicOperator)
+	op, flags, topic, payload, err := parseKMessageTopicFrame(message)
+	if err != nil {
+		status, reason = xTOPIC_STATUS_REJECTED, err.Error()
+	} else {
+		switch op {
+		case xTOPIC_OP_SUBSCRIBE:
+			if ok {
+				err = topicOperator.OnSubscribe(conn, topic)
+			}
+			if err == nil {
+				retained = topics.subscribe(conn, topic)
+			}
+		case xTOPIC_OP_UNSUBSCRIBE:
+			topics.unsubscribe(conn, topic)
+		case xTOPIC_OP_PUBLISH:
+			if ok {
+				err = topicOperator.OnPublish(conn, topic, payload)
+			}
+			if err == nil {
+				count, err = topics.publish(topic, payload, flags&xTOPIC_FLAG_RETAIN != 0)
+			}
+		default:
+			err = fmt.Errorf("unknown topic op 0x%02x", op)
+		}
+		if err != nil {
+			status, reason = xTOPIC_STATUS_REJECTED, err.Error()
+		}
+	}
+
+	ackMessage, err := newKMessageTopicAck(message, status, count, reason)
+	if err != nil {
+		return err
+	}
+	err = conn.pushMessage(ackMessage)
+	if err != nil || len(retained) <= 0 {
+		return err
+	}
+	return topics.deliver(conn, topic, retained, xTOPIC_FLAG_RETAIN)
+}
+
+func (topics *kZeroKMessageTopics) names(topic string) []string {
+	conns := topics.list(topic)
+	registerIds := make([]string, 0, len(conns))
+	for _, conn := range conns {
+		registerIds = append(registerIds, conn.RegisterId())
+	}
+	sort.Strings(registerIds)
+	return registerIds
+}
+
+type kZeroKMessageSubscriptions struct {
+	handlers map[string]ZeroKMessageTopicHandler
+	mutex    sync.RWMutex
+}
+
+func newKMessageSubscriptions() *kZeroKMessageSubscriptions {
+	return &kZeroKMessageSubscriptions{handlers: make(map[string]ZeroKMessageTopicHandler)}
+}
+
+func (subscriptions *kZeroKMessageSubscriptions) exec(ctx context.Context, exec xKMessageExec, uniquekey string, op byte, flags byte, topic string, payload []byte) (int, error) {
+	err := checkKMessageTopic(topic)
+	if err != nil {
+		return 0, err
+	}
+	message, err := newKMessageTopicFrame(uniquekey, op, flags, topic, payload)
+	if err != nil {
+		return 0, err
+	}
+	ackMessage, err := exec(ctx, message)
+	if err != nil {
+		return 0, err
+	}
+	return parseKMessageTopicAck(ackMessage)
+}
+
+func (subscriptions *kZeroKMessageSubscriptions) subscribe(ctx context.Context, exec xKMessageExec, uniquekey string, topic string, handler ZeroKMessageTopicHandler) error {
+	if handler == nil {
+		return errors.New(" topic handler is nil ")
+	}
+	subscriptions.mutex.Lock()
+	xHandler, exists := subscriptions.handlers[topic]
+	subscriptions.handlers[topic] = handler
+	subscriptions.mutex.Unlock()
+
+	_, err := subscriptions.exec(ctx, exec, uniquekey, xTOPIC_OP_SUBSCRIBE, 0, topic, nil)
+	if err != nil {
+		subscriptions.mutex.Lock()
+		if exists {
+			subscriptions.handlers[topic] = xHandler
+		} else {
+			delete(subscriptions.handlers, topic)
+		}
+		subscriptions.mutex.Unlock()
+	}
+	return err
+}
+
+func (subscriptions *kZeroKMessageSubscriptions) unsubscribe(ctx context.Context, exec xKMessageExec, uniquekey string, topic string) error {
+	subscriptions.mutex.Lock()
+	delete(subscriptions.handlers, topic)
+	subscriptions.mutex.Unlock()
+	_, err := subscriptions.exec(ctx, exec, uniquekey, xTOPIC_OP_UNSUBSCRIBE, 0, topic, nil)
+	return err
+}
+
+func (subscriptions *kZeroKMessageSubscriptions) publish(ctx context.Context, exec xKMessageExec, uniquekey string, topic string, payload []byte, retain bool) (int, error) {
+	var flags byte
+	if retain {
+		flags = xTOPIC_FLAG_RETAIN
+	}
+	return subscriptions.exec(ctx, exec, uniquekey, xTOPIC_OP_PUBLISH, flags, topic, payload)
+}
+
+func (subscriptions *kZeroKMessageSubscriptions) resubscribe(exec xKMessageExec, uniquekey string) {
+	subscriptions.mutex.RLock()
+	topics := make([]string, 0, len(subscriptions.handlers))
+	for topic := range subscriptions.handlers {
+		topics = append(topics, topic)
+	}
+	subscriptions.mutex.RUnlock()
+	for _, topic := range topics {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*xDEFAULT_TOPIC_RESUBSCRIBE_ACK)
+		_, err := subscriptions.exec(ctx, exec, uniquekey, xTOPIC_OP_SUBSCRIBE, 0, topic, nil)
+		cancel()
+		if err != nil {
+			global.Logger().Warn(fmt.Sprintf("0protocol/1.0 topic `%s` resubscribe error : %s", topic, err.Error()))
+		}
+	}
+}
+
+func (subscriptions *kZeroKMessageSubscriptions) onTopic(message *ZeroKMessage) {
+	op, flags, topic, payload, err := parseKMessageTopicFrame(message)
+	if err != nil || op != xTOPIC_OP_PUBLISH {
+		global.Logger().Debug(fmt.Sprintf("0protocol/1.0 topic ignore message \n%s", message.String()))
+		return
+	}
+	subscriptions.mutex.RLock()
+	handler, ok := subscriptions.handlers[topic]
+	subscriptions.mutex.RUnlock()
+	if !ok {
+		global.Logger().Debug(fmt.Sprintf("0protocol/1.0 topic `%s` not subscribed, ignore message %s", topic, message.MessageId()))
+		return
+	}
+	handler(topic, payload, flags&xTOPIC_FLAG_RETAIN != 0)
+}
@@ -0,0 +1,175 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func testKMessageFrame(t *testing.T, version byte, body []byte) []byte {
+	message, err := NewKMessageFrame(version, MESSAGE_TYPE_RPC, "", "k1", 0, 0, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message.Bytes()
+}
+
+func testKMessageLength(datas []byte, dataLength uint32) []byte {
+	xDatas := append([]byte{}, datas...)
+	binary.BigEndian.PutUint32(xDatas[6:10], dataLength)
+	return xDatas
+}
+
+func TestParseKMessageLength(t *testing.T) {
+	maxBodySize := kMessageMaxBodySize
+	kMessageMaxBodySize = 16
+	defer func() { kMessageMaxBodySize = maxBodySize }()
+
+	v1 := testKMessageFrame(t, KMESSAGE_VERSION_1, []byte("abc"))
+	v2 := testKMessageFrame(t, KMESSAGE_VERSION_2, []byte("abc"))
+	cases := []struct {
+		name   string
+		datas  []byte
+		length int
+	}{
+		{"short", v1[:9], -1},
+		{"bad head", append([]byte("Zero"), v1[4:]...), -1},
+		{"v1", v1, xV1_FIXED_LENGTH + 3},
+		{"v2", v2, xV2_FIXED_LENGTH + 3},
+		{"v1 below fixed header", testKMessageLength(v1, xV1_FIXED_LENGTH-1), -1},
+		{"v2 below fixed header", testKMessageLength(v2, xV1_FIXED_LENGTH), -1},
+		{"v1 max body", testKMessageLength(v1, xV1_FIXED_LENGTH+16), xV1_FIXED_LENGTH + 16},
+		{"v1 above max body", testKMessageLength(v1, xV1_FIXED_LENGTH+17), -1},
+		{"v2 above max body", testKMessageLength(v2, xV2_FIXED_LENGTH+17), -1},
+		{"uint32 max", testKMessageLength(v1, 0xFFFFFFFF), -1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if length := ParseKMessageLength(c.datas); length != c.length {
+				t.Fatalf("length %d expect %d", length, c.length)
+			}
+		})
+	}
+}
+
+func TestKMessageCheckerLength(t *testing.T) {
+	maxBodySize := kMessageMaxBodySize
+	kMessageMaxBodySize = 16
+	defer func() { kMessageMaxBodySize = maxBodySize }()
+
+	frame := testKMessageFrame(t, KMESSAGE_VERSION_1, []byte("abc"))
+	cases := []struct {
+		name  string
+		datas []byte
+	}{
+		{"oversize", testKMessageLength(frame, xV1_FIXED_LENGTH+17)},
+		{"undersize", testKMessageLength(frame, 10)},
+		{"zero", testKMessageLength(frame, 0)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			checker := &kZeroKMessageChecker{}
+			if pkgs := checker.CheckPackageData("test", c.datas[:40]); len(pkgs) != 0 {
+				t.Fatalf("invalid frame accepted %d", len(pkgs))
+			}
+			if checker.cachebytes != nil {
+				t.Fatalf("cache kept %d bytes", len(checker.cachebytes))
+			}
+			if pkgs := checker.CheckPackageData("test", c.datas[40:]); len(pkgs) != 0 {
+				t.Fatalf("invalid frame tail accepted %d", len(pkgs))
+			}
+
+			pkgs := checker.CheckPackageData("test", frame)
+			if len(pkgs) != 1 || string(ParseKMessage(pkgs[0]).MessageBody()) != "abc" {
+				t.Fatalf("valid frame after invalid %d", len(pkgs))
+			}
+		})
+	}
+}
+
+func TestSplitKMessageFramesLength(t *testing.T) {
+	frame := testKMessageFrame(t, KMESSAGE_VERSION_2, []byte("abc"))
+	datas := append(testKMessageLength(frame, 0xFFFFFFFF)[:20], frame...)
+	frames, remain := SplitKMessageFrames(datas)
+	if len(frames) != 1 || len(remain) != 0 || string(ParseKMessage(frames[0]).MessageBody()) != "abc" {
+		t.Fatalf("frames %d remain %d", len(frames), len(remain))
+	}
+}
+
+func TestKMessageV2RoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte("zero-framework "), 128)
+	cases := []struct {
+		name  string
+		flags byte
+	}{
+		{"plain", 0},
+		{"gzip", KMESSAGE_FLAG_GZIP},
+		{"deflate", KMESSAGE_FLAG_DEFLATE},
+		{"ack req", KMESSAGE_FLAG_ACK_REQ},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			message, err := NewKMessageFrame(KMESSAGE_VERSION_2, MESSAGE_TYPE_RPC, "", "k1", c.flags, 7, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			datas := message.Bytes()
+			if c.flags&xFLAG_COMPRESSION != 0 && len(datas) >= xV2_FIXED_LENGTH+len(body) {
+				t.Fatalf("compressed frame %d bytes", len(datas))
+			}
+			if ParseKMessageLength(datas) != len(datas) {
+				t.Fatalf("data length %d frame %d", ParseKMessageLength(datas), len(datas))
+			}
+
+			parsed := ParseKMessage(datas)
+			err = parsed.Check()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Version() != KMESSAGE_VERSION_2 || parsed.Flags() != c.flags || parsed.Sequence() != 7 ||
+				parsed.UniqueKey() != "k1" || parsed.MessageId() != message.MessageId() || !bytes.Equal(parsed.MessageBody(), body) {
+				t.Fatalf("parsed %s", parsed.String())
+			}
+			if !bytes.Equal(parsed.Bytes(), datas) {
+				t.Fatal("re-encoded frame differs")
+			}
+		})
+	}
+}
+
+func TestKMessageV2CheckSum(t *testing.T) {
+	datas := testKMessageFrame(t, KMESSAGE_VERSION_2, []byte("abcdef"))
+	zeroed := append([]byte{}, datas...)
+	copy(zeroed[len(zeroed)-8:len(zeroed)-4], make([]byte, 4))
+	if binary.BigEndian.Uint32(datas[len(datas)-8:len(datas)-4]) != crc32.ChecksumIEEE(zeroed) {
+		t.Fatal("checksum is not crc32 of the frame")
+	}
+	for _, offset := range []int{42, 75, 76, 84, len(datas) - 8} {
+		xDatas := append([]byte{}, datas...)
+		xDatas[offset] ^= 0xFF
+		if err := ParseKMessage(xDatas).Check(); err == nil {
+			t.Fatalf("corrupted byte %d passed check", offset)
+		}
+	}
+
+	xDatas := append([]byte{}, datas...)
+	xDatas[75] = KMESSAGE_FLAG_GZIP
+	if err := ParseKMessage(xDatas).Check(); err == nil {
+		t.Fatal("uncompressed body with gzip flag passed check")
+	}
+}
+
+func TestKMessageV2MaxBodySize(t *testing.T) {
+	body := bytes.Repeat([]byte{'z'}, 4096)
+	message, err := NewKMessageFrame(KMESSAGE_VERSION_2, MESSAGE_TYPE_RPC, "", "", KMESSAGE_FLAG_GZIP, 0, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxBodySize := kMessageMaxBodySize
+	kMessageMaxBodySize = 1024
+	defer func() { kMessageMaxBodySize = maxBodySize }()
+	if err := ParseKMessage(message.Bytes()).Check(); err == nil {
+		t.Fatal("decompressed body above max body size passed check")
+	}
+}
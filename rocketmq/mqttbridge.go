@@ -0,0 +1,252 @@
+package rocketmq
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/server"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	ROCKETMQ_MQTT_BRIDGE = "zero.rocketmq.mqttbridge"
+
+	BRIDGE_MQTT_TO_MQ = "mqtt2mq"
+	BRIDGE_MQ_TO_MQTT = "mq2mqtt"
+
+	BRIDGE_ENCODING_JSON   = "json"
+	BRIDGE_ENCODING_TEXT   = "text"
+	BRIDGE_ENCODING_BASE64 = "base64"
+)
+
+type MqttBridgeRule struct {
+	Direction   string `json:"direction,omitempty"`
+	MqttTopic   string `json:"mqttTopic,omitempty"`
+	Topic       string `json:"topic,omitempty"`
+	MessageType string `json:"messageType,omitempty"`
+	Qos         byte   `json:"qos,omitempty"`
+}
+
+type MQMqttEnvelope struct {
+	MqttTopic string      `json:"mqttTopic,omitempty"`
+	ClientId  string      `json:"clientId,omitempty"`
+	Qos       byte        `json:"qos"`
+	Encoding  string      `json:"encoding,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Origin    string      `json:"origin,omitempty"`
+}
+
+func (envelope *MQMqttEnvelope) encode(data []byte) {
+	if json.Valid(data) {
+		envelope.Encoding = BRIDGE_ENCODING_JSON
+		envelope.Data = json.RawMessage(data)
+	} else if utf8.Valid(data) {
+		envelope.Encoding = BRIDGE_ENCODING_TEXT
+		envelope.Data = string(data)
+	} else {
+		envelope.Encoding = BRIDGE_ENCODING_BASE64
+		envelope.Data = base64.StdEncoding.EncodeToString(data)
+	}
+}
+
+func (envelope *MQMqttEnvelope) Bytes() ([]byte, error) {
+	switch envelope.Encoding {
+	case BRIDGE_ENCODING_TEXT:
+		return []byte(fmt.Sprintf("%v", envelope.Data)), nil
+	case BRIDGE_ENCODING_BASE64:
+		return base64.StdEncoding.DecodeString(fmt.Sprintf("%v", envelope.Data))
+	default:
+		return json.Marshal(envelope.Data)
+	}
+}
+
+type MqttBridge struct {
+	nodeId string
+	rules  []*MqttBridgeRule
+}
+
+func NewMqttBridge(rules ...*MqttBridgeRule) (*MqttBridge, error) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.Direction != BRIDGE_MQTT_TO_MQ && rule.Direction != BRIDGE_MQ_TO_MQTT {
+			return nil, fmt.Errorf("mqtt bridge unknown direction `%s`", rule.Direction)
+		}
+		if len(rule.Topic) <= 0 {
+			return nil, fmt.Errorf("mqtt bridge rule `%s` without rocketmq topic", rule.MqttTopic)
+		}
+	}
+	return &MqttBridge{
+		nodeId: uid.String(),
+		rules:  rules,
+	}, nil
+}
+
+func (bridge *MqttBridge) Name() string {
+	return ROCKETMQ_MQTT_BRIDGE
+}
+
+func (bridge *MqttBridge) Topics() []string {
+	xTopics := make([]string, 0)
+	for _, rule := range bridge.rules {
+		if rule.Direction == BRIDGE_MQ_TO_MQTT {
+			xTopics = append(xTopics, rule.Topic)
+		}
+	}
+	return xTopics
+}
+
+func (bridge *MqttBridge) Publish(conn server.ZeroConnect, mqttMessage *server.MqttMessage) error {
+	topic := mqttMessage.VariableHeader().(*server.MqttPublishVariableHeader).Topic()
+	qos := mqttMessage.FixedHeader().Qos()
+	for _, rule := range bridge.rules {
+		if rule.Direction != BRIDGE_MQTT_TO_MQ || !server.MqttTopicMatch(rule.MqttTopic, topic) {
+			continue
+		}
+
+		envelope := &MQMqttEnvelope{
+			MqttTopic: topic,
+			Qos:       qos,
+			Origin:    bridge.nodeId,
+		}
+		if mqttconn, ok := conn.(*server.MqttConnect); ok {
+			envelope.ClientId = mqttconn.ClientId()
+		}
+		envelope.encode(mqttMessage.Payload().Payload())
+
+		keeper := global.Value(ROCKETMQ_KEEPER).(*RocketmqKeeper)
+		message, err := keeper.NewMessage(rule.Topic, rule.MessageType, envelope)
+		if err != nil {
+			return err
+		}
+		if qos == server.Qos0 {
+			err = keeper.SendMessageOneway(message)
+		} else {
+			err = keeper.SendMessage(message)
+		}
+		if err != nil {
+			return err
+		}
+		global.Logger().Debug(fmt.Sprintf("mqtt bridge `%s` -> rocketmq `%s` type `%s`", topic, rule.Topic, rule.MessageType))
+	}
+	return nil
+}
+
+func (bridge *MqttBridge) envelope(message *MQNotifyMessage) (*MQMqttEnvelope, error) {
+	envelope := &MQMqttEnvelope{}
+	if payload, ok := message.Payload.(map[string]interface{}); ok {
+		if _, ok := payload["mqttTopic"]; ok {
+			jsonbytes, err := json.Marshal(payload)
+			if err != nil {
+				return nil, err
+			}
+			err = json.Unmarshal(jsonbytes, envelope)
+			if err != nil {
+				return nil, err
+			}
+			return envelope, nil
+		}
+	}
+	if text, ok := message.Payload.(string); ok {
+		envelope.Encoding = BRIDGE_ENCODING_TEXT
+		envelope.Data = text
+	} else {
+		envelope.Encoding = BRIDGE_ENCODING_JSON
+		envelope.Data = message.Payload
+	}
+	return envelope, nil
+}
+
+func (bridge *MqttBridge) target(rule *MqttBridgeRule, message *MQNotifyMessage, envelope *MQMqttEnvelope) string {
+	mqttTopic := envelope.MqttTopic
+	if len(mqttTopic) <= 0 || (len(rule.MqttTopic) > 0 && !server.MqttTopicMatch(rule.MqttTopic, mqttTopic)) {
+		mqttTopic = rule.MqttTopic
+	}
+	if len(mqttTopic) <= 0 || strings.ContainsAny(mqttTopic, "+#") {
+		global.Logger().Warn(fmt.Sprintf("mqtt bridge rocketmq `%s` message %s without mqtt topic", message.Topic, message.MessageId))
+		return ""
+	}
+	if envelope.Origin == bridge.nodeId && mqttTopic == envelope.MqttTopic {
+		return ""
+	}
+	return mqttTopic
+}
+
+func (bridge *MqttBridge) OnMessage(message *MQNotifyMessage) error {
+	var envelope *MQMqttEnvelope
+	for _, rule := range bridge.rules {
+		if rule.Direction != BRIDGE_MQ_TO_MQTT || rule.Topic != message.Topic {
+			continue
+		}
+		if len(rule.MessageType) > 0 && rule.MessageType != message.MessageType {
+			continue
+		}
+
+		if envelope == nil {
+			xEnvelope, err := bridge.envelope(message)
+			if err != nil {
+				return err
+			}
+			envelope = xEnvelope
+		}
+
+		mqttTopic := bridge.target(rule, message, envelope)
+		if len(mqttTopic) <= 0 {
+			continue
+		}
+
+		data, err := envelope.Bytes()
+		if err != nil {
+			return err
+		}
+		mqttserv, ok := global.Value(server.CORE_MQTT_SERVER).(*server.MqttServer)
+		if !ok {
+			return fmt.Errorf("mqtt bridge mqtt server not running")
+		}
+		mqttserv.Publish(mqttTopic, rule.Qos, data)
+		global.Logger().Debug(fmt.Sprintf("mqtt bridge rocketmq `%s` type `%s` -> `%s`", message.Topic, message.MessageType, mqttTopic))
+	}
+	return nil
+}
+
+func (bridge *MqttBridge) attach() {
+	for !global.Contains(server.CORE_MQTT_SERVER) {
+		<-time.After(time.Second)
+	}
+	global.Value(server.CORE_MQTT_SERVER).(*server.MqttServer).AddPublishListeners(bridge)
+	global.Logger().Info(fmt.Sprintf("mqtt bridge attached, %d rules", len(bridge.rules)))
+}
+
+func initMqttBridge() *MqttBridge {
+	xRules := global.Find("zero.rocketmq.bridge")
+	if xRules == nil {
+		return nil
+	}
+	rules := make([]*MqttBridgeRule, 0)
+	jsonbytes, err := json.Marshal(xRules)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(jsonbytes, &rules)
+	if err != nil {
+		panic(err)
+	}
+	if len(rules) <= 0 {
+		return nil
+	}
+
+	bridge, err := NewMqttBridge(rules...)
+	if err != nil {
+		panic(err)
+	}
+	global.Key(ROCKETMQ_MQTT_BRIDGE, bridge)
+	go bridge.attach()
+	return bridge
+}
@@ -0,0 +1,179 @@
+package rocketmq
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestMQMqttEnvelopeEncode(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     []byte
+		encoding string
+	}{
+		{"json object", []byte(`{"a":1,"b":[true,"x"]}`), BRIDGE_ENCODING_JSON},
+		{"json number", []byte(`42`), BRIDGE_ENCODING_JSON},
+		{"text", []byte("temperature 21.5"), BRIDGE_ENCODING_TEXT},
+		{"utf8 text", []byte("温度 21.5"), BRIDGE_ENCODING_TEXT},
+		{"binary", []byte{0x00, 0xFF, 0xFE, 0x80}, BRIDGE_ENCODING_BASE64},
+	}
+	bridge, err := NewMqttBridge(&MqttBridgeRule{Direction: BRIDGE_MQ_TO_MQTT, MqttTopic: "sensors/+", Topic: "SENSORS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			envelope := &MQMqttEnvelope{MqttTopic: "sensors/t1", ClientId: "c1", Qos: 1, Origin: "node"}
+			envelope.encode(c.data)
+			if envelope.Encoding != c.encoding {
+				t.Fatalf("expected encoding `%s` got `%s`", c.encoding, envelope.Encoding)
+			}
+			data, err := envelope.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, c.data) {
+				t.Fatalf("expected `%s` got `%s`", c.data, data)
+			}
+
+			jsonbytes, err := json.Marshal(envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			message := &MQNotifyMessage{Topic: "SENSORS"}
+			err = json.Unmarshal([]byte(`{"payload":`+string(jsonbytes)+`}`), message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			xEnvelope, err := bridge.envelope(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if xEnvelope.MqttTopic != envelope.MqttTopic || xEnvelope.ClientId != envelope.ClientId ||
+				xEnvelope.Qos != envelope.Qos || xEnvelope.Origin != envelope.Origin || xEnvelope.Encoding != envelope.Encoding {
+				t.Fatalf("unexpected envelope %+v", xEnvelope)
+			}
+			data, err = xEnvelope.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, c.data) {
+				t.Fatalf("expected `%s` after decode got `%s`", c.data, data)
+			}
+		})
+	}
+}
+
+func TestMqttBridgePlainPayload(t *testing.T) {
+	bridge, err := NewMqttBridge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		payload  interface{}
+		encoding string
+		data     string
+	}{
+		{"hello", BRIDGE_ENCODING_TEXT, "hello"},
+		{map[string]interface{}{"value": 1.5}, BRIDGE_ENCODING_JSON, `{"value":1.5}`},
+		{[]interface{}{"a", "b"}, BRIDGE_ENCODING_JSON, `["a","b"]`},
+	}
+	for _, c := range cases {
+		envelope, err := bridge.envelope(&MQNotifyMessage{Payload: c.payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Encoding != c.encoding || len(envelope.MqttTopic) > 0 {
+			t.Fatalf("unexpected envelope %+v", envelope)
+		}
+		data, err := envelope.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.data {
+			t.Fatalf("expected `%s` got `%s`", c.data, data)
+		}
+	}
+}
+
+func TestMqttBridgeTarget(t *testing.T) {
+	bridge, err := NewMqttBridge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name      string
+		ruleTopic string
+		mqttTopic string
+		origin    string
+		target    string
+	}{
+		{"envelope topic", "sensors/+", "sensors/t1", "other", "sensors/t1"},
+		{"envelope topic without rule", "", "sensors/t1", "other", "sensors/t1"},
+		{"rule topic fallback", "alerts/all", "", "", "alerts/all"},
+		{"rule topic on mismatch", "alerts/all", "sensors/t1", "other", "alerts/all"},
+		{"wildcard rule without topic", "sensors/+", "", "", ""},
+		{"no topic", "", "", "", ""},
+		{"own origin loop", "sensors/+", "sensors/t1", bridge.nodeId, ""},
+		{"own origin rewritten", "alerts/all", "sensors/t1", bridge.nodeId, "alerts/all"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rule := &MqttBridgeRule{Direction: BRIDGE_MQ_TO_MQTT, MqttTopic: c.ruleTopic, Topic: "SENSORS"}
+			envelope := &MQMqttEnvelope{MqttTopic: c.mqttTopic, Origin: c.origin}
+			target := bridge.target(rule, &MQNotifyMessage{Topic: "SENSORS"}, envelope)
+			if target != c.target {
+				t.Fatalf("expected target `%s` got `%s`", c.target, target)
+			}
+		})
+	}
+}
+
+func TestMqttBridgeOnMessageLoop(t *testing.T) {
+	bridge, err := NewMqttBridge(&MqttBridgeRule{Direction: BRIDGE_MQ_TO_MQTT, MqttTopic: "sensors/+", Topic: "SENSORS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := map[string]interface{}{"mqttTopic": "sensors/t1", "encoding": BRIDGE_ENCODING_TEXT, "data": "x", "origin": bridge.nodeId}
+	err = bridge.OnMessage(&MQNotifyMessage{Topic: "SENSORS", Payload: payload})
+	if err != nil {
+		t.Fatalf("own message expected to be dropped, got %s", err.Error())
+	}
+
+	payload["origin"] = "other"
+	err = bridge.OnMessage(&MQNotifyMessage{Topic: "SENSORS", Payload: payload})
+	if err == nil {
+		t.Fatal("foreign message expected to reach the mqtt server")
+	}
+
+	err = bridge.OnMessage(&MQNotifyMessage{Topic: "OTHER", Payload: payload})
+	if err != nil {
+		t.Fatalf("unbridged topic expected to be ignored, got %s", err.Error())
+	}
+}
+
+func TestNewMqttBridgeRules(t *testing.T) {
+	cases := []struct {
+		rule *MqttBridgeRule
+		ok   bool
+	}{
+		{&MqttBridgeRule{Direction: BRIDGE_MQTT_TO_MQ, MqttTopic: "a/#", Topic: "A"}, true},
+		{&MqttBridgeRule{Direction: BRIDGE_MQ_TO_MQTT, Topic: "A"}, true},
+		{&MqttBridgeRule{Direction: "both", Topic: "A"}, false},
+		{&MqttBridgeRule{Direction: BRIDGE_MQTT_TO_MQ, MqttTopic: "a/#"}, false},
+	}
+	for _, c := range cases {
+		_, err := NewMqttBridge(c.rule)
+		if (err == nil) != c.ok {
+			t.Fatalf("rule %+v expected ok %v got %v", c.rule, c.ok, err)
+		}
+	}
+	bridge, _ := NewMqttBridge(
+		&MqttBridgeRule{Direction: BRIDGE_MQTT_TO_MQ, MqttTopic: "a/#", Topic: "A"},
+		&MqttBridgeRule{Direction: BRIDGE_MQ_TO_MQTT, Topic: "B"},
+	)
+	if topics := bridge.Topics(); len(topics) != 1 || topics[0] != "B" {
+		t.Fatalf("unexpected topics %v", topics)
+	}
+}
@@ -22,6 +22,7 @@ const (
 
 var (
 	notifyPushConsumer rocketmq.PushConsumer
+	bridgePushConsumer rocketmq.PushConsumer
 	notifyProducer     rocketmq.Producer
 
 	nameserv    string
@@ -76,6 +77,8 @@ func InitRocketMQ(newObservers ...MQMessageObserver) {
 		observers[obs.Name()] = obs
 	}
 
+	bridge := initMqttBridge()
+
 	uid, err := uuid.NewV4()
 	if err != nil {
 		panic(err)
@@ -109,6 +112,9 @@ func InitRocketMQ(newObservers ...MQMessageObserver) {
 
 	<-time.After(time.Second * time.Duration(1))
 	initRocketMQConsumer()
+	if bridge != nil {
+		initBridgeConsumer(bridge)
+	}
 }
 
 func initRocketMQConsumer() {
@@ -134,6 +140,47 @@ func initRocketMQConsumer() {
 	notifyPushConsumer = _notifyPushConsumer
 }
 
+func initBridgeConsumer(bridge *MqttBridge) {
+	_bridgePushConsumer, err := rocketmq.NewPushConsumer(
+		consumer.WithGroupName(fmt.Sprintf("%s-mqttbridge", groupName)),
+		consumer.WithNsResolver(primitive.NewPassthroughResolver([]string{nameserv})),
+		consumer.WithConsumerModel(consumer.BroadCasting),
+		consumer.WithConsumeFromWhere(consumer.ConsumeFromLastOffset))
+	if err != nil {
+		panic(err)
+	}
+
+	onBridgeMessage := func(_ context.Context, messages ...*primitive.MessageExt) (consumer.ConsumeResult, error) {
+		for _, message := range messages {
+			notify := MQNotifyMessage{}
+			err := notify.WithJSONString(message.Body)
+			if err != nil {
+				global.Logger().Error(fmt.Sprintf(" mqtt bridge parse error %s", err.Error()))
+				continue
+			}
+			if notify.MessageType == MESSAGE_TYPE_TEST {
+				continue
+			}
+			err = bridge.OnMessage(&notify)
+			if err != nil {
+				global.Logger().Error(fmt.Sprintf(" mqtt bridge error %s", err.Error()))
+			}
+		}
+		return consumer.ConsumeSuccess, nil
+	}
+	for _, topic := range bridge.Topics() {
+		err = _bridgePushConsumer.Subscribe(topic, consumer.MessageSelector{}, onBridgeMessage)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = _bridgePushConsumer.Start()
+	if err != nil {
+		panic(err)
+	}
+	bridgePushConsumer = _bridgePushConsumer
+}
+
 func ShutdownNotifyConsumer() {
 	if notifyPushConsumer != nil {
 		err := notifyPushConsumer.Shutdown()
@@ -141,6 +188,12 @@ func ShutdownNotifyConsumer() {
 			panic(err)
 		}
 	}
+	if bridgePushConsumer != nil {
+		err := bridgePushConsumer.Shutdown()
+		if err != nil {
+			panic(err)
+		}
+	}
 	global.Pop(ROCKETMQ_KEEPER)
 }
 
@@ -189,6 +242,17 @@ func (keeper *RocketmqKeeper) SendMessage(message *MQNotifyMessage) error {
 	return nil
 }
 
+func (keeper *RocketmqKeeper) SendMessageOneway(message *MQNotifyMessage) error {
+	jsonBytes, err := message.JSONString()
+	if err != nil {
+		return err
+	}
+	return notifyProducer.SendOneWay(context.Background(), &primitive.Message{
+		Topic: message.Topic,
+		Body:  jsonBytes,
+	})
+}
+
 func (keeper *RocketmqKeeper) NewMessage(topic string, messageType string, payload interface{}) (*MQNotifyMessage, error) {
 	message := &MQNotifyMessage{}
 	err := message.NewMessage(topic, messageType, payload)
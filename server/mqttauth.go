@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/0meet1/zero-framework/database"
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/processors"
+	"github.com/0meet1/zero-framework/structs"
+)
+
+const (
+	MQTT_ACL_PUBLISH   = "publish"
+	MQTT_ACL_SUBSCRIBE = "subscribe"
+	MQTT_ACL_ALL       = "all"
+
+	MQTT_ACL_ALLOW = "allow"
+	MQTT_ACL_DENY  = "deny"
+
+	MQTT_ACL_ANY           = "*"
+	MQTT_ACL_HOLD_CLIENTID = "%c"
+	MQTT_ACL_HOLD_USERNAME = "%u"
+)
+
+type MqttAuthenticator interface {
+	Authenticate(clientId string, username string, password []byte) byte
+}
+
+type MqttAclChecker interface {
+	CanPublish(clientId string, username string, topic string) bool
+	CanSubscribe(clientId string, username string, topicFilter string) bool
+}
+
+type MqttAclRule struct {
+	ClientId   string `json:"clientId,omitempty"`
+	UserName   string `json:"username,omitempty"`
+	Topic      string `json:"topic,omitempty"`
+	Action     string `json:"action,omitempty"`
+	Permission string `json:"permission,omitempty"`
+}
+
+func (rule *MqttAclRule) matchWho(clientId string, username string) bool {
+	if len(rule.ClientId) > 0 && rule.ClientId != MQTT_ACL_ANY && rule.ClientId != clientId {
+		return false
+	}
+	if len(rule.UserName) > 0 && rule.UserName != MQTT_ACL_ANY && rule.UserName != username {
+		return false
+	}
+	return true
+}
+
+func (rule *MqttAclRule) matchAction(action string) bool {
+	xAction := strings.ToLower(strings.TrimSpace(rule.Action))
+	return len(xAction) <= 0 || xAction == MQTT_ACL_ALL || xAction == action
+}
+
+func (rule *MqttAclRule) filter(clientId string, username string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(rule.Topic, MQTT_ACL_HOLD_CLIENTID, clientId), MQTT_ACL_HOLD_USERNAME, username)
+}
+
+func (rule *MqttAclRule) allow() bool {
+	return strings.ToLower(rule.Permission) != MQTT_ACL_DENY
+}
+
+type MqttAclRules struct {
+	rules      []*MqttAclRule
+	rulesMutex sync.RWMutex
+
+	defaultAllow bool
+	loader       func() ([]*MqttAclRule, error)
+}
+
+func (acl *MqttAclRules) check(action string, clientId string, username string, matcher func(string) bool) bool {
+	acl.rulesMutex.RLock()
+	defer acl.rulesMutex.RUnlock()
+	for _, rule := range acl.rules {
+		if !rule.matchWho(clientId, username) || !rule.matchAction(action) {
+			continue
+		}
+		if matcher(rule.filter(clientId, username)) {
+			return rule.allow()
+		}
+	}
+	return acl.defaultAllow
+}
+
+func (acl *MqttAclRules) CanPublish(clientId string, username string, topic string) bool {
+	return acl.check(MQTT_ACL_PUBLISH, clientId, username, func(filter string) bool {
+		return MqttTopicMatch(filter, topic)
+	})
+}
+
+func (acl *MqttAclRules) CanSubscribe(clientId string, username string, topicFilter string) bool {
+	return acl.check(MQTT_ACL_SUBSCRIBE, clientId, username, func(filter string) bool {
+		return MqttTopicCovers(filter, topicFilter)
+	})
+}
+
+func (acl *MqttAclRules) Rules() []*MqttAclRule {
+	acl.rulesMutex.RLock()
+	defer acl.rulesMutex.RUnlock()
+	rules := make([]*MqttAclRule, len(acl.rules))
+	copy(rules, acl.rules)
+	return rules
+}
+
+func (acl *MqttAclRules) Reload() error {
+	if acl.loader == nil {
+		return nil
+	}
+	rules, err := acl.loader()
+	if err != nil {
+		return err
+	}
+	acl.rulesMutex.Lock()
+	acl.rules = rules
+	acl.rulesMutex.Unlock()
+	global.Logger().Infof("mqtt acl reload %d rules", len(rules))
+	return nil
+}
+
+func NewMqttAclRules(defaultAllow bool, rules ...*MqttAclRule) *MqttAclRules {
+	return &MqttAclRules{
+		rules:        rules,
+		defaultAllow: defaultAllow,
+	}
+}
+
+func NewMqttConfigAcl(prefix string) (*MqttAclRules, error) {
+	acl := &MqttAclRules{
+		defaultAllow: strings.ToLower(global.StringValue(fmt.Sprintf("%s.default", prefix))) != MQTT_ACL_DENY,
+		loader: func() ([]*MqttAclRule, error) {
+			rules := make([]*MqttAclRule, 0)
+			xRules := global.Find(fmt.Sprintf("%s.rules", prefix))
+			if xRules == nil {
+				return rules, nil
+			}
+			jsonbytes, err := json.Marshal(xRules)
+			if err != nil {
+				return nil, err
+			}
+			err = json.Unmarshal(jsonbytes, &rules)
+			if err != nil {
+				return nil, err
+			}
+			return rules, nil
+		},
+	}
+	return acl, acl.Reload()
+}
+
+func NewMqttDataSourceAcl(dataSource database.DataSource, tableName string, defaultAllow bool) (*MqttAclRules, error) {
+	acl := &MqttAclRules{
+		defaultAllow: defaultAllow,
+		loader: func() ([]*MqttAclRule, error) {
+			transaction := dataSource.Transaction()
+			defer transaction.Commit()
+
+			processor := &processors.ZeroCoreProcessor{}
+			processor.Build(transaction)
+
+			rows, err := processor.PreparedStmt(fmt.Sprintf("SELECT client_id, user_name, topic, action, permission FROM %s ORDER BY priority", tableName)).Query()
+			defer func() {
+				if rows != nil {
+					rows.Close()
+				}
+			}()
+			if err != nil {
+				return nil, err
+			}
+
+			rowsmap := processor.Parser(rows)
+			rules := make([]*MqttAclRule, len(rowsmap))
+			for i, row := range rowsmap {
+				rules[i] = &MqttAclRule{
+					ClientId:   structs.ParseStringField(row, "client_id"),
+					UserName:   structs.ParseStringField(row, "user_name"),
+					Topic:      structs.ParseStringField(row, "topic"),
+					Action:     structs.ParseStringField(row, "action"),
+					Permission: structs.ParseStringField(row, "permission"),
+				}
+			}
+			return rules, nil
+		},
+	}
+	return acl, acl.Reload()
+}
@@ -0,0 +1,58 @@
+package server
+
+import "testing"
+
+func TestMqttAclFirstMatch(t *testing.T) {
+	acl := NewMqttAclRules(false,
+		&MqttAclRule{ClientId: "banned", Topic: "#", Permission: MQTT_ACL_DENY},
+		&MqttAclRule{Topic: "devices/%c/#", Action: MQTT_ACL_ALL, Permission: MQTT_ACL_ALLOW},
+		&MqttAclRule{Topic: "users/%u/inbox", Action: MQTT_ACL_SUBSCRIBE, Permission: MQTT_ACL_ALLOW},
+		&MqttAclRule{UserName: "admin", Topic: "#", Permission: MQTT_ACL_ALLOW},
+		&MqttAclRule{Topic: "devices/#", Action: MQTT_ACL_PUBLISH, Permission: MQTT_ACL_DENY},
+		&MqttAclRule{Topic: "public/#", Action: MQTT_ACL_PUBLISH},
+	)
+
+	cases := []struct {
+		name     string
+		action   string
+		clientId string
+		username string
+		topic    string
+		allowed  bool
+	}{
+		{"deny before allow", MQTT_ACL_PUBLISH, "banned", "admin", "public/a", false},
+		{"client placeholder publish", MQTT_ACL_PUBLISH, "c1", "u1", "devices/c1/state", true},
+		{"client placeholder subscribe", MQTT_ACL_SUBSCRIBE, "c1", "u1", "devices/c1/+", true},
+		{"client placeholder other client", MQTT_ACL_PUBLISH, "c1", "u1", "devices/c2/state", false},
+		{"username placeholder", MQTT_ACL_SUBSCRIBE, "c1", "u1", "users/u1/inbox", true},
+		{"username placeholder other user", MQTT_ACL_SUBSCRIBE, "c1", "u1", "users/u2/inbox", false},
+		{"username placeholder wrong action", MQTT_ACL_PUBLISH, "c1", "u1", "users/u1/inbox", false},
+		{"admin wins over later deny", MQTT_ACL_PUBLISH, "c1", "admin", "devices/c2/state", true},
+		{"empty permission allows", MQTT_ACL_PUBLISH, "c1", "u1", "public/news", true},
+		{"wider subscribe not covered", MQTT_ACL_SUBSCRIBE, "c1", "u1", "devices/#", false},
+		{"default", MQTT_ACL_SUBSCRIBE, "c1", "u1", "other/topic", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var allowed bool
+			if c.action == MQTT_ACL_PUBLISH {
+				allowed = acl.CanPublish(c.clientId, c.username, c.topic)
+			} else {
+				allowed = acl.CanSubscribe(c.clientId, c.username, c.topic)
+			}
+			if allowed != c.allowed {
+				t.Fatalf("%s %s/%s %s : expected %v got %v", c.action, c.clientId, c.username, c.topic, c.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestMqttAclDefaultAllow(t *testing.T) {
+	acl := NewMqttAclRules(true, &MqttAclRule{Topic: "secret/#", Permission: MQTT_ACL_DENY})
+	if !acl.CanPublish("c1", "u1", "open/topic") {
+		t.Fatal("expected default allow")
+	}
+	if acl.CanSubscribe("c1", "u1", "secret/+/x") {
+		t.Fatal("expected deny for covered filter")
+	}
+}
@@ -0,0 +1,509 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+)
+
+const (
+	MQTT_CLIENT_DEFAULT_KEEPALIVE   = 60
+	MQTT_CLIENT_DEFAULT_ACK_SECONDS = 10
+	MQTT_CLIENT_DEFAULT_AUTH_WAIT   = 10
+	MQTT_CLIENT_DEFAULT_BUFFER_SIZE = 4096
+)
+
+type MqttClientMessageListener interface {
+	Publish(ZeroClientConnect, *MqttMessage) error
+}
+
+type xMqttClientListener struct{}
+
+func (xListener *xMqttClientListener) OnConnect(conn ZeroClientConnect) error {
+	return conn.(*MqttClient).sendConnect()
+}
+
+func (xListener *xMqttClientListener) OnHeartbeat(conn ZeroClientConnect) error {
+	message := &MqttMessage{}
+	message.MakePingreqMessage()
+	return conn.(*MqttClient).Write(message.Bytes())
+}
+
+type xMqttSubscription struct {
+	qos       byte
+	xListener MqttClientMessageListener
+}
+
+type xMqttInflight struct {
+	topic    string
+	qos      byte
+	data     []byte
+	released bool
+	done     chan *MqttMessage
+}
+
+type MqttClient struct {
+	TCPClient
+
+	clientId     string
+	username     string
+	password     []byte
+	will         *MqttWill
+	keepAlive    uint16
+	cleanSession bool
+	ackSeconds   int64
+
+	connected      bool
+	sessionPresent bool
+
+	messageSerialNnumber uint16
+	serialNnumberMutex   sync.Mutex
+
+	subscriptions      map[string]*xMqttSubscription
+	subscriptionsMutex sync.RWMutex
+
+	inflights      map[uint16]*xMqttInflight
+	pendings       map[uint16]chan *MqttMessage
+	received       map[uint16]bool
+	inflightsMutex sync.Mutex
+
+	xListener MqttClientMessageListener
+}
+
+func (client *MqttClient) This() interface{} {
+	if client.ZeroMeta.This() == nil {
+		client.ThisDef(client)
+	}
+	return client.ZeroMeta.This()
+}
+
+func (client *MqttClient) Active() bool {
+	return client.TCPClient.Active() && client.connected
+}
+
+func (client *MqttClient) ClientId() string {
+	return client.clientId
+}
+
+func (client *MqttClient) SessionPresent() bool {
+	return client.sessionPresent
+}
+
+func (client *MqttClient) UseCredentials(username string, password []byte) *MqttClient {
+	client.username = username
+	client.password = password
+	return client
+}
+
+func (client *MqttClient) UseWill(topic string, qos byte, retain bool, message []byte) *MqttClient {
+	client.will = &MqttWill{
+		Topic:   topic,
+		Message: message,
+		Qos:     qos,
+		Retain:  retain,
+	}
+	return client
+}
+
+func (client *MqttClient) UseCleanSession(cleanSession bool) *MqttClient {
+	client.cleanSession = cleanSession
+	return client
+}
+
+func (client *MqttClient) UseAckSeconds(ackSeconds int64) *MqttClient {
+	client.ackSeconds = ackSeconds
+	return client
+}
+
+func (client *MqttClient) AddMessageListener(xListener MqttClientMessageListener) {
+	client.xListener = xListener
+}
+
+func (client *MqttClient) UseSerialNnumber() uint16 {
+	client.serialNnumberMutex.Lock()
+	defer client.serialNnumberMutex.Unlock()
+	for {
+		client.messageSerialNnumber++
+		if client.messageSerialNnumber == 0 {
+			continue
+		}
+		client.inflightsMutex.Lock()
+		_, inflight := client.inflights[client.messageSerialNnumber]
+		_, pending := client.pendings[client.messageSerialNnumber]
+		client.inflightsMutex.Unlock()
+		if !inflight && !pending {
+			return client.messageSerialNnumber
+		}
+	}
+}
+
+func (client *MqttClient) sendConnect() error {
+	client.connected = false
+	message := &MqttMessage{}
+	message.MakeConnectMessage(client.clientId, client.keepAlive, client.cleanSession, client.username, client.password, client.will)
+	err := client.Write(message.Bytes())
+	if err != nil {
+		return err
+	}
+	global.Logger().Debug(fmt.Sprintf("mqtt client %s send connect, clientId `%s`", client.RemoteAddr(), client.clientId))
+	return nil
+}
+
+func (client *MqttClient) await(identifier uint16, ch chan *MqttMessage) (*MqttMessage, error) {
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-time.After(time.Second * time.Duration(client.ackSeconds)):
+		return nil, fmt.Errorf("mqtt client %s message %d ack timeout", client.RemoteAddr(), identifier)
+	}
+}
+
+func (client *MqttClient) request(identifier uint16, datas []byte) (*MqttMessage, error) {
+	ch := make(chan *MqttMessage, 1)
+	client.inflightsMutex.Lock()
+	client.pendings[identifier] = ch
+	client.inflightsMutex.Unlock()
+	defer func() {
+		client.inflightsMutex.Lock()
+		delete(client.pendings, identifier)
+		client.inflightsMutex.Unlock()
+	}()
+
+	err := client.Write(datas)
+	if err != nil {
+		return nil, err
+	}
+	return client.await(identifier, ch)
+}
+
+func (client *MqttClient) subscribe(topics []*MqttTopic) ([]byte, error) {
+	identifier := client.UseSerialNnumber()
+	message := &MqttMessage{}
+	message.MakeSubscribeMessage(identifier, topics)
+	resp, err := client.request(identifier, message.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return resp.Payload().(*MqttPayload).Payload(), nil
+}
+
+func (client *MqttClient) Subscribe(topic string, qos byte, xListener ...MqttClientMessageListener) error {
+	subscription := &xMqttSubscription{qos: qos}
+	if len(xListener) > 0 {
+		subscription.xListener = xListener[0]
+	}
+	client.subscriptionsMutex.Lock()
+	client.subscriptions[topic] = subscription
+	client.subscriptionsMutex.Unlock()
+
+	if !client.Active() {
+		return nil
+	}
+	results, err := client.subscribe([]*MqttTopic{{TopicName: topic, Qos: qos}})
+	if err != nil {
+		return err
+	}
+	if len(results) <= 0 || results[0] >= SUBACK_FAILURE {
+		client.subscriptionsMutex.Lock()
+		delete(client.subscriptions, topic)
+		client.subscriptionsMutex.Unlock()
+		return fmt.Errorf("mqtt client %s subscribe `%s` refused", client.RemoteAddr(), topic)
+	}
+	return nil
+}
+
+func (client *MqttClient) Unsubscribe(topics ...string) error {
+	client.subscriptionsMutex.Lock()
+	for _, topic := range topics {
+		delete(client.subscriptions, topic)
+	}
+	client.subscriptionsMutex.Unlock()
+
+	if !client.Active() {
+		return nil
+	}
+	identifier := client.UseSerialNnumber()
+	message := &MqttMessage{}
+	message.MakeUnsubscribeMessage(identifier, topics)
+	_, err := client.request(identifier, message.Bytes())
+	return err
+}
+
+func (client *MqttClient) resubscribe() {
+	client.subscriptionsMutex.RLock()
+	topics := make([]*MqttTopic, 0, len(client.subscriptions))
+	for topic, subscription := range client.subscriptions {
+		topics = append(topics, &MqttTopic{TopicName: topic, Qos: subscription.qos})
+	}
+	client.subscriptionsMutex.RUnlock()
+	if len(topics) <= 0 {
+		return
+	}
+
+	results, err := client.subscribe(topics)
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("mqtt client %s resubscribe error %s", client.RemoteAddr(), err.Error()))
+		return
+	}
+	for i, topic := range topics {
+		if i >= len(results) || results[i] >= SUBACK_FAILURE {
+			global.Logger().Warn(fmt.Sprintf("mqtt client %s resubscribe `%s` refused", client.RemoteAddr(), topic.TopicName))
+		}
+	}
+	global.Logger().Info(fmt.Sprintf("mqtt client %s resubscribe %d topics", client.RemoteAddr(), len(topics)))
+}
+
+func (client *MqttClient) Publish(topic string, qos byte, data []byte) error {
+	if qos == Qos0 {
+		message := &MqttMessage{}
+		message.MakePublistMessage(topic, 0, FIXED_FLAG_Qos0s, data)
+		return client.Write(message.Bytes())
+	}
+	if qos > Qos2 {
+		return fmt.Errorf("mqtt client invalid qos %d", qos)
+	}
+
+	identifier := client.UseSerialNnumber()
+	inflight := &xMqttInflight{
+		topic: topic,
+		qos:   qos,
+		data:  data,
+		done:  make(chan *MqttMessage, 1),
+	}
+	client.inflightsMutex.Lock()
+	client.inflights[identifier] = inflight
+	client.inflightsMutex.Unlock()
+
+	message := &MqttMessage{}
+	message.MakePublistMessage(topic, identifier, qos<<1, data)
+	err := client.Write(message.Bytes())
+	if err != nil {
+		global.Logger().Warn(fmt.Sprintf("mqtt client %s publish %d queued, %s", client.RemoteAddr(), identifier, err.Error()))
+	}
+	resp, err := client.await(identifier, inflight.done)
+	if err != nil {
+		client.inflightsMutex.Lock()
+		delete(client.inflights, identifier)
+		client.inflightsMutex.Unlock()
+		return err
+	}
+	if resp == nil {
+		return fmt.Errorf("mqtt client %s message %d discarded by clean session", client.RemoteAddr(), identifier)
+	}
+	return nil
+}
+
+func (client *MqttClient) Inflights() int {
+	client.inflightsMutex.Lock()
+	defer client.inflightsMutex.Unlock()
+	return len(client.inflights)
+}
+
+func (client *MqttClient) redeliver() {
+	client.inflightsMutex.Lock()
+	defer client.inflightsMutex.Unlock()
+	for identifier, inflight := range client.inflights {
+		message := &MqttMessage{}
+		if inflight.released {
+			message.MakePubrelMessage(identifier)
+		} else {
+			message.MakePublistMessage(inflight.topic, identifier, inflight.qos<<1|0b00001000, inflight.data)
+		}
+		err := client.Write(message.Bytes())
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("mqtt client %s redeliver %d error %s", client.RemoteAddr(), identifier, err.Error()))
+			return
+		}
+	}
+}
+
+func (client *MqttClient) discard() {
+	client.inflightsMutex.Lock()
+	for identifier, inflight := range client.inflights {
+		delete(client.inflights, identifier)
+		close(inflight.done)
+	}
+	client.received = make(map[uint16]bool)
+	client.inflightsMutex.Unlock()
+}
+
+func (client *MqttClient) complete(identifier uint16, mqttMessage *MqttMessage) {
+	client.inflightsMutex.Lock()
+	inflight, ok := client.inflights[identifier]
+	if ok {
+		delete(client.inflights, identifier)
+	}
+	client.inflightsMutex.Unlock()
+	if ok {
+		inflight.done <- mqttMessage
+	}
+}
+
+func (client *MqttClient) onConnack(mqttMessage *MqttMessage) error {
+	connackHeader := mqttMessage.VariableHeader().(*MqttConnackVariableHeader)
+	if connackHeader.ReturnCode() != CONNACK_ACCEPTED {
+		client.connectMutex.Lock()
+		conn := client.connect
+		client.connectMutex.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+		return fmt.Errorf("mqtt client %s connect refused, return code 0x%02x", client.RemoteAddr(), connackHeader.ReturnCode())
+	}
+	client.connected = true
+	client.sessionPresent = connackHeader.SessionPresent() == 0x01
+	global.Logger().Info(fmt.Sprintf("mqtt client %s connected, clientId `%s` session present %t", client.RemoteAddr(), client.clientId, client.sessionPresent))
+
+	if client.sessionPresent {
+		client.redeliver()
+	} else {
+		client.discard()
+	}
+	go client.resubscribe()
+	return nil
+}
+
+func (client *MqttClient) dispatch(mqttMessage *MqttMessage) error {
+	topic := mqttMessage.VariableHeader().(*MqttPublishVariableHeader).Topic()
+
+	xListeners := make([]MqttClientMessageListener, 0)
+	client.subscriptionsMutex.RLock()
+	for filter, subscription := range client.subscriptions {
+		_, xfilter, _ := MqttShareFilter(filter)
+		if subscription.xListener != nil && MqttTopicMatch(xfilter, topic) {
+			xListeners = append(xListeners, subscription.xListener)
+		}
+	}
+	client.subscriptionsMutex.RUnlock()
+	if len(xListeners) <= 0 && client.xListener != nil {
+		xListeners = append(xListeners, client.xListener)
+	}
+
+	for _, xListener := range xListeners {
+		err := xListener.Publish(client.This().(ZeroClientConnect), mqttMessage)
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("mqtt client %s process publish `%s` err : %s", client.RemoteAddr(), topic, err.Error()))
+		}
+	}
+	return nil
+}
+
+func (client *MqttClient) onPublish(mqttMessage *MqttMessage) error {
+	identifier := mqttMessage.VariableHeader().(*MqttPublishVariableHeader).Identifier()
+	switch mqttMessage.FixedHeader().Qos() {
+	case Qos1:
+		client.dispatch(mqttMessage)
+		message := &MqttMessage{}
+		message.MakePubackMessage(identifier)
+		return client.Write(message.Bytes())
+	case Qos2:
+		client.inflightsMutex.Lock()
+		duplicate := client.received[identifier]
+		client.received[identifier] = true
+		client.inflightsMutex.Unlock()
+		if !duplicate {
+			client.dispatch(mqttMessage)
+		}
+		message := &MqttMessage{}
+		message.MakePubrecMessage(identifier)
+		return client.Write(message.Bytes())
+	default:
+		return client.dispatch(mqttMessage)
+	}
+}
+
+func (client *MqttClient) onPubrec(mqttMessage *MqttMessage) error {
+	identifier := mqttMessage.VariableHeader().(*MqttIdentifierVariableHeader).Identifier()
+	client.inflightsMutex.Lock()
+	inflight, ok := client.inflights[identifier]
+	if ok {
+		inflight.released = true
+	}
+	client.inflightsMutex.Unlock()
+
+	message := &MqttMessage{}
+	message.MakePubrelMessage(identifier)
+	return client.Write(message.Bytes())
+}
+
+func (client *MqttClient) onPubrel(mqttMessage *MqttMessage) error {
+	identifier := mqttMessage.VariableHeader().(*MqttIdentifierVariableHeader).Identifier()
+	client.inflightsMutex.Lock()
+	delete(client.received, identifier)
+	client.inflightsMutex.Unlock()
+
+	message := &MqttMessage{}
+	message.MakePubcompMessage(identifier)
+	return client.Write(message.Bytes())
+}
+
+func (client *MqttClient) onAck(mqttMessage *MqttMessage) error {
+	identifier := mqttMessage.VariableHeader().(*MqttIdentifierVariableHeader).Identifier()
+	client.inflightsMutex.Lock()
+	ch, ok := client.pendings[identifier]
+	client.inflightsMutex.Unlock()
+	if ok {
+		ch <- mqttMessage
+	}
+	return nil
+}
+
+func (client *MqttClient) OnMessage(datas []byte) error {
+	client.TCPClient.OnMessage(datas)
+	mqttMessage, err := ParseMqttMessage(datas)
+	if err != nil {
+		return err
+	}
+	global.Logger().Debug(fmt.Sprintf("mqtt client %s on message type `%s`", client.RemoteAddr(), mqttMessage.FixedHeader().MessageTypeString()))
+
+	switch mqttMessage.FixedHeader().MessageType() {
+	case CONNACK:
+		return client.onConnack(mqttMessage)
+	case PUBLISH:
+		return client.onPublish(mqttMessage)
+	case PUBACK, PUBCOMP:
+		client.complete(mqttMessage.VariableHeader().(*MqttIdentifierVariableHeader).Identifier(), mqttMessage)
+	case PUBREC:
+		return client.onPubrec(mqttMessage)
+	case PUBREL:
+		return client.onPubrel(mqttMessage)
+	case SUBACK, UNSUBACK:
+		return client.onAck(mqttMessage)
+	case PINGRESP:
+	default:
+	}
+	return nil
+}
+
+func (client *MqttClient) Connect() {
+	client.AddListener(&xMqttClientListener{})
+	client.AddChecker(&xMqttDataChecker{})
+	client.TCPClient.Connect()
+}
+
+func NewMqttClient(address string, clientId string, keepAlive uint16) *MqttClient {
+	if keepAlive <= 0 {
+		keepAlive = MQTT_CLIENT_DEFAULT_KEEPALIVE
+	}
+	client := &MqttClient{
+		TCPClient: *NewTCPClient(
+			address,
+			MQTT_CLIENT_DEFAULT_AUTH_WAIT,
+			int64(keepAlive)*3/2,
+			int64(keepAlive),
+			MQTT_CLIENT_DEFAULT_BUFFER_SIZE,
+		),
+		clientId:      clientId,
+		keepAlive:     keepAlive,
+		cleanSession:  true,
+		ackSeconds:    MQTT_CLIENT_DEFAULT_ACK_SECONDS,
+		subscriptions: make(map[string]*xMqttSubscription),
+		inflights:     make(map[uint16]*xMqttInflight),
+		pendings:      make(map[uint16]chan *MqttMessage),
+		received:      make(map[uint16]bool),
+	}
+	client.ThisDef(client)
+	return client
+}
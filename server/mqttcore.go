@@ -3,6 +3,7 @@ package server
 import (
 	"encoding/binary"
 	"fmt"
+	"strings"
 
 	"github.com/0meet1/zero-framework/global"
 )
@@ -36,6 +37,15 @@ const (
 	FIXED_FLAG_Qos0s = 0b0000
 	FIXED_FLAG_Qos1s = 0b0010
 	FIXED_FLAG_Qos2s = 0b0100
+
+	CONNACK_ACCEPTED                      = 0x00
+	CONNACK_REFUSED_PROTOCOL_VERSION      = 0x01
+	CONNACK_REFUSED_IDENTIFIER_REJECTED   = 0x02
+	CONNACK_REFUSED_SERVER_UNAVAILABLE    = 0x03
+	CONNACK_REFUSED_BAD_USERNAME_PASSWORD = 0x04
+	CONNACK_REFUSED_NOT_AUTHORIZED        = 0x05
+
+	SUBACK_FAILURE = 0x80
 )
 
 type MqttFixedHeader struct {
@@ -124,6 +134,8 @@ func (fixedHeader *MqttFixedHeader) MessageTypeString() string {
 		return "PINGRESP"
 	case DISCONNECT:
 		return "DISCONNECT"
+	case AUTH:
+		return "AUTH"
 	default:
 		return "UNKNOW"
 	}
@@ -210,22 +222,32 @@ type MqttMessage struct {
 	fixedHeader    *MqttFixedHeader
 	variableHeader MqttCoreVariableHeader
 	payload        MqttCorePayload
+
+	level      byte
+	reasonCode byte
+	properties *MqttProperties
 }
 
-func ParseMqttMessage(data []byte) (*MqttMessage, error) {
+func ParseMqttMessage(data []byte, levels ...byte) (*MqttMessage, error) {
 	m := &MqttMessage{}
-	err := m.build(data)
+	err := m.build(data, levels...)
 	return m, err
 }
 
-func (message *MqttMessage) build(data []byte) error {
+func (message *MqttMessage) build(data []byte, levels ...byte) (err error) {
 	defer func() {
-		err := recover()
-		if err != nil {
-			global.Logger().Error(fmt.Sprintf("mqttcore build message err : %s", err))
+		xerr := recover()
+		if xerr != nil {
+			global.Logger().Error(fmt.Sprintf("mqttcore build message err : %s", xerr))
+			err = fmt.Errorf("mqttcore build message err : %s", xerr)
 		}
 	}()
 
+	message.level = MQTT_LEVEL_3_1_1
+	if len(levels) > 0 && levels[0] > 0 {
+		message.level = levels[0]
+	}
+
 	lengthBytes := make([]byte, 0)
 	i := 1
 	for {
@@ -250,92 +272,111 @@ func (message *MqttMessage) build(data []byte) error {
 	}
 
 	fixedHeaderLen := message.fixedHeader.Size()
+	body := data[fixedHeaderLen:]
 
 	switch message.fixedHeader.MessageType() {
 	case CONNECT:
 		variableHeader := &MqttConnectVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+10])
+		err = variableHeader.build(body)
+		if err != nil {
+			return err
+		}
 		message.variableHeader = variableHeader
+		message.level = variableHeader.Level()
+		message.properties = variableHeader.properties
 
-		payload := &MqttParamsPayload{}
-		payload.build(data[fixedHeaderLen+10:])
+		payload := &MqttConnectPayload{}
+		err = payload.build(body[len(variableHeader.variableHeader):], variableHeader.variableHeader[7], message.level)
+		if err != nil {
+			return err
+		}
 		message.payload = payload
 	case CONNACK:
 		variableHeader := &MqttConnackVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+2])
+		err = variableHeader.build(body, message.level)
+		if err != nil {
+			return err
+		}
 		message.variableHeader = variableHeader
+		message.reasonCode = variableHeader.ReturnCode()
+		message.properties = variableHeader.properties
 
 		message.payload = &MqttPayload{}
 	case PUBLISH:
 		variableHeader := &MqttPublishVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen:])
+		err = variableHeader.build(body, message.fixedHeader.Qos(), message.level)
+		if err != nil {
+			return err
+		}
 		message.variableHeader = variableHeader
+		message.properties = variableHeader.properties
 
 		payload := &MqttPayload{}
-		payload.build(data[fixedHeaderLen+len(variableHeader.variableHeader):])
+		payload.build(body[len(variableHeader.variableHeader):])
 		message.payload = payload
-	case PUBACK:
+	case PUBACK, PUBREC, PUBREL, PUBCOMP:
 		variableHeader := &MqttIdentifierVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+2])
-		message.variableHeader = variableHeader
-
-		message.payload = &MqttPayload{}
-	case PUBREC:
-		variableHeader := &MqttIdentifierVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+2])
-		message.variableHeader = variableHeader
-
-		message.payload = &MqttPayload{}
-	case PUBREL:
-		variableHeader := &MqttIdentifierVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+2])
-		message.variableHeader = variableHeader
-
-		message.payload = &MqttPayload{}
-	case PUBCOMP:
-		variableHeader := &MqttIdentifierVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+2])
+		err = variableHeader.buildReason(body, message.level)
+		if err != nil {
+			return err
+		}
 		message.variableHeader = variableHeader
+		message.reasonCode = variableHeader.reasonCode
+		message.properties = variableHeader.properties
 
 		message.payload = &MqttPayload{}
 	case SUBSCRIBE:
 		variableHeader := &MqttIdentifierVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+2])
+		err = variableHeader.buildProperties(body, message.level)
+		if err != nil {
+			return err
+		}
 		message.variableHeader = variableHeader
+		message.properties = variableHeader.properties
 
 		payload := &MqttSubscribePayload{}
-		payload.build(data[fixedHeaderLen+len(variableHeader.variableHeader):])
+		payload.build(body[len(variableHeader.variableHeader):])
 		message.payload = payload
-	case SUBACK:
+	case SUBACK, UNSUBACK:
 		variableHeader := &MqttIdentifierVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+2])
+		err = variableHeader.buildProperties(body, message.level)
+		if err != nil {
+			return err
+		}
 		message.variableHeader = variableHeader
+		message.properties = variableHeader.properties
 
 		payload := &MqttPayload{}
-		payload.build(data[fixedHeaderLen+2:])
+		payload.build(body[len(variableHeader.variableHeader):])
 		message.payload = payload
 	case UNSUBSCRIBE:
 		variableHeader := &MqttIdentifierVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+2])
+		err = variableHeader.buildProperties(body, message.level)
+		if err != nil {
+			return err
+		}
 		message.variableHeader = variableHeader
+		message.properties = variableHeader.properties
 
 		payload := &MqttParamsPayload{}
-		payload.build(data[fixedHeaderLen+2:])
+		payload.build(body[len(variableHeader.variableHeader):])
 		message.payload = payload
-	case UNSUBACK:
-		variableHeader := &MqttIdentifierVariableHeader{}
-		variableHeader.build(data[fixedHeaderLen : fixedHeaderLen+2])
-		message.variableHeader = variableHeader
-
-		message.payload = &MqttPayload{}
 	case PINGREQ:
 		message.variableHeader = &MqttVariableHeader{}
 		message.payload = &MqttPayload{}
 	case PINGRESP:
 		message.variableHeader = &MqttVariableHeader{}
 		message.payload = &MqttPayload{}
-	case DISCONNECT:
-		message.variableHeader = &MqttVariableHeader{}
+	case DISCONNECT, AUTH:
+		variableHeader := &MqttReasonVariableHeader{}
+		err = variableHeader.build(body, message.level)
+		if err != nil {
+			return err
+		}
+		message.variableHeader = variableHeader
+		message.reasonCode = variableHeader.ReasonCode()
+		message.properties = variableHeader.properties
+
 		message.payload = &MqttPayload{}
 	}
 
@@ -354,10 +395,79 @@ func (message *MqttMessage) Payload() MqttCorePayload {
 	return message.payload
 }
 
+func (message *MqttMessage) Level() byte {
+	if message.level == 0 {
+		return MQTT_LEVEL_3_1_1
+	}
+	return message.level
+}
+
+func (message *MqttMessage) ReasonCode() byte {
+	return message.reasonCode
+}
+
+func (message *MqttMessage) Properties() *MqttProperties {
+	return message.properties
+}
+
+func (message *MqttMessage) UseLevel(level byte) *MqttMessage {
+	message.level = level
+	return message
+}
+
+func (message *MqttMessage) UseReasonCode(reasonCode byte) *MqttMessage {
+	message.reasonCode = reasonCode
+	return message
+}
+
+func (message *MqttMessage) UseProperties(properties *MqttProperties) *MqttMessage {
+	message.properties = properties
+	return message
+}
+
+func (message *MqttMessage) MakeConnectMessage(clientId string, keepAlive uint16, cleanSession bool, username string, password []byte, will *MqttWill) {
+
+	flags := byte(0b00000000)
+	if cleanSession {
+		flags |= 0b00000010
+	}
+	if will != nil {
+		flags |= 0b00000100 | (will.Qos&0b00000011)<<3
+		if will.Retain {
+			flags |= 0b00100000
+		}
+	}
+	if len(username) > 0 {
+		flags |= 0b10000000
+	}
+	if password != nil {
+		flags |= 0b01000000
+	}
+
+	connect := &MqttConnectVariableHeader{}
+	connect.make(message.Level(), flags, keepAlive, message.properties)
+	message.variableHeader = connect
+
+	payload := &MqttConnectPayload{}
+	payload.make(clientId, will, username, password, message.Level())
+	message.payload = payload
+
+	message.fixedHeader = &MqttFixedHeader{}
+	message.fixedHeader.make(CONNECT, FIXED_FLAG_NONE, len(connect.variableHeader)+len(payload.payload))
+}
+
 func (message *MqttMessage) MakeConnackMessage() {
+	message.MakeConnackCodeMessage(0x00, CONNACK_ACCEPTED)
+}
+
+func (message *MqttMessage) MakeConnackCodeMessage(sessionPresent byte, returnCode byte) {
 
 	connack := &MqttConnackVariableHeader{}
-	connack.make(0x00, 0x00)
+	if message.Level() == MQTT_LEVEL_5 {
+		connack.make5(sessionPresent, MqttConnackReasonCode(returnCode), message.properties)
+	} else {
+		connack.make(sessionPresent, returnCode)
+	}
 	message.variableHeader = connack
 
 	payload := &MqttPayload{}
@@ -382,80 +492,114 @@ func (message *MqttMessage) MakePingrespMessage() {
 	message.fixedHeader.make(PINGRESP, FIXED_FLAG_NONE, len(pingresp.variableHeader)+len(payload.payload))
 }
 
-func (message *MqttMessage) MakeSubackMessage(identifier uint16, results []byte) {
+func (message *MqttMessage) MakePingreqMessage() {
 
-	suback := &MqttIdentifierVariableHeader{}
-	suback.make(identifier)
-	message.variableHeader = suback
+	pingreq := &MqttVariableHeader{}
+	pingreq.build(make([]byte, 0))
+	message.variableHeader = pingreq
 
 	payload := &MqttPayload{}
-	payload.build(results)
+	payload.build(make([]byte, 0))
 	message.payload = payload
 
 	message.fixedHeader = &MqttFixedHeader{}
-	message.fixedHeader.make(SUBACK, FIXED_FLAG_NONE, len(suback.variableHeader)+len(payload.payload))
+	message.fixedHeader.make(PINGREQ, FIXED_FLAG_NONE, len(pingreq.variableHeader)+len(payload.payload))
 }
 
-func (message *MqttMessage) MakePubackMessage(identifier uint16) {
+func (message *MqttMessage) MakeSubscribeMessage(identifier uint16, topics []*MqttTopic) {
 
-	puback := &MqttIdentifierVariableHeader{}
-	puback.make(identifier)
-	message.variableHeader = puback
+	subscribe := &MqttIdentifierVariableHeader{}
+	subscribe.makeProperties(identifier, message.Level(), message.properties)
+	message.variableHeader = subscribe
 
-	payload := &MqttPayload{}
-	payload.build(make([]byte, 0))
+	payload := &MqttSubscribePayload{}
+	payload.make(topics)
 	message.payload = payload
 
 	message.fixedHeader = &MqttFixedHeader{}
-	message.fixedHeader.make(PUBACK, FIXED_FLAG_NONE, len(puback.variableHeader)+len(payload.payload))
+	message.fixedHeader.make(SUBSCRIBE, FIXED_FLAG_Qos1s, len(subscribe.variableHeader)+len(payload.payload))
 }
 
-func (message *MqttMessage) MakePubrecMessage(identifier uint16) {
+func (message *MqttMessage) MakeUnsubscribeMessage(identifier uint16, topics []string) {
+
+	unsubscribe := &MqttIdentifierVariableHeader{}
+	unsubscribe.makeProperties(identifier, message.Level(), message.properties)
+	message.variableHeader = unsubscribe
 
-	pubrec := &MqttIdentifierVariableHeader{}
-	pubrec.make(identifier)
-	message.variableHeader = pubrec
+	payload := &MqttParamsPayload{}
+	payload.make(topics)
+	message.payload = payload
+
+	message.fixedHeader = &MqttFixedHeader{}
+	message.fixedHeader.make(UNSUBSCRIBE, FIXED_FLAG_Qos1s, len(unsubscribe.variableHeader)+len(payload.payload))
+}
+
+func (message *MqttMessage) MakeSubackMessage(identifier uint16, results []byte) {
+
+	suback := &MqttIdentifierVariableHeader{}
+	suback.makeProperties(identifier, message.Level(), message.properties)
+	message.variableHeader = suback
 
 	payload := &MqttPayload{}
-	payload.build(make([]byte, 0))
+	payload.build(results)
 	message.payload = payload
 
 	message.fixedHeader = &MqttFixedHeader{}
-	message.fixedHeader.make(PUBREC, FIXED_FLAG_NONE, len(pubrec.variableHeader)+len(payload.payload))
+	message.fixedHeader.make(SUBACK, FIXED_FLAG_NONE, len(suback.variableHeader)+len(payload.payload))
 }
 
-func (message *MqttMessage) MakePubrelMessage(identifier uint16) {
+func (message *MqttMessage) MakeUnsubackMessage(identifier uint16, results []byte) {
 
-	pubrel := &MqttIdentifierVariableHeader{}
-	pubrel.make(identifier)
-	message.variableHeader = pubrel
+	unsuback := &MqttIdentifierVariableHeader{}
+	unsuback.makeProperties(identifier, message.Level(), message.properties)
+	message.variableHeader = unsuback
 
 	payload := &MqttPayload{}
-	payload.build(make([]byte, 0))
+	if message.Level() == MQTT_LEVEL_5 {
+		payload.build(results)
+	} else {
+		payload.build(make([]byte, 0))
+	}
 	message.payload = payload
 
 	message.fixedHeader = &MqttFixedHeader{}
-	message.fixedHeader.make(PUBREL, FIXED_FLAG_NONE, len(pubrel.variableHeader)+len(payload.payload))
+	message.fixedHeader.make(UNSUBACK, FIXED_FLAG_NONE, len(unsuback.variableHeader)+len(payload.payload))
 }
 
-func (message *MqttMessage) MakePubcompMessage(identifier uint16) {
+func (message *MqttMessage) makeAckMessage(messageType byte, messageTypeExt byte, identifier uint16) {
 
-	pubcomp := &MqttIdentifierVariableHeader{}
-	pubcomp.make(identifier)
-	message.variableHeader = pubcomp
+	ack := &MqttIdentifierVariableHeader{}
+	ack.makeReason(identifier, message.Level(), message.reasonCode, message.properties)
+	message.variableHeader = ack
 
 	payload := &MqttPayload{}
 	payload.build(make([]byte, 0))
 	message.payload = payload
 
 	message.fixedHeader = &MqttFixedHeader{}
-	message.fixedHeader.make(PUBCOMP, FIXED_FLAG_NONE, len(pubcomp.variableHeader)+len(payload.payload))
+	message.fixedHeader.make(messageType, messageTypeExt, len(ack.variableHeader)+len(payload.payload))
+}
+
+func (message *MqttMessage) MakePubackMessage(identifier uint16) {
+	message.makeAckMessage(PUBACK, FIXED_FLAG_NONE, identifier)
+}
+
+func (message *MqttMessage) MakePubrecMessage(identifier uint16) {
+	message.makeAckMessage(PUBREC, FIXED_FLAG_NONE, identifier)
+}
+
+func (message *MqttMessage) MakePubrelMessage(identifier uint16) {
+	message.makeAckMessage(PUBREL, FIXED_FLAG_Qos1s, identifier)
+}
+
+func (message *MqttMessage) MakePubcompMessage(identifier uint16) {
+	message.makeAckMessage(PUBCOMP, FIXED_FLAG_NONE, identifier)
 }
 
 func (message *MqttMessage) MakePublistMessage(topic string, identifier uint16, flag byte, data []byte) {
 
 	publish := &MqttPublishVariableHeader{}
-	publish.make(topic, int(identifier))
+	publish.make(topic, int(identifier), flag>>1&0b00000011, message.Level(), message.properties)
 	message.variableHeader = publish
 
 	payload := &MqttPayload{}
@@ -466,6 +610,20 @@ func (message *MqttMessage) MakePublistMessage(topic string, identifier uint16,
 	message.fixedHeader.make(PUBLISH, flag, len(publish.variableHeader)+len(payload.payload))
 }
 
+func (message *MqttMessage) MakeDisconnectMessage() {
+
+	disconnect := &MqttReasonVariableHeader{}
+	disconnect.make(message.Level(), message.reasonCode, message.properties)
+	message.variableHeader = disconnect
+
+	payload := &MqttPayload{}
+	payload.build(make([]byte, 0))
+	message.payload = payload
+
+	message.fixedHeader = &MqttFixedHeader{}
+	message.fixedHeader.make(DISCONNECT, FIXED_FLAG_NONE, len(disconnect.variableHeader)+len(payload.payload))
+}
+
 func (message *MqttMessage) Bytes() []byte {
 	bytes := make([]byte, 0)
 	bytes = append(bytes, message.fixedHeader.header)
@@ -477,6 +635,9 @@ func (message *MqttMessage) Bytes() []byte {
 
 type MqttIdentifierVariableHeader struct {
 	MqttVariableHeader
+
+	reasonCode byte
+	properties *MqttProperties
 }
 
 func (identifierHeader *MqttIdentifierVariableHeader) build(data []byte) error {
@@ -487,24 +648,81 @@ func (identifierHeader *MqttIdentifierVariableHeader) build(data []byte) error {
 	return nil
 }
 
+func (identifierHeader *MqttIdentifierVariableHeader) buildReason(data []byte, level byte) error {
+	if level != MQTT_LEVEL_5 || len(data) <= 2 {
+		return identifierHeader.build(data[:2])
+	}
+	identifierHeader.reasonCode = data[2]
+	length := 3
+	if len(data) > 3 {
+		identifierHeader.properties = NewMqttProperties()
+		propertiesLen, err := identifierHeader.properties.build(data[3:])
+		if err != nil {
+			return err
+		}
+		length += propertiesLen
+	}
+	return identifierHeader.MqttVariableHeader.build(data[:length])
+}
+
+func (identifierHeader *MqttIdentifierVariableHeader) buildProperties(data []byte, level byte) error {
+	if level != MQTT_LEVEL_5 {
+		return identifierHeader.build(data[:2])
+	}
+	identifierHeader.properties = NewMqttProperties()
+	propertiesLen, err := identifierHeader.properties.build(data[2:])
+	if err != nil {
+		return err
+	}
+	return identifierHeader.MqttVariableHeader.build(data[:2+propertiesLen])
+}
+
 func (identifierHeader *MqttIdentifierVariableHeader) make(identifier uint16) {
 	identifierHeader.MqttVariableHeader.variableHeader = make([]byte, 2)
 	binary.BigEndian.PutUint16(identifierHeader.MqttVariableHeader.variableHeader, uint16(identifier))
 }
 
+func (identifierHeader *MqttIdentifierVariableHeader) makeReason(identifier uint16, level byte, reasonCode byte, properties *MqttProperties) {
+	identifierHeader.make(identifier)
+	if level == MQTT_LEVEL_5 {
+		identifierHeader.reasonCode = reasonCode
+		identifierHeader.properties = properties
+		identifierHeader.variableHeader = append(identifierHeader.variableHeader, reasonCode)
+		identifierHeader.variableHeader = append(identifierHeader.variableHeader, properties.Bytes()...)
+	}
+}
+
+func (identifierHeader *MqttIdentifierVariableHeader) makeProperties(identifier uint16, level byte, properties *MqttProperties) {
+	identifierHeader.make(identifier)
+	if level == MQTT_LEVEL_5 {
+		identifierHeader.properties = properties
+		identifierHeader.variableHeader = append(identifierHeader.variableHeader, properties.Bytes()...)
+	}
+}
+
 func (identifierHeader *MqttIdentifierVariableHeader) Identifier() uint16 {
 	return binary.BigEndian.Uint16(identifierHeader.MqttVariableHeader.variableHeader)
 }
 
+func (identifierHeader *MqttIdentifierVariableHeader) ReasonCode() byte {
+	return identifierHeader.reasonCode
+}
+
+func (identifierHeader *MqttIdentifierVariableHeader) Properties() *MqttProperties {
+	return identifierHeader.properties
+}
+
 type MqttConnectVariableHeader struct {
 	MqttVariableHeader
+
+	properties *MqttProperties
 }
 
 func (connectHeader *MqttConnectVariableHeader) build(data []byte) error {
-	connectHeader.MqttVariableHeader.build(data)
-	if len(connectHeader.MqttVariableHeader.variableHeader) != 10 {
-		return fmt.Errorf("invalid connect variable header length : %d", len(connectHeader.MqttVariableHeader.variableHeader))
+	if len(data) < CONNECT_VARIABLE_HEADER_LEN {
+		return fmt.Errorf("invalid connect variable header length : %d", len(data))
 	}
+	connectHeader.MqttVariableHeader.build(data[:CONNECT_VARIABLE_HEADER_LEN])
 
 	if connectHeader.ProtocolLength() != 4 {
 		return fmt.Errorf("invalid connect variable protocol length : %d", connectHeader.ProtocolLength())
@@ -514,47 +732,27 @@ func (connectHeader *MqttConnectVariableHeader) build(data []byte) error {
 		return fmt.Errorf("invalid connect variable protocol : %s", connectHeader.Protocol())
 	}
 
+	if connectHeader.Level() == MQTT_LEVEL_5 {
+		connectHeader.properties = NewMqttProperties()
+		propertiesLen, err := connectHeader.properties.build(data[CONNECT_VARIABLE_HEADER_LEN:])
+		if err != nil {
+			return err
+		}
+		connectHeader.MqttVariableHeader.build(data[:CONNECT_VARIABLE_HEADER_LEN+propertiesLen])
+	}
+
 	return nil
 }
 
-// func (connectHeader *MqttConnectVariableHeader) make(
-// 	level byte,
-// 	UserNameFlag byte,
-// 	PasswordFlag byte,
-// 	WillRetain byte,
-// 	WillQos byte,
-// 	WillFlag byte,
-// 	CleanSession byte,
-// 	KeepAlive int,
-// ) {
-// 	protocolLength := make([]byte, 8)
-// 	protocolLength[0] = 0x00
-// 	protocolLength[1] = 0x04
-// 	protocolLength[2] = 'M'
-// 	protocolLength[3] = 'Q'
-// 	protocolLength[4] = 'T'
-// 	protocolLength[5] = 'T'
-// 	protocolLength[6] = level
-// 	protocolLength[7] = 0x00
-
-// 	keepAliveBuf := make([]byte, 2)
-// 	binary.BigEndian.PutUint16(keepAliveBuf, uint16(KeepAlive))
-// 	protocolLength = append(protocolLength, keepAliveBuf...)
-
-// 	protocolLength[7] += UserNameFlag
-// 	protocolLength[7] <<= 1
-// 	protocolLength[7] += PasswordFlag
-// 	protocolLength[7] <<= 1
-// 	protocolLength[7] += WillRetain
-// 	protocolLength[7] <<= 2
-// 	protocolLength[7] += WillQos
-// 	protocolLength[7] <<= 1
-// 	protocolLength[7] += WillFlag
-// 	protocolLength[7] <<= 1
-// 	protocolLength[7] += CleanSession
-// 	protocolLength[7] <<= 1
-// 	protocolLength[7] += 0b0
-// }
+func (connectHeader *MqttConnectVariableHeader) make(level byte, flags byte, keepAlive uint16, properties *MqttProperties) {
+	connectHeader.variableHeader = mqttBinary([]byte(MQTT_HEADER))
+	connectHeader.variableHeader = append(connectHeader.variableHeader, level, flags)
+	connectHeader.variableHeader = binary.BigEndian.AppendUint16(connectHeader.variableHeader, keepAlive)
+	if level == MQTT_LEVEL_5 {
+		connectHeader.properties = properties
+		connectHeader.variableHeader = append(connectHeader.variableHeader, properties.Bytes()...)
+	}
+}
 
 func (connectHeader *MqttConnectVariableHeader) ProtocolLength() int {
 	return int(binary.BigEndian.Uint16(connectHeader.MqttVariableHeader.variableHeader[:2]))
@@ -597,14 +795,28 @@ func (connectHeader *MqttConnectVariableHeader) Reserved() byte {
 }
 
 func (connectHeader *MqttConnectVariableHeader) KeepAlive() int {
-	return int(binary.BigEndian.Uint16(connectHeader.MqttVariableHeader.variableHeader[8:]))
+	return int(binary.BigEndian.Uint16(connectHeader.MqttVariableHeader.variableHeader[8:10]))
+}
+
+func (connectHeader *MqttConnectVariableHeader) Properties() *MqttProperties {
+	return connectHeader.properties
 }
 
 type MqttConnackVariableHeader struct {
 	MqttVariableHeader
+
+	properties *MqttProperties
 }
 
-func (connackHeader *MqttConnackVariableHeader) build(data []byte) error {
+func (connackHeader *MqttConnackVariableHeader) build(data []byte, levels ...byte) error {
+	if len(levels) > 0 && levels[0] == MQTT_LEVEL_5 && len(data) > 2 {
+		connackHeader.properties = NewMqttProperties()
+		propertiesLen, err := connackHeader.properties.build(data[2:])
+		if err != nil {
+			return err
+		}
+		return connackHeader.MqttVariableHeader.build(data[:2+propertiesLen])
+	}
 	connackHeader.MqttVariableHeader.build(data)
 	if len(connackHeader.MqttVariableHeader.variableHeader) != 2 {
 		return fmt.Errorf("invalid connack variable header length : %d", len(connackHeader.MqttVariableHeader.variableHeader))
@@ -618,6 +830,12 @@ func (connackHeader *MqttConnackVariableHeader) make(sessionPresent byte, return
 	connackHeader.variableHeader[1] = returnCode
 }
 
+func (connackHeader *MqttConnackVariableHeader) make5(sessionPresent byte, reasonCode byte, properties *MqttProperties) {
+	connackHeader.make(sessionPresent, reasonCode)
+	connackHeader.properties = properties
+	connackHeader.variableHeader = append(connackHeader.variableHeader, properties.Bytes()...)
+}
+
 func (connackHeader *MqttConnackVariableHeader) SessionPresent() byte {
 	return connackHeader.variableHeader[0]
 }
@@ -626,35 +844,105 @@ func (connackHeader *MqttConnackVariableHeader) ReturnCode() byte {
 	return connackHeader.variableHeader[1]
 }
 
+func (connackHeader *MqttConnackVariableHeader) Properties() *MqttProperties {
+	return connackHeader.properties
+}
+
 type MqttPublishVariableHeader struct {
 	MqttVariableHeader
+
+	topic      string
+	identifier uint16
+	properties *MqttProperties
 }
 
-func (publishVariableHeader *MqttPublishVariableHeader) build(data []byte) error {
-	topicLen := int(binary.BigEndian.Uint16(data[:2]))
-	publishVariableHeader.MqttVariableHeader.build(data[:2+topicLen+2])
-	return nil
+func (publishVariableHeader *MqttPublishVariableHeader) build(data []byte, qos byte, levels ...byte) error {
+	topic, length, err := mqttParseBinary(data)
+	if err != nil {
+		return err
+	}
+	publishVariableHeader.topic = string(topic)
+	if qos > Qos0 {
+		publishVariableHeader.identifier = binary.BigEndian.Uint16(data[length : length+2])
+		length += 2
+	}
+	if len(levels) > 0 && levels[0] == MQTT_LEVEL_5 {
+		publishVariableHeader.properties = NewMqttProperties()
+		propertiesLen, err := publishVariableHeader.properties.build(data[length:])
+		if err != nil {
+			return err
+		}
+		length += propertiesLen
+	}
+	return publishVariableHeader.MqttVariableHeader.build(data[:length])
 }
 
-func (publishVariableHeader *MqttPublishVariableHeader) make(topic string, identifier int) {
-	topicLen := len(topic)
-	topicLenbytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(topicLenbytes, uint16(topicLen))
-	publishVariableHeader.variableHeader = make([]byte, 0)
-	publishVariableHeader.variableHeader = append(publishVariableHeader.variableHeader, topicLenbytes...)
-	publishVariableHeader.variableHeader = append(publishVariableHeader.variableHeader, []byte(topic)...)
+func (publishVariableHeader *MqttPublishVariableHeader) make(topic string, identifier int, qos byte, level byte, properties *MqttProperties) {
+	publishVariableHeader.topic = topic
+	publishVariableHeader.identifier = uint16(identifier)
+	publishVariableHeader.properties = properties
 
-	identifierLenbytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(identifierLenbytes, uint16(identifier))
-	publishVariableHeader.variableHeader = append(publishVariableHeader.variableHeader, identifierLenbytes...)
+	publishVariableHeader.variableHeader = mqttBinary([]byte(topic))
+	if qos > Qos0 {
+		identifierLenbytes := make([]byte, 2)
+		binary.BigEndian.PutUint16(identifierLenbytes, uint16(identifier))
+		publishVariableHeader.variableHeader = append(publishVariableHeader.variableHeader, identifierLenbytes...)
+	}
+	if level == MQTT_LEVEL_5 {
+		publishVariableHeader.variableHeader = append(publishVariableHeader.variableHeader, properties.Bytes()...)
+	}
 }
 
 func (publishVariableHeader *MqttPublishVariableHeader) Topic() string {
-	return string(publishVariableHeader.variableHeader[2 : len(publishVariableHeader.variableHeader)-2])
+	return publishVariableHeader.topic
 }
 
 func (publishVariableHeader *MqttPublishVariableHeader) Identifier() uint16 {
-	return binary.BigEndian.Uint16(publishVariableHeader.variableHeader[len(publishVariableHeader.variableHeader)-2:])
+	return publishVariableHeader.identifier
+}
+
+func (publishVariableHeader *MqttPublishVariableHeader) Properties() *MqttProperties {
+	return publishVariableHeader.properties
+}
+
+type MqttReasonVariableHeader struct {
+	MqttVariableHeader
+
+	properties *MqttProperties
+}
+
+func (reasonHeader *MqttReasonVariableHeader) build(data []byte, level byte) error {
+	if level != MQTT_LEVEL_5 || len(data) <= 0 {
+		return reasonHeader.MqttVariableHeader.build(make([]byte, 0))
+	}
+	if len(data) > 1 {
+		reasonHeader.properties = NewMqttProperties()
+		_, err := reasonHeader.properties.build(data[1:])
+		if err != nil {
+			return err
+		}
+	}
+	return reasonHeader.MqttVariableHeader.build(data)
+}
+
+func (reasonHeader *MqttReasonVariableHeader) make(level byte, reasonCode byte, properties *MqttProperties) {
+	reasonHeader.variableHeader = make([]byte, 0)
+	if level == MQTT_LEVEL_5 {
+		reasonHeader.properties = properties
+		reasonHeader.variableHeader = append(reasonHeader.variableHeader, reasonCode)
+		reasonHeader.variableHeader = append(reasonHeader.variableHeader, properties.Bytes()...)
+	}
+}
+
+func (reasonHeader *MqttReasonVariableHeader) ReasonCode() byte {
+	if len(reasonHeader.variableHeader) <= 0 {
+		return REASON_SUCCESS
+	}
+	return reasonHeader.variableHeader[0]
+}
+
+func (reasonHeader *MqttReasonVariableHeader) Properties() *MqttProperties {
+	return reasonHeader.properties
 }
 
 type MqttParamsPayload struct {
@@ -679,27 +967,155 @@ func (connectPayload *MqttParamsPayload) build(data []byte) error {
 	return nil
 }
 
-// func (connectPayload *MqttParamsPayload) make(params ...string) error {
-// 	connectPayload.params = params
-// 	connectPayload.payload = make([]byte, 0)
-
-// 	for i := 0; i < len(params); i++ {
-// 		paramLenBuf := make([]byte, 2)
-// 		binary.BigEndian.PutUint16(paramLenBuf, uint16(len(params[i])))
-// 		connectPayload.payload = append(connectPayload.payload, paramLenBuf...)
-// 		connectPayload.payload = append(connectPayload.payload, string(params[i])...)
-// 	}
-
-// 	return nil
-// }
+func (connectPayload *MqttParamsPayload) make(params []string) {
+	connectPayload.params = params
+	connectPayload.payload = make([]byte, 0)
+	for _, param := range params {
+		connectPayload.payload = append(connectPayload.payload, mqttBinary([]byte(param))...)
+	}
+}
 
 func (connectPayload *MqttParamsPayload) Params() []string {
 	return connectPayload.params
 }
 
+type MqttConnectPayload struct {
+	MqttParamsPayload
+
+	clientId       string
+	willProperties *MqttProperties
+	willTopic      string
+	willMessage    []byte
+	username       string
+	password       []byte
+}
+
+func (connectPayload *MqttConnectPayload) build(data []byte, flags byte, level byte) error {
+	connectPayload.MqttPayload.build(data)
+	connectPayload.params = make([]string, 0)
+
+	index := 0
+	next := func() ([]byte, error) {
+		value, length, err := mqttParseBinary(data[index:])
+		if err != nil {
+			return nil, err
+		}
+		index += length
+		connectPayload.params = append(connectPayload.params, string(value))
+		return value, nil
+	}
+
+	clientId, err := next()
+	if err != nil {
+		return err
+	}
+	connectPayload.clientId = string(clientId)
+
+	if flags<<5&0xFF>>7&0xFF == 0b1 {
+		if level == MQTT_LEVEL_5 {
+			connectPayload.willProperties = NewMqttProperties()
+			propertiesLen, err := connectPayload.willProperties.build(data[index:])
+			if err != nil {
+				return err
+			}
+			index += propertiesLen
+		}
+		willTopic, err := next()
+		if err != nil {
+			return err
+		}
+		connectPayload.willTopic = string(willTopic)
+		willMessage, err := next()
+		if err != nil {
+			return err
+		}
+		connectPayload.willMessage = willMessage
+	}
+
+	if flags>>7&0xFF == 0b1 {
+		username, err := next()
+		if err != nil {
+			return err
+		}
+		connectPayload.username = string(username)
+	}
+
+	if flags<<1&0xFF>>7&0xFF == 0b1 {
+		password, err := next()
+		if err != nil {
+			return err
+		}
+		connectPayload.password = password
+	}
+	return nil
+}
+
+func (connectPayload *MqttConnectPayload) make(clientId string, will *MqttWill, username string, password []byte, level byte) {
+	connectPayload.clientId = clientId
+	connectPayload.username = username
+	connectPayload.password = password
+	connectPayload.params = []string{clientId}
+
+	connectPayload.payload = mqttBinary([]byte(clientId))
+	if will != nil {
+		connectPayload.willTopic = will.Topic
+		connectPayload.willMessage = will.Message
+		if level == MQTT_LEVEL_5 {
+			connectPayload.willProperties = will.Properties
+			connectPayload.payload = append(connectPayload.payload, will.Properties.Bytes()...)
+		}
+		connectPayload.payload = append(connectPayload.payload, mqttBinary([]byte(will.Topic))...)
+		connectPayload.payload = append(connectPayload.payload, mqttBinary(will.Message)...)
+		connectPayload.params = append(connectPayload.params, will.Topic, string(will.Message))
+	}
+	if len(username) > 0 {
+		connectPayload.payload = append(connectPayload.payload, mqttBinary([]byte(username))...)
+		connectPayload.params = append(connectPayload.params, username)
+	}
+	if password != nil {
+		connectPayload.payload = append(connectPayload.payload, mqttBinary(password)...)
+		connectPayload.params = append(connectPayload.params, string(password))
+	}
+}
+
+func (connectPayload *MqttConnectPayload) ClientId() string {
+	return connectPayload.clientId
+}
+
+func (connectPayload *MqttConnectPayload) WillProperties() *MqttProperties {
+	return connectPayload.willProperties
+}
+
+func (connectPayload *MqttConnectPayload) WillTopic() string {
+	return connectPayload.willTopic
+}
+
+func (connectPayload *MqttConnectPayload) WillMessage() []byte {
+	return connectPayload.willMessage
+}
+
+func (connectPayload *MqttConnectPayload) UserName() string {
+	return connectPayload.username
+}
+
+func (connectPayload *MqttConnectPayload) Password() []byte {
+	return connectPayload.password
+}
+
+type MqttWill struct {
+	Topic      string
+	Message    []byte
+	Qos        byte
+	Retain     bool
+	Properties *MqttProperties
+}
+
 type MqttTopic struct {
-	TopicName string
-	Qos       byte
+	TopicName         string
+	Qos               byte
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
 }
 
 type MqttSubscribePayload struct {
@@ -715,36 +1131,94 @@ func (subscribePayload *MqttSubscribePayload) build(data []byte) error {
 		if index >= len(data) {
 			break
 		}
-		bodyLenBytes := subscribePayload.payload[index:2]
+		bodyLenBytes := subscribePayload.payload[index : index+2]
 		bodyLen := int(binary.BigEndian.Uint16(bodyLenBytes))
 		index += 2
 		topic := string(subscribePayload.payload[index : index+bodyLen])
 		index += bodyLen
-		qos := subscribePayload.payload[index]
+		options := subscribePayload.payload[index]
 		index += 1
 		subscribePayload.topics = append(subscribePayload.topics, &MqttTopic{
-			TopicName: topic,
-			Qos:       qos,
+			TopicName:         topic,
+			Qos:               options & 0b00000011,
+			NoLocal:           options>>2&0b1 == 0b1,
+			RetainAsPublished: options>>3&0b1 == 0b1,
+			RetainHandling:    options >> 4 & 0b00000011,
 		})
 	}
 	return nil
 }
 
-// func (subscribePayload *MqttSubscribePayload) make(topics []*MqttTopic) error {
-// 	subscribePayload.topics = topics
-// 	subscribePayload.payload = make([]byte, 0)
-
-// 	for i := 0; i < len(topics); i++ {
-// 		paramLenBuf := make([]byte, 2)
-// 		binary.BigEndian.PutUint16(paramLenBuf, uint16(len(topics[i].TopicName)))
-// 		subscribePayload.payload = append(subscribePayload.payload, paramLenBuf...)
-// 		subscribePayload.payload = append(subscribePayload.payload, string(topics[i].TopicName)...)
-// 		subscribePayload.payload = append(subscribePayload.payload, topics[i].Qos)
-// 	}
-
-// 	return nil
-// }
+func (subscribePayload *MqttSubscribePayload) make(topics []*MqttTopic) {
+	subscribePayload.topics = topics
+	subscribePayload.payload = make([]byte, 0)
+	for _, topic := range topics {
+		options := topic.Qos & 0b00000011
+		if topic.NoLocal {
+			options |= 0b00000100
+		}
+		if topic.RetainAsPublished {
+			options |= 0b00001000
+		}
+		options |= (topic.RetainHandling & 0b00000011) << 4
+		subscribePayload.payload = append(subscribePayload.payload, mqttBinary([]byte(topic.TopicName))...)
+		subscribePayload.payload = append(subscribePayload.payload, options)
+	}
+}
 
 func (subscribePayload *MqttSubscribePayload) Topics() []*MqttTopic {
 	return subscribePayload.topics
 }
+
+func MqttTopicMatch(filter string, topic string) bool {
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+func MqttTopicCovers(filter string, subfilter string) bool {
+	filterLevels := strings.Split(filter, "/")
+	subfilterLevels := strings.Split(subfilter, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(subfilterLevels) || subfilterLevels[i] == "#" {
+			return false
+		}
+		if level != "+" && level != subfilterLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(subfilterLevels)
+}
+
+func MqttShareFilter(filter string) (string, string, bool) {
+	if !strings.HasPrefix(filter, MQTT_SHARE_PREFIX) {
+		return "", filter, len(filter) > 0
+	}
+	share := strings.TrimPrefix(filter, MQTT_SHARE_PREFIX)
+	index := strings.Index(share, "/")
+	if index <= 0 || index >= len(share)-1 {
+		return "", "", false
+	}
+	group := share[:index]
+	if strings.ContainsAny(group, "+#") {
+		return "", "", false
+	}
+	return group, share[index+1:], true
+}
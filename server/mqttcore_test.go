@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseMqttConnect(t *testing.T) {
+	cases := []struct {
+		name         string
+		level        byte
+		clientId     string
+		cleanSession bool
+		username     string
+		password     []byte
+		will         *MqttWill
+		properties   *MqttProperties
+	}{
+		{name: "3.1.1 minimal", level: MQTT_LEVEL_3_1_1, clientId: "c311", cleanSession: true},
+		{name: "3.1.1 credentials", level: MQTT_LEVEL_3_1_1, clientId: "c311", username: "user", password: []byte("secret")},
+		{name: "3.1.1 will", level: MQTT_LEVEL_3_1_1, clientId: "c311", cleanSession: true,
+			will: &MqttWill{Topic: "will/c311", Message: []byte("bye"), Qos: Qos1, Retain: true}},
+		{name: "5 minimal", level: MQTT_LEVEL_5, clientId: "c5", cleanSession: true, properties: NewMqttProperties()},
+		{name: "5 properties", level: MQTT_LEVEL_5, clientId: "c5", username: "user", password: []byte("secret"),
+			properties: NewMqttProperties().
+				SetUint32(PROPERTY_SESSION_EXPIRY_INTERVAL, 3600).
+				SetUint16(PROPERTY_TOPIC_ALIAS_MAXIMUM, 16).
+				SetUint16(PROPERTY_RECEIVE_MAXIMUM, 32).
+				SetByte(PROPERTY_REQUEST_RESPONSE_INFORMATION, 0x01).
+				AddUserProperty("region", "east").
+				AddUserProperty("region", "west")},
+		{name: "5 will properties", level: MQTT_LEVEL_5, clientId: "", cleanSession: true, properties: NewMqttProperties(),
+			will: &MqttWill{Topic: "will/c5", Message: []byte("bye"), Qos: Qos2,
+				Properties: NewMqttProperties().SetUint32(PROPERTY_WILL_DELAY_INTERVAL, 30).SetString(PROPERTY_CONTENT_TYPE, "text/plain")}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			message := (&MqttMessage{}).UseLevel(c.level).UseProperties(c.properties)
+			message.MakeConnectMessage(c.clientId, 60, c.cleanSession, c.username, c.password, c.will)
+
+			xmessage, err := ParseMqttMessage(message.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if xmessage.FixedHeader().MessageType() != CONNECT {
+				t.Fatalf("expected CONNECT got %d", xmessage.FixedHeader().MessageType())
+			}
+			if xmessage.Level() != c.level {
+				t.Fatalf("expected level %d got %d", c.level, xmessage.Level())
+			}
+
+			header := xmessage.VariableHeader().(*MqttConnectVariableHeader)
+			if header.Protocol() != MQTT_HEADER || header.KeepAlive() != 60 {
+				t.Fatalf("unexpected protocol `%s` keepalive %d", header.Protocol(), header.KeepAlive())
+			}
+			if (header.CleanSession() == 0b1) != c.cleanSession {
+				t.Fatalf("expected clean session %v", c.cleanSession)
+			}
+			if (header.UserNameFlag() == 0b1) != (len(c.username) > 0) || (header.PasswordFlag() == 0b1) != (c.password != nil) {
+				t.Fatal("unexpected credential flags")
+			}
+
+			payload := xmessage.Payload().(*MqttConnectPayload)
+			if payload.ClientId() != c.clientId || payload.UserName() != c.username || !bytes.Equal(payload.Password(), c.password) {
+				t.Fatalf("unexpected payload clientId `%s` username `%s`", payload.ClientId(), payload.UserName())
+			}
+
+			if c.will != nil {
+				if header.WillFlag() != 0b1 || header.WillQos() != c.will.Qos || (header.WillRetain() == 0b1) != c.will.Retain {
+					t.Fatal("unexpected will flags")
+				}
+				if payload.WillTopic() != c.will.Topic || !bytes.Equal(payload.WillMessage(), c.will.Message) {
+					t.Fatalf("unexpected will `%s`", payload.WillTopic())
+				}
+				if c.level == MQTT_LEVEL_5 {
+					if payload.WillProperties().Uint32(PROPERTY_WILL_DELAY_INTERVAL) != c.will.Properties.Uint32(PROPERTY_WILL_DELAY_INTERVAL) ||
+						payload.WillProperties().String(PROPERTY_CONTENT_TYPE) != c.will.Properties.String(PROPERTY_CONTENT_TYPE) {
+						t.Fatal("unexpected will properties")
+					}
+				}
+			} else if header.WillFlag() != 0b0 {
+				t.Fatal("unexpected will flag")
+			}
+
+			if c.level != MQTT_LEVEL_5 {
+				if header.Properties() != nil {
+					t.Fatal("unexpected properties on 3.1.1")
+				}
+				return
+			}
+			properties := header.Properties()
+			for _, identifier := range []byte{PROPERTY_SESSION_EXPIRY_INTERVAL, PROPERTY_TOPIC_ALIAS_MAXIMUM, PROPERTY_RECEIVE_MAXIMUM, PROPERTY_REQUEST_RESPONSE_INFORMATION} {
+				if properties.Has(identifier) != c.properties.Has(identifier) {
+					t.Fatalf("property 0x%02x mismatch", identifier)
+				}
+			}
+			if properties.Uint32(PROPERTY_SESSION_EXPIRY_INTERVAL) != c.properties.Uint32(PROPERTY_SESSION_EXPIRY_INTERVAL) ||
+				properties.Uint16(PROPERTY_TOPIC_ALIAS_MAXIMUM) != c.properties.Uint16(PROPERTY_TOPIC_ALIAS_MAXIMUM) {
+				t.Fatal("unexpected property values")
+			}
+			if len(properties.UserProperties()) != len(c.properties.UserProperties()) {
+				t.Fatalf("expected %d user properties got %d", len(c.properties.UserProperties()), len(properties.UserProperties()))
+			}
+			for i, userProperty := range properties.UserProperties() {
+				if *userProperty != *c.properties.UserProperties()[i] {
+					t.Fatalf("user property %d mismatch", i)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMqttConnectMalformed(t *testing.T) {
+	valid := (&MqttMessage{}).UseLevel(MQTT_LEVEL_5).UseProperties(NewMqttProperties().SetUint32(PROPERTY_SESSION_EXPIRY_INTERVAL, 10))
+	valid.MakeConnectMessage("client", 60, true, "", nil, nil)
+	data := valid.Bytes()
+
+	protocol := append([]byte{}, data...)
+	copy(protocol[4:8], "MQIs")
+
+	length := append([]byte{}, data...)
+	length[1]++
+
+	property := append([]byte{}, data...)
+	property[13] = 0x7F
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"protocol name", protocol},
+		{"remaining length", length},
+		{"truncated", data[:len(data)-3]},
+		{"unknown property", property},
+		{"short header", []byte{CONNECT << 4, 0x02, 0x00, 0x04}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseMqttMessage(c.data)
+			if err == nil {
+				t.Fatal("expected parse error")
+			}
+		})
+	}
+}
+
+func TestMqttConnackCodes(t *testing.T) {
+	cases := []struct {
+		returnCode byte
+		reasonCode byte
+	}{
+		{CONNACK_ACCEPTED, REASON_SUCCESS},
+		{CONNACK_REFUSED_PROTOCOL_VERSION, REASON_UNSUPPORTED_PROTOCOL_VERSION},
+		{CONNACK_REFUSED_IDENTIFIER_REJECTED, REASON_CLIENT_IDENTIFIER_NOT_VALID},
+		{CONNACK_REFUSED_SERVER_UNAVAILABLE, REASON_SERVER_UNAVAILABLE},
+		{CONNACK_REFUSED_BAD_USERNAME_PASSWORD, REASON_BAD_USER_NAME_OR_PASSWORD},
+		{CONNACK_REFUSED_NOT_AUTHORIZED, REASON_NOT_AUTHORIZED},
+	}
+	for _, c := range cases {
+		if reasonCode := MqttConnackReasonCode(c.returnCode); reasonCode != c.reasonCode {
+			t.Fatalf("return code 0x%02x expected reason 0x%02x got 0x%02x", c.returnCode, c.reasonCode, reasonCode)
+		}
+		if returnCode := MqttConnackReturnCode(c.reasonCode); returnCode != c.returnCode {
+			t.Fatalf("reason code 0x%02x expected return 0x%02x got 0x%02x", c.reasonCode, c.returnCode, returnCode)
+		}
+		for _, level := range []byte{MQTT_LEVEL_3_1_1, MQTT_LEVEL_5} {
+			message := (&MqttMessage{}).UseLevel(level).UseProperties(NewMqttProperties())
+			message.MakeConnackCodeMessage(0x00, c.returnCode)
+			xmessage, err := ParseMqttMessage(message.Bytes(), level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := c.returnCode
+			if level == MQTT_LEVEL_5 {
+				expected = c.reasonCode
+			}
+			if xmessage.ReasonCode() != expected {
+				t.Fatalf("level %d expected connack code 0x%02x got 0x%02x", level, expected, xmessage.ReasonCode())
+			}
+		}
+	}
+	for _, reasonCode := range []byte{REASON_BANNED, REASON_BAD_AUTHENTICATION_METHOD} {
+		if MqttConnackReturnCode(reasonCode) != CONNACK_REFUSED_NOT_AUTHORIZED {
+			t.Fatalf("reason code 0x%02x expected not authorized", reasonCode)
+		}
+	}
+	if MqttConnackReturnCode(REASON_SERVER_BUSY) != CONNACK_REFUSED_SERVER_UNAVAILABLE {
+		t.Fatal("unmapped reason code expected server unavailable")
+	}
+}
+
+type xTestMqttAuthenticator byte
+
+func (returnCode xTestMqttAuthenticator) Authenticate(_ string, _ string, _ []byte) byte {
+	return byte(returnCode)
+}
+
+func TestMqttConnectRefused(t *testing.T) {
+	cases := []struct {
+		name          string
+		level         byte
+		clientId      string
+		cleanSession  bool
+		properties    *MqttProperties
+		authenticator MqttAuthenticator
+		code          byte
+	}{
+		{name: "unsupported level", level: 0x03, clientId: "c", cleanSession: true, code: CONNACK_REFUSED_PROTOCOL_VERSION},
+		{name: "3.1.1 empty clientId persistent", level: MQTT_LEVEL_3_1_1, cleanSession: false, code: CONNACK_REFUSED_IDENTIFIER_REJECTED},
+		{name: "3.1.1 bad credentials", level: MQTT_LEVEL_3_1_1, clientId: "c", cleanSession: true,
+			authenticator: xTestMqttAuthenticator(CONNACK_REFUSED_BAD_USERNAME_PASSWORD), code: CONNACK_REFUSED_BAD_USERNAME_PASSWORD},
+		{name: "5 bad credentials", level: MQTT_LEVEL_5, clientId: "c", cleanSession: true, properties: NewMqttProperties(),
+			authenticator: xTestMqttAuthenticator(CONNACK_REFUSED_BAD_USERNAME_PASSWORD), code: REASON_BAD_USER_NAME_OR_PASSWORD},
+		{name: "5 banned", level: MQTT_LEVEL_5, clientId: "c", cleanSession: true, properties: NewMqttProperties(),
+			authenticator: xTestMqttAuthenticator(REASON_BANNED), code: REASON_BANNED},
+		{name: "5 authentication method", level: MQTT_LEVEL_5, clientId: "c", cleanSession: true,
+			properties: NewMqttProperties().SetString(PROPERTY_AUTHENTICATION_METHOD, "SCRAM-SHA-1"), code: REASON_BAD_AUTHENTICATION_METHOD},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mqttserv := newTestMqttServer()
+			mqttserv.AddAuthenticator(c.authenticator)
+
+			level := c.level
+			if level != MQTT_LEVEL_5 {
+				level = MQTT_LEVEL_3_1_1
+			}
+			peer := newTestMqttPeer(mqttserv, level)
+			message := (&MqttMessage{}).UseLevel(c.level).UseProperties(c.properties)
+			message.MakeConnectMessage(c.clientId, 60, c.cleanSession, "", nil, nil)
+			peer.conn.OnMessage(message.Bytes())
+
+			connack := peer.next(t, CONNACK)
+			if connack.ReasonCode() != c.code {
+				t.Fatalf("expected connack code 0x%02x got 0x%02x", c.code, connack.ReasonCode())
+			}
+			peer.closed(t)
+			if _, err := mqttserv.UseConnect(c.clientId); err == nil && len(c.clientId) > 0 {
+				t.Fatal("refused connect registered")
+			}
+		})
+	}
+}
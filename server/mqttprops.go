@@ -0,0 +1,436 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	MQTT_LEVEL_5 = 0x05
+
+	AUTH = 0b1111
+
+	PROPERTY_PAYLOAD_FORMAT_INDICATOR          = 0x01
+	PROPERTY_MESSAGE_EXPIRY_INTERVAL           = 0x02
+	PROPERTY_CONTENT_TYPE                      = 0x03
+	PROPERTY_RESPONSE_TOPIC                    = 0x08
+	PROPERTY_CORRELATION_DATA                  = 0x09
+	PROPERTY_SUBSCRIPTION_IDENTIFIER           = 0x0B
+	PROPERTY_SESSION_EXPIRY_INTERVAL           = 0x11
+	PROPERTY_ASSIGNED_CLIENT_IDENTIFIER        = 0x12
+	PROPERTY_SERVER_KEEP_ALIVE                 = 0x13
+	PROPERTY_AUTHENTICATION_METHOD             = 0x15
+	PROPERTY_AUTHENTICATION_DATA               = 0x16
+	PROPERTY_REQUEST_PROBLEM_INFORMATION       = 0x17
+	PROPERTY_WILL_DELAY_INTERVAL               = 0x18
+	PROPERTY_REQUEST_RESPONSE_INFORMATION      = 0x19
+	PROPERTY_RESPONSE_INFORMATION              = 0x1A
+	PROPERTY_SERVER_REFERENCE                  = 0x1C
+	PROPERTY_REASON_STRING                     = 0x1F
+	PROPERTY_RECEIVE_MAXIMUM                   = 0x21
+	PROPERTY_TOPIC_ALIAS_MAXIMUM               = 0x22
+	PROPERTY_TOPIC_ALIAS                       = 0x23
+	PROPERTY_MAXIMUM_QOS                       = 0x24
+	PROPERTY_RETAIN_AVAILABLE                  = 0x25
+	PROPERTY_USER_PROPERTY                     = 0x26
+	PROPERTY_MAXIMUM_PACKET_SIZE               = 0x27
+	PROPERTY_WILDCARD_SUBSCRIPTION_AVAILABLE   = 0x28
+	PROPERTY_SUBSCRIPTION_IDENTIFIER_AVAILABLE = 0x29
+	PROPERTY_SHARED_SUBSCRIPTION_AVAILABLE     = 0x2A
+
+	REASON_SUCCESS                                = 0x00
+	REASON_NORMAL_DISCONNECTION                   = 0x00
+	REASON_GRANTED_QOS0                           = 0x00
+	REASON_GRANTED_QOS1                           = 0x01
+	REASON_GRANTED_QOS2                           = 0x02
+	REASON_DISCONNECT_WITH_WILL_MESSAGE           = 0x04
+	REASON_NO_MATCHING_SUBSCRIBERS                = 0x10
+	REASON_NO_SUBSCRIPTION_EXISTED                = 0x11
+	REASON_CONTINUE_AUTHENTICATION                = 0x18
+	REASON_REAUTHENTICATE                         = 0x19
+	REASON_UNSPECIFIED_ERROR                      = 0x80
+	REASON_MALFORMED_PACKET                       = 0x81
+	REASON_PROTOCOL_ERROR                         = 0x82
+	REASON_IMPLEMENTATION_SPECIFIC_ERROR          = 0x83
+	REASON_UNSUPPORTED_PROTOCOL_VERSION           = 0x84
+	REASON_CLIENT_IDENTIFIER_NOT_VALID            = 0x85
+	REASON_BAD_USER_NAME_OR_PASSWORD              = 0x86
+	REASON_NOT_AUTHORIZED                         = 0x87
+	REASON_SERVER_UNAVAILABLE                     = 0x88
+	REASON_SERVER_BUSY                            = 0x89
+	REASON_BANNED                                 = 0x8A
+	REASON_SERVER_SHUTTING_DOWN                   = 0x8B
+	REASON_BAD_AUTHENTICATION_METHOD              = 0x8C
+	REASON_KEEP_ALIVE_TIMEOUT                     = 0x8D
+	REASON_SESSION_TAKEN_OVER                     = 0x8E
+	REASON_TOPIC_FILTER_INVALID                   = 0x8F
+	REASON_TOPIC_NAME_INVALID                     = 0x90
+	REASON_PACKET_IDENTIFIER_IN_USE               = 0x91
+	REASON_PACKET_IDENTIFIER_NOT_FOUND            = 0x92
+	REASON_RECEIVE_MAXIMUM_EXCEEDED               = 0x93
+	REASON_TOPIC_ALIAS_INVALID                    = 0x94
+	REASON_PACKET_TOO_LARGE                       = 0x95
+	REASON_MESSAGE_RATE_TOO_HIGH                  = 0x96
+	REASON_QUOTA_EXCEEDED                         = 0x97
+	REASON_ADMINISTRATIVE_ACTION                  = 0x98
+	REASON_PAYLOAD_FORMAT_INVALID                 = 0x99
+	REASON_RETAIN_NOT_SUPPORTED                   = 0x9A
+	REASON_QOS_NOT_SUPPORTED                      = 0x9B
+	REASON_USE_ANOTHER_SERVER                     = 0x9C
+	REASON_SERVER_MOVED                           = 0x9D
+	REASON_SHARED_SUBSCRIPTIONS_NOT_SUPPORTED     = 0x9E
+	REASON_CONNECTION_RATE_EXCEEDED               = 0x9F
+	REASON_MAXIMUM_CONNECT_TIME                   = 0xA0
+	REASON_SUBSCRIPTION_IDENTIFIERS_NOT_SUPPORTED = 0xA1
+	REASON_WILDCARD_SUBSCRIPTIONS_NOT_SUPPORTED   = 0xA2
+)
+
+const (
+	xPROPERTY_TYPE_BYTE = iota
+	xPROPERTY_TYPE_UINT16
+	xPROPERTY_TYPE_UINT32
+	xPROPERTY_TYPE_VARINT
+	xPROPERTY_TYPE_STRING
+	xPROPERTY_TYPE_BINARY
+	xPROPERTY_TYPE_STRING_PAIR
+)
+
+var xMqttPropertyTypes = map[byte]int{
+	PROPERTY_PAYLOAD_FORMAT_INDICATOR:          xPROPERTY_TYPE_BYTE,
+	PROPERTY_MESSAGE_EXPIRY_INTERVAL:           xPROPERTY_TYPE_UINT32,
+	PROPERTY_CONTENT_TYPE:                      xPROPERTY_TYPE_STRING,
+	PROPERTY_RESPONSE_TOPIC:                    xPROPERTY_TYPE_STRING,
+	PROPERTY_CORRELATION_DATA:                  xPROPERTY_TYPE_BINARY,
+	PROPERTY_SUBSCRIPTION_IDENTIFIER:           xPROPERTY_TYPE_VARINT,
+	PROPERTY_SESSION_EXPIRY_INTERVAL:           xPROPERTY_TYPE_UINT32,
+	PROPERTY_ASSIGNED_CLIENT_IDENTIFIER:        xPROPERTY_TYPE_STRING,
+	PROPERTY_SERVER_KEEP_ALIVE:                 xPROPERTY_TYPE_UINT16,
+	PROPERTY_AUTHENTICATION_METHOD:             xPROPERTY_TYPE_STRING,
+	PROPERTY_AUTHENTICATION_DATA:               xPROPERTY_TYPE_BINARY,
+	PROPERTY_REQUEST_PROBLEM_INFORMATION:       xPROPERTY_TYPE_BYTE,
+	PROPERTY_WILL_DELAY_INTERVAL:               xPROPERTY_TYPE_UINT32,
+	PROPERTY_REQUEST_RESPONSE_INFORMATION:      xPROPERTY_TYPE_BYTE,
+	PROPERTY_RESPONSE_INFORMATION:              xPROPERTY_TYPE_STRING,
+	PROPERTY_SERVER_REFERENCE:                  xPROPERTY_TYPE_STRING,
+	PROPERTY_REASON_STRING:                     xPROPERTY_TYPE_STRING,
+	PROPERTY_RECEIVE_MAXIMUM:                   xPROPERTY_TYPE_UINT16,
+	PROPERTY_TOPIC_ALIAS_MAXIMUM:               xPROPERTY_TYPE_UINT16,
+	PROPERTY_TOPIC_ALIAS:                       xPROPERTY_TYPE_UINT16,
+	PROPERTY_MAXIMUM_QOS:                       xPROPERTY_TYPE_BYTE,
+	PROPERTY_RETAIN_AVAILABLE:                  xPROPERTY_TYPE_BYTE,
+	PROPERTY_USER_PROPERTY:                     xPROPERTY_TYPE_STRING_PAIR,
+	PROPERTY_MAXIMUM_PACKET_SIZE:               xPROPERTY_TYPE_UINT32,
+	PROPERTY_WILDCARD_SUBSCRIPTION_AVAILABLE:   xPROPERTY_TYPE_BYTE,
+	PROPERTY_SUBSCRIPTION_IDENTIFIER_AVAILABLE: xPROPERTY_TYPE_BYTE,
+	PROPERTY_SHARED_SUBSCRIPTION_AVAILABLE:     xPROPERTY_TYPE_BYTE,
+}
+
+func MqttConnackReasonCode(returnCode byte) byte {
+	switch returnCode {
+	case CONNACK_REFUSED_PROTOCOL_VERSION:
+		return REASON_UNSUPPORTED_PROTOCOL_VERSION
+	case CONNACK_REFUSED_IDENTIFIER_REJECTED:
+		return REASON_CLIENT_IDENTIFIER_NOT_VALID
+	case CONNACK_REFUSED_SERVER_UNAVAILABLE:
+		return REASON_SERVER_UNAVAILABLE
+	case CONNACK_REFUSED_BAD_USERNAME_PASSWORD:
+		return REASON_BAD_USER_NAME_OR_PASSWORD
+	case CONNACK_REFUSED_NOT_AUTHORIZED:
+		return REASON_NOT_AUTHORIZED
+	default:
+		return returnCode
+	}
+}
+
+func MqttConnackReturnCode(reasonCode byte) byte {
+	switch reasonCode {
+	case REASON_SUCCESS:
+		return CONNACK_ACCEPTED
+	case REASON_UNSUPPORTED_PROTOCOL_VERSION:
+		return CONNACK_REFUSED_PROTOCOL_VERSION
+	case REASON_CLIENT_IDENTIFIER_NOT_VALID:
+		return CONNACK_REFUSED_IDENTIFIER_REJECTED
+	case REASON_BAD_USER_NAME_OR_PASSWORD:
+		return CONNACK_REFUSED_BAD_USERNAME_PASSWORD
+	case REASON_NOT_AUTHORIZED, REASON_BANNED, REASON_BAD_AUTHENTICATION_METHOD:
+		return CONNACK_REFUSED_NOT_AUTHORIZED
+	default:
+		if reasonCode <= CONNACK_REFUSED_NOT_AUTHORIZED {
+			return reasonCode
+		}
+		return CONNACK_REFUSED_SERVER_UNAVAILABLE
+	}
+}
+
+func mqttVarint(length int) []byte {
+	return (&MqttFixedHeader{}).varLTB(length)
+}
+
+func mqttParseVarint(data []byte) (int, int, error) {
+	value := 0
+	for i := 0; i < 4; i++ {
+		if i >= len(data) {
+			return 0, 0, fmt.Errorf("incomplete variable byte integer")
+		}
+		value += int(data[i]&0x7F) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return value, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("malformed variable byte integer")
+}
+
+func mqttParseBinary(data []byte) ([]byte, int, error) {
+	if len(data) < 2 {
+		return nil, 0, fmt.Errorf("incomplete binary length")
+	}
+	length := int(binary.BigEndian.Uint16(data[:2]))
+	if len(data) < 2+length {
+		return nil, 0, fmt.Errorf("incomplete binary data, expected %d real %d", length, len(data)-2)
+	}
+	return data[2 : 2+length], 2 + length, nil
+}
+
+func mqttBinary(data []byte) []byte {
+	bytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(bytes, uint16(len(data)))
+	return append(bytes, data...)
+}
+
+type MqttUserProperty struct {
+	Key   string
+	Value string
+}
+
+type MqttProperties struct {
+	properties              map[byte]any
+	userProperties          []*MqttUserProperty
+	subscriptionIdentifiers []int
+}
+
+func NewMqttProperties() *MqttProperties {
+	return &MqttProperties{
+		properties:              make(map[byte]any),
+		userProperties:          make([]*MqttUserProperty, 0),
+		subscriptionIdentifiers: make([]int, 0),
+	}
+}
+
+func (props *MqttProperties) build(data []byte) (int, error) {
+	props.properties = make(map[byte]any)
+	props.userProperties = make([]*MqttUserProperty, 0)
+	props.subscriptionIdentifiers = make([]int, 0)
+
+	length, lengthSize, err := mqttParseVarint(data)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < lengthSize+length {
+		return 0, fmt.Errorf("incomplete properties, expected %d real %d", length, len(data)-lengthSize)
+	}
+
+	xdata := data[lengthSize : lengthSize+length]
+	index := 0
+	for index < len(xdata) {
+		identifier := xdata[index]
+		index++
+		propertyType, ok := xMqttPropertyTypes[identifier]
+		if !ok {
+			return 0, fmt.Errorf("unknown property identifier 0x%02x", identifier)
+		}
+		switch propertyType {
+		case xPROPERTY_TYPE_BYTE:
+			props.properties[identifier] = xdata[index]
+			index++
+		case xPROPERTY_TYPE_UINT16:
+			props.properties[identifier] = binary.BigEndian.Uint16(xdata[index : index+2])
+			index += 2
+		case xPROPERTY_TYPE_UINT32:
+			props.properties[identifier] = binary.BigEndian.Uint32(xdata[index : index+4])
+			index += 4
+		case xPROPERTY_TYPE_VARINT:
+			value, size, err := mqttParseVarint(xdata[index:])
+			if err != nil {
+				return 0, err
+			}
+			if identifier == PROPERTY_SUBSCRIPTION_IDENTIFIER {
+				props.subscriptionIdentifiers = append(props.subscriptionIdentifiers, value)
+			} else {
+				props.properties[identifier] = value
+			}
+			index += size
+		case xPROPERTY_TYPE_STRING, xPROPERTY_TYPE_BINARY:
+			value, size, err := mqttParseBinary(xdata[index:])
+			if err != nil {
+				return 0, err
+			}
+			props.properties[identifier] = value
+			index += size
+		case xPROPERTY_TYPE_STRING_PAIR:
+			key, size, err := mqttParseBinary(xdata[index:])
+			if err != nil {
+				return 0, err
+			}
+			index += size
+			value, size, err := mqttParseBinary(xdata[index:])
+			if err != nil {
+				return 0, err
+			}
+			index += size
+			props.userProperties = append(props.userProperties, &MqttUserProperty{Key: string(key), Value: string(value)})
+		}
+	}
+	return lengthSize + length, nil
+}
+
+func (props *MqttProperties) Bytes() []byte {
+	bytes := make([]byte, 0)
+	if props != nil {
+		for identifier, value := range props.properties {
+			bytes = append(bytes, identifier)
+			switch xMqttPropertyTypes[identifier] {
+			case xPROPERTY_TYPE_BYTE:
+				bytes = append(bytes, value.(byte))
+			case xPROPERTY_TYPE_UINT16:
+				bytes = binary.BigEndian.AppendUint16(bytes, value.(uint16))
+			case xPROPERTY_TYPE_UINT32:
+				bytes = binary.BigEndian.AppendUint32(bytes, value.(uint32))
+			case xPROPERTY_TYPE_VARINT:
+				bytes = append(bytes, mqttVarint(value.(int))...)
+			case xPROPERTY_TYPE_STRING, xPROPERTY_TYPE_BINARY:
+				bytes = append(bytes, mqttBinary(value.([]byte))...)
+			}
+		}
+		for _, subscriptionIdentifier := range props.subscriptionIdentifiers {
+			bytes = append(bytes, PROPERTY_SUBSCRIPTION_IDENTIFIER)
+			bytes = append(bytes, mqttVarint(subscriptionIdentifier)...)
+		}
+		for _, userProperty := range props.userProperties {
+			bytes = append(bytes, PROPERTY_USER_PROPERTY)
+			bytes = append(bytes, mqttBinary([]byte(userProperty.Key))...)
+			bytes = append(bytes, mqttBinary([]byte(userProperty.Value))...)
+		}
+	}
+	return append(mqttVarint(len(bytes)), bytes...)
+}
+
+func (props *MqttProperties) Has(identifier byte) bool {
+	if props == nil {
+		return false
+	}
+	_, ok := props.properties[identifier]
+	return ok
+}
+
+func (props *MqttProperties) Del(identifier byte) *MqttProperties {
+	delete(props.properties, identifier)
+	return props
+}
+
+func (props *MqttProperties) Byte(identifier byte) byte {
+	if !props.Has(identifier) {
+		return 0
+	}
+	return props.properties[identifier].(byte)
+}
+
+func (props *MqttProperties) Uint16(identifier byte) uint16 {
+	if !props.Has(identifier) {
+		return 0
+	}
+	return props.properties[identifier].(uint16)
+}
+
+func (props *MqttProperties) Uint32(identifier byte) uint32 {
+	if !props.Has(identifier) {
+		return 0
+	}
+	return props.properties[identifier].(uint32)
+}
+
+func (props *MqttProperties) Varint(identifier byte) int {
+	if !props.Has(identifier) {
+		return 0
+	}
+	return props.properties[identifier].(int)
+}
+
+func (props *MqttProperties) String(identifier byte) string {
+	if !props.Has(identifier) {
+		return ""
+	}
+	return string(props.properties[identifier].([]byte))
+}
+
+func (props *MqttProperties) Binary(identifier byte) []byte {
+	if !props.Has(identifier) {
+		return nil
+	}
+	return props.properties[identifier].([]byte)
+}
+
+func (props *MqttProperties) UserProperties() []*MqttUserProperty {
+	if props == nil {
+		return nil
+	}
+	return props.userProperties
+}
+
+func (props *MqttProperties) SubscriptionIdentifiers() []int {
+	if props == nil {
+		return nil
+	}
+	return props.subscriptionIdentifiers
+}
+
+func (props *MqttProperties) SetByte(identifier byte, value byte) *MqttProperties {
+	props.properties[identifier] = value
+	return props
+}
+
+func (props *MqttProperties) SetUint16(identifier byte, value uint16) *MqttProperties {
+	props.properties[identifier] = value
+	return props
+}
+
+func (props *MqttProperties) SetUint32(identifier byte, value uint32) *MqttProperties {
+	props.properties[identifier] = value
+	return props
+}
+
+func (props *MqttProperties) SetVarint(identifier byte, value int) *MqttProperties {
+	if identifier == PROPERTY_SUBSCRIPTION_IDENTIFIER {
+		props.subscriptionIdentifiers = append(props.subscriptionIdentifiers, value)
+	} else {
+		props.properties[identifier] = value
+	}
+	return props
+}
+
+func (props *MqttProperties) SetString(identifier byte, value string) *MqttProperties {
+	props.properties[identifier] = []byte(value)
+	return props
+}
+
+func (props *MqttProperties) SetBinary(identifier byte, value []byte) *MqttProperties {
+	props.properties[identifier] = value
+	return props
+}
+
+func (props *MqttProperties) AddUserProperty(key string, value string) *MqttProperties {
+	props.userProperties = append(props.userProperties, &MqttUserProperty{Key: key, Value: value})
+	return props
+}
+
+func (props *MqttProperties) Clone() *MqttProperties {
+	xprops := NewMqttProperties()
+	if props == nil {
+		return xprops
+	}
+	for identifier, value := range props.properties {
+		xprops.properties[identifier] = value
+	}
+	xprops.userProperties = append(xprops.userProperties, props.userProperties...)
+	xprops.subscriptionIdentifiers = append(xprops.subscriptionIdentifiers, props.subscriptionIdentifiers...)
+	return xprops
+}
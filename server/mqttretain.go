@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+)
+
+const (
+	MQTT_DEFAULT_SESSION_QUEUE = 1000
+)
+
+type xMqttStoredMessage struct {
+	topic      string
+	qos        byte
+	data       []byte
+	properties *MqttProperties
+	expireAt   int64
+}
+
+func newMqttStoredMessage(topic string, qos byte, data []byte, properties *MqttProperties) *xMqttStoredMessage {
+	message := &xMqttStoredMessage{
+		topic:      topic,
+		qos:        qos,
+		data:       data,
+		properties: properties,
+	}
+	if properties.Has(PROPERTY_MESSAGE_EXPIRY_INTERVAL) {
+		message.expireAt = time.Now().Unix() + int64(properties.Uint32(PROPERTY_MESSAGE_EXPIRY_INTERVAL))
+	}
+	return message
+}
+
+func (message *xMqttStoredMessage) expired(now int64) bool {
+	return message.expireAt > 0 && message.expireAt <= now
+}
+
+func (message *xMqttStoredMessage) forward(now int64) *MqttProperties {
+	if message.properties == nil {
+		return nil
+	}
+	xproperties := message.properties.Clone().Del(PROPERTY_TOPIC_ALIAS)
+	if message.expireAt > 0 {
+		xproperties.SetUint32(PROPERTY_MESSAGE_EXPIRY_INTERVAL, uint32(message.expireAt-now))
+	}
+	return xproperties
+}
+
+func (message *xMqttStoredMessage) deliver(mqttconn *MqttConnect, qos byte, retain bool) error {
+	now := time.Now().Unix()
+	if message.expired(now) {
+		return nil
+	}
+	xqos := message.qos
+	if qos < xqos {
+		xqos = qos
+	}
+	return mqttconn.publish(message.topic, xqos, retain, message.data, message.forward(now))
+}
+
+type xMqttRetained struct {
+	messages      map[string]*xMqttStoredMessage
+	messagesMutex sync.Mutex
+}
+
+func (retained *xMqttRetained) keep(message *xMqttStoredMessage) {
+	retained.messagesMutex.Lock()
+	defer retained.messagesMutex.Unlock()
+	if len(message.data) <= 0 {
+		delete(retained.messages, message.topic)
+		return
+	}
+	retained.messages[message.topic] = message
+}
+
+func (retained *xMqttRetained) match(filter string) []*xMqttStoredMessage {
+	retained.messagesMutex.Lock()
+	defer retained.messagesMutex.Unlock()
+	now := time.Now().Unix()
+	messages := make([]*xMqttStoredMessage, 0)
+	for topic, message := range retained.messages {
+		if message.expired(now) {
+			delete(retained.messages, topic)
+			continue
+		}
+		if MqttTopicMatch(filter, topic) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func (mqttserv *MqttServer) retain(topic string, qos byte, data []byte, properties *MqttProperties) {
+	mqttserv.retained.keep(newMqttStoredMessage(topic, qos, data, properties))
+}
+
+func (mqttserv *MqttServer) deliverRetained(mqttconn *MqttConnect, topics []*MqttTopic, existed map[string]bool) {
+	for _, topic := range topics {
+		group, filter, ok := MqttShareFilter(topic.TopicName)
+		if !ok || len(group) > 0 || topic.RetainHandling == 0x02 {
+			continue
+		}
+		if topic.RetainHandling == 0x01 && existed[topic.TopicName] {
+			continue
+		}
+		granted, ok := mqttconn.granted(topic.TopicName)
+		if !ok {
+			continue
+		}
+		for _, message := range mqttserv.retained.match(filter) {
+			err := message.deliver(mqttconn, granted, true)
+			if err != nil {
+				global.Logger().Error(fmt.Sprintf("mqtt server retained `%s` to %s error %s", message.topic, mqttconn.RemoteAddr(), err.Error()))
+				return
+			}
+		}
+	}
+}
+
+func (mqttserv *MqttServer) enqueue(message *xMqttStoredMessage) {
+	if message.qos <= Qos0 {
+		return
+	}
+	mqttserv.sessionsMutex.Lock()
+	defer mqttserv.sessionsMutex.Unlock()
+	for _, session := range mqttserv.sessions {
+		granted := byte(0)
+		for filter, qos := range session.topics {
+			group, xfilter, _ := MqttShareFilter(filter)
+			if len(group) <= 0 && MqttTopicMatch(xfilter, message.topic) && qos > granted {
+				granted = qos
+			}
+		}
+		if granted <= Qos0 {
+			continue
+		}
+		if len(session.queue) >= MQTT_DEFAULT_SESSION_QUEUE {
+			session.queue = session.queue[1:]
+		}
+		session.queue = append(session.queue, &xMqttSessionMessage{message: message, qos: granted})
+	}
+}
+
+func (mqttserv *MqttServer) deliverQueue(mqttconn *MqttConnect, queue []*xMqttSessionMessage) {
+	for _, queued := range queue {
+		err := queued.message.deliver(mqttconn, queued.qos, false)
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("mqtt server queued `%s` to %s error %s", queued.message.topic, mqttconn.RemoteAddr(), err.Error()))
+			return
+		}
+	}
+}
@@ -3,13 +3,24 @@ package server
 import (
 	"fmt"
 	"net"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/0meet1/zero-framework/global"
+	"github.com/gofrs/uuid"
 )
 
 const (
 	CORE_MQTT_SERVER = "X##!CORE_MQTT_SERVER"
+
+	MQTT_GENERATED_CLIENTID_PREFIX = "zero-"
+	MQTT_SHARE_PREFIX              = "$share/"
+	MQTT_SYS_PREFIX                = "$SYS/"
+
+	MQTT_SESSION_NEVER_EXPIRE        = 0xFFFFFFFF
+	MQTT_DEFAULT_TOPIC_ALIAS_MAXIMUM = 16
 )
 
 type MqttMessageListener interface {
@@ -123,10 +134,23 @@ var DefaultMqttChecker = func() *xMqttDataChecker {
 type MqttConnect struct {
 	ZeroSocketConnect
 
+	clientId      string
+	username      string
+	connected     bool
+	cleanSession  bool
+	level         byte
+	sessionExpiry uint32
+
 	topcis               map[string]byte
+	topcisMutex          sync.RWMutex
 	messageSerialNnumber uint16
 	serialNnumberMutex   sync.Mutex
 
+	topicAliasMaximum uint16
+	inboundAliases    map[uint16]string
+	outboundAliases   map[string]uint16
+	aliasesMutex      sync.Mutex
+
 	xListener MqttMessageListener
 }
 
@@ -142,14 +166,41 @@ func (mqttconn *MqttConnect) AddListener(xListener MqttMessageListener) {
 	mqttconn.xListener = xListener
 }
 
+func (mqttconn *MqttConnect) ClientId() string {
+	return mqttconn.clientId
+}
+
+func (mqttconn *MqttConnect) UserName() string {
+	return mqttconn.username
+}
+
+func (mqttconn *MqttConnect) CleanSession() bool {
+	return mqttconn.cleanSession
+}
+
+func (mqttconn *MqttConnect) Level() byte {
+	if mqttconn.level == 0 {
+		return MQTT_LEVEL_3_1_1
+	}
+	return mqttconn.level
+}
+
 func (mqttconn *MqttConnect) RegisterId() string {
+	if len(mqttconn.clientId) > 0 {
+		return mqttconn.clientId
+	}
 	return mqttconn.This().(ZeroConnect).RemoteAddr()
 }
 
 func (mqttconn *MqttConnect) Accept(_ ZeroServ, connect net.Conn) error {
 	mqttconn.ZeroSocketConnect.Accept(global.Value(CORE_MQTT_SERVER).(*MqttServer), connect)
+	mqttconn.topcisMutex.Lock()
 	mqttconn.topcis = make(map[string]byte)
+	mqttconn.topcisMutex.Unlock()
 	mqttconn.messageSerialNnumber = 0
+	mqttconn.connected = false
+	mqttconn.inboundAliases = make(map[uint16]string)
+	mqttconn.outboundAliases = make(map[string]uint16)
 	return nil
 }
 
@@ -157,11 +208,15 @@ func (mqttconn *MqttConnect) Close() error {
 	err := mqttconn.ZeroSocketConnect.Close()
 
 	mqttserv := mqttconn.zserv.(*MqttServer)
+	topics := mqttconn.topics()
 	mqttserv.topicsMapMutex.Lock()
-	for topic := range mqttconn.topcis {
+	for topic := range topics {
 		_, ok := mqttserv.topicsMap[topic]
 		if ok {
-			delete(mqttserv.topicsMap[topic], mqttconn.RemoteAddr())
+			xconn, ok := mqttserv.topicsMap[topic][mqttconn.RegisterId()]
+			if ok && xconn == mqttconn {
+				delete(mqttserv.topicsMap[topic], mqttconn.RegisterId())
+			}
 			if len(mqttserv.topicsMap[topic]) <= 0 {
 				delete(mqttserv.topicsMap, topic)
 			}
@@ -169,9 +224,30 @@ func (mqttconn *MqttConnect) Close() error {
 	}
 	mqttserv.topicsMapMutex.Unlock()
 
+	if mqttconn.connected && mqttconn.sessionExpiry > 0 {
+		mqttserv.keepSession(mqttconn.clientId, topics, mqttconn.sessionExpiry)
+	}
+	mqttconn.connected = false
+
 	return err
 }
 
+func (mqttconn *MqttConnect) Disconnect(reasonCode byte, reasonString ...string) error {
+	if mqttconn.Level() == MQTT_LEVEL_5 && mqttconn.Active() {
+		properties := NewMqttProperties()
+		if len(reasonString) > 0 && len(reasonString[0]) > 0 {
+			properties.SetString(PROPERTY_REASON_STRING, reasonString[0])
+		}
+		message := mqttconn.newMessage().UseReasonCode(reasonCode).UseProperties(properties)
+		message.MakeDisconnectMessage()
+		err := mqttconn.This().(ZeroConnect).Write(message.Bytes())
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("mqtt connect %s write disconnect error %s", mqttconn.RemoteAddr(), err.Error()))
+		}
+	}
+	return mqttconn.This().(ZeroConnect).Close()
+}
+
 func (mqttconn *MqttConnect) UpdateSerialNnumber(serialNnumber uint16) {
 	mqttconn.serialNnumberMutex.Lock()
 	if mqttconn.messageSerialNnumber < serialNnumber || serialNnumber < 10 {
@@ -183,16 +259,117 @@ func (mqttconn *MqttConnect) UpdateSerialNnumber(serialNnumber uint16) {
 func (mqttconn *MqttConnect) UseSerialNnumber() uint16 {
 	mqttconn.serialNnumberMutex.Lock()
 	mqttconn.messageSerialNnumber++
+	if mqttconn.messageSerialNnumber == 0 {
+		mqttconn.messageSerialNnumber++
+	}
 	serialNnumber := mqttconn.messageSerialNnumber
 	mqttconn.serialNnumberMutex.Unlock()
 	return serialNnumber
 }
 
+func (mqttconn *MqttConnect) newMessage() *MqttMessage {
+	return (&MqttMessage{}).UseLevel(mqttconn.Level())
+}
+
+func (mqttconn *MqttConnect) useTopicAlias(topic string, properties *MqttProperties) string {
+	if mqttconn.topicAliasMaximum <= 0 {
+		return topic
+	}
+	mqttconn.aliasesMutex.Lock()
+	defer mqttconn.aliasesMutex.Unlock()
+	alias, ok := mqttconn.outboundAliases[topic]
+	if ok {
+		properties.SetUint16(PROPERTY_TOPIC_ALIAS, alias)
+		return ""
+	}
+	if len(mqttconn.outboundAliases) < int(mqttconn.topicAliasMaximum) {
+		alias = uint16(len(mqttconn.outboundAliases) + 1)
+		mqttconn.outboundAliases[topic] = alias
+		properties.SetUint16(PROPERTY_TOPIC_ALIAS, alias)
+	}
+	return topic
+}
+
+func (mqttconn *MqttConnect) publish(topic string, qos byte, retain bool, data []byte, properties *MqttProperties) error {
+	identifier := uint16(0)
+	if qos > Qos0 {
+		identifier = mqttconn.UseSerialNnumber()
+	}
+
+	var xproperties *MqttProperties
+	if mqttconn.Level() == MQTT_LEVEL_5 {
+		if properties != nil {
+			xproperties = properties.Clone()
+		} else {
+			xproperties = NewMqttProperties()
+		}
+		topic = mqttconn.useTopicAlias(topic, xproperties)
+	}
+
+	flag := qos << 1
+	if retain {
+		flag |= 0b1
+	}
+	message := mqttconn.newMessage().UseProperties(xproperties)
+	message.MakePublistMessage(topic, identifier, flag, data)
+	return mqttconn.This().(ZeroConnect).Write(message.Bytes())
+}
+
+func (mqttconn *MqttConnect) Publish(topic string, qos byte, data []byte, properties ...*MqttProperties) error {
+	if len(properties) > 0 {
+		return mqttconn.publish(topic, qos, false, data, properties[0])
+	}
+	return mqttconn.publish(topic, qos, false, data, nil)
+}
+
+func (mqttconn *MqttConnect) granted(topic string) (byte, bool) {
+	mqttconn.topcisMutex.RLock()
+	defer mqttconn.topcisMutex.RUnlock()
+	qos, ok := mqttconn.topcis[topic]
+	return qos, ok
+}
+
+func (mqttconn *MqttConnect) topics() map[string]byte {
+	mqttconn.topcisMutex.RLock()
+	defer mqttconn.topcisMutex.RUnlock()
+	topics := make(map[string]byte, len(mqttconn.topcis))
+	for topic, qos := range mqttconn.topcis {
+		topics[topic] = qos
+	}
+	return topics
+}
+
+func (mqttconn *MqttConnect) Reply(request *MqttMessage, qos byte, data []byte) error {
+	responseTopic := request.Properties().String(PROPERTY_RESPONSE_TOPIC)
+	if len(responseTopic) <= 0 {
+		return fmt.Errorf("mqtt connect %s request without response topic", mqttconn.RemoteAddr())
+	}
+	properties := NewMqttProperties()
+	if request.Properties().Has(PROPERTY_CORRELATION_DATA) {
+		properties.SetBinary(PROPERTY_CORRELATION_DATA, request.Properties().Binary(PROPERTY_CORRELATION_DATA))
+	}
+	return mqttconn.Publish(responseTopic, qos, data, properties)
+}
+
+func (mqttconn *MqttConnect) Write(datas []byte) error {
+	err := mqttconn.ZeroSocketConnect.Write(datas)
+	if err == nil {
+		mqttconn.zserv.(*MqttServer).statistics.sent(datas)
+	}
+	return err
+}
+
 func (mqttconn *MqttConnect) OnMessage(datas []byte) error {
+	mqttconn.zserv.(*MqttServer).statistics.received(datas)
 	mqttMessage := &MqttMessage{}
-	err := mqttMessage.build(datas)
+	err := mqttMessage.build(datas, mqttconn.level)
 	if err != nil {
 		global.Logger().Error(fmt.Sprintf("mqtt server connect %s message error %s", mqttconn.RemoteAddr(), err.Error()))
+		if mqttconn.connected {
+			mqttconn.Disconnect(REASON_MALFORMED_PACKET, err.Error())
+		} else {
+			mqttconn.This().(ZeroConnect).Close()
+		}
 		return err
 	}
 	global.Logger().Debug(fmt.Sprintf("mqtt connect %s on message type `%s`", mqttconn.RemoteAddr(), mqttMessage.FixedHeader().MessageTypeString()))
@@ -203,10 +380,136 @@ func (mqttconn *MqttConnect) OnMessage(datas []byte) error {
 	return err
 }
 
-func (mqttconn *MqttConnect) onConnect(_ *MqttMessage) error {
-	message := &MqttMessage{}
-	message.MakeConnackMessage()
-	return mqttconn.This().(ZeroConnect).Write(message.Bytes())
+func (mqttconn *MqttConnect) refuse(returnCode byte, reasonString ...string) error {
+	message := mqttconn.newMessage()
+	if mqttconn.Level() == MQTT_LEVEL_5 {
+		properties := NewMqttProperties()
+		if len(reasonString) > 0 && len(reasonString[0]) > 0 {
+			properties.SetString(PROPERTY_REASON_STRING, reasonString[0])
+		}
+		message.UseProperties(properties)
+	} else {
+		returnCode = MqttConnackReturnCode(returnCode)
+	}
+	message.MakeConnackCodeMessage(0x00, returnCode)
+	err := mqttconn.This().(ZeroConnect).Write(message.Bytes())
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("mqtt connect %s write connack error %s", mqttconn.RemoteAddr(), err.Error()))
+	}
+	mqttconn.This().(ZeroConnect).Close()
+	return fmt.Errorf("mqtt connect %s refused, return code 0x%02x", mqttconn.RemoteAddr(), returnCode)
+}
+
+func (mqttconn *MqttConnect) onConnect(mqttMessage *MqttMessage) error {
+	if mqttconn.connected {
+		return mqttconn.Disconnect(REASON_PROTOCOL_ERROR, "duplicate connect")
+	}
+
+	connectHeader := mqttMessage.VariableHeader().(*MqttConnectVariableHeader)
+	if connectHeader.Protocol() != MQTT_HEADER {
+		mqttconn.This().(ZeroConnect).Close()
+		return fmt.Errorf("mqtt connect %s invalid protocol `%s`", mqttconn.RemoteAddr(), connectHeader.Protocol())
+	}
+	if connectHeader.Level() != MQTT_LEVEL_3_1_1 && connectHeader.Level() != MQTT_LEVEL_5 {
+		return mqttconn.refuse(CONNACK_REFUSED_PROTOCOL_VERSION)
+	}
+	mqttconn.level = connectHeader.Level()
+
+	properties := connectHeader.Properties()
+	if properties.Has(PROPERTY_AUTHENTICATION_METHOD) {
+		return mqttconn.refuse(REASON_BAD_AUTHENTICATION_METHOD, "enhanced authentication not supported")
+	}
+
+	connectPayload := mqttMessage.Payload().(*MqttConnectPayload)
+	clientId := connectPayload.ClientId()
+	assigned := false
+	cleanSession := connectHeader.CleanSession() == 0b1
+	if len(clientId) <= 0 {
+		if !cleanSession && mqttconn.Level() != MQTT_LEVEL_5 {
+			return mqttconn.refuse(CONNACK_REFUSED_IDENTIFIER_REJECTED)
+		}
+		uid, err := uuid.NewV4()
+		if err != nil {
+			return mqttconn.refuse(CONNACK_REFUSED_SERVER_UNAVAILABLE)
+		}
+		clientId = fmt.Sprintf("%s%s", MQTT_GENERATED_CLIENTID_PREFIX, uid.String())
+		assigned = true
+	}
+
+	mqttserv := mqttconn.zserv.(*MqttServer)
+	if mqttserv.authenticator != nil {
+		returnCode := mqttserv.authenticator.Authenticate(clientId, connectPayload.UserName(), connectPayload.Password())
+		if returnCode != CONNACK_ACCEPTED {
+			return mqttconn.refuse(returnCode)
+		}
+	}
+
+	mqttserv.takeoverMutex.Lock()
+	defer mqttserv.takeoverMutex.Unlock()
+
+	xconn, err := mqttserv.UseConnect(clientId)
+	if err == nil && xconn != nil {
+		global.Logger().Warn(fmt.Sprintf("mqtt connect %s takeover clientId `%s` from %s", mqttconn.RemoteAddr(), clientId, xconn.RemoteAddr()))
+		if xmqttconn, ok := xconn.(*MqttConnect); ok {
+			err = xmqttconn.Disconnect(REASON_SESSION_TAKEN_OVER, "session taken over")
+		} else {
+			err = xconn.Close()
+		}
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("mqtt connect %s takeover close error %s", xconn.RemoteAddr(), err.Error()))
+		}
+	}
+
+	mqttconn.clientId = clientId
+	mqttconn.username = connectPayload.UserName()
+	mqttconn.cleanSession = cleanSession
+	if mqttconn.Level() == MQTT_LEVEL_5 {
+		mqttconn.sessionExpiry = properties.Uint32(PROPERTY_SESSION_EXPIRY_INTERVAL)
+		mqttconn.topicAliasMaximum = properties.Uint16(PROPERTY_TOPIC_ALIAS_MAXIMUM)
+	} else if !cleanSession {
+		mqttconn.sessionExpiry = MQTT_SESSION_NEVER_EXPIRE
+	}
+	mqttconn.connected = true
+
+	sessionPresent := byte(0x00)
+	session := mqttserv.takeSession(clientId)
+	if !cleanSession && session != nil {
+		sessionPresent = 0x01
+		mqttconn.topcisMutex.Lock()
+		for topic, qos := range session.topics {
+			mqttconn.topcis[topic] = qos
+		}
+		mqttconn.topcisMutex.Unlock()
+	}
+
+	message := mqttconn.newMessage()
+	if mqttconn.Level() == MQTT_LEVEL_5 {
+		connackProperties := NewMqttProperties().
+			SetUint16(PROPERTY_TOPIC_ALIAS_MAXIMUM, mqttserv.topicAliasMaximum).
+			SetByte(PROPERTY_RETAIN_AVAILABLE, 0x01).
+			SetByte(PROPERTY_SHARED_SUBSCRIPTION_AVAILABLE, 0x01).
+			SetByte(PROPERTY_SUBSCRIPTION_IDENTIFIER_AVAILABLE, 0x00).
+			SetByte(PROPERTY_WILDCARD_SUBSCRIPTION_AVAILABLE, 0x01)
+		if assigned {
+			connackProperties.SetString(PROPERTY_ASSIGNED_CLIENT_IDENTIFIER, clientId)
+		}
+		if properties.Byte(PROPERTY_REQUEST_RESPONSE_INFORMATION) == 0x01 && len(mqttserv.responseInformation) > 0 {
+			connackProperties.SetString(PROPERTY_RESPONSE_INFORMATION, fmt.Sprintf("%s/%s", mqttserv.responseInformation, clientId))
+		}
+		message.UseProperties(connackProperties)
+	}
+	message.MakeConnackCodeMessage(sessionPresent, CONNACK_ACCEPTED)
+	err = mqttconn.This().(ZeroConnect).Write(message.Bytes())
+	if err != nil {
+		return err
+	}
+	mqttconn.This().(ZeroConnect).Authorized()
+	if sessionPresent == 0x01 {
+		mqttserv.subscribe(mqttconn)
+		mqttserv.deliverQueue(mqttconn, session.queue)
+	}
+	global.Logger().Info(fmt.Sprintf("mqtt connect %s authorized, clientId `%s` level %d", mqttconn.RemoteAddr(), mqttconn.clientId, mqttconn.Level()))
+	return nil
 }
 
 func (mqttconn *MqttConnect) onPingreq(_ *MqttMessage) error {
@@ -219,29 +522,111 @@ func (mqttconn *MqttConnect) onPingreq(_ *MqttMessage) error {
 }
 
 func (mqttconn *MqttConnect) onSubscribe(mqttMessage *MqttMessage) error {
+	mqttserv := mqttconn.zserv.(*MqttServer)
+
 	results := make([]byte, 0)
+	existed := make(map[string]bool)
 	for _, topic := range mqttMessage.Payload().(*MqttSubscribePayload).topics {
-		mqttconn.topcis[topic.TopicName] = topic.Qos
-		results = append(results, topic.Qos)
+		_, filter, ok := MqttShareFilter(topic.TopicName)
+		if !ok {
+			global.Logger().Warn(fmt.Sprintf("mqtt connect %s subscribe `%s` invalid", mqttconn.RemoteAddr(), topic.TopicName))
+			if mqttconn.Level() == MQTT_LEVEL_5 {
+				results = append(results, REASON_TOPIC_FILTER_INVALID)
+			} else {
+				results = append(results, SUBACK_FAILURE)
+			}
+			continue
+		}
+		if mqttserv.aclChecker != nil && !mqttserv.aclChecker.CanSubscribe(mqttconn.clientId, mqttconn.username, filter) {
+			global.Logger().Warn(fmt.Sprintf("mqtt connect %s subscribe `%s` not authorized", mqttconn.RemoteAddr(), topic.TopicName))
+			if mqttconn.Level() == MQTT_LEVEL_5 {
+				results = append(results, REASON_NOT_AUTHORIZED)
+			} else {
+				results = append(results, SUBACK_FAILURE)
+			}
+			continue
+		}
+		qos := topic.Qos
+		if qos > Qos2 {
+			qos = Qos2
+		}
+		mqttconn.topcisMutex.Lock()
+		_, existed[topic.TopicName] = mqttconn.topcis[topic.TopicName]
+		mqttconn.topcis[topic.TopicName] = qos
+		mqttconn.topcisMutex.Unlock()
+		results = append(results, qos)
 	}
-	mqttconn.This().(ZeroConnect).Authorized()
 
+	mqttserv.subscribe(mqttconn)
+
+	message := mqttconn.newMessage()
+	message.MakeSubackMessage(mqttMessage.VariableHeader().(*MqttIdentifierVariableHeader).Identifier(), results)
+	err := mqttconn.This().(ZeroConnect).Write(message.Bytes())
+	if err != nil {
+		return err
+	}
+	mqttserv.deliverRetained(mqttconn, mqttMessage.Payload().(*MqttSubscribePayload).topics, existed)
+	return nil
+}
+
+func (mqttconn *MqttConnect) onUnsubscribe(mqttMessage *MqttMessage) error {
 	mqttserv := mqttconn.zserv.(*MqttServer)
+
+	results := make([]byte, 0)
 	mqttserv.topicsMapMutex.Lock()
-	for topic := range mqttconn.topcis {
-		_, ok := mqttserv.topicsMap[topic]
+	mqttconn.topcisMutex.Lock()
+	for _, topic := range mqttMessage.Payload().(*MqttParamsPayload).Params() {
+		_, ok := mqttconn.topcis[topic]
 		if !ok {
-			mqttserv.topicsMap[topic] = make(map[string]*MqttConnect)
+			results = append(results, REASON_NO_SUBSCRIPTION_EXISTED)
+			continue
 		}
-		mqttserv.topicsMap[topic][mqttconn.This().(ZeroConnect).RemoteAddr()] = mqttconn
+		delete(mqttconn.topcis, topic)
+		_, ok = mqttserv.topicsMap[topic]
+		if ok {
+			delete(mqttserv.topicsMap[topic], mqttconn.RegisterId())
+			if len(mqttserv.topicsMap[topic]) <= 0 {
+				delete(mqttserv.topicsMap, topic)
+			}
+		}
+		results = append(results, REASON_SUCCESS)
 	}
+	mqttconn.topcisMutex.Unlock()
 	mqttserv.topicsMapMutex.Unlock()
 
-	message := &MqttMessage{}
-	message.MakeSubackMessage(mqttMessage.VariableHeader().(*MqttIdentifierVariableHeader).Identifier(), results)
+	message := mqttconn.newMessage()
+	message.MakeUnsubackMessage(mqttMessage.VariableHeader().(*MqttIdentifierVariableHeader).Identifier(), results)
 	return mqttconn.This().(ZeroConnect).Write(message.Bytes())
 }
 
+func (mqttconn *MqttConnect) resolveTopicAlias(publishHeader *MqttPublishVariableHeader) error {
+	if !publishHeader.properties.Has(PROPERTY_TOPIC_ALIAS) {
+		if len(publishHeader.topic) <= 0 {
+			return fmt.Errorf("publish without topic name")
+		}
+		return nil
+	}
+
+	mqttserv := mqttconn.zserv.(*MqttServer)
+	alias := publishHeader.properties.Uint16(PROPERTY_TOPIC_ALIAS)
+	if alias <= 0 || alias > mqttserv.topicAliasMaximum {
+		return fmt.Errorf("topic alias %d out of range", alias)
+	}
+
+	mqttconn.aliasesMutex.Lock()
+	defer mqttconn.aliasesMutex.Unlock()
+	if len(publishHeader.topic) > 0 {
+		mqttconn.inboundAliases[alias] = publishHeader.topic
+		return nil
+	}
+	topic, ok := mqttconn.inboundAliases[alias]
+	if !ok {
+		return fmt.Errorf("topic alias %d not found", alias)
+	}
+	publishHeader.topic = topic
+	return nil
+}
+
 func (mqttconn *MqttConnect) onPublish(mqttMessage *MqttMessage) error {
 	defer func() {
 		err := recover()
@@ -250,41 +635,98 @@ func (mqttconn *MqttConnect) onPublish(mqttMessage *MqttMessage) error {
 		}
 	}()
 
-	if mqttconn.xListener != nil {
+	publishHeader := mqttMessage.VariableHeader().(*MqttPublishVariableHeader)
+	if mqttconn.Level() == MQTT_LEVEL_5 {
+		err := mqttconn.resolveTopicAlias(publishHeader)
+		if err != nil {
+			mqttconn.Disconnect(REASON_TOPIC_ALIAS_INVALID, err.Error())
+			return err
+		}
+	}
+
+	reasonCode := byte(REASON_SUCCESS)
+	mqttserv := mqttconn.zserv.(*MqttServer)
+	topic := publishHeader.Topic()
+	if strings.HasPrefix(topic, MQTT_SYS_PREFIX) {
+		global.Logger().Warn(fmt.Sprintf("mqtt connect %s publish `%s` reserved", mqttconn.RemoteAddr(), topic))
+		reasonCode = REASON_TOPIC_NAME_INVALID
+	} else if mqttserv.aclChecker != nil && !mqttserv.aclChecker.CanPublish(mqttconn.clientId, mqttconn.username, topic) {
+		global.Logger().Warn(fmt.Sprintf("mqtt connect %s publish `%s` not authorized", mqttconn.RemoteAddr(), topic))
+		reasonCode = REASON_NOT_AUTHORIZED
+	} else {
+		if mqttMessage.FixedHeader().B0() == 0b1 {
+			mqttserv.retain(topic, mqttMessage.FixedHeader().Qos(), mqttMessage.Payload().(*MqttPayload).Payload(), publishHeader.Properties())
+		}
+		mqttserv.route(topic, mqttMessage.FixedHeader().Qos(), mqttMessage.Payload().(*MqttPayload).Payload(), publishHeader.Properties())
+		err := mqttserv.notifyPublish(mqttconn, mqttMessage)
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("mqtt connect %s publish `%s` listener err : %s", mqttconn.RemoteAddr(), topic, err.Error()))
+			if mqttconn.Level() != MQTT_LEVEL_5 {
+				return err
+			}
+			reasonCode = REASON_IMPLEMENTATION_SPECIFIC_ERROR
+		}
+	}
+	if reasonCode == REASON_SUCCESS && mqttconn.xListener != nil {
 		err := mqttconn.xListener.Publish(mqttconn.This().(ZeroConnect), mqttMessage)
 		if err != nil {
 			global.Logger().Error(fmt.Sprintf("mqttserv process publish err : %s", err))
+			reasonCode = REASON_IMPLEMENTATION_SPECIFIC_ERROR
 		}
 	}
 
 	if mqttMessage.FixedHeader().Qos() == Qos1 {
-		mqttconn.UpdateSerialNnumber(mqttMessage.VariableHeader().(*MqttPublishVariableHeader).Identifier())
-		message := &MqttMessage{}
-		message.MakePubackMessage(mqttMessage.VariableHeader().(*MqttPublishVariableHeader).Identifier())
+		mqttconn.UpdateSerialNnumber(publishHeader.Identifier())
+		message := mqttconn.newMessage().UseReasonCode(reasonCode)
+		message.MakePubackMessage(publishHeader.Identifier())
 		return mqttconn.This().(ZeroConnect).Write(message.Bytes())
 	} else if mqttMessage.FixedHeader().Qos() == Qos2 {
-		mqttconn.UpdateSerialNnumber(mqttMessage.VariableHeader().(*MqttPublishVariableHeader).Identifier())
-		message := &MqttMessage{}
-		message.MakePubrecMessage(mqttMessage.VariableHeader().(*MqttPublishVariableHeader).Identifier())
+		mqttconn.UpdateSerialNnumber(publishHeader.Identifier())
+		message := mqttconn.newMessage().UseReasonCode(reasonCode)
+		message.MakePubrecMessage(publishHeader.Identifier())
 		return mqttconn.This().(ZeroConnect).Write(message.Bytes())
 	}
 	return nil
 }
 
 func (mqttconn *MqttConnect) onPubrec(mqttMessage *MqttMessage) error {
-	message := &MqttMessage{}
+	if mqttMessage.ReasonCode() >= REASON_UNSPECIFIED_ERROR {
+		global.Logger().Warn(fmt.Sprintf("mqtt connect %s pubrec reason 0x%02x", mqttconn.RemoteAddr(), mqttMessage.ReasonCode()))
+		return nil
+	}
+	message := mqttconn.newMessage()
 	message.MakePubrelMessage(mqttMessage.VariableHeader().(*MqttIdentifierVariableHeader).Identifier())
 	return mqttconn.This().(ZeroConnect).Write(message.Bytes())
 }
 
 func (mqttconn *MqttConnect) onPubrel(mqttMessage *MqttMessage) error {
-	message := &MqttMessage{}
+	message := mqttconn.newMessage()
 	message.MakePubcompMessage(mqttMessage.VariableHeader().(*MqttIdentifierVariableHeader).Identifier())
 	return mqttconn.This().(ZeroConnect).Write(message.Bytes())
 }
 
+func (mqttconn *MqttConnect) onDisconnect(mqttMessage *MqttMessage) error {
+	if mqttconn.Level() == MQTT_LEVEL_5 && mqttMessage.Properties().Has(PROPERTY_SESSION_EXPIRY_INTERVAL) {
+		if mqttconn.sessionExpiry == 0 && mqttMessage.Properties().Uint32(PROPERTY_SESSION_EXPIRY_INTERVAL) > 0 {
+			return mqttconn.Disconnect(REASON_PROTOCOL_ERROR, "session expiry interval cannot be set on disconnect")
+		}
+		mqttconn.sessionExpiry = mqttMessage.Properties().Uint32(PROPERTY_SESSION_EXPIRY_INTERVAL)
+	}
+	global.Logger().Info(fmt.Sprintf("mqtt connect %s on disconnect, reason 0x%02x", mqttconn.RemoteAddr(), mqttMessage.ReasonCode()))
+	return mqttconn.This().(ZeroConnect).Close()
+}
+
 func (mqttconn *MqttConnect) onMqttMessage(mqttMessage *MqttMessage) error {
-	defer mqttconn.Heartbeat()
+	defer func() {
+		if mqttconn.Active() {
+			mqttconn.Heartbeat()
+		}
+	}()
+
+	if !mqttconn.connected && mqttMessage.FixedHeader().MessageType() != CONNECT {
+		mqttconn.This().(ZeroConnect).Close()
+		return fmt.Errorf("mqtt connect %s send `%s` before connect", mqttconn.RemoteAddr(), mqttMessage.FixedHeader().MessageTypeString())
+	}
 
 	switch mqttMessage.FixedHeader().MessageType() {
 	case CONNECT:
@@ -302,27 +744,233 @@ func (mqttconn *MqttConnect) onMqttMessage(mqttMessage *MqttMessage) error {
 		return mqttconn.onSubscribe(mqttMessage)
 	case SUBACK:
 	case UNSUBSCRIBE:
+		return mqttconn.onUnsubscribe(mqttMessage)
 	case UNSUBACK:
 	case PINGREQ:
 		return mqttconn.onPingreq(mqttMessage)
 	case PINGRESP:
 	case DISCONNECT:
+		return mqttconn.onDisconnect(mqttMessage)
+	case AUTH:
+		return mqttconn.Disconnect(REASON_PROTOCOL_ERROR, "enhanced authentication not supported")
 	default:
 	}
 	return nil
 }
 
+type xMqttSessionMessage struct {
+	message *xMqttStoredMessage
+	qos     byte
+}
+
+type xMqttSession struct {
+	topics   map[string]byte
+	queue    []*xMqttSessionMessage
+	expireAt int64
+}
+
 type MqttServer struct {
 	TCPServer
 
 	topicsMap      map[string]map[string]*MqttConnect
 	topicsMapMutex sync.RWMutex
+
+	authenticator MqttAuthenticator
+	aclChecker    MqttAclChecker
+
+	takeoverMutex sync.Mutex
+	sessions      map[string]*xMqttSession
+	sessionsMutex sync.Mutex
+	retained      xMqttRetained
+
+	topicAliasMaximum   uint16
+	responseInformation string
+
+	wsAddress string
+	wsPath    string
+
+	shareCursors map[string]uint64
+	sharesMutex  sync.Mutex
+
+	statistics  xMqttStatistics
+	sysInterval int64
+
+	publishListeners      []MqttMessageListener
+	publishListenersMutex sync.RWMutex
+}
+
+func (mqttserv *MqttServer) subscribe(mqttconn *MqttConnect) {
+	topics := mqttconn.topics()
+	mqttserv.topicsMapMutex.Lock()
+	for topic := range topics {
+		_, ok := mqttserv.topicsMap[topic]
+		if !ok {
+			mqttserv.topicsMap[topic] = make(map[string]*MqttConnect)
+		}
+		mqttserv.topicsMap[topic][mqttconn.RegisterId()] = mqttconn
+	}
+	mqttserv.topicsMapMutex.Unlock()
+}
+
+func (mqttserv *MqttServer) route(topic string, qos byte, data []byte, properties *MqttProperties) {
+	now := time.Now().Unix()
+	stored := newMqttStoredMessage(topic, qos, data, properties)
+	if stored.expired(now) {
+		return
+	}
+	mqttserv.enqueue(stored)
+
+	receivers := make(map[*MqttConnect]byte)
+	shares := make(map[string][]*MqttConnect)
+	mqttserv.topicsMapMutex.RLock()
+	for filter, conns := range mqttserv.topicsMap {
+		group, xfilter, _ := MqttShareFilter(filter)
+		if !MqttTopicMatch(xfilter, topic) {
+			continue
+		}
+		if len(group) > 0 {
+			for _, mqttconn := range conns {
+				if mqttconn.Active() {
+					shares[filter] = append(shares[filter], mqttconn)
+				}
+			}
+			continue
+		}
+		for _, mqttconn := range conns {
+			granted, _ := mqttconn.granted(filter)
+			if xgranted, ok := receivers[mqttconn]; !ok || xgranted < granted {
+				receivers[mqttconn] = granted
+			}
+		}
+	}
+	mqttserv.topicsMapMutex.RUnlock()
+
+	for filter, members := range shares {
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].RegisterId() < members[j].RegisterId()
+		})
+		mqttconn := members[mqttserv.nextShare(filter)%uint64(len(members))]
+		granted, _ := mqttconn.granted(filter)
+		if xgranted, ok := receivers[mqttconn]; !ok || xgranted < granted {
+			receivers[mqttconn] = granted
+		}
+	}
+
+	xproperties := stored.forward(now)
+	for mqttconn, granted := range receivers {
+		if !mqttconn.Active() {
+			continue
+		}
+		xqos := qos
+		if granted < xqos {
+			xqos = granted
+		}
+		var err error
+		if xproperties != nil {
+			err = mqttconn.Publish(topic, xqos, data, xproperties)
+		} else {
+			err = mqttconn.Publish(topic, xqos, data)
+		}
+		if err != nil {
+			global.Logger().Error(fmt.Sprintf("mqtt server route `%s` to %s error %s", topic, mqttconn.RemoteAddr(), err.Error()))
+		}
+	}
+}
+
+func (mqttserv *MqttServer) AddPublishListeners(publishListeners ...MqttMessageListener) {
+	mqttserv.publishListenersMutex.Lock()
+	mqttserv.publishListeners = append(mqttserv.publishListeners, publishListeners...)
+	mqttserv.publishListenersMutex.Unlock()
+}
+
+func (mqttserv *MqttServer) notifyPublish(mqttconn *MqttConnect, mqttMessage *MqttMessage) error {
+	mqttserv.publishListenersMutex.RLock()
+	defer mqttserv.publishListenersMutex.RUnlock()
+	for _, publishListener := range mqttserv.publishListeners {
+		err := publishListener.Publish(mqttconn.This().(ZeroConnect), mqttMessage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mqttserv *MqttServer) nextShare(filter string) uint64 {
+	mqttserv.sharesMutex.Lock()
+	defer mqttserv.sharesMutex.Unlock()
+	cursor := mqttserv.shareCursors[filter]
+	mqttserv.shareCursors[filter] = cursor + 1
+	return cursor
+}
+
+func (mqttserv *MqttServer) Publish(topic string, qos byte, data []byte, properties ...*MqttProperties) {
+	if len(properties) > 0 {
+		mqttserv.route(topic, qos, data, properties[0])
+	} else {
+		mqttserv.route(topic, qos, data, nil)
+	}
+}
+
+func (mqttserv *MqttServer) keepSession(clientId string, topics map[string]byte, sessionExpiry uint32) {
+	session := &xMqttSession{
+		topics:   make(map[string]byte),
+		expireAt: 0,
+	}
+	for topic, qos := range topics {
+		session.topics[topic] = qos
+	}
+	if sessionExpiry != MQTT_SESSION_NEVER_EXPIRE {
+		session.expireAt = time.Now().Unix() + int64(sessionExpiry)
+	}
+	mqttserv.sessionsMutex.Lock()
+	mqttserv.sessions[clientId] = session
+	mqttserv.sessionsMutex.Unlock()
+}
+
+func (mqttserv *MqttServer) takeSession(clientId string) *xMqttSession {
+	mqttserv.sessionsMutex.Lock()
+	defer mqttserv.sessionsMutex.Unlock()
+	now := time.Now().Unix()
+	for xclientId, session := range mqttserv.sessions {
+		if session.expireAt > 0 && session.expireAt < now {
+			delete(mqttserv.sessions, xclientId)
+		}
+	}
+	session, ok := mqttserv.sessions[clientId]
+	if !ok {
+		return nil
+	}
+	delete(mqttserv.sessions, clientId)
+	return session
+}
+
+func (mqttserv *MqttServer) AddAuthenticator(authenticator MqttAuthenticator) {
+	mqttserv.authenticator = authenticator
+}
+
+func (mqttserv *MqttServer) AddAclChecker(aclChecker MqttAclChecker) {
+	mqttserv.aclChecker = aclChecker
+}
+
+func (mqttserv *MqttServer) UseTopicAliasMaximum(topicAliasMaximum uint16) *MqttServer {
+	mqttserv.topicAliasMaximum = topicAliasMaximum
+	return mqttserv
+}
+
+func (mqttserv *MqttServer) UseResponseInformation(responseInformation string) *MqttServer {
+	mqttserv.responseInformation = responseInformation
+	return mqttserv
 }
 
 func NewMqttServer(address string, authWaitSeconds int64, heartbeatSeconds int64, bufferSize int) *MqttServer {
 	return &MqttServer{
-		TCPServer: *NewTCPServer(address, authWaitSeconds, heartbeatSeconds, bufferSize),
-		topicsMap: make(map[string]map[string]*MqttConnect),
+		TCPServer:         *NewTCPServer(address, authWaitSeconds, heartbeatSeconds, bufferSize),
+		topicsMap:         make(map[string]map[string]*MqttConnect),
+		sessions:          make(map[string]*xMqttSession),
+		retained:          xMqttRetained{messages: make(map[string]*xMqttStoredMessage)},
+		topicAliasMaximum: MQTT_DEFAULT_TOPIC_ALIAS_MAXIMUM,
+		shareCursors:      make(map[string]uint64),
+		sysInterval:       MQTT_DEFAULT_SYS_INTERVAL,
 	}
 }
 
@@ -331,5 +979,12 @@ func (mqttserv *MqttServer) RunServer() {
 		mqttserv.ConnectBuilder = &MqttConnectBuilder{}
 	}
 	global.Key(CORE_MQTT_SERVER, mqttserv)
+	mqttserv.statistics.startTime = time.Now().Unix()
+	if len(mqttserv.wsAddress) > 0 {
+		go mqttserv.runWebSocket()
+	}
+	if mqttserv.sysInterval > 0 {
+		go mqttserv.runSysLoop()
+	}
 	mqttserv.TCPServer.RunServer()
 }
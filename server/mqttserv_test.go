@@ -0,0 +1,282 @@
+package server
+
+import (
+	"container/ring"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0meet1/zero-framework/global"
+	"github.com/0meet1/zero-framework/structs"
+)
+
+type xTestMqttPeer struct {
+	conn     *MqttConnect
+	client   net.Conn
+	messages chan *MqttMessage
+}
+
+func (peer *xTestMqttPeer) send(t *testing.T, message *MqttMessage) {
+	t.Helper()
+	err := peer.conn.OnMessage(message.Bytes())
+	if err != nil {
+		t.Fatalf("peer on message error %s", err.Error())
+	}
+}
+
+func (peer *xTestMqttPeer) next(t *testing.T, messageType byte) *MqttMessage {
+	t.Helper()
+	for {
+		select {
+		case message, ok := <-peer.messages:
+			if !ok {
+				t.Fatalf("peer closed while waiting for message type %d", messageType)
+			}
+			if message.FixedHeader().MessageType() == messageType {
+				return message
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for message type %d", messageType)
+		}
+	}
+}
+
+func (peer *xTestMqttPeer) closed(t *testing.T) {
+	t.Helper()
+	for {
+		select {
+		case _, ok := <-peer.messages:
+			if !ok {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for peer close")
+		}
+	}
+}
+
+func newTestClock(size int) *ring.Ring {
+	clock := ring.New(size)
+	for i := 0; i < size; i++ {
+		clock.Value = structs.NewLinked()
+		clock = clock.Next()
+	}
+	return clock
+}
+
+var (
+	xTestMqttServer     *MqttServer
+	xTestMqttServerOnce sync.Once
+)
+
+func newTestMqttServer() *MqttServer {
+	xTestMqttServerOnce.Do(func() {
+		xTestMqttServer = NewMqttServer("127.0.0.1:0", 5, 60, 4096)
+		global.Key(CORE_MQTT_SERVER, xTestMqttServer)
+	})
+	mqttserv := xTestMqttServer
+	mqttserv.acceptClock = newTestClock(10)
+	mqttserv.heartbeatClock = newTestClock(60)
+	mqttserv.connects = make(map[string]ZeroConnect)
+	mqttserv.topicsMap = make(map[string]map[string]*MqttConnect)
+	mqttserv.sessions = make(map[string]*xMqttSession)
+	mqttserv.shareCursors = make(map[string]uint64)
+	mqttserv.authenticator = nil
+	mqttserv.aclChecker = nil
+	return mqttserv
+}
+
+func newTestMqttPeer(mqttserv *MqttServer, level byte) *xTestMqttPeer {
+	server, client := net.Pipe()
+	peer := &xTestMqttPeer{
+		conn:     (&MqttConnectBuilder{}).NewConnect().(*MqttConnect),
+		client:   client,
+		messages: make(chan *MqttMessage, 64),
+	}
+	peer.conn.Accept(mqttserv, server)
+	go func() {
+		defer close(peer.messages)
+		checker := &xMqttDataChecker{}
+		buf := make([]byte, 4096)
+		for {
+			n, err := client.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, data := range checker.CheckPackageData("peer", append([]byte{}, buf[:n]...)) {
+				message, err := ParseMqttMessage(data, level)
+				if err == nil {
+					peer.messages <- message
+				}
+			}
+		}
+	}()
+	return peer
+}
+
+func connectTestMqttPeer(t *testing.T, mqttserv *MqttServer, level byte, clientId string, cleanSession bool) (*xTestMqttPeer, *MqttMessage) {
+	t.Helper()
+	peer := newTestMqttPeer(mqttserv, level)
+	message := (&MqttMessage{}).UseLevel(level).UseProperties(NewMqttProperties())
+	message.MakeConnectMessage(clientId, 60, cleanSession, "", nil, nil)
+	peer.send(t, message)
+	return peer, peer.next(t, CONNACK)
+}
+
+func TestMqttTakeoverByClientId(t *testing.T) {
+	cases := []struct {
+		name         string
+		level        byte
+		cleanSession bool
+		present      byte
+	}{
+		{"3.1.1 clean", MQTT_LEVEL_3_1_1, true, 0x00},
+		{"3.1.1 persistent", MQTT_LEVEL_3_1_1, false, 0x01},
+		{"5 clean", MQTT_LEVEL_5, true, 0x00},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mqttserv := newTestMqttServer()
+
+			first, connack := connectTestMqttPeer(t, mqttserv, c.level, "takeover", c.cleanSession)
+			if connack.ReasonCode() != CONNACK_ACCEPTED {
+				t.Fatalf("first connect refused 0x%02x", connack.ReasonCode())
+			}
+			subscribe := (&MqttMessage{}).UseLevel(c.level).UseProperties(NewMqttProperties())
+			subscribe.MakeSubscribeMessage(1, []*MqttTopic{{TopicName: "takeover/topic", Qos: Qos1}})
+			first.send(t, subscribe)
+			first.next(t, SUBACK)
+
+			second, connack := connectTestMqttPeer(t, mqttserv, c.level, "takeover", c.cleanSession)
+			if connack.ReasonCode() != CONNACK_ACCEPTED {
+				t.Fatalf("second connect refused 0x%02x", connack.ReasonCode())
+			}
+			if present := connack.VariableHeader().(*MqttConnackVariableHeader).SessionPresent(); present != c.present {
+				t.Fatalf("expected session present %d got %d", c.present, present)
+			}
+
+			if c.level == MQTT_LEVEL_5 {
+				disconnect := first.next(t, DISCONNECT)
+				if disconnect.ReasonCode() != REASON_SESSION_TAKEN_OVER {
+					t.Fatalf("expected reason 0x%02x got 0x%02x", REASON_SESSION_TAKEN_OVER, disconnect.ReasonCode())
+				}
+			}
+			first.closed(t)
+			if first.conn.Active() {
+				t.Fatal("first connect still active after takeover")
+			}
+
+			xconn, err := mqttserv.UseConnect("takeover")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if xconn != second.conn {
+				t.Fatal("clientId not registered to the new connect")
+			}
+			_, granted := second.conn.granted("takeover/topic")
+			if granted != !c.cleanSession {
+				t.Fatalf("expected subscription kept %v got %v", !c.cleanSession, granted)
+			}
+			second.conn.Close()
+		})
+	}
+}
+
+func TestMqttShareFilter(t *testing.T) {
+	cases := []struct {
+		filter string
+		group  string
+		topic  string
+		ok     bool
+	}{
+		{"sensors/+", "", "sensors/+", true},
+		{"$share/g1/sensors/#", "g1", "sensors/#", true},
+		{"$share/g1/a", "g1", "a", true},
+		{"$share/g1", "", "", false},
+		{"$share/g1/", "", "", false},
+		{"$share//a", "", "", false},
+		{"$share/g+/a", "", "", false},
+		{"", "", "", false},
+	}
+	for _, c := range cases {
+		group, topic, ok := MqttShareFilter(c.filter)
+		if group != c.group || topic != c.topic || ok != c.ok {
+			t.Fatalf("`%s` expected (%s, %s, %v) got (%s, %s, %v)", c.filter, c.group, c.topic, c.ok, group, topic, ok)
+		}
+	}
+}
+
+func TestMqttShareRoundRobin(t *testing.T) {
+	mqttserv := newTestMqttServer()
+
+	subscribe := func(peer *xTestMqttPeer, filter string) {
+		message := &MqttMessage{}
+		message.MakeSubscribeMessage(1, []*MqttTopic{{TopicName: filter, Qos: Qos0}})
+		peer.send(t, message)
+		suback := peer.next(t, SUBACK)
+		if results := suback.Payload().Payload(); len(results) != 1 || results[0] != Qos0 {
+			t.Fatalf("subscribe `%s` refused %v", filter, results)
+		}
+	}
+
+	groups := []struct {
+		filter  string
+		members []string
+	}{
+		{"$share/g1/sensors/+", []string{"share-c", "share-a", "share-b"}},
+		{"$share/g2/sensors/#", []string{"share-e", "share-d"}},
+		{"sensors/#", []string{"plain"}},
+	}
+	peers := make(map[string]*xTestMqttPeer)
+	for _, group := range groups {
+		for _, clientId := range group.members {
+			peer, _ := connectTestMqttPeer(t, mqttserv, MQTT_LEVEL_3_1_1, clientId, true)
+			subscribe(peer, group.filter)
+			peers[clientId] = peer
+		}
+	}
+
+	const rounds = 6
+	for i := 0; i < rounds; i++ {
+		mqttserv.Publish("sensors/t1", Qos0, []byte{byte(i)})
+	}
+
+	expected := map[string][]byte{
+		"share-a": {0, 3},
+		"share-b": {1, 4},
+		"share-c": {2, 5},
+		"share-d": {0, 2, 4},
+		"share-e": {1, 3, 5},
+		"plain":   {0, 1, 2, 3, 4, 5},
+	}
+	for clientId, datas := range expected {
+		peer := peers[clientId]
+		for _, data := range datas {
+			publish := peer.next(t, PUBLISH)
+			if payload := publish.Payload().Payload(); len(payload) != 1 || payload[0] != data {
+				t.Fatalf("%s expected message %d got %v", clientId, data, payload)
+			}
+		}
+		select {
+		case message := <-peer.messages:
+			t.Fatalf("%s unexpected extra message type %d", clientId, message.FixedHeader().MessageType())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	peers["share-b"].conn.Close()
+	peers["share-b"].closed(t)
+	mqttserv.Publish("sensors/t1", Qos0, []byte{rounds})
+	mqttserv.Publish("sensors/t1", Qos0, []byte{rounds + 1})
+	for i, clientId := range []string{"share-a", "share-c"} {
+		publish := peers[clientId].next(t, PUBLISH)
+		if payload := publish.Payload().Payload(); payload[0] != byte(rounds+i) {
+			t.Fatalf("%s expected message %d got %v", clientId, rounds+i, payload)
+		}
+	}
+
+	for _, peer := range peers {
+		peer.conn.Close()
+	}
+}
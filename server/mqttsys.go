@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MQTT_DEFAULT_SYS_INTERVAL = 10
+
+	MQTT_SYS_UPTIME                 = "$SYS/broker/uptime"
+	MQTT_SYS_CLIENTS_CONNECTED      = "$SYS/broker/clients/connected"
+	MQTT_SYS_SUBSCRIPTIONS_COUNT    = "$SYS/broker/subscriptions/count"
+	MQTT_SYS_MESSAGES_RECEIVED      = "$SYS/broker/messages/received"
+	MQTT_SYS_MESSAGES_SENT          = "$SYS/broker/messages/sent"
+	MQTT_SYS_PUBLISH_RECEIVED       = "$SYS/broker/publish/messages/received"
+	MQTT_SYS_PUBLISH_SENT           = "$SYS/broker/publish/messages/sent"
+	MQTT_SYS_BYTES_RECEIVED         = "$SYS/broker/bytes/received"
+	MQTT_SYS_BYTES_SENT             = "$SYS/broker/bytes/sent"
+	MQTT_SYS_LOAD_MESSAGES_RECEIVED = "$SYS/broker/load/messages/received"
+	MQTT_SYS_LOAD_MESSAGES_SENT     = "$SYS/broker/load/messages/sent"
+	MQTT_SYS_LOAD_PUBLISH_RECEIVED  = "$SYS/broker/load/publish/received"
+	MQTT_SYS_LOAD_PUBLISH_SENT      = "$SYS/broker/load/publish/sent"
+	MQTT_SYS_LOAD_BYTES_RECEIVED    = "$SYS/broker/load/bytes/received"
+	MQTT_SYS_LOAD_BYTES_SENT        = "$SYS/broker/load/bytes/sent"
+)
+
+type xMqttStatistics struct {
+	startTime int64
+
+	messagesReceived atomic.Int64
+	messagesSent     atomic.Int64
+	publishReceived  atomic.Int64
+	publishSent      atomic.Int64
+	bytesReceived    atomic.Int64
+	bytesSent        atomic.Int64
+}
+
+func (statistics *xMqttStatistics) received(datas []byte) {
+	statistics.messagesReceived.Add(1)
+	statistics.bytesReceived.Add(int64(len(datas)))
+	if len(datas) > 0 && datas[0]>>4 == PUBLISH {
+		statistics.publishReceived.Add(1)
+	}
+}
+
+func (statistics *xMqttStatistics) sent(datas []byte) {
+	statistics.messagesSent.Add(1)
+	statistics.bytesSent.Add(int64(len(datas)))
+	if len(datas) > 0 && datas[0]>>4 == PUBLISH {
+		statistics.publishSent.Add(1)
+	}
+}
+
+func (statistics *xMqttStatistics) snapshot() []int64 {
+	return []int64{
+		statistics.messagesReceived.Load(),
+		statistics.messagesSent.Load(),
+		statistics.publishReceived.Load(),
+		statistics.publishSent.Load(),
+		statistics.bytesReceived.Load(),
+		statistics.bytesSent.Load(),
+	}
+}
+
+func (mqttserv *MqttServer) UseSysInterval(sysInterval int64) *MqttServer {
+	mqttserv.sysInterval = sysInterval
+	return mqttserv
+}
+
+func (mqttserv *MqttServer) ConnectedClients() int {
+	mqttserv.connectMutex.RLock()
+	defer mqttserv.connectMutex.RUnlock()
+	return len(mqttserv.connects)
+}
+
+func (mqttserv *MqttServer) Subscriptions() int {
+	mqttserv.topicsMapMutex.RLock()
+	defer mqttserv.topicsMapMutex.RUnlock()
+	count := 0
+	for _, conns := range mqttserv.topicsMap {
+		count += len(conns)
+	}
+	return count
+}
+
+func (mqttserv *MqttServer) publishSys(topic string, value string) {
+	mqttserv.route(topic, Qos0, []byte(value), nil)
+}
+
+func (mqttserv *MqttServer) runSysLoop() {
+	lastTime := time.Now()
+	last := mqttserv.statistics.snapshot()
+	for {
+		<-time.After(time.Duration(mqttserv.sysInterval) * time.Second)
+		now := time.Now()
+		current := mqttserv.statistics.snapshot()
+		seconds := now.Sub(lastTime).Seconds()
+		rate := func(i int) string {
+			return fmt.Sprintf("%.2f", float64(current[i]-last[i])/seconds)
+		}
+
+		mqttserv.publishSys(MQTT_SYS_UPTIME, fmt.Sprintf("%d", now.Unix()-mqttserv.statistics.startTime))
+		mqttserv.publishSys(MQTT_SYS_CLIENTS_CONNECTED, fmt.Sprintf("%d", mqttserv.ConnectedClients()))
+		mqttserv.publishSys(MQTT_SYS_SUBSCRIPTIONS_COUNT, fmt.Sprintf("%d", mqttserv.Subscriptions()))
+		mqttserv.publishSys(MQTT_SYS_MESSAGES_RECEIVED, fmt.Sprintf("%d", current[0]))
+		mqttserv.publishSys(MQTT_SYS_MESSAGES_SENT, fmt.Sprintf("%d", current[1]))
+		mqttserv.publishSys(MQTT_SYS_PUBLISH_RECEIVED, fmt.Sprintf("%d", current[2]))
+		mqttserv.publishSys(MQTT_SYS_PUBLISH_SENT, fmt.Sprintf("%d", current[3]))
+		mqttserv.publishSys(MQTT_SYS_BYTES_RECEIVED, fmt.Sprintf("%d", current[4]))
+		mqttserv.publishSys(MQTT_SYS_BYTES_SENT, fmt.Sprintf("%d", current[5]))
+		mqttserv.publishSys(MQTT_SYS_LOAD_MESSAGES_RECEIVED, rate(0))
+		mqttserv.publishSys(MQTT_SYS_LOAD_MESSAGES_SENT, rate(1))
+		mqttserv.publishSys(MQTT_SYS_LOAD_PUBLISH_RECEIVED, rate(2))
+		mqttserv.publishSys(MQTT_SYS_LOAD_PUBLISH_SENT, rate(3))
+		mqttserv.publishSys(MQTT_SYS_LOAD_BYTES_RECEIVED, rate(4))
+		mqttserv.publishSys(MQTT_SYS_LOAD_BYTES_SENT, rate(5))
+
+		lastTime = now
+		last = current
+	}
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/0meet1/zero-framework/global"
+)
+
+const (
+	MQTT_WEBSOCKET_GUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	MQTT_WEBSOCKET_PROTOCOL    = "mqtt"
+	MQTT_WEBSOCKET_PROTOCOL_V3 = "mqttv3.1"
+	MQTT_WEBSOCKET_PATH        = "/mqtt"
+
+	MQTT_WEBSOCKET_MAX_FRAME = 0x10000000
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsCloseNormal      = 1000
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+	wsCloseTooBig      = 1009
+)
+
+type xMqttWebSocketConn struct {
+	net.Conn
+
+	reader *bufio.Reader
+
+	remaining int64
+	masked    bool
+	maskKey   [4]byte
+	maskPos   int
+
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+}
+
+func newMqttWebSocketConn(conn net.Conn, reader *bufio.Reader) *xMqttWebSocketConn {
+	return &xMqttWebSocketConn{
+		Conn:   conn,
+		reader: reader,
+	}
+}
+
+func (wsconn *xMqttWebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	wsconn.writeMutex.Lock()
+	defer wsconn.writeMutex.Unlock()
+	_, err := wsconn.Conn.Write(frame)
+	return err
+}
+
+func (wsconn *xMqttWebSocketConn) closeFrame(code uint16) error {
+	var err error
+	wsconn.closeOnce.Do(func() {
+		err = wsconn.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(make([]byte, 0, 2), code))
+	})
+	return err
+}
+
+func (wsconn *xMqttWebSocketConn) readControl(opcode byte, length int64) error {
+	if length > 125 {
+		wsconn.closeFrame(wsCloseProtocol)
+		return fmt.Errorf("websocket control frame too long %d", length)
+	}
+	payload := make([]byte, length)
+	_, err := io.ReadFull(wsconn.reader, payload)
+	if err != nil {
+		return err
+	}
+	if wsconn.masked {
+		for i := range payload {
+			payload[i] ^= wsconn.maskKey[i%4]
+		}
+	}
+
+	switch opcode {
+	case wsOpClose:
+		wsconn.closeFrame(wsCloseNormal)
+		return io.EOF
+	case wsOpPing:
+		return wsconn.writeFrame(wsOpPong, payload)
+	}
+	return nil
+}
+
+func (wsconn *xMqttWebSocketConn) nextFrame() error {
+	for {
+		header := make([]byte, 2)
+		_, err := io.ReadFull(wsconn.reader, header)
+		if err != nil {
+			return err
+		}
+
+		opcode := header[0] & 0x0F
+		wsconn.masked = header[1]&0x80 != 0
+		length := int64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			xLength := make([]byte, 2)
+			_, err = io.ReadFull(wsconn.reader, xLength)
+			if err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint16(xLength))
+		case 127:
+			xLength := make([]byte, 8)
+			_, err = io.ReadFull(wsconn.reader, xLength)
+			if err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint64(xLength))
+		}
+
+		if !wsconn.masked {
+			wsconn.closeFrame(wsCloseProtocol)
+			return fmt.Errorf("websocket client frame not masked")
+		}
+		_, err = io.ReadFull(wsconn.reader, wsconn.maskKey[:])
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case wsOpClose, wsOpPing, wsOpPong:
+			err = wsconn.readControl(opcode, length)
+			if err != nil {
+				return err
+			}
+		case wsOpBinary, wsOpContinuation:
+			if length < 0 || length > MQTT_WEBSOCKET_MAX_FRAME {
+				wsconn.closeFrame(wsCloseTooBig)
+				return fmt.Errorf("websocket frame too long %d", length)
+			}
+			if length <= 0 {
+				continue
+			}
+			wsconn.remaining = length
+			wsconn.maskPos = 0
+			return nil
+		case wsOpText:
+			wsconn.closeFrame(wsCloseUnsupported)
+			return fmt.Errorf("websocket text frame not supported")
+		default:
+			wsconn.closeFrame(wsCloseProtocol)
+			return fmt.Errorf("websocket unknown opcode 0x%x", opcode)
+		}
+	}
+}
+
+func (wsconn *xMqttWebSocketConn) Read(b []byte) (int, error) {
+	if wsconn.remaining <= 0 {
+		err := wsconn.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(b)) > wsconn.remaining {
+		b = b[:wsconn.remaining]
+	}
+	n, err := wsconn.reader.Read(b)
+	for i := 0; i < n; i++ {
+		b[i] ^= wsconn.maskKey[wsconn.maskPos%4]
+		wsconn.maskPos++
+	}
+	wsconn.remaining -= int64(n)
+	return n, err
+}
+
+func (wsconn *xMqttWebSocketConn) Write(b []byte) (int, error) {
+	err := wsconn.writeFrame(wsOpBinary, b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (wsconn *xMqttWebSocketConn) Close() error {
+	wsconn.closeFrame(wsCloseNormal)
+	return wsconn.Conn.Close()
+}
+
+func mqttWebSocketProtocol(request *http.Request) string {
+	for _, xProtocols := range request.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(xProtocols, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if protocol == MQTT_WEBSOCKET_PROTOCOL || protocol == MQTT_WEBSOCKET_PROTOCOL_V3 {
+				return protocol
+			}
+		}
+	}
+	return ""
+}
+
+func mqttWebSocketUpgrade(request *http.Request) bool {
+	if !strings.EqualFold(request.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, token := range strings.Split(request.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+func (mqttserv *MqttServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet || !mqttWebSocketUpgrade(request) {
+		http.Error(writer, "websocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		writer.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(writer, "unsupported websocket version", http.StatusBadRequest)
+		return
+	}
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if len(key) <= 0 {
+		http.Error(writer, "missing websocket key", http.StatusBadRequest)
+		return
+	}
+	protocol := mqttWebSocketProtocol(request)
+	if len(protocol) <= 0 {
+		http.Error(writer, "mqtt subprotocol required", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := writer.(http.Hijacker)
+	if !ok {
+		http.Error(writer, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("mqtt websocket hijack error : %s", err.Error()))
+		return
+	}
+
+	digest := sha1.Sum([]byte(key + MQTT_WEBSOCKET_GUID))
+	response := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\nSec-WebSocket-Protocol: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(digest[:]), protocol)
+	_, err = conn.Write([]byte(response))
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("mqtt websocket handshake error : %s", err.Error()))
+		conn.Close()
+		return
+	}
+
+	global.Logger().Info(fmt.Sprintf("mqtt websocket upgrade %s", conn.RemoteAddr().String()))
+	mqttserv.accept(newMqttWebSocketConn(conn, rw.Reader))
+}
+
+func (mqttserv *MqttServer) UseWebSocket(address string, path ...string) *MqttServer {
+	mqttserv.wsAddress = address
+	mqttserv.wsPath = MQTT_WEBSOCKET_PATH
+	if len(path) > 0 && len(path[0]) > 0 {
+		mqttserv.wsPath = path[0]
+	}
+	return mqttserv
+}
+
+func (mqttserv *MqttServer) runWebSocket() {
+	mux := http.NewServeMux()
+	mux.Handle(mqttserv.wsPath, mqttserv)
+	server := http.Server{Addr: mqttserv.wsAddress, Handler: mux}
+	global.Logger().Info(fmt.Sprintf("mqtt websocket server start on ws://%s%s", mqttserv.wsAddress, mqttserv.wsPath))
+	err := server.ListenAndServe()
+	if err != nil {
+		global.Logger().Error(fmt.Sprintf("mqtt websocket server start error : %s", err.Error()))
+		panic(err)
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+type xTestWebSocketConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (conn *xTestWebSocketConn) Write(b []byte) (int, error) {
+	return conn.written.Write(b)
+}
+
+func (conn *xTestWebSocketConn) Close() error {
+	return nil
+}
+
+func (conn *xTestWebSocketConn) frames(t *testing.T) ([]byte, [][]byte) {
+	t.Helper()
+	opcodes := make([]byte, 0)
+	payloads := make([][]byte, 0)
+	data := conn.written.Bytes()
+	for len(data) > 0 {
+		if data[1]&0x80 != 0 {
+			t.Fatal("server frame masked")
+		}
+		length := int(data[1] & 0x7F)
+		offset := 2
+		switch length {
+		case 126:
+			length = int(binary.BigEndian.Uint16(data[2:4]))
+			offset = 4
+		case 127:
+			length = int(binary.BigEndian.Uint64(data[2:10]))
+			offset = 10
+		}
+		opcodes = append(opcodes, data[0]&0x0F)
+		payloads = append(payloads, data[offset:offset+length])
+		data = data[offset+length:]
+	}
+	return opcodes, payloads
+}
+
+func testWebSocketFrame(fin bool, opcode byte, payload []byte, masked bool) []byte {
+	frame := make([]byte, 0, len(payload)+14)
+	if fin {
+		frame = append(frame, 0x80|opcode)
+	} else {
+		frame = append(frame, opcode)
+	}
+	maskBit := byte(0x00)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	maskKey := []byte{0x37, 0xFA, 0x21, 0x3D}
+	frame = append(frame, maskKey...)
+	for i, b := range payload {
+		frame = append(frame, b^maskKey[i%4])
+	}
+	return frame
+}
+
+func TestMqttWebSocketRead(t *testing.T) {
+	long := bytes.Repeat([]byte("0123456789"), 30)
+	closePayload := binary.BigEndian.AppendUint16(nil, wsCloseNormal)
+
+	cases := []struct {
+		name    string
+		frames  [][]byte
+		payload []byte
+		err     bool
+		opcodes []byte
+		closed  uint16
+	}{
+		{name: "masked binary",
+			frames:  [][]byte{testWebSocketFrame(true, wsOpBinary, []byte("hello mqtt"), true)},
+			payload: []byte("hello mqtt")},
+		{name: "extended length",
+			frames:  [][]byte{testWebSocketFrame(true, wsOpBinary, long, true)},
+			payload: long},
+		{name: "fragmented",
+			frames: [][]byte{
+				testWebSocketFrame(false, wsOpBinary, []byte("hel"), true),
+				testWebSocketFrame(false, wsOpContinuation, []byte("lo "), true),
+				testWebSocketFrame(true, wsOpContinuation, []byte("mqtt"), true)},
+			payload: []byte("hello mqtt")},
+		{name: "ping between fragments",
+			frames: [][]byte{
+				testWebSocketFrame(false, wsOpBinary, []byte("hello "), true),
+				testWebSocketFrame(true, wsOpPing, []byte("ping"), true),
+				testWebSocketFrame(true, wsOpContinuation, []byte("mqtt"), true)},
+			payload: []byte("hello mqtt"),
+			opcodes: []byte{wsOpPong}},
+		{name: "empty fragment skipped",
+			frames: [][]byte{
+				testWebSocketFrame(false, wsOpBinary, []byte{}, true),
+				testWebSocketFrame(true, wsOpContinuation, []byte("mqtt"), true)},
+			payload: []byte("mqtt")},
+		{name: "unmasked",
+			frames: [][]byte{testWebSocketFrame(true, wsOpBinary, []byte("hello"), false)},
+			err:    true, opcodes: []byte{wsOpClose}, closed: wsCloseProtocol},
+		{name: "text",
+			frames: [][]byte{testWebSocketFrame(true, wsOpText, []byte("hello"), true)},
+			err:    true, opcodes: []byte{wsOpClose}, closed: wsCloseUnsupported},
+		{name: "long control",
+			frames: [][]byte{testWebSocketFrame(true, wsOpPing, long[:126], true)},
+			err:    true, opcodes: []byte{wsOpClose}, closed: wsCloseProtocol},
+		{name: "unknown opcode",
+			frames: [][]byte{testWebSocketFrame(true, 0x3, []byte("hello"), true)},
+			err:    true, opcodes: []byte{wsOpClose}, closed: wsCloseProtocol},
+		{name: "close",
+			frames: [][]byte{testWebSocketFrame(true, wsOpClose, closePayload, true)},
+			err:    true, opcodes: []byte{wsOpClose}, closed: wsCloseNormal},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := &xTestWebSocketConn{}
+			wsconn := newMqttWebSocketConn(conn, bufio.NewReader(bytes.NewReader(bytes.Join(c.frames, nil))))
+
+			payload := make([]byte, 0)
+			buf := make([]byte, 7)
+			var err error
+			for {
+				var n int
+				n, err = wsconn.Read(buf)
+				payload = append(payload, buf[:n]...)
+				if err != nil {
+					break
+				}
+			}
+			if c.err {
+				if errors.Is(err, io.EOF) != (c.closed == wsCloseNormal) {
+					t.Fatalf("unexpected error %v", err)
+				}
+			} else {
+				if !errors.Is(err, io.EOF) {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if !bytes.Equal(payload, c.payload) {
+					t.Fatalf("expected payload `%s` got `%s`", c.payload, payload)
+				}
+			}
+
+			opcodes, payloads := conn.frames(t)
+			if !bytes.Equal(opcodes, c.opcodes) {
+				t.Fatalf("expected server opcodes %v got %v", c.opcodes, opcodes)
+			}
+			if c.closed > 0 && binary.BigEndian.Uint16(payloads[len(payloads)-1]) != c.closed {
+				t.Fatalf("expected close code %d got %d", c.closed, binary.BigEndian.Uint16(payloads[len(payloads)-1]))
+			}
+			if len(opcodes) > 0 && opcodes[0] == wsOpPong && string(payloads[0]) != "ping" {
+				t.Fatalf("unexpected pong payload `%s`", payloads[0])
+			}
+		})
+	}
+}
+
+func TestMqttWebSocketWrite(t *testing.T) {
+	for _, size := range []int{10, 300, 0x10001} {
+		conn := &xTestWebSocketConn{}
+		wsconn := newMqttWebSocketConn(conn, bufio.NewReader(bytes.NewReader(nil)))
+		payload := bytes.Repeat([]byte{0xA5}, size)
+		n, err := wsconn.Write(payload)
+		if err != nil || n != size {
+			t.Fatalf("write %d bytes error %v", n, err)
+		}
+		opcodes, payloads := conn.frames(t)
+		if len(opcodes) != 1 || opcodes[0] != wsOpBinary || !bytes.Equal(payloads[0], payload) {
+			t.Fatalf("unexpected frame for %d bytes", size)
+		}
+		if conn.written.Bytes()[0]&0x80 == 0 {
+			t.Fatal("expected fin bit")
+		}
+	}
+
+	conn := &xTestWebSocketConn{}
+	wsconn := newMqttWebSocketConn(conn, bufio.NewReader(bytes.NewReader(nil)))
+	wsconn.Close()
+	wsconn.Close()
+	opcodes, _ := conn.frames(t)
+	if len(opcodes) != 1 || opcodes[0] != wsOpClose {
+		t.Fatalf("expected single close frame got %v", opcodes)
+	}
+}
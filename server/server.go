@@ -296,8 +296,8 @@ func (sockServer *ZeroSocketServer) OnDisconnect(conn ZeroConnect) error {
 	defer sockServer.notifyOnDisconnect(conn.This().(ZeroConnect))
 	conn.Clock().Value.(*structs.ZeroLinked).Remove(conn.Node())
 	sockServer.connectMutex.Lock()
-	_, ok := sockServer.connects[conn.This().(ZeroConnect).RegisterId()]
-	if ok {
+	xconn, ok := sockServer.connects[conn.This().(ZeroConnect).RegisterId()]
+	if ok && xconn == conn.This().(ZeroConnect) {
 		delete(sockServer.connects, conn.This().(ZeroConnect).RegisterId())
 	}
 	sockServer.connectMutex.Unlock()
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/0meet1/zero-framework/global"
@@ -30,6 +31,8 @@ type TCPClient struct {
 
 	checker   ZeroDataChecker
 	xListener ZeroClientListener
+
+	shutdown atomic.Bool
 }
 
 func (client *TCPClient) initHeartbeatTimer() {
@@ -117,6 +120,17 @@ func (client *TCPClient) Close() error {
 	return err
 }
 
+func (client *TCPClient) Shutdown() error {
+	client.shutdown.Store(true)
+	client.connectMutex.Lock()
+	conn := client.connect
+	client.connectMutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
 func (client *TCPClient) Write(datas []byte) error {
 	client.connectMutex.Lock()
 	defer client.connectMutex.Unlock()
@@ -167,6 +181,10 @@ func (client *TCPClient) receive() {
 func (client *TCPClient) startingLoop() {
 	for {
 		<-time.After(time.Duration(time.Second * 5))
+		if client.shutdown.Load() {
+			global.Logger().Info(fmt.Sprintf("tcp client shutdown -> %s", client.connAddr))
+			break
+		}
 		global.Logger().Info(fmt.Sprintf("tcp client starting -> %s", client.connAddr))
 		err := client.start()
 		if err != nil {
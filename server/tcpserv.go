@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"net"
+	"sync/atomic"
 
 	"github.com/0meet1/zero-framework/global"
 )
@@ -12,6 +13,8 @@ type TCPServer struct {
 
 	address   string
 	tcpServer net.Listener
+
+	shutdown atomic.Bool
 }
 
 func NewTCPServer(address string, authWaitSeconds int64, heartbeatSeconds int64, bufferSize int, watchers ...ZeroServerWatcher) *TCPServer {
@@ -42,9 +45,21 @@ func (tcpserv *TCPServer) RunServer() {
 	for {
 		conn, err := tcpServer.Accept()
 		if err != nil {
+			if tcpserv.shutdown.Load() {
+				global.Logger().Info(fmt.Sprintf("tcp server shutdown -> tcp://%s", tcpserv.address))
+				break
+			}
 			global.Logger().Error(fmt.Sprintf("tcp server accept error : %s", err.Error()))
 			continue
 		}
 		go tcpserv.accept(conn)
 	}
 }
+
+func (tcpserv *TCPServer) Shutdown() error {
+	tcpserv.shutdown.Store(true)
+	if tcpserv.tcpServer == nil {
+		return nil
+	}
+	return tcpserv.tcpServer.Close()
+}
@@ -65,10 +65,16 @@ var CustomSQLiteDatabase = database.CustomSQLiteDatabase
 var NewSQLiteTable = database.NewSQLiteTable
 
 const ROCKETMQ_KEEPER = rocketmq.ROCKETMQ_KEEPER
+const ROCKETMQ_MQTT_BRIDGE = rocketmq.ROCKETMQ_MQTT_BRIDGE
 
 type RocketmqKeeper = rocketmq.RocketmqKeeper
 type MQNotifyMessage = rocketmq.MQNotifyMessage
 type MQMessageObserver = rocketmq.MQMessageObserver
+type MqttBridgeRule = rocketmq.MqttBridgeRule
+type MqttBridge = rocketmq.MqttBridge
+type MQMqttEnvelope = rocketmq.MQMqttEnvelope
+
+var NewMqttBridge = rocketmq.NewMqttBridge
 
 var XhttpResponseMaps = server.XhttpResponseMaps
 var XhttpResponseDatas = server.XhttpResponseDatas
@@ -140,14 +146,34 @@ type MqttIdentifierVariableHeader = server.MqttIdentifierVariableHeader
 type MqttConnectVariableHeader = server.MqttConnectVariableHeader
 type MqttConnackVariableHeader = server.MqttConnackVariableHeader
 type MqttPublishVariableHeader = server.MqttPublishVariableHeader
+type MqttReasonVariableHeader = server.MqttReasonVariableHeader
 
 type MqttParamsPayload = server.MqttParamsPayload
+type MqttConnectPayload = server.MqttConnectPayload
 type MqttTopic = server.MqttTopic
+type MqttWill = server.MqttWill
+type MqttProperties = server.MqttProperties
+type MqttUserProperty = server.MqttUserProperty
 
 type MqttMessageListener = server.MqttMessageListener
 type MqttConnectBuilder = server.MqttConnectBuilder
 type MqttConnect = server.MqttConnect
 type MqttServer = server.MqttServer
+type MqttClientMessageListener = server.MqttClientMessageListener
+type MqttClient = server.MqttClient
+
+type MqttAuthenticator = server.MqttAuthenticator
+type MqttAclChecker = server.MqttAclChecker
+type MqttAclRule = server.MqttAclRule
+type MqttAclRules = server.MqttAclRules
+
+var NewMqttProperties = server.NewMqttProperties
+var NewMqttClient = server.NewMqttClient
+var NewMqttAclRules = server.NewMqttAclRules
+var NewMqttConfigAcl = server.NewMqttConfigAcl
+var NewMqttDataSourceAcl = server.NewMqttDataSourceAcl
+var MqttTopicMatch = server.MqttTopicMatch
+var MqttShareFilter = server.MqttShareFilter
 
 var DefaultMqttChecker = server.DefaultMqttChecker
 
@@ -156,15 +182,91 @@ const ZEROKMSG_CLIENT = protocol.ZEROKMSG_CLIENT
 
 var RunKMessageServer = protocol.RunKMessageServer
 var RunKMessageClient = protocol.RunKMessageClient
+var NewKMessagePool = protocol.NewKMessagePool
+var NewKMessageConsulEndpoints = protocol.NewKMessageConsulEndpoints
 
 type ZeroKMessageServer = protocol.ZeroKMessageServer
 type ZeroKMessageClient = protocol.ZeroKMessageClient
 type ZeroKMessageOperator = protocol.ZeroKMessageOperator
+type ZeroKMessagePool = protocol.ZeroKMessagePool
+type ZeroKMessageEndpointProvider = protocol.ZeroKMessageEndpointProvider
+
+const KMESSAGE_BALANCE_ROUND_ROBIN = protocol.KMESSAGE_BALANCE_ROUND_ROBIN
+const KMESSAGE_BALANCE_LEAST_INFLIGHT = protocol.KMESSAGE_BALANCE_LEAST_INFLIGHT
 
 type ZeroKMessage = protocol.ZeroKMessage
 type ZeroKMessageConnect = protocol.ZeroKMessageConnect
+type ZeroKMessageSecretFetcher = protocol.ZeroKMessageSecretFetcher
+type ZeroKMessageConnackError = protocol.ZeroKMessageConnackError
+
+const KMESSAGE_CIPHER_AES_GCM = protocol.KMESSAGE_CIPHER_AES_GCM
+const KMESSAGE_CIPHER_CHACHA20 = protocol.KMESSAGE_CIPHER_CHACHA20
+
+const KMESSAGE_DUPLICATE_KICK = protocol.KMESSAGE_DUPLICATE_KICK
+const KMESSAGE_DUPLICATE_REJECT = protocol.KMESSAGE_DUPLICATE_REJECT
+
+var UseKMessageSecretFetcher = protocol.UseKMessageSecretFetcher
+var UseKMessageSecret = protocol.UseKMessageSecret
+
+const KMESSAGE_RPC_INBOUND = protocol.KMESSAGE_RPC_INBOUND
+const KMESSAGE_RPC_OUTBOUND = protocol.KMESSAGE_RPC_OUTBOUND
+
+type ZeroKMessageCodec = protocol.ZeroKMessageCodec
+type ZeroKMessageJSONCodec = protocol.ZeroKMessageJSONCodec
+type ZeroKMessageRPC = protocol.ZeroKMessageRPC
+type ZeroKMessageRPCContext = protocol.ZeroKMessageRPCContext
+type ZeroKMessageRPCHandler = protocol.ZeroKMessageRPCHandler
+type ZeroKMessageRPCMiddleware = protocol.ZeroKMessageRPCMiddleware
+
+var NewKMessageRPC = protocol.NewKMessageRPC
+
+const (
+	KMESSAGE_VERSION_1 = protocol.KMESSAGE_VERSION_1
+	KMESSAGE_VERSION_2 = protocol.KMESSAGE_VERSION_2
+
+	KMESSAGE_FLAG_GZIP      = protocol.KMESSAGE_FLAG_GZIP
+	KMESSAGE_FLAG_DEFLATE   = protocol.KMESSAGE_FLAG_DEFLATE
+	KMESSAGE_FLAG_CHUNKED   = protocol.KMESSAGE_FLAG_CHUNKED
+	KMESSAGE_FLAG_CHUNK_END = protocol.KMESSAGE_FLAG_CHUNK_END
+	KMESSAGE_FLAG_WINDOW    = protocol.KMESSAGE_FLAG_WINDOW
+	KMESSAGE_FLAG_ACK_REQ   = protocol.KMESSAGE_FLAG_ACK_REQ
+)
+
+type ZeroKMessageStream = protocol.ZeroKMessageStream
+type ZeroKMessageStreamOperator = protocol.ZeroKMessageStreamOperator
+
+type ZeroKMessageFileOffer = protocol.ZeroKMessageFileOffer
+type ZeroKMessageFileProgress = protocol.ZeroKMessageFileProgress
+type ZeroKMessageFileOperator = protocol.ZeroKMessageFileOperator
+
+type ZeroKMessageTopicHandler = protocol.ZeroKMessageTopicHandler
+type ZeroKMessageTopicOperator = protocol.ZeroKMessageTopicOperator
+
+const (
+	KMESSAGE_RELIABLE_PENDING   = protocol.KMESSAGE_RELIABLE_PENDING
+	KMESSAGE_RELIABLE_INFLIGHT  = protocol.KMESSAGE_RELIABLE_INFLIGHT
+	KMESSAGE_RELIABLE_DELIVERED = protocol.KMESSAGE_RELIABLE_DELIVERED
+	KMESSAGE_RELIABLE_EXPIRED   = protocol.KMESSAGE_RELIABLE_EXPIRED
+	KMESSAGE_RELIABLE_FAILED    = protocol.KMESSAGE_RELIABLE_FAILED
+)
+
+type ZeroKMessageReliableRecord = protocol.ZeroKMessageReliableRecord
+type ZeroKMessageReliableStore = protocol.ZeroKMessageReliableStore
+type ZeroKMessageMemoryStore = protocol.ZeroKMessageMemoryStore
+type ZeroKMessageSQLiteStore = protocol.ZeroKMessageSQLiteStore
+
+var UseKMessageReliableStore = protocol.UseKMessageReliableStore
+var NewKMessageMemoryStore = protocol.NewKMessageMemoryStore
+var NewKMessageSQLiteStore = protocol.NewKMessageSQLiteStore
+
+type ZeroKMessageFrame = protocol.ZeroKMessageFrame
+
+var NewKMessageFrame = protocol.NewKMessageFrame
+var DescribeKMessage = protocol.DescribeKMessage
+var SplitKMessageFrames = protocol.SplitKMessageFrames
 
 const WORKER_MONO_STATUS_READY = mfgrc.WORKER_MONO_STATUS_READY
+const WORKER_MONO_STATUS_SCHEDULED = mfgrc.WORKER_MONO_STATUS_SCHEDULED
 const WORKER_MONO_STATUS_PENDING = mfgrc.WORKER_MONO_STATUS_PENDING
 const WORKER_MONO_STATUS_EXECUTING = mfgrc.WORKER_MONO_STATUS_EXECUTING
 const WORKER_MONO_STATUS_RETRYING = mfgrc.WORKER_MONO_STATUS_RETRYING
@@ -183,8 +285,69 @@ type MfgrcMono = mfgrc.MfgrcMono
 type MfgrcGroup = mfgrc.MfgrcGroup
 type ZeroMfgrcMonoStore = mfgrc.ZeroMfgrcMonoStore
 type ZeroMfgrcMonoEventListener = mfgrc.ZeroMfgrcMonoEventListener
+type ZeroMfgrcMonoTimeoutListener = mfgrc.ZeroMfgrcMonoTimeoutListener
+type ZeroMfgrcMonoScheduleListener = mfgrc.ZeroMfgrcMonoScheduleListener
+type ZeroMfgrcTimeoutError = mfgrc.ZeroMfgrcTimeoutError
 type ZeroMfgrcKeeperOpts = mfgrc.ZeroMfgrcKeeperOpts
 
+type ZeroMfgrcRetryPolicy = mfgrc.ZeroMfgrcRetryPolicy
+type ZeroMfgrcRetryClassifier = mfgrc.ZeroMfgrcRetryClassifier
+
+const MFGRC_BACKOFF_FIXED = mfgrc.MFGRC_BACKOFF_FIXED
+const MFGRC_BACKOFF_LINEAR = mfgrc.MFGRC_BACKOFF_LINEAR
+const MFGRC_BACKOFF_EXPONENTIAL = mfgrc.MFGRC_BACKOFF_EXPONENTIAL
+
+var NewMfgrcRetryPolicy = mfgrc.NewMfgrcRetryPolicy
+
+type ZeroMfgrcPriorityPolicy = mfgrc.ZeroMfgrcPriorityPolicy
+
+const MFGRC_PRIORITY_LOW = mfgrc.MFGRC_PRIORITY_LOW
+const MFGRC_PRIORITY_NORMAL = mfgrc.MFGRC_PRIORITY_NORMAL
+const MFGRC_PRIORITY_HIGH = mfgrc.MFGRC_PRIORITY_HIGH
+const MFGRC_PRIORITY_URGENT = mfgrc.MFGRC_PRIORITY_URGENT
+const MFGRC_PRIORITY_STRICT = mfgrc.MFGRC_PRIORITY_STRICT
+const MFGRC_PRIORITY_WEIGHTED = mfgrc.MFGRC_PRIORITY_WEIGHTED
+
+var NewMfgrcPriorityPolicy = mfgrc.NewMfgrcPriorityPolicy
+
+type ZeroMfgrcCronSchedule = mfgrc.ZeroMfgrcCronSchedule
+type ZeroMfgrcCronStore = mfgrc.ZeroMfgrcCronStore
+type ZeroMfgrcCronJob = mfgrc.ZeroMfgrcCronJob
+type ZeroMfgrcCronKeeper = mfgrc.ZeroMfgrcCronKeeper
+
+const MFGRC_CRON_OVERLAP_SKIP = mfgrc.MFGRC_CRON_OVERLAP_SKIP
+const MFGRC_CRON_OVERLAP_QUEUE = mfgrc.MFGRC_CRON_OVERLAP_QUEUE
+const MFGRC_CRON_OVERLAP_REPLACE = mfgrc.MFGRC_CRON_OVERLAP_REPLACE
+const MFGRC_CRON_CATCHUP_NONE = mfgrc.MFGRC_CRON_CATCHUP_NONE
+const MFGRC_CRON_CATCHUP_LATEST = mfgrc.MFGRC_CRON_CATCHUP_LATEST
+const MFGRC_CRON_CATCHUP_ALL = mfgrc.MFGRC_CRON_CATCHUP_ALL
+
+var ParseMfgrcCron = mfgrc.ParseMfgrcCron
+var NewMfgrcCronMonoJob = mfgrc.NewMfgrcCronMonoJob
+var NewMfgrcCronGroupJob = mfgrc.NewMfgrcCronGroupJob
+var NewCronKeeper = mfgrc.NewCronKeeper
+
+type ZeroMfgrcIdempotentMono = mfgrc.ZeroMfgrcIdempotentMono
+type ZeroMfgrcRecoveryHandler = mfgrc.ZeroMfgrcRecoveryHandler
+type ZeroMfgrcRecoveryListener = mfgrc.ZeroMfgrcRecoveryListener
+type ZeroMfgrcRecoveryReport = mfgrc.ZeroMfgrcRecoveryReport
+
+const MFGRC_RECOVERY_REVOKE = mfgrc.MFGRC_RECOVERY_REVOKE
+const MFGRC_RECOVERY_RESUME = mfgrc.MFGRC_RECOVERY_RESUME
+const MFGRC_RECOVERY_RERUN = mfgrc.MFGRC_RECOVERY_RERUN
+const MFGRC_RECOVERY_FAIL = mfgrc.MFGRC_RECOVERY_FAIL
+const MFGRC_RECOVERY_CALLBACK = mfgrc.MFGRC_RECOVERY_CALLBACK
+
+type ZeroMfgrcLeaseBackend = mfgrc.ZeroMfgrcLeaseBackend
+type ZeroMfgrcMonoForwarder = mfgrc.ZeroMfgrcMonoForwarder
+type ZeroMfgrcCluster = mfgrc.ZeroMfgrcCluster
+type ZeroMfgrcXhttpForwarder = mfgrc.ZeroMfgrcXhttpForwarder
+
+var NewMfgrcCluster = mfgrc.NewMfgrcCluster
+var NewMfgrcRedisLease = mfgrc.NewMfgrcRedisLease
+var NewMfgrcConsulLease = mfgrc.NewMfgrcConsulLease
+var NewMfgrcXhttpForwarder = mfgrc.NewMfgrcXhttpForwarder
+
 type ZeroMfgrcMono = mfgrc.ZeroMfgrcMono
 type ZeroMfgrcFlux = mfgrc.ZeroMfgrcFlux
 type ZeroMfgrcWorker = mfgrc.ZeroMfgrcWorker
@@ -309,6 +472,8 @@ const (
 	EXCEPTION_AUTO_PROC = errdef.EXCEPTION_AUTO_PROC
 	EXCEPTION_OPERATION = errdef.EXCEPTION_OPERATION
 
+	ES00400 = errdef.ES00400
+	ES00404 = errdef.ES00404
 	ES00500 = errdef.ES00500
 )
 